@@ -0,0 +1,32 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+
+	"k8s.io/component-base/cli"
+	"k8s.io/kubernetes/cmd/kube-scheduler/app"
+
+	"github.com/AliyunContainerService/gpushare-scheduler-extender/pkg/log"
+	"github.com/AliyunContainerService/gpushare-scheduler-extender/pkg/plugin"
+)
+
+// gpushare-kube-scheduler is kube-scheduler with the GPUShare plugin compiled in, which is
+// enabled by the scheduler configuration instead of calling the extender over HTTP.
+func main() {
+	level, err := strconv.Atoi(os.Getenv("LOG_LEVEL"))
+	if err != nil {
+		level = 0
+	}
+	log.NewLoggerWithLevel(int32(level))
+
+	// the scheduler handles the signals itself, the context only stops the plugin's controller
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	command := app.NewSchedulerCommand(app.WithPlugin(plugin.Name, plugin.NewFactory(ctx)))
+	code := cli.Run(command)
+	stop()
+	os.Exit(code)
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/AliyunContainerService/gpushare-scheduler-extender/pkg/log"
+	"github.com/AliyunContainerService/gpushare-scheduler-extender/pkg/simulator"
+	"github.com/AliyunContainerService/gpushare-scheduler-extender/pkg/utils"
+)
+
+// gpushare-sim replays pod arrivals and departures against a cluster loaded from files,
+// through the extender's filter and bind, without a kube API server.
+func main() {
+	clusterFiles := flag.String("cluster", "", "comma separated YAML/JSON files of nodes, unhealthy GPU configmaps and pods, or snapshots from /gpushare-scheduler/snapshot; the pods without nodeName arrive in order")
+	eventsFile := flag.String("events", "", "YAML/JSON file of pod arrivals and departures replayed after the cluster files")
+	strategyName := flag.String("strategy", "binpack", "how to pick the node among the filtered ones: binpack or spread")
+	output := flag.String("output", "table", "output format: table or json")
+	logLevel := flag.Int("v", 0, "log level of the extender code")
+	flag.Parse()
+
+	log.NewLoggerWithLevel(int32(*logLevel))
+
+	if len(*clusterFiles) == 0 {
+		fmt.Fprintln(os.Stderr, "-cluster is required")
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	strategy, err := simulator.GetStrategy(*strategyName)
+	if err != nil {
+		exit(err)
+	}
+
+	objs, profiles, err := simulator.LoadObjects(strings.Split(*clusterFiles, ",")...)
+	if err != nil {
+		exit(err)
+	}
+	// the pods of the snapshots are read with the extender's profiles
+	utils.SetProfiles(profiles...)
+
+	cluster, pending, err := simulator.NewCluster(objs, strategy)
+	if err != nil {
+		exit(err)
+	}
+
+	events := []simulator.Event{}
+	for _, pod := range pending {
+		events = append(events, simulator.Event{Action: simulator.ActionAdd, Pod: pod})
+	}
+	if len(*eventsFile) > 0 {
+		fileEvents, err := simulator.LoadEvents(*eventsFile)
+		if err != nil {
+			exit(err)
+		}
+		events = append(events, fileEvents...)
+	}
+
+	stats := cluster.Replay(events)
+	report, err := cluster.Report(stats)
+	if err != nil {
+		exit(err)
+	}
+
+	switch *output {
+	case "json":
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(report); err != nil {
+			exit(err)
+		}
+	default:
+		report.Print(os.Stdout)
+	}
+}
+
+func exit(err error) {
+	fmt.Fprintln(os.Stderr, err)
+	os.Exit(1)
+}
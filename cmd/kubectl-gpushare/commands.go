@@ -0,0 +1,323 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	"github.com/AliyunContainerService/gpushare-scheduler-extender/pkg/scheduler"
+	"github.com/AliyunContainerService/gpushare-scheduler-extender/pkg/simulator"
+	"github.com/AliyunContainerService/gpushare-scheduler-extender/pkg/utils"
+)
+
+// runNodes shows the gpu memory of the devices and the pods on them
+func runNodes(opts *options, args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("nodes takes at most one node name")
+	}
+	selector, err := labels.Parse(opts.selector)
+	if err != nil {
+		return fmt.Errorf("invalid selector %q: %v", opts.selector, err)
+	}
+	cluster, _, err := opts.newCluster()
+	if err != nil {
+		return err
+	}
+
+	name := ""
+	if len(args) == 1 {
+		name = args[0]
+	}
+	result, err := scheduler.NewGPUShareInspect(cluster.GetSchedulerCache()).Handler(name, scheduler.InspectOptions{
+		Selector:      selector,
+		MinFreeGPU:    opts.minFree,
+		UnhealthyOnly: opts.unhealthy,
+	})
+	if err != nil {
+		return err
+	}
+
+	if opts.output == outputJSON {
+		return printJSON(result)
+	}
+	result.Print(os.Stdout)
+	return nil
+}
+
+// runPods shows where the gpu memory of the pods is allocated, of all the namespaces by default
+func runPods(opts *options, args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("pods takes at most one NAMESPACE or NAMESPACE/NAME")
+	}
+	cluster, _, err := opts.newCluster()
+	if err != nil {
+		return err
+	}
+	inspect := scheduler.NewGPUShareInspect(cluster.GetSchedulerCache())
+
+	allocations := []*scheduler.PodAllocation{}
+	switch {
+	case len(args) == 0:
+		allocations = inspect.PodsHandler(metav1.NamespaceAll)
+	case strings.Contains(args[0], "/"):
+		namespace, name, _ := strings.Cut(args[0], "/")
+		allocation := inspect.PodHandler(namespace, name)
+		if allocation == nil {
+			return fmt.Errorf("pod %s has no gpu memory allocated", args[0])
+		}
+		allocations = append(allocations, allocation)
+	default:
+		allocations = inspect.PodsHandler(args[0])
+	}
+
+	if opts.output == outputJSON {
+		return printJSON(allocations)
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAMESPACE\tNAME\tNODE\tDEVICE\tGPU-MEM\tASSIGNED\tNEIGHBORS")
+	for _, allocation := range allocations {
+		neighbors := []string{}
+		for _, pod := range allocation.Neighbors {
+			neighbors = append(neighbors, fmt.Sprintf("%s/%s(%d)", pod.Namespace, pod.Name, pod.UsedGPU))
+		}
+		if len(neighbors) == 0 {
+			neighbors = append(neighbors, "<none>")
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%d\t%t\t%s\n",
+			allocation.Namespace,
+			allocation.Name,
+			allocation.Node,
+			allocation.Device,
+			allocation.GPUMemory,
+			allocation.Assigned,
+			strings.Join(neighbors, " "))
+	}
+	return tw.Flush()
+}
+
+// runCapacity counts the pods of each size which still fit
+func runCapacity(opts *options, args []string) error {
+	if len(args) > 0 {
+		return fmt.Errorf("capacity takes no arguments, the sizes are given by --size")
+	}
+	if len(opts.sizes) == 0 {
+		return fmt.Errorf("at least one --size should be specified")
+	}
+	selector, err := labels.Parse(opts.selector)
+	if err != nil {
+		return fmt.Errorf("invalid selector %q: %v", opts.selector, err)
+	}
+	cluster, _, err := opts.newCluster()
+	if err != nil {
+		return err
+	}
+
+	result := scheduler.NewGPUShareCapacity(cluster.GetSchedulerCache()).Handler(opts.sizes, selector)
+	if len(result.Error) > 0 {
+		return errors.New(result.Error)
+	}
+
+	if opts.output == outputJSON {
+		return printJSON(result)
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "SIZE\tCOUNT\tNODES")
+	for _, size := range result.Sizes {
+		nodes := []string{}
+		for _, node := range size.Nodes {
+			if node.Count > 0 {
+				nodes = append(nodes, fmt.Sprintf("%s:%d", node.Name, node.Count))
+			}
+		}
+		if len(nodes) == 0 {
+			nodes = append(nodes, "<none>")
+		}
+		fmt.Fprintf(tw, "%d\t%d\t%s\n", size.GPUMemory, size.Count, strings.Join(nodes, " "))
+	}
+	return tw.Flush()
+}
+
+// runSimulate schedules the pods of the files in order on the copy of the cluster
+func runSimulate(opts *options, args []string) error {
+	if len(args) > 0 {
+		return fmt.Errorf("simulate takes no arguments, the pods are given by -f")
+	}
+	if len(opts.files) == 0 {
+		return fmt.Errorf("at least one -f should be specified")
+	}
+	// the profiles come from the view of the cluster, not from the files of the pods
+	objs, _, err := simulator.LoadObjects(opts.files...)
+	if err != nil {
+		return err
+	}
+	cluster, pending, err := opts.newCluster(objs...)
+	if err != nil {
+		return err
+	}
+
+	events := []simulator.Event{}
+	for _, pod := range pending {
+		events = append(events, simulator.Event{Action: simulator.ActionAdd, Pod: pod})
+	}
+	report, err := cluster.Report(cluster.Replay(events))
+	if err != nil {
+		return err
+	}
+
+	if opts.output == outputJSON {
+		return printJSON(report)
+	}
+	report.Print(os.Stdout)
+	return nil
+}
+
+// Explanation is why the pod fits or not on each node
+type Explanation struct {
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	GPUMemory int    `json:"gpuMemory"`
+	// Allocation is set if the pod is already allocated
+	Allocation *scheduler.PodAllocation `json:"allocation,omitempty"`
+	Nodes      []*NodeExplanation       `json:"nodes,omitempty"`
+}
+
+type NodeExplanation struct {
+	Name string `json:"name"`
+	Fit  bool   `json:"fit"`
+	// Devices are the devices which fit the pod
+	Devices []int  `json:"devices,omitempty"`
+	Reason  string `json:"reason,omitempty"`
+}
+
+// runExplain runs the extender's filter of a pod on every node
+func runExplain(opts *options, args []string) error {
+	pod, err := opts.explainedPod(args)
+	if err != nil {
+		return err
+	}
+	if !utils.IsGPUsharingPod(pod) {
+		return fmt.Errorf("pod %s/%s doesn't request gpu memory", pod.Namespace, pod.Name)
+	}
+	cluster, _, err := opts.newCluster()
+	if err != nil {
+		return err
+	}
+
+	explanation := &Explanation{Namespace: pod.Namespace, Name: pod.Name, GPUMemory: utils.GetGPUMemoryFromPodResource(pod)}
+	explanation.Allocation = scheduler.NewGPUShareInspect(cluster.GetSchedulerCache()).PodHandler(pod.Namespace, pod.Name)
+	if explanation.Allocation == nil {
+		// the allocated pod would be filtered as its own neighbor, so only the pending one is filtered
+		filterResult := cluster.Filter(pod)
+		if len(filterResult.Error) > 0 {
+			return errors.New(filterResult.Error)
+		}
+		if filterResult.NodeNames != nil {
+			for _, name := range *filterResult.NodeNames {
+				node := &NodeExplanation{Name: name, Fit: true}
+				if info, err := cluster.GetSchedulerCache().GetNodeInfo(name); err == nil {
+					for id, availableGPU := range info.GetAvailableGPUsFor(uint(explanation.GPUMemory)) {
+						if availableGPU >= uint(explanation.GPUMemory) {
+							node.Devices = append(node.Devices, id)
+						}
+					}
+					sort.Ints(node.Devices)
+				}
+				explanation.Nodes = append(explanation.Nodes, node)
+			}
+		}
+		for name, reason := range filterResult.FailedNodes {
+			explanation.Nodes = append(explanation.Nodes, &NodeExplanation{Name: name, Reason: reason})
+		}
+		sort.Slice(explanation.Nodes, func(i, j int) bool {
+			return explanation.Nodes[i].Name < explanation.Nodes[j].Name
+		})
+	}
+
+	if opts.output == outputJSON {
+		return printJSON(explanation)
+	}
+	explanation.Print()
+	return nil
+}
+
+func (e *Explanation) Print() {
+	fmt.Printf("Pod %s/%s requests %d GPU memory\n", e.Namespace, e.Name, e.GPUMemory)
+	if e.Allocation != nil {
+		fmt.Printf("It is allocated on node %s device %d\n", e.Allocation.Node, e.Allocation.Device)
+		return
+	}
+
+	fit := 0
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "NODE\tFIT\tDEVICES/REASON")
+	for _, node := range e.Nodes {
+		if node.Fit {
+			fit++
+			fmt.Fprintf(tw, "%s\tyes\t%v\n", node.Name, node.Devices)
+			continue
+		}
+		fmt.Fprintf(tw, "%s\tno\t%s\n", node.Name, node.Reason)
+	}
+	tw.Flush()
+	fmt.Printf("%d/%d nodes fit the pod\n", fit, len(e.Nodes))
+}
+
+// explainedPod reads the pod from the file, or gets it from the API server by [NAMESPACE/]NAME
+func (opts *options) explainedPod(args []string) (*v1.Pod, error) {
+	if len(opts.files) > 0 {
+		if len(args) > 0 {
+			return nil, fmt.Errorf("explain takes either -f or the pod name")
+		}
+		objs, _, err := simulator.LoadObjects(opts.files...)
+		if err != nil {
+			return nil, err
+		}
+		pods := podsOf(objs)
+		if len(pods) != 1 {
+			return nil, fmt.Errorf("explain takes exactly one pod, but %d are given", len(pods))
+		}
+		if len(pods[0].Namespace) == 0 {
+			pods[0].Namespace = metav1.NamespaceDefault
+		}
+		return pods[0], nil
+	}
+
+	if len(args) != 1 {
+		return nil, fmt.Errorf("explain takes a pod by NAMESPACE/NAME, NAME or -f")
+	}
+	namespace, name, found := strings.Cut(args[0], "/")
+	if !found {
+		var err error
+		name = namespace
+		namespace, _, err = opts.clientConfig().Namespace()
+		if err != nil {
+			return nil, err
+		}
+	}
+	clientset, err := opts.clientset()
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+	return clientset.CoreV1().Pods(namespace).Get(ctx, name, metav1.GetOptions{})
+}
+
+func podsOf(objs []runtime.Object) []*v1.Pod {
+	pods := []*v1.Pod{}
+	for _, obj := range objs {
+		if pod, ok := obj.(*v1.Pod); ok {
+			pods = append(pods, pod)
+		}
+	}
+	return pods
+}
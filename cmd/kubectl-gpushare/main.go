@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+
+	"github.com/AliyunContainerService/gpushare-scheduler-extender/pkg/log"
+)
+
+const usage = `kubectl gpushare inspects the GPU share of the cluster and simulates scheduling on it.
+
+The view of the cluster is read from the extender's snapshot API with --extender,
+or listed from the API server of the kubeconfig otherwise. The API server is read with
+the default profile, or with the profiles of the extender's configuration with --config.
+
+Usage:
+  kubectl gpushare nodes [NODE] [--selector=...] [--min-free=N] [--unhealthy]
+  kubectl gpushare pods [NAMESPACE[/NAME]]
+  kubectl gpushare capacity --size=N [--size=N ...] [--selector=...]
+  kubectl gpushare simulate -f PODS_FILE [-f ...] [--strategy=binpack|spread]
+  kubectl gpushare explain (NAMESPACE/NAME | -f POD_FILE)
+
+Options of all the commands:
+  --kubeconfig                 the kubeconfig file, KUBECONFIG and ~/.kube/config are used by default
+  --config                     the configuration file of the extender, for its profiles without --extender
+  --extender                   the URL of the extender's admin listener, e.g. https://localhost:12346
+  --token                      the bearer token for the extender
+  --insecure-skip-tls-verify   skip verifying the certificate of the extender
+  -o, --output                 table or json
+  -v                           log level of the extender code
+`
+
+// commands run with the view of the cluster and the arguments after the flags
+var commands = map[string]func(opts *options, args []string) error{
+	"nodes":    runNodes,
+	"pods":     runPods,
+	"capacity": runCapacity,
+	"simulate": runSimulate,
+	"explain":  runExplain,
+}
+
+// kubectl-gpushare is the kubectl plugin of the extender, it runs the extender's code on a
+// copy of the cluster, so what it shows matches how the extender schedules.
+func main() {
+	if len(os.Args) < 2 || os.Args[1] == "-h" || os.Args[1] == "--help" || os.Args[1] == "help" {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	run, found := commands[os.Args[1]]
+	if !found {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s", os.Args[1], usage)
+		os.Exit(2)
+	}
+
+	opts := &options{}
+	fs := flag.NewFlagSet(os.Args[1], flag.ContinueOnError)
+	fs.Usage = func() { fmt.Fprint(os.Stderr, usage) }
+	opts.addFlags(fs)
+	args, err := parseInterspersed(fs, os.Args[2:])
+	if err != nil {
+		os.Exit(2)
+	}
+
+	// the logs of the extender code go to stderr, so the output stays parsable
+	log.NewLoggerWithLevel(int32(opts.logLevel), zap.WrapCore(func(zapcore.Core) zapcore.Core {
+		return zapcore.NewCore(zapcore.NewConsoleEncoder(zap.NewDevelopmentEncoderConfig()), zapcore.Lock(os.Stderr), zapcore.DebugLevel)
+	}))
+
+	if opts.output != outputTable && opts.output != outputJSON {
+		exit(fmt.Errorf("invalid output %q, it should be %s or %s", opts.output, outputTable, outputJSON))
+	}
+	if err := run(opts, args); err != nil {
+		exit(err)
+	}
+}
+
+// parseInterspersed parses the flags before and after the positional arguments, as kubectl does
+func parseInterspersed(fs *flag.FlagSet, args []string) ([]string, error) {
+	positional := []string{}
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		if fs.NArg() == 0 {
+			return positional, nil
+		}
+		positional = append(positional, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+}
+
+func printJSON(v interface{}) error {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
+
+func exit(err error) {
+	fmt.Fprintln(os.Stderr, err)
+	os.Exit(1)
+}
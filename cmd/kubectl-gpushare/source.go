@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+
+	"github.com/AliyunContainerService/gpushare-scheduler-extender/pkg/cache"
+	"github.com/AliyunContainerService/gpushare-scheduler-extender/pkg/config"
+	"github.com/AliyunContainerService/gpushare-scheduler-extender/pkg/simulator"
+	"github.com/AliyunContainerService/gpushare-scheduler-extender/pkg/utils"
+)
+
+const (
+	outputTable = "table"
+	outputJSON  = "json"
+
+	snapshotPath   = "/gpushare-scheduler/snapshot"
+	requestTimeout = 30 * time.Second
+)
+
+type options struct {
+	kubeconfig            string
+	config                string
+	extender              string
+	token                 string
+	insecureSkipTLSVerify bool
+	output                string
+	logLevel              int
+
+	selector  string
+	minFree   uint
+	unhealthy bool
+	sizes     sizeList
+	files     stringList
+	strategy  string
+}
+
+func (opts *options) addFlags(fs *flag.FlagSet) {
+	fs.StringVar(&opts.kubeconfig, "kubeconfig", "", "the kubeconfig file")
+	fs.StringVar(&opts.config, "config", "", "the configuration file of the extender, for its profiles without --extender")
+	fs.StringVar(&opts.extender, "extender", "", "the URL of the extender's admin listener")
+	fs.StringVar(&opts.token, "token", "", "the bearer token for the extender")
+	fs.BoolVar(&opts.insecureSkipTLSVerify, "insecure-skip-tls-verify", false, "skip verifying the certificate of the extender")
+	fs.StringVar(&opts.output, "output", outputTable, "table or json")
+	fs.StringVar(&opts.output, "o", outputTable, "table or json")
+	fs.IntVar(&opts.logLevel, "v", 0, "log level of the extender code")
+
+	fs.StringVar(&opts.selector, "selector", "", "the label selector of the nodes")
+	fs.StringVar(&opts.selector, "l", "", "the label selector of the nodes")
+	fs.UintVar(&opts.minFree, "min-free", 0, "the nodes with at least the free gpu memory on the healthy devices")
+	fs.BoolVar(&opts.unhealthy, "unhealthy", false, "the nodes with any unhealthy device")
+	fs.Var(&opts.sizes, "size", "the gpu memory of a pod, repeated for several sizes")
+	fs.Var(&opts.files, "filename", "YAML/JSON file of the pods, repeated for several files")
+	fs.Var(&opts.files, "f", "YAML/JSON file of the pods, repeated for several files")
+	fs.StringVar(&opts.strategy, "strategy", "binpack", "how to pick the node among the filtered ones: binpack or spread")
+}
+
+type sizeList []uint
+
+func (s *sizeList) String() string {
+	return fmt.Sprint([]uint(*s))
+}
+
+func (s *sizeList) Set(value string) error {
+	size, err := strconv.ParseUint(value, 10, 32)
+	if err != nil || size == 0 {
+		return fmt.Errorf("it should be a positive gpu memory")
+	}
+	*s = append(*s, uint(size))
+	return nil
+}
+
+type stringList []string
+
+func (s *stringList) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringList) Set(value string) error {
+	*s = append(*s, value)
+	return nil
+}
+
+func (opts *options) clientConfig() clientcmd.ClientConfig {
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	rules.ExplicitPath = opts.kubeconfig
+	return clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, &clientcmd.ConfigOverrides{})
+}
+
+func (opts *options) clientset() (kubernetes.Interface, error) {
+	restConfig, err := opts.clientConfig().ClientConfig()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load kubeconfig: %v", err)
+	}
+	return kubernetes.NewForConfig(restConfig)
+}
+
+// newCluster builds the in-memory cluster from the view of the cluster and the objects.
+// The pods without nodeName are returned to be scheduled.
+func (opts *options) newCluster(objs ...runtime.Object) (*simulator.Cluster, []*v1.Pod, error) {
+	strategy, err := simulator.GetStrategy(opts.strategy)
+	if err != nil {
+		return nil, nil, err
+	}
+	view, err := opts.loadObjects()
+	if err != nil {
+		return nil, nil, err
+	}
+	return simulator.NewCluster(append(view, objs...), strategy)
+}
+
+// loadObjects reads the nodes, the unhealthy GPU configmaps and the allocated pods
+func (opts *options) loadObjects() ([]runtime.Object, error) {
+	if len(opts.extender) > 0 {
+		return opts.loadFromExtender()
+	}
+	return opts.loadFromAPIServer()
+}
+
+func (opts *options) loadFromExtender() ([]runtime.Object, error) {
+	client := &http.Client{
+		Timeout:   requestTimeout,
+		Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: opts.insecureSkipTLSVerify}},
+	}
+	req, err := http.NewRequest(http.MethodGet, strings.TrimSuffix(opts.extender, "/")+snapshotPath, nil)
+	if err != nil {
+		return nil, err
+	}
+	if len(opts.token) > 0 {
+		req.Header.Set("Authorization", "Bearer "+opts.token)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the snapshot from the extender: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("failed to get the snapshot from the extender: %s %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	snapshot := &cache.Snapshot{}
+	if err := json.NewDecoder(resp.Body).Decode(snapshot); err != nil {
+		return nil, fmt.Errorf("failed to decode the snapshot from the extender: %v", err)
+	}
+	objs, err := snapshot.Objects()
+	if err != nil {
+		return nil, err
+	}
+	// the cluster reads the annotations of the extender's profiles
+	utils.SetProfiles(snapshot.Profiles...)
+	return objs, nil
+}
+
+func (opts *options) loadFromAPIServer() ([]runtime.Object, error) {
+	if len(opts.config) > 0 {
+		cfg, err := config.Load(opts.config)
+		if err != nil {
+			return nil, err
+		}
+		utils.SetProfiles(cfg.GetProfiles()...)
+		cache.ConfigMapNamespace = cfg.ConfigMapNamespace
+	}
+	clientset, err := opts.clientset()
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+
+	objs := []runtime.Object{}
+	nodes, err := clientset.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list nodes: %v", err)
+	}
+	for i := range nodes.Items {
+		objs = append(objs, &nodes.Items[i])
+	}
+
+	configMaps, err := clientset.CoreV1().ConfigMaps(cache.ConfigMapNamespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list configmaps: %v", err)
+	}
+	for i := range configMaps.Items {
+		if strings.HasPrefix(configMaps.Items[i].Name, cache.UnhealthyConfigMapPrefix) {
+			objs = append(objs, &configMaps.Items[i])
+		}
+	}
+
+	pods, err := clientset.CoreV1().Pods(metav1.NamespaceAll).List(ctx, metav1.ListOptions{FieldSelector: "spec.nodeName!="})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list pods: %v", err)
+	}
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		if utils.IsGPUsharingPod(pod) && utils.AssignedNonTerminatedPod(pod) {
+			objs = append(objs, pod)
+		}
+	}
+	return objs, nil
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"flag"
+	"fmt"
 	"github.com/AliyunContainerService/gpushare-scheduler-extender/pkg/log"
 	"net/http"
 	"os"
@@ -10,23 +11,40 @@ import (
 	"strconv"
 	"time"
 
+	"github.com/AliyunContainerService/gpushare-scheduler-extender/pkg/auth"
+	"github.com/AliyunContainerService/gpushare-scheduler-extender/pkg/cache"
+	"github.com/AliyunContainerService/gpushare-scheduler-extender/pkg/certs"
+	"github.com/AliyunContainerService/gpushare-scheduler-extender/pkg/config"
+	"github.com/AliyunContainerService/gpushare-scheduler-extender/pkg/defrag"
 	"github.com/AliyunContainerService/gpushare-scheduler-extender/pkg/gpushare"
+	"github.com/AliyunContainerService/gpushare-scheduler-extender/pkg/leader"
+	"github.com/AliyunContainerService/gpushare-scheduler-extender/pkg/nodeshare"
 	"github.com/AliyunContainerService/gpushare-scheduler-extender/pkg/routes"
 	"github.com/AliyunContainerService/gpushare-scheduler-extender/pkg/scheduler"
+	"github.com/AliyunContainerService/gpushare-scheduler-extender/pkg/tracing"
+	"github.com/AliyunContainerService/gpushare-scheduler-extender/pkg/utils"
 	"github.com/AliyunContainerService/gpushare-scheduler-extender/pkg/utils/signals"
 	"github.com/julienschmidt/httprouter"
 
+	"k8s.io/client-go/dynamic"
 	kubeinformers "k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
+	clientgocache "k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/tools/clientcmd"
 )
 
-const RecommendedKubeConfigPathEnv = "KUBECONFIG"
+const (
+	RecommendedKubeConfigPathEnv = "KUBECONFIG"
+
+	// certReloadInterval is how often the certificate files are checked for rotation
+	certReloadInterval = time.Minute
+)
 
 var (
-	clientset    *kubernetes.Clientset
-	resyncPeriod = 30 * time.Second
-	clientConfig clientcmd.ClientConfig
+	clientset     *kubernetes.Clientset
+	dynamicClient dynamic.Interface
+	clientConfig  clientcmd.ClientConfig
+	configFile    = flag.String("config", "", "path of the configuration file, the legacy environment variables are used without it")
 )
 
 func initKubeClient() {
@@ -40,80 +58,253 @@ func initKubeClient() {
 	// Get kubernetes config.
 	restConfig, err := clientcmd.BuildConfigFromFlags("", kubeConfig)
 	if err != nil {
-		log.Fatal("Error building kubeconfig: %s", err.Error())
+		log.Fatal("failed to build kubeconfig", log.Err(err))
 	}
 
 	// create the clientset
 	clientset, err = kubernetes.NewForConfig(restConfig)
 	if err != nil {
-		log.Fatal("fatal: Failed to init rest config due to %v", err)
+		log.Fatal("failed to init rest config", log.Err(err))
+	}
+	dynamicClient, err = dynamic.NewForConfig(restConfig)
+	if err != nil {
+		log.Fatal("failed to init dynamic client", log.Err(err))
 	}
 }
 
 func main() {
 
-	// Call Parse() to avoid noisy logs
-	flag.CommandLine.Parse([]string{})
+	// --config replaces the configuration from the environment, so the flags are parsed first
+	flag.Parse()
 	ctx := context.Background()
 
-	var logLevel int32 = 10
-	switch os.Getenv("LOG_LEVEL") {
-	case "debug":
-		logLevel = 101
-	case "info":
-		logLevel = 50
-	case "warn":
-		logLevel = 10
-	case "error":
-		logLevel = 5
+	cfg := config.FromEnv()
+	if len(*configFile) > 0 {
+		var err error
+		cfg, err = config.Load(*configFile)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	} else if err := cfg.Validate(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
 	}
-	log.NewLoggerWithLevel(logLevel)
 
-	threadness := StringToInt(os.Getenv("THREADNESS"))
+	// The level can be changed at runtime by /debug/loglevel
+	if err := log.NewLogger(cfg.GetLogLevel(), cfg.Log.Format); err != nil {
+		panic(err)
+	}
+	applyConfig(cfg)
 
-	initKubeClient()
-	port := os.Getenv("PORT")
-	if _, err := strconv.Atoi(port); err != nil {
-		port = "39999"
+	threadness := cfg.Threadness
+	if threadness == 0 {
+		threadness = runtime.NumCPU()
 	}
 
+	// The otlp exporter is configured by the OTEL_EXPORTER_OTLP_* env
+	shutdownTracing, err := tracing.Setup(ctx, cfg.Tracing.Exporter)
+	if err != nil {
+		log.Fatal("failed to set up tracing", log.Err(err))
+	}
+	defer shutdownTracing(ctx)
+
+	initKubeClient()
+
 	// Set up signals so we handle the first shutdown signal gracefully.
 	stopCh := signals.SetupSignalHandler()
+	if len(*configFile) > 0 {
+		config.WatchReload(*configFile, cfg, applyHotReload, stopCh)
+	}
 
-	informerFactory := kubeinformers.NewSharedInformerFactory(clientset, resyncPeriod)
+	informerFactory := kubeinformers.NewSharedInformerFactory(clientset, cfg.ResyncPeriod.Duration)
 	controller, err := gpushare.NewController(clientset, informerFactory, stopCh)
 	if err != nil {
-		log.Fatal("Failed to start due to %v", err)
+		log.Fatal("failed to start", log.Err(err))
 	}
-	err = controller.BuildCache()
-	if err != nil {
-		log.Fatal("Failed to start due to %v", err)
+
+	// Evicting the pods on unhealthy GPUs is opt-in, "dryrun" only reports what would be evicted
+	var evictor *gpushare.Evictor
+	switch cfg.UnhealthyGPUEviction {
+	case config.EvictionEnabled, config.EvictionDryRun:
+		evictor = gpushare.NewEvictor(clientset,
+			informerFactory,
+			controller.GetSchedulerCache(),
+			controller.GetRecorder(),
+			cfg.UnhealthyGPUEviction == config.EvictionDryRun)
 	}
 
-	go controller.Run(threadness, stopCh)
+	// The defrag planner respects the PodDisruptionBudgets, and the executor is opt-in
+	pdbInformer := informerFactory.Policy().V1().PodDisruptionBudgets()
+	planner := defrag.NewPlanner(controller.GetSchedulerCache(), pdbInformer.Lister())
+	var executor *defrag.Executor
+	if cfg.Defrag.Executor {
+		executor = defrag.NewExecutor(clientset, controller.GetSchedulerCache(), controller.GetRecorder(), cfg.Defrag.ReserveTTL.Duration)
+	}
+	var publisher *nodeshare.Publisher
+	if cfg.NodeShare.Enabled {
+		publisher = nodeshare.NewPublisher(dynamicClient, controller.GetSchedulerCache(), cfg.NodeShare.QPS, cfg.NodeShare.Burst, cfg.ResyncPeriod.Duration)
+	}
+	informerFactory.Start(stopCh)
 
-	gpusharePredicate := scheduler.NewGPUsharePredicate(clientset, controller.GetSchedulerCache())
-	gpushareBind := scheduler.NewGPUShareBind(ctx, clientset, controller.GetSchedulerCache())
+	gpusharePredicate := scheduler.NewGPUsharePredicate(clientset, controller.GetSchedulerCache(), controller.GetRecorder())
+	gpushareBind := scheduler.NewGPUShareBind(clientset, controller.GetSchedulerCache(), controller.GetRecorder())
 	gpushareInspect := scheduler.NewGPUShareInspect(controller.GetSchedulerCache())
+	gpushareSimulate := scheduler.NewGPUShareSimulate(controller.GetSchedulerCache())
+	gpushareCapacity := scheduler.NewGPUShareCapacity(controller.GetSchedulerCache())
 
+	// The scheduler port only serves kube-scheduler, the admin routes have their own authenticated listener with admin.port
+	var isLeader func() bool
+	if cfg.LeaderElection.Enabled {
+		isLeader = leader.IsLeader
+	}
+	health := routes.NewHealth(controller, isLeader)
 	router := httprouter.New()
-
-	routes.AddPProf(router)
-	routes.AddVersion(router)
+	routes.AddHealth(router, health)
 	routes.AddPredicate(router, gpusharePredicate)
 	routes.AddBind(router, gpushareBind)
-	routes.AddInspect(router, gpushareInspect)
 
-	log.V(3).Info("server starting on the port :%s", port)
-	if err := http.ListenAndServe(":"+port, router); err != nil {
-		log.Fatal("server listen fail %+v", err)
+	adminRouter := httprouter.New()
+	routes.AddPProf(adminRouter)
+	routes.AddLogLevel(adminRouter)
+	routes.AddMetrics(adminRouter, controller.GetSchedulerCache())
+	routes.AddVersion(adminRouter)
+	routes.AddInspect(adminRouter, gpushareInspect)
+	routes.AddPods(adminRouter, gpushareInspect)
+	routes.AddDefrag(adminRouter, planner, executor)
+	routes.AddSimulate(adminRouter, gpushareSimulate)
+	routes.AddCapacity(adminRouter, gpushareCapacity)
+	routes.AddSnapshot(adminRouter, controller.GetSchedulerCache())
+	routes.AddWatch(adminRouter, controller.GetSchedulerCache())
+
+	var reloader *certs.Reloader
+	if cfg.Server.TLS.Enabled() {
+		tlsConfig := cfg.Server.TLS
+		reloader, err = certs.NewReloader(tlsConfig.CertFile, tlsConfig.KeyFile, tlsConfig.ClientCAFile, tlsConfig.AllowedClientNames)
+		if err != nil {
+			log.Fatal("failed to load the certificates", log.Err(err))
+		}
+		go reloader.Run(certReloadInterval, stopCh)
+	}
+
+	if cfg.Admin.Enabled() {
+		adminServer := &http.Server{
+			Addr:    ":" + strconv.Itoa(cfg.Admin.Port),
+			Handler: auth.WithAuthentication(adminRouter, adminAuthorizer(cfg), adminAuthenticators(cfg)...),
+		}
+		go func() {
+			log.V(3).Info("admin server starting", log.Int("port", cfg.Admin.Port))
+			if err := serve(adminServer, reloader, false); err != nil {
+				log.Fatal("admin server listen fail", log.Err(err))
+			}
+		}()
+	} else {
+		log.V(3).Info("the admin routes are disabled, set admin.port and server.tls to serve them")
+	}
+
+	// The server starts before the caches are synced so that the probes can tell,
+	// the other requests are rejected until the cache is built.
+	// The probes are also served without a client certificate.
+	var handler http.Handler = router
+	if reloader != nil {
+		handler = reloader.WithClientCert(router, routes.HealthPaths()...)
 	}
+	server := &http.Server{
+		Addr:         ":" + strconv.Itoa(cfg.Server.Port),
+		Handler:      routes.WaitForReady(handler, health),
+		ReadTimeout:  cfg.Server.ReadTimeout.Duration,
+		WriteTimeout: cfg.Server.WriteTimeout.Duration,
+	}
+	go func() {
+		log.V(3).Info("server starting", log.Int("port", cfg.Server.Port), log.Bool("tls", reloader != nil))
+		if err := serve(server, reloader, true); err != nil {
+			log.Fatal("server listen fail", log.Err(err))
+		}
+	}()
+
+	if err := controller.WaitForCacheSync(stopCh); err != nil {
+		log.Fatal("failed to start", log.Err(err))
+	}
+	if ok := clientgocache.WaitForCacheSync(stopCh, pdbInformer.Informer().HasSynced); !ok {
+		log.Fatal("failed to wait for pdb caches to sync")
+	}
+	if err := controller.BuildCache(); err != nil {
+		log.Fatal("failed to start", log.Err(err))
+	}
+
+	go controller.Run(threadness, stopCh)
+	if evictor != nil {
+		go evictor.Run(1, stopCh)
+	}
+	if publisher != nil {
+		go publisher.Run(1, stopCh)
+	}
+
+	// The followers keep the cache warm, the new leader reads the pods from the API server before binding.
+	// The pod of the leader is labeled for the leader Service, POD_NAME and POD_NAMESPACE are set by the downward API.
+	if le := cfg.LeaderElection; le.Enabled {
+		go func() {
+			err := leader.Run(clientset, leader.Config{
+				Namespace:     le.LeaseNamespace,
+				Name:          le.LeaseName,
+				LeaseDuration: le.LeaseDuration.Duration,
+				RenewDeadline: le.RenewDeadline.Duration,
+				RetryPeriod:   le.RetryPeriod.Duration,
+				PodName:       os.Getenv("POD_NAME"),
+				PodNamespace:  os.Getenv("POD_NAMESPACE"),
+			}, controller.Resync, stopCh)
+			if err != nil {
+				log.Fatal("failed to run leader election", log.Err(err))
+			}
+		}()
+	}
+
+	<-stopCh
+	log.V(3).Info("shutting down")
 }
 
-func StringToInt(sThread string) int {
-	thread := runtime.NumCPU()
-	if threadInt, err := strconv.Atoi(sThread); err == nil {
-		thread = threadInt
+// serve listens with HTTPS if the reloader is not nil, the client certificates are verified if verifyClients
+func serve(server *http.Server, reloader *certs.Reloader, verifyClients bool) error {
+	if reloader == nil {
+		return server.ListenAndServe()
 	}
-	return thread
+	server.TLSConfig = reloader.TLSConfig(verifyClients)
+	return server.ListenAndServeTLS("", "")
+}
+
+// adminAuthenticators tries the static tokens before TokenReview, so the local tokens don't call the API server
+func adminAuthenticators(cfg *config.Configuration) []auth.Authenticator {
+	authenticators := []auth.Authenticator{}
+	if len(cfg.Admin.TokenFile) > 0 {
+		tokenFile, err := auth.NewTokenFile(cfg.Admin.TokenFile)
+		if err != nil {
+			log.Fatal("failed to load the token file", log.Err(err))
+		}
+		authenticators = append(authenticators, tokenFile)
+	}
+	if cfg.Admin.TokenReview {
+		authenticators = append(authenticators, auth.NewTokenReview(clientset))
+	}
+	return authenticators
+}
+
+func adminAuthorizer(cfg *config.Configuration) auth.Authorizer {
+	if cfg.Admin.TokenReview {
+		return auth.NewSubjectAccessReview(clientset)
+	}
+	return auth.AlwaysAllow{}
+}
+
+// applyConfig sets the names and the settings which are read by the packages, before anything starts
+func applyConfig(cfg *config.Configuration) {
+	utils.SetProfiles(cfg.GetProfiles()...)
+	cache.ConfigMapNamespace = cfg.ConfigMapNamespace
+	applyHotReload(cfg)
+}
+
+// applyHotReload sets the settings which can be changed at runtime
+func applyHotReload(cfg *config.Configuration) {
+	log.SetLevel(cfg.GetLogLevel())
+	cache.SetDeviceStrategy(cfg.Strategy)
+	scheduler.SetBindTimeout(cfg.Timeouts.Bind.Duration)
 }
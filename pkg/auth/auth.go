@@ -0,0 +1,153 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/AliyunContainerService/gpushare-scheduler-extender/pkg/log"
+)
+
+// cacheTTL is how long the results of the reviews are reused, so a scraper doesn't call the API server every time
+const cacheTTL = time.Minute
+
+// User is the caller of the request
+type User struct {
+	Name   string
+	UID    string
+	Groups []string
+	Extra  map[string][]string
+}
+
+// Authenticator finds the user of a bearer token, nil if the token is unknown
+type Authenticator interface {
+	Authenticate(ctx context.Context, token string) (*User, error)
+}
+
+// Authorizer decides if the user can call the path with the verb, and the reason of a denial
+type Authorizer interface {
+	Authorize(ctx context.Context, user *User, verb, path string) (bool, string, error)
+}
+
+// WithAuthentication rejects the requests without a valid bearer token, or which are not authorized.
+// The authenticators are tried in order. The requests without TLS are refused, so the tokens are not
+// accepted in plain text.
+func WithAuthentication(handler http.Handler, authorizer Authorizer, authenticators ...Authenticator) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS == nil {
+			http.Error(w, "HTTPS is required", http.StatusForbidden)
+			return
+		}
+		token := bearerToken(r)
+		if len(token) == 0 {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		var user *User
+		for _, authenticator := range authenticators {
+			var err error
+			user, err = authenticator.Authenticate(r.Context(), token)
+			if err != nil {
+				log.V(3).Warn("failed to authenticate the request", log.String("path", r.URL.Path), log.Err(err))
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				return
+			}
+			if user != nil {
+				break
+			}
+		}
+		if user == nil {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		verb := requestVerb(r)
+		allowed, reason, err := authorizer.Authorize(r.Context(), user, verb, r.URL.Path)
+		if err != nil {
+			log.V(3).Warn("failed to authorize the request", log.String("user", user.Name), log.String("path", r.URL.Path), log.Err(err))
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		if !allowed {
+			log.V(4).Info("forbidden request", log.String("user", user.Name), log.String("verb", verb), log.String("path", r.URL.Path), log.String("reason", reason))
+			http.Error(w, fmt.Sprintf("Forbidden (user=%s, verb=%s, path=%s)", user.Name, verb, r.URL.Path), http.StatusForbidden)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
+
+func bearerToken(r *http.Request) string {
+	parts := strings.SplitN(r.Header.Get("Authorization"), " ", 2)
+	if len(parts) != 2 || !strings.EqualFold(parts[0], "bearer") {
+		return ""
+	}
+	return strings.TrimSpace(parts[1])
+}
+
+// requestVerb maps the method to the verb of the non-resource urls in RBAC
+func requestVerb(r *http.Request) string {
+	switch r.Method {
+	case http.MethodPost:
+		return "create"
+	case http.MethodPut:
+		return "update"
+	case http.MethodPatch:
+		return "patch"
+	case http.MethodDelete:
+		return "delete"
+	case http.MethodHead:
+		return "head"
+	default:
+		return "get"
+	}
+}
+
+// AlwaysAllow authorizes all the authenticated users
+type AlwaysAllow struct{}
+
+func (AlwaysAllow) Authorize(context.Context, *User, string, string) (bool, string, error) {
+	return true, "", nil
+}
+
+// resultCache keeps the results of the reviews until they expire, keyed by a hash so the tokens are not kept
+type resultCache struct {
+	lock    sync.Mutex
+	entries map[[sha256.Size]byte]cacheEntry
+}
+
+type cacheEntry struct {
+	value   interface{}
+	expires time.Time
+}
+
+func newResultCache() *resultCache {
+	return &resultCache{entries: map[[sha256.Size]byte]cacheEntry{}}
+}
+
+func (c *resultCache) get(key string) (interface{}, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	entry, found := c.entries[sha256.Sum256([]byte(key))]
+	if !found || time.Now().After(entry.expires) {
+		return nil, false
+	}
+	return entry.value, true
+}
+
+func (c *resultCache) set(key string, value interface{}) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	now := time.Now()
+	for k, entry := range c.entries {
+		if now.After(entry.expires) {
+			delete(c.entries, k)
+		}
+	}
+	c.entries[sha256.Sum256([]byte(key))] = cacheEntry{value: value, expires: now.Add(cacheTTL)}
+}
@@ -0,0 +1,305 @@
+package auth
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/AliyunContainerService/gpushare-scheduler-extender/pkg/log"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func init() {
+	log.NewLoggerWithLevel(0)
+}
+
+type fakeAuthenticator struct {
+	tokens map[string]*User
+	err    error
+}
+
+func (f fakeAuthenticator) Authenticate(_ context.Context, token string) (*User, error) {
+	return f.tokens[token], f.err
+}
+
+type fakeAuthorizer struct {
+	allowed map[string]bool
+	err     error
+}
+
+func (f fakeAuthorizer) Authorize(_ context.Context, user *User, verb, path string) (bool, string, error) {
+	return f.allowed[user.Name+" "+verb+" "+path], "", f.err
+}
+
+func TestWithAuthentication(t *testing.T) {
+	alice := &User{Name: "alice"}
+	tests := []struct {
+		name           string
+		noTLS          bool
+		method         string
+		header         string
+		authenticators []Authenticator
+		authorizer     Authorizer
+		wantCode       int
+	}{
+		{
+			name:           "plain HTTP is refused",
+			noTLS:          true,
+			header:         "Bearer alice-token",
+			authenticators: []Authenticator{fakeAuthenticator{tokens: map[string]*User{"alice-token": alice}}},
+			authorizer:     AlwaysAllow{},
+			wantCode:       http.StatusForbidden,
+		},
+		{
+			name:           "missing token",
+			authenticators: []Authenticator{fakeAuthenticator{tokens: map[string]*User{"alice-token": alice}}},
+			authorizer:     AlwaysAllow{},
+			wantCode:       http.StatusUnauthorized,
+		},
+		{
+			name:           "not a bearer token",
+			header:         "Basic alice-token",
+			authenticators: []Authenticator{fakeAuthenticator{tokens: map[string]*User{"alice-token": alice}}},
+			authorizer:     AlwaysAllow{},
+			wantCode:       http.StatusUnauthorized,
+		},
+		{
+			name:           "unknown token",
+			header:         "Bearer bob-token",
+			authenticators: []Authenticator{fakeAuthenticator{tokens: map[string]*User{"alice-token": alice}}},
+			authorizer:     AlwaysAllow{},
+			wantCode:       http.StatusUnauthorized,
+		},
+		{
+			name:           "second authenticator finds the user",
+			header:         "Bearer alice-token",
+			authenticators: []Authenticator{fakeAuthenticator{}, fakeAuthenticator{tokens: map[string]*User{"alice-token": alice}}},
+			authorizer:     AlwaysAllow{},
+			wantCode:       http.StatusOK,
+		},
+		{
+			name:           "authenticator error",
+			header:         "Bearer alice-token",
+			authenticators: []Authenticator{fakeAuthenticator{err: fmt.Errorf("unavailable")}},
+			authorizer:     AlwaysAllow{},
+			wantCode:       http.StatusInternalServerError,
+		},
+		{
+			name:           "allowed verb",
+			header:         "Bearer alice-token",
+			authenticators: []Authenticator{fakeAuthenticator{tokens: map[string]*User{"alice-token": alice}}},
+			authorizer:     fakeAuthorizer{allowed: map[string]bool{"alice get /metrics": true}},
+			wantCode:       http.StatusOK,
+		},
+		{
+			name:           "forbidden verb",
+			method:         http.MethodPost,
+			header:         "Bearer alice-token",
+			authenticators: []Authenticator{fakeAuthenticator{tokens: map[string]*User{"alice-token": alice}}},
+			authorizer:     fakeAuthorizer{allowed: map[string]bool{"alice get /metrics": true}},
+			wantCode:       http.StatusForbidden,
+		},
+		{
+			name:           "authorizer error",
+			header:         "Bearer alice-token",
+			authenticators: []Authenticator{fakeAuthenticator{tokens: map[string]*User{"alice-token": alice}}},
+			authorizer:     fakeAuthorizer{err: fmt.Errorf("unavailable")},
+			wantCode:       http.StatusInternalServerError,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			handler := WithAuthentication(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			}), test.authorizer, test.authenticators...)
+
+			method := test.method
+			if len(method) == 0 {
+				method = http.MethodGet
+			}
+			r := httptest.NewRequest(method, "/metrics", nil)
+			if !test.noTLS {
+				r.TLS = &tls.ConnectionState{}
+			}
+			if len(test.header) > 0 {
+				r.Header.Set("Authorization", test.header)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			if w.Code != test.wantCode {
+				t.Errorf("expected code %d, got %d: %s", test.wantCode, w.Code, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestNewTokenFile(t *testing.T) {
+	tests := []struct {
+		name      string
+		content   string
+		token     string
+		wantUser  *User
+		wantError bool
+	}{
+		{
+			name:     "user with groups",
+			content:  "alice-token,alice,1,\"admins,viewers\"\n",
+			token:    "alice-token",
+			wantUser: &User{Name: "alice", UID: "1", Groups: []string{"admins", "viewers"}},
+		},
+		{
+			name:     "user without groups",
+			content:  "alice-token,alice,1\n",
+			token:    "alice-token",
+			wantUser: &User{Name: "alice", UID: "1"},
+		},
+		{
+			name:    "unknown token",
+			content: "alice-token,alice,1\n",
+			token:   "bob-token",
+		},
+		{
+			name:      "missing uid",
+			content:   "alice-token,alice\n",
+			wantError: true,
+		},
+		{
+			name:      "duplicated token",
+			content:   "alice-token,alice,1\nalice-token,bob,2\n",
+			wantError: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "tokens.csv")
+			if err := os.WriteFile(path, []byte(test.content), 0600); err != nil {
+				t.Fatal(err)
+			}
+			tokenFile, err := NewTokenFile(path)
+			if (err != nil) != test.wantError {
+				t.Fatalf("expected error %v, got %v", test.wantError, err)
+			}
+			if err != nil {
+				return
+			}
+			user, err := tokenFile.Authenticate(context.Background(), test.token)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(user, test.wantUser) {
+				t.Errorf("expected user %+v, got %+v", test.wantUser, user)
+			}
+		})
+	}
+}
+
+func TestTokenReview(t *testing.T) {
+	tests := []struct {
+		name          string
+		authenticated bool
+		wantUser      *User
+	}{
+		{
+			name:          "authenticated token",
+			authenticated: true,
+			wantUser:      &User{Name: "system:serviceaccount:monitoring:prometheus", UID: "1", Groups: []string{"system:serviceaccounts"}, Extra: map[string][]string{}},
+		},
+		{
+			name: "unauthenticated token",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			clientset := fake.NewSimpleClientset()
+			reviews := 0
+			clientset.PrependReactor("create", "tokenreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+				reviews++
+				review := action.(k8stesting.CreateAction).GetObject().(*authenticationv1.TokenReview)
+				if review.Spec.Token != "token" {
+					t.Errorf("expected the token to be reviewed, got %q", review.Spec.Token)
+				}
+				review.Status.Authenticated = test.authenticated
+				if test.authenticated {
+					review.Status.User = authenticationv1.UserInfo{Username: test.wantUser.Name, UID: test.wantUser.UID, Groups: test.wantUser.Groups}
+				}
+				return true, review, nil
+			})
+
+			tokenReview := NewTokenReview(clientset)
+			// the second call is cached
+			for i := 0; i < 2; i++ {
+				user, err := tokenReview.Authenticate(context.Background(), "token")
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !reflect.DeepEqual(user, test.wantUser) {
+					t.Errorf("expected user %+v, got %+v", test.wantUser, user)
+				}
+			}
+			if reviews != 1 {
+				t.Errorf("expected 1 TokenReview, got %d", reviews)
+			}
+		})
+	}
+}
+
+func TestSubjectAccessReview(t *testing.T) {
+	tests := []struct {
+		name        string
+		allowed     bool
+		denied      bool
+		verb        string
+		path        string
+		wantAllowed bool
+	}{
+		{name: "allowed", allowed: true, verb: "get", path: "/metrics", wantAllowed: true},
+		{name: "not allowed", verb: "create", path: "/debug/loglevel"},
+		{name: "allowed but denied", allowed: true, denied: true, verb: "get", path: "/debug/pprof/"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			clientset := fake.NewSimpleClientset()
+			reviews := 0
+			clientset.PrependReactor("create", "subjectaccessreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+				reviews++
+				review := action.(k8stesting.CreateAction).GetObject().(*authorizationv1.SubjectAccessReview)
+				attrs := review.Spec.NonResourceAttributes
+				if review.Spec.User != "alice" || attrs == nil || attrs.Verb != test.verb || attrs.Path != test.path {
+					t.Errorf("unexpected review spec %+v", review.Spec)
+				}
+				review.Status.Allowed = test.allowed
+				review.Status.Denied = test.denied
+				return true, review, nil
+			})
+
+			sar := NewSubjectAccessReview(clientset)
+			// the second call is cached
+			for i := 0; i < 2; i++ {
+				allowed, _, err := sar.Authorize(context.Background(), &User{Name: "alice"}, test.verb, test.path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if allowed != test.wantAllowed {
+					t.Errorf("expected allowed %v, got %v", test.wantAllowed, allowed)
+				}
+			}
+			if reviews != 1 {
+				t.Errorf("expected 1 SubjectAccessReview, got %d", reviews)
+			}
+		})
+	}
+}
@@ -0,0 +1,93 @@
+package auth
+
+import (
+	"context"
+	"strings"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// TokenReview authenticates the tokens of the service accounts and the users by the API server
+type TokenReview struct {
+	clientset kubernetes.Interface
+	cache     *resultCache
+}
+
+func NewTokenReview(clientset kubernetes.Interface) *TokenReview {
+	return &TokenReview{clientset: clientset, cache: newResultCache()}
+}
+
+func (t *TokenReview) Authenticate(ctx context.Context, token string) (*User, error) {
+	if cached, found := t.cache.get(token); found {
+		return cached.(*User), nil
+	}
+
+	review, err := t.clientset.AuthenticationV1().TokenReviews().Create(ctx, &authenticationv1.TokenReview{
+		Spec: authenticationv1.TokenReviewSpec{Token: token},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	var user *User
+	if review.Status.Authenticated {
+		info := review.Status.User
+		user = &User{Name: info.Username, UID: info.UID, Groups: info.Groups, Extra: map[string][]string{}}
+		for k, v := range info.Extra {
+			user.Extra[k] = v
+		}
+	}
+	t.cache.set(token, user)
+	return user, nil
+}
+
+// SubjectAccessReview authorizes the users by the RBAC rules of the non-resource urls, e.g.
+//
+//	rules:
+//	- nonResourceURLs: ["/debug/*", "/metrics"]
+//	  verbs: ["get"]
+type SubjectAccessReview struct {
+	clientset kubernetes.Interface
+	cache     *resultCache
+}
+
+func NewSubjectAccessReview(clientset kubernetes.Interface) *SubjectAccessReview {
+	return &SubjectAccessReview{clientset: clientset, cache: newResultCache()}
+}
+
+type decision struct {
+	allowed bool
+	reason  string
+}
+
+func (s *SubjectAccessReview) Authorize(ctx context.Context, user *User, verb, path string) (bool, string, error) {
+	key := strings.Join([]string{user.Name, user.UID, strings.Join(user.Groups, ","), verb, path}, "\x00")
+	if cached, found := s.cache.get(key); found {
+		d := cached.(decision)
+		return d.allowed, d.reason, nil
+	}
+
+	extra := map[string]authorizationv1.ExtraValue{}
+	for k, v := range user.Extra {
+		extra[k] = v
+	}
+	review, err := s.clientset.AuthorizationV1().SubjectAccessReviews().Create(ctx, &authorizationv1.SubjectAccessReview{
+		Spec: authorizationv1.SubjectAccessReviewSpec{
+			User:                  user.Name,
+			UID:                   user.UID,
+			Groups:                user.Groups,
+			Extra:                 extra,
+			NonResourceAttributes: &authorizationv1.NonResourceAttributes{Path: path, Verb: verb},
+		},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return false, "", err
+	}
+
+	d := decision{allowed: review.Status.Allowed && !review.Status.Denied, reason: review.Status.Reason}
+	s.cache.set(key, d)
+	return d.allowed, d.reason, nil
+}
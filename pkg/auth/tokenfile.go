@@ -0,0 +1,61 @@
+package auth
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// TokenFile authenticates the static tokens for the local use, in the format of the token file of kube-apiserver:
+//
+//	token,user,uid,"group1,group2"
+type TokenFile struct {
+	tokens map[string]*User
+}
+
+func NewTokenFile(path string) (*TokenFile, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	reader := csv.NewReader(f)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	tokens := map[string]*User{}
+	for line := 1; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the token file %s: %v", path, err)
+		}
+		if len(record) < 3 || len(record[0]) == 0 || len(record[1]) == 0 {
+			return nil, fmt.Errorf("token file %s line %d should be token,user,uid[,groups]", path, line)
+		}
+		if _, found := tokens[record[0]]; found {
+			return nil, fmt.Errorf("token file %s line %d has a duplicated token", path, line)
+		}
+		user := &User{Name: record[1], UID: record[2]}
+		if len(record) > 3 && len(record[3]) > 0 {
+			user.Groups = strings.Split(record[3], ",")
+		}
+		tokens[record[0]] = user
+	}
+	return &TokenFile{tokens: tokens}, nil
+}
+
+func (t *TokenFile) Authenticate(_ context.Context, token string) (*User, error) {
+	for known, user := range t.tokens {
+		if subtle.ConstantTimeCompare([]byte(known), []byte(token)) == 1 {
+			return user, nil
+		}
+	}
+	return nil, nil
+}
@@ -11,6 +11,11 @@ import (
 	corelisters "k8s.io/client-go/listers/core/v1"
 )
 
+var (
+	cacheLog = log.Named(log.SubsystemCache)
+	bindLog  = log.Named(log.SubsystemBind)
+)
+
 type SchedulerCache struct {
 
 	// a map from pod key to podState.
@@ -22,22 +27,41 @@ type SchedulerCache struct {
 	//
 	podLister corelisters.PodLister
 
+	// configMapLister gets the unhealthy GPU configmaps of the nodes
+	configMapLister corelisters.ConfigMapLister
+
+	// profiles read the resources of the nodes and the annotations of the pods
+	profiles utils.Profiles
+
 	// record the knownPod, it will be added when annotation ALIYUN_GPU_ID is added, and will be removed when complete and deleted
 	knownPods map[types.UID]*v1.Pod
 	nLock     *sync.RWMutex
+
+	// feed streams the changes to the watchers
+	feed *changeFeed
 }
 
-func NewSchedulerCache(nLister corelisters.NodeLister, pLister corelisters.PodLister) *SchedulerCache {
+func NewSchedulerCache(nLister corelisters.NodeLister, pLister corelisters.PodLister, cmLister corelisters.ConfigMapLister) *SchedulerCache {
 	return &SchedulerCache{
-		nodes:      make(map[string]*NodeInfo),
-		nodeLister: nLister,
-		podLister:  pLister,
-		knownPods:  make(map[types.UID]*v1.Pod),
-		nLock:      new(sync.RWMutex),
+		nodes:           make(map[string]*NodeInfo),
+		nodeLister:      nLister,
+		podLister:       pLister,
+		configMapLister: cmLister,
+		profiles:        utils.GetProfiles(),
+		knownPods:       make(map[types.UID]*v1.Pod),
+		nLock:           new(sync.RWMutex),
+		feed:            newChangeFeed(),
 	}
 }
 
+// GetProfiles returns the profiles of the cache, the first one is the default
+func (cache *SchedulerCache) GetProfiles() utils.Profiles {
+	return cache.profiles
+}
+
 func (cache *SchedulerCache) GetNodeinfos() []*NodeInfo {
+	cache.nLock.RLock()
+	defer cache.nLock.RUnlock()
 	nodes := []*NodeInfo{}
 	for _, n := range cache.nodes {
 		nodes = append(nodes, n)
@@ -45,16 +69,37 @@ func (cache *SchedulerCache) GetNodeinfos() []*NodeInfo {
 	return nodes
 }
 
+// ListNodeInfos gets or builds the nodeInfos of the GPU sharing nodes which match the selector
+func (cache *SchedulerCache) ListNodeInfos(selector labels.Selector) ([]*NodeInfo, error) {
+	nodes, err := cache.nodeLister.List(selector)
+	if err != nil {
+		return nil, err
+	}
+
+	nodeInfos := []*NodeInfo{}
+	for _, node := range nodes {
+		if cache.profiles.OfNode(node).TotalGPUMemory(node) <= 0 {
+			continue
+		}
+		n, err := cache.GetNodeInfo(node.Name)
+		if err != nil {
+			return nil, err
+		}
+		nodeInfos = append(nodeInfos, n)
+	}
+	return nodeInfos, nil
+}
+
 // build cache when initializing
 func (cache *SchedulerCache) BuildCache() error {
-	log.V(5).Info("debug: begin to build scheduler cache")
+	cacheLog.V(5).Debug("begin to build scheduler cache")
 	pods, err := cache.podLister.List(labels.Everything())
 
 	if err != nil {
 		return err
 	} else {
 		for _, pod := range pods {
-			if utils.GetGPUMemoryFromPodAnnotation(pod) <= uint(0) {
+			if cache.profiles.OfPod(pod).GPUMemoryFromPodAnnotation(pod) <= uint(0) {
 				continue
 			}
 
@@ -86,11 +131,42 @@ func (cache *SchedulerCache) KnownPod(podUID types.UID) bool {
 	return found
 }
 
+// GetKnownPod returns the allocated pod with the name, nil if it's not known.
+// The latest one is returned if the pods of the same name are known, e.g. the old one is not deleted yet.
+func (cache *SchedulerCache) GetKnownPod(namespace, name string) *v1.Pod {
+	cache.nLock.RLock()
+	defer cache.nLock.RUnlock()
+
+	var known *v1.Pod
+	for _, pod := range cache.knownPods {
+		if pod.Namespace != namespace || pod.Name != name {
+			continue
+		}
+		if known == nil || known.CreationTimestamp.Before(&pod.CreationTimestamp) {
+			known = pod
+		}
+	}
+	return known
+}
+
+// ListKnownPods returns the allocated pods in the namespace, all the namespaces if it's empty
+func (cache *SchedulerCache) ListKnownPods(namespace string) []*v1.Pod {
+	cache.nLock.RLock()
+	defer cache.nLock.RUnlock()
+
+	pods := []*v1.Pod{}
+	for _, pod := range cache.knownPods {
+		if len(namespace) == 0 || pod.Namespace == namespace {
+			pods = append(pods, pod)
+		}
+	}
+	return pods
+}
+
 func (cache *SchedulerCache) AddOrUpdatePod(pod *v1.Pod) error {
-	log.V(100).Info("debug: Add or update pod info: %v", pod)
-	log.V(100).Info("debug: Node %v", cache.nodes)
+	cacheLog.V(100).Debug("add or update pod info", log.Pod(pod.Name), log.Namespace(pod.Namespace), log.Node(pod.Spec.NodeName))
 	if len(pod.Spec.NodeName) == 0 {
-		log.V(100).Info("debug: pod %s in ns %s is not assigned to any node, skip", pod.Name, pod.Namespace)
+		cacheLog.V(100).Debug("pod is not assigned to any node, skip", log.Pod(pod.Name), log.Namespace(pod.Namespace))
 		return nil
 	}
 
@@ -103,30 +179,44 @@ func (cache *SchedulerCache) AddOrUpdatePod(pod *v1.Pod) error {
 		// put it into known pod
 		cache.rememberPod(pod.UID, podCopy)
 	} else {
-		log.V(100).Info("debug: pod %s in ns %s's gpu id is %d, it's illegal, skip",
-			pod.Name,
-			pod.Namespace,
-			utils.GetGPUIDFromAnnotation(pod))
+		cacheLog.V(100).Debug("pod's gpu id is illegal, skip",
+			log.Pod(pod.Name),
+			log.Namespace(pod.Namespace),
+			log.DevID(n.profile.GPUIDFromAnnotation(pod)))
 	}
 
 	return nil
 }
 
+// AssumePod allocates a device of the node to the pod in the cache only, see NodeInfo.AssumePod
+func (cache *SchedulerCache) AssumePod(pod *v1.Pod, nodeName string) (*v1.Pod, error) {
+	n, err := cache.GetNodeInfo(nodeName)
+	if err != nil {
+		return nil, err
+	}
+	assumed, err := n.AssumePod(pod)
+	if err != nil {
+		return nil, err
+	}
+	cache.rememberPod(assumed.UID, assumed)
+	return assumed, nil
+}
+
 // The lock is in cacheNode
 func (cache *SchedulerCache) RemovePod(pod *v1.Pod) {
-	log.V(100).Info("debug: Remove pod info: %v", pod)
-	log.V(100).Info("debug: Node %v", cache.nodes)
+	cacheLog.V(100).Debug("remove pod info", log.Pod(pod.Name), log.Namespace(pod.Namespace), log.Node(pod.Spec.NodeName))
 	n, err := cache.GetNodeInfo(pod.Spec.NodeName)
 	if err == nil {
 		n.removePod(pod)
 	} else {
-		log.V(10).Info("debug: Failed to get node %s due to %v", pod.Spec.NodeName, err)
+		cacheLog.V(10).Warn("failed to get node", log.Node(pod.Spec.NodeName), log.Err(err))
 	}
 
 	cache.forgetPod(pod.UID)
 }
 
-// Get or build nodeInfo if it doesn't exist
+// Get or build nodeInfo if it doesn't exist. The nodes which are not for GPU sharing are not cached,
+// their nodeInfos have no devices and are only used to reject the pods.
 func (cache *SchedulerCache) GetNodeInfo(name string) (*NodeInfo, error) {
 	node, err := cache.nodeLister.Get(name)
 	if err != nil {
@@ -137,30 +227,32 @@ func (cache *SchedulerCache) GetNodeInfo(name string) (*NodeInfo, error) {
 	defer cache.nLock.Unlock()
 	n, ok := cache.nodes[name]
 
+	if cache.profiles.OfNode(node).TotalGPUMemory(node) <= 0 {
+		if ok {
+			cacheLog.V(3).Info("node is no longer for GPU sharing", log.Node(name))
+			delete(cache.nodes, name)
+			cache.feed.publish(&Change{Type: ChangeNode, Node: name})
+		}
+		return NewNodeInfo(node, cache.profiles, cache.configMapLister), nil
+	}
+
 	if !ok {
-		n = NewNodeInfo(node)
+		n = NewNodeInfo(node, cache.profiles, cache.configMapLister)
+		n.feed = cache.feed
 		cache.nodes[name] = n
-	} else {
-		// if the existing node turn from non gpushare to gpushare
-		// if (utils.GetTotalGPUMemory(n.node) <= 0 && utils.GetTotalGPUMemory(node) > 0) ||
-		// 	(utils.GetGPUCountInNode(n.node) <= 0 && utils.GetGPUCountInNode(node) > 0) ||
-		// 	// if the existing node turn from gpushare to non gpushare
-		// 	(utils.GetTotalGPUMemory(n.node) > 0 && utils.GetTotalGPUMemory(node) <= 0) ||
-		// 	(utils.GetGPUCountInNode(n.node) > 0 && utils.GetGPUCountInNode(node) <= 0) {
-		if len(cache.nodes[name].devs) == 0 ||
-			utils.GetTotalGPUMemory(n.node) <= 0 ||
-			utils.GetGPUCountInNode(n.node) <= 0 {
-			log.V(10).Info("info: GetNodeInfo() need update node %s",
-				name)
-
-			// fix the scenario that the number of devices changes from 0 to an positive number
-			cache.nodes[name].Reset(node)
-			log.V(10).Info("info: node: %s, labels from cache after been updated: %v", n.node.Name, n.node.Labels)
-		} else {
-			log.V(10).Info("info: GetNodeInfo() uses the existing nodeInfo for %s", name)
+		n.publishNode()
+	} else if n.changed(node) {
+		// the devices are added, removed or reordered
+		cacheLog.V(10).Info("GetNodeInfo() need update node", log.Node(name))
+		// the pods of the removed devices are forgotten, they are added back if they are allocated again
+		for _, pod := range n.Reset(node) {
+			delete(cache.knownPods, pod.UID)
 		}
-		log.V(100).Info("debug: node %s with devices %v", name, n.devs)
+		n.publishNode()
+	} else {
+		n.setNode(node)
 	}
+	cacheLog.V(100).Debug("node with devices", log.Node(name), log.Int("devices", len(n.devs)))
 	return n, nil
 }
 
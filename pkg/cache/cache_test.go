@@ -0,0 +1,151 @@
+package cache
+
+import (
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	clientgocache "k8s.io/client-go/tools/cache"
+)
+
+func newTestNode(name string, gpuCount, gpuMemory int64) *v1.Node {
+	node := &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Status:     v1.NodeStatus{Capacity: v1.ResourceList{}},
+	}
+	if gpuMemory > 0 {
+		node.Status.Capacity["aliyun.com/gpu-mem"] = *resource.NewQuantity(gpuMemory, resource.DecimalSI)
+		node.Status.Capacity["aliyun.com/gpu-count"] = *resource.NewQuantity(gpuCount, resource.DecimalSI)
+	}
+	return node
+}
+
+func newTestCache(t *testing.T, nodes ...*v1.Node) (*SchedulerCache, clientgocache.Indexer) {
+	nodeIndexer := clientgocache.NewIndexer(clientgocache.MetaNamespaceKeyFunc, clientgocache.Indexers{})
+	for _, node := range nodes {
+		if err := nodeIndexer.Add(node); err != nil {
+			t.Fatal(err)
+		}
+	}
+	podIndexer := clientgocache.NewIndexer(clientgocache.MetaNamespaceKeyFunc, clientgocache.Indexers{clientgocache.NamespaceIndex: clientgocache.MetaNamespaceIndexFunc})
+	configMaps := corelisters.NewConfigMapLister(clientgocache.NewIndexer(clientgocache.MetaNamespaceKeyFunc, clientgocache.Indexers{}))
+	return NewSchedulerCache(corelisters.NewNodeLister(nodeIndexer), corelisters.NewPodLister(podIndexer), configMaps), nodeIndexer
+}
+
+func TestGetNodeInfoPublishesNodeChanges(t *testing.T) {
+	tests := []struct {
+		name        string
+		node        *v1.Node
+		updated     *v1.Node
+		wantCached  bool
+		wantChanges int
+		wantDevices int
+	}{
+		{
+			name:        "node without GPU sharing is not cached",
+			node:        newTestNode("n1", 0, 0),
+			updated:     newTestNode("n1", 0, 0),
+			wantChanges: 0,
+		},
+		{
+			name:        "unchanged node is published once",
+			node:        newTestNode("n1", 2, 16),
+			updated:     newTestNode("n1", 2, 16),
+			wantCached:  true,
+			wantChanges: 1,
+			wantDevices: 2,
+		},
+		{
+			name:        "GPU memory change is published",
+			node:        newTestNode("n1", 2, 16),
+			updated:     newTestNode("n1", 2, 32),
+			wantCached:  true,
+			wantChanges: 2,
+			wantDevices: 2,
+		},
+		{
+			name:        "GPU count change is published",
+			node:        newTestNode("n1", 2, 16),
+			updated:     newTestNode("n1", 1, 8),
+			wantCached:  true,
+			wantChanges: 2,
+			wantDevices: 1,
+		},
+		{
+			name:        "node which stops GPU sharing is dropped",
+			node:        newTestNode("n1", 2, 16),
+			updated:     newTestNode("n1", 0, 0),
+			wantChanges: 2,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cache, nodeIndexer := newTestCache(t, test.node)
+			for i := 0; i < 3; i++ {
+				if _, err := cache.GetNodeInfo(test.node.Name); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := nodeIndexer.Update(test.updated); err != nil {
+				t.Fatal(err)
+			}
+			var info *NodeInfo
+			for i := 0; i < 3; i++ {
+				var err error
+				if info, err = cache.GetNodeInfo(test.node.Name); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if info.GetNode() != test.updated {
+				t.Errorf("expected the nodeInfo to have the updated node")
+			}
+			if len(info.GetDevs()) != test.wantDevices {
+				t.Errorf("expected %d devices, got %d", test.wantDevices, len(info.GetDevs()))
+			}
+			_, cached := cache.nodes[test.node.Name]
+			if cached != test.wantCached {
+				t.Errorf("expected cached %v, got %v", test.wantCached, cached)
+			}
+			if changes := len(cache.feed.history); changes != test.wantChanges {
+				t.Errorf("expected %d changes, got %d", test.wantChanges, changes)
+			}
+		})
+	}
+}
+
+func TestGetNodeInfoReleasesPodsOfRemovedDevices(t *testing.T) {
+	cache, nodeIndexer := newTestCache(t, newTestNode("n1", 2, 16))
+	kept, dropped := newTestPodWithMemory("kept", 0, 2), newTestPodWithMemory("dropped", 1, 2)
+	for _, pod := range []*v1.Pod{kept, dropped} {
+		if err := cache.AddOrUpdatePod(pod); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := nodeIndexer.Update(newTestNode("n1", 1, 8)); err != nil {
+		t.Fatal(err)
+	}
+	info, err := cache.GetNodeInfo("n1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := info.GetDevs()[0].GetUsedGPUMemory(); got != 2 {
+		t.Errorf("expected the pod kept on the device 0 using 2, got %d", got)
+	}
+	var released []string
+	for _, change := range cache.feed.history {
+		if change.Type == ChangeReleased {
+			released = append(released, change.Pod.Name)
+		}
+	}
+	if len(released) != 1 || released[0] != "dropped" {
+		t.Errorf("expected the pod dropped released, got %v", released)
+	}
+	if !cache.KnownPod(kept.UID) || cache.KnownPod(dropped.UID) {
+		t.Errorf("expected only the pod kept to be known")
+	}
+}
@@ -1,6 +1,10 @@
 package cache
 
 import (
+	"fmt"
+	"strconv"
+	"strings"
+
 	"github.com/AliyunContainerService/gpushare-scheduler-extender/pkg/log"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
@@ -11,19 +15,29 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
+const (
+	// UnhealthyConfigMapPrefix is the name prefix of the configmap which records the unhealthy GPUs of a node
+	UnhealthyConfigMapPrefix = "unhealthy-gpu-"
+)
+
 var (
-	ConfigMapLister         corelisters.ConfigMapLister
 	ConfigMapInformerSynced clientgocache.InformerSynced
+	// ConfigMapNamespace is where the unhealthy GPU configmaps are, it's set at startup
+	ConfigMapNamespace = metav1.NamespaceSystem
 )
 
-func getConfigMap(name string) *v1.ConfigMap {
-	configMap, err := ConfigMapLister.ConfigMaps(metav1.NamespaceSystem).Get(name)
+// getConfigMap returns nil if the configmap is not found, or the lister is nil
+func getConfigMap(lister corelisters.ConfigMapLister, name string) *v1.ConfigMap {
+	if lister == nil {
+		return nil
+	}
+	configMap, err := lister.ConfigMaps(ConfigMapNamespace).Get(name)
 
 	// If we can't get the configmap just return nil. The resync will eventually
 	// sync things up.
 	if err != nil {
 		if !apierrors.IsNotFound(err) {
-			log.V(10).Info("warn: find configmap with error: %v", err)
+			cacheLog.V(10).Warn("find configmap with error", log.String("configmap", name), log.Err(err))
 			utilruntime.HandleError(err)
 		}
 		return nil
@@ -31,3 +45,33 @@ func getConfigMap(name string) *v1.ConfigMap {
 
 	return configMap
 }
+
+// UnhealthyConfigMapName returns the name of the configmap which records the unhealthy GPUs of the node
+func UnhealthyConfigMapName(nodeName string) string {
+	return fmt.Sprintf("%s%s", UnhealthyConfigMapPrefix, nodeName)
+}
+
+// UnhealthyGPUsFromConfigMap parses the comma separated device ids in the gpus key, the bad ids are skipped
+func UnhealthyGPUsFromConfigMap(cm *v1.ConfigMap) map[int]bool {
+	unhealthyGPUs := map[int]bool{}
+	if cm == nil || len(strings.TrimSpace(cm.Data["gpus"])) == 0 {
+		return unhealthyGPUs
+	}
+	for _, sid := range strings.Split(cm.Data["gpus"], ",") {
+		id, err := strconv.Atoi(strings.TrimSpace(sid))
+		if err != nil {
+			cacheLog.V(3).Warn("failed to parse id", log.String("id", sid), log.Err(err))
+			continue
+		}
+		unhealthyGPUs[id] = true
+	}
+	return unhealthyGPUs
+}
+
+// NodeNameFromUnhealthyConfigMap returns the node name of the unhealthy configmap, and false if it's not one
+func NodeNameFromUnhealthyConfigMap(cm *v1.ConfigMap) (string, bool) {
+	if cm.Namespace != ConfigMapNamespace || !strings.HasPrefix(cm.Name, UnhealthyConfigMapPrefix) {
+		return "", false
+	}
+	return strings.TrimPrefix(cm.Name, UnhealthyConfigMapPrefix), true
+}
@@ -3,6 +3,7 @@ package cache
 import (
 	"github.com/AliyunContainerService/gpushare-scheduler-extender/pkg/log"
 	"sync"
+	"time"
 
 	"github.com/AliyunContainerService/gpushare-scheduler-extender/pkg/utils"
 	"k8s.io/api/core/v1"
@@ -10,14 +11,32 @@ import (
 )
 
 type DeviceInfo struct {
-	idx    int
+	idx int
+	// uuid is empty if the device plugin doesn't report the UUIDs of the node
+	uuid   string
 	podMap map[types.UID]*v1.Pod
 	// usedGPUMem  uint
 	totalGPUMem uint
-	rwmu        *sync.RWMutex
+	// reservedGPUMem is held back for the pods which request at least this amount until reservedUntil
+	reservedGPUMem uint
+	reservedUntil  time.Time
+	rwmu           *sync.RWMutex
+	// profile reads the gpu memory of the pods, it's the profile of the node
+	profile *utils.Profile
+}
+
+func (d *DeviceInfo) GetID() int {
+	return d.idx
+}
+
+// GetUUID returns the UUID of the device, empty if it's unknown
+func (d *DeviceInfo) GetUUID() string {
+	return d.uuid
 }
 
 func (d *DeviceInfo) GetPods() []*v1.Pod {
+	d.rwmu.RLock()
+	defer d.rwmu.RUnlock()
 	pods := []*v1.Pod{}
 	for _, pod := range d.podMap {
 		pods = append(pods, pod)
@@ -25,12 +44,34 @@ func (d *DeviceInfo) GetPods() []*v1.Pod {
 	return pods
 }
 
-func newDeviceInfo(index int, totalGPUMem uint) *DeviceInfo {
+func newDeviceInfo(index int, uuid string, totalGPUMem uint, profile *utils.Profile) *DeviceInfo {
 	return &DeviceInfo{
 		idx:         index,
+		uuid:        uuid,
 		totalGPUMem: totalGPUMem,
 		podMap:      map[types.UID]*v1.Pod{},
 		rwmu:        new(sync.RWMutex),
+		profile:     profile,
+	}
+}
+
+// clone copies the device with its pods, the pods are shared and should be read only
+func (d *DeviceInfo) clone() *DeviceInfo {
+	d.rwmu.RLock()
+	defer d.rwmu.RUnlock()
+	podMap := make(map[types.UID]*v1.Pod, len(d.podMap))
+	for uid, pod := range d.podMap {
+		podMap[uid] = pod
+	}
+	return &DeviceInfo{
+		idx:            d.idx,
+		uuid:           d.uuid,
+		podMap:         podMap,
+		totalGPUMem:    d.totalGPUMem,
+		reservedGPUMem: d.reservedGPUMem,
+		reservedUntil:  d.reservedUntil,
+		rwmu:           new(sync.RWMutex),
+		profile:        d.profile,
 	}
 }
 
@@ -39,42 +80,75 @@ func (d *DeviceInfo) GetTotalGPUMemory() uint {
 }
 
 func (d *DeviceInfo) GetUsedGPUMemory() (gpuMem uint) {
-	log.V(100).Info("debug: GetUsedGPUMemory() podMap %v, and its address is %p", d.podMap, d)
+	cacheLog.V(100).Debug("GetUsedGPUMemory()", log.DevID(d.idx), log.Int("pods", len(d.podMap)))
 	d.rwmu.RLock()
 	defer d.rwmu.RUnlock()
 	for _, pod := range d.podMap {
 		if pod.Status.Phase == v1.PodSucceeded || pod.Status.Phase == v1.PodFailed {
-			log.V(100).Info("debug: skip the pod %s in ns %s due to its status is %s", pod.Name, pod.Namespace, pod.Status.Phase)
+			cacheLog.V(100).Debug("skip the pod due to its status", log.Pod(pod.Name), log.Namespace(pod.Namespace), log.String("phase", string(pod.Status.Phase)))
 			continue
 		}
 		// gpuMem += utils.GetGPUMemoryFromPodEnv(pod)
-		gpuMem += utils.GetGPUMemoryFromPodAnnotation(pod)
+		gpuMem += d.profile.GPUMemoryFromPodAnnotation(pod)
 	}
 	return gpuMem
 }
 
-func (d *DeviceInfo) addPod(pod *v1.Pod) {
-	log.V(100).Info("debug: dev.addPod() Pod %s in ns %s with the GPU ID %d will be added to device map",
-		pod.Name,
-		pod.Namespace,
-		d.idx)
+// Reserve holds back the gpu memory on the device for the pods which request at least
+// the same amount until the ttl expires, so that a defragmented slot isn't refilled by small pods.
+func (d *DeviceInfo) Reserve(gpuMem uint, ttl time.Duration) {
 	d.rwmu.Lock()
 	defer d.rwmu.Unlock()
+	d.reservedGPUMem = gpuMem
+	d.reservedUntil = time.Now().Add(ttl)
+}
+
+// Unreserve releases the reserved gpu memory before the ttl expires
+func (d *DeviceInfo) Unreserve() {
+	d.rwmu.Lock()
+	defer d.rwmu.Unlock()
+	d.reservedGPUMem = 0
+	d.reservedUntil = time.Time{}
+}
+
+// GetReservedGPUMemory returns the reserved gpu memory which is not usable for the request
+func (d *DeviceInfo) GetReservedGPUMemory(reqGPU uint) uint {
+	d.rwmu.RLock()
+	defer d.rwmu.RUnlock()
+	if d.reservedGPUMem == 0 || reqGPU >= d.reservedGPUMem || time.Now().After(d.reservedUntil) {
+		return 0
+	}
+	return d.reservedGPUMem
+}
+
+// addPod returns true if the pod is new to the device
+func (d *DeviceInfo) addPod(pod *v1.Pod) (added bool) {
+	cacheLog.V(100).Debug("dev.addPod() pod will be added to device map",
+		log.Pod(pod.Name),
+		log.Namespace(pod.Namespace),
+		log.DevID(d.idx))
+	d.rwmu.Lock()
+	defer d.rwmu.Unlock()
+	_, found := d.podMap[pod.UID]
 	d.podMap[pod.UID] = pod
-	log.V(100).Info("debug: dev.addPod() after updated is %v, and its address is %p",
-		d.podMap,
-		d)
+	// the reservation is consumed by the pod it was made for
+	if d.reservedGPUMem > 0 && d.profile.GPUMemoryFromPodAnnotation(pod) >= d.reservedGPUMem {
+		d.reservedGPUMem = 0
+	}
+	cacheLog.V(100).Debug("dev.addPod() after updated", log.DevID(d.idx), log.Int("pods", len(d.podMap)))
+	return !found
 }
 
-func (d *DeviceInfo) removePod(pod *v1.Pod) {
-	log.V(100).Info("debug: dev.removePod() Pod %s in ns %s with the GPU ID %d will be removed from device map",
-		pod.Name,
-		pod.Namespace,
-		d.idx)
+// removePod returns true if the pod was on the device
+func (d *DeviceInfo) removePod(pod *v1.Pod) (removed bool) {
+	cacheLog.V(100).Debug("dev.removePod() pod will be removed from device map",
+		log.Pod(pod.Name),
+		log.Namespace(pod.Namespace),
+		log.DevID(d.idx))
 	d.rwmu.Lock()
 	defer d.rwmu.Unlock()
+	_, found := d.podMap[pod.UID]
 	delete(d.podMap, pod.UID)
-	log.V(100).Info("debug: dev.removePod() after updated is %v, and its address is %p",
-		d.podMap,
-		d)
+	cacheLog.V(100).Debug("dev.removePod() after updated", log.DevID(d.idx), log.Int("pods", len(d.podMap)))
+	return found
 }
@@ -0,0 +1,230 @@
+package cache
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/AliyunContainerService/gpushare-scheduler-extender/pkg/log"
+	"github.com/AliyunContainerService/gpushare-scheduler-extender/pkg/utils"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/uuid"
+)
+
+// ChangeType is the kind of the change applied to the cache
+type ChangeType string
+
+const (
+	// ChangeAllocated is a pod added to a device
+	ChangeAllocated ChangeType = "Allocated"
+	// ChangeReleased is a pod removed from a device
+	ChangeReleased ChangeType = "Released"
+	// ChangeNode is the gpu count or memory of a node, when it's built or reset in the cache.
+	// A node without them stopped GPU sharing and is removed from the cache.
+	ChangeNode ChangeType = "Node"
+	// ChangeUnhealthy is the set of the unhealthy devices of a node
+	ChangeUnhealthy ChangeType = "Unhealthy"
+)
+
+const (
+	// changeHistorySize is how many recent changes are kept to resume the watches from
+	changeHistorySize = 1024
+	// watcherBufferSize is how many changes a watcher can lag behind before it's closed
+	watcherBufferSize = 256
+)
+
+// Change is an entry of the change feed of the cache. The changes are idempotent,
+// so applying a change which the snapshot already has is harmless.
+type Change struct {
+	// Epoch identifies the feed, the seqs restart from 1 in the feed of another extender or after a restart
+	Epoch     string     `json:"epoch"`
+	Seq       uint64     `json:"seq"`
+	Type      ChangeType `json:"type"`
+	Timestamp time.Time  `json:"timestamp"`
+	Node      string     `json:"node"`
+	// Device and Pod are set for the allocation and the release
+	Device *int         `json:"device,omitempty"`
+	Pod    *PodSnapshot `json:"pod,omitempty"`
+	// GPUCount and TotalGPUMemory are set for the node change
+	GPUCount       int `json:"gpuCount,omitempty"`
+	TotalGPUMemory int `json:"totalGPUMemory,omitempty"`
+	// UnhealthyDevices are all the unhealthy devices of the node for the unhealthy change, none if it's empty
+	UnhealthyDevices []int `json:"unhealthyDevices,omitempty"`
+}
+
+// changeFeed numbers the changes of the cache and fans them out to the watchers
+type changeFeed struct {
+	mu    sync.Mutex
+	epoch string
+	seq   uint64
+	// history is the recent changes in order
+	history  []*Change
+	watchers map[chan *Change]struct{}
+	// unhealthy is the last published unhealthy devices of each node
+	unhealthy map[string][]int
+}
+
+func newChangeFeed() *changeFeed {
+	return &changeFeed{
+		epoch:     string(uuid.NewUUID()),
+		watchers:  map[chan *Change]struct{}{},
+		unhealthy: map[string][]int{},
+	}
+}
+
+// publish numbers the change and sends it to the watchers, the watcher which can't keep up is closed
+func (f *changeFeed) publish(change *Change) {
+	if f == nil {
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.publishLocked(change)
+}
+
+// publishLocked is publish with the lock held
+func (f *changeFeed) publishLocked(change *Change) {
+	f.seq++
+	change.Epoch = f.epoch
+	change.Seq = f.seq
+	change.Timestamp = time.Now()
+	f.history = append(f.history, change)
+	if len(f.history) > changeHistorySize {
+		f.history = f.history[len(f.history)-changeHistorySize:]
+	}
+
+	for ch := range f.watchers {
+		select {
+		case ch <- change:
+		default:
+			cacheLog.V(3).Warn("close the watcher which falls behind", log.Int("seq", int(change.Seq)))
+			delete(f.watchers, ch)
+			close(ch)
+		}
+	}
+}
+
+func (f *changeFeed) publishPod(changeType ChangeType, nodeName string, devID int, pod *v1.Pod, profile *utils.Profile) {
+	if f == nil {
+		return
+	}
+	f.publish(&Change{Type: changeType, Node: nodeName, Device: &devID, Pod: podSnapshot(pod, profile)})
+}
+
+// publishUnhealthy publishes the unhealthy devices of the node if they are changed
+func (f *changeFeed) publishUnhealthy(nodeName string, unhealthyGPUs map[int]bool) {
+	if f == nil {
+		return
+	}
+	ids := []int{}
+	for id := range unhealthyGPUs {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+
+	// the devices are compared and published under one lock, so the concurrent updates are published in order
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	last, found := f.unhealthy[nodeName]
+	f.unhealthy[nodeName] = ids
+	if found && equalInts(last, ids) || !found && len(ids) == 0 {
+		return
+	}
+	f.publishLocked(&Change{Type: ChangeUnhealthy, Node: nodeName, UnhealthyDevices: ids})
+}
+
+// watch returns the changes after the seq of the epoch and the channel of the following ones.
+// It's not resumed if the epoch is another one, or the seq is 0 or older than the history,
+// then the watcher starts from now.
+func (f *changeFeed) watch(epoch string, since uint64) (seq uint64, backlog []*Change, resumed bool, changes chan *Change) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	oldest := f.seq + 1
+	if len(f.history) > 0 {
+		oldest = f.history[0].Seq
+	}
+	if epoch == f.epoch && since > 0 && since+1 >= oldest && since <= f.seq {
+		resumed = true
+		for _, change := range f.history {
+			if change.Seq > since {
+				backlog = append(backlog, change)
+			}
+		}
+	}
+
+	changes = make(chan *Change, watcherBufferSize)
+	f.watchers[changes] = struct{}{}
+	return f.seq, backlog, resumed, changes
+}
+
+func (f *changeFeed) stop(changes chan *Change) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, found := f.watchers[changes]; found {
+		delete(f.watchers, changes)
+		close(changes)
+	}
+}
+
+func equalInts(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Watch is a subscription to the change feed of the cache
+type Watch struct {
+	// Epoch identifies the feed, a watch is only resumed in the same epoch
+	Epoch string
+	// Snapshot is the state of the cache to apply the changes to, nil if the watch is resumed
+	Snapshot *Snapshot
+	// Seq is the last change before the watch starts, which the snapshot may be behind
+	Seq uint64
+	// Backlog are the changes after the resumed seq
+	Backlog []*Change
+	// Changes are the following changes, it's closed if the watcher falls behind or stops
+	Changes <-chan *Change
+
+	stop func()
+}
+
+// Stop unsubscribes the watch
+func (w *Watch) Stop() {
+	w.stop()
+}
+
+// Watch subscribes to the changes after the seq of the epoch. It starts with a snapshot if the seq is 0,
+// too old to resume or of another epoch, e.g. the extender restarted or the client switched to another replica.
+func (cache *SchedulerCache) Watch(epoch string, since uint64) (*Watch, error) {
+	seq, backlog, resumed, changes := cache.feed.watch(epoch, since)
+	w := &Watch{
+		Epoch:   cache.feed.epoch,
+		Seq:     seq,
+		Backlog: backlog,
+		Changes: changes,
+		stop:    func() { cache.feed.stop(changes) },
+	}
+	if resumed {
+		return w, nil
+	}
+
+	snapshot, err := cache.Snapshot()
+	if err != nil {
+		w.Stop()
+		return nil, err
+	}
+	w.Snapshot = snapshot
+	return w, nil
+}
+
+// UpdateUnhealthyGPUs publishes the unhealthy devices of the node from its configmap when they change
+func (cache *SchedulerCache) UpdateUnhealthyGPUs(nodeName string, unhealthyGPUs map[int]bool) {
+	cache.feed.publishUnhealthy(nodeName, unhealthyGPUs)
+}
@@ -0,0 +1,152 @@
+package cache
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestChangeFeedWatch(t *testing.T) {
+	// the history keeps the changes from 11 to the last one
+	last := uint64(changeHistorySize + 10)
+	feed := newChangeFeed()
+	for i := uint64(0); i < last; i++ {
+		feed.publish(&Change{Type: ChangeNode, Node: "n1"})
+	}
+
+	tests := []struct {
+		name string
+		// epoch is the epoch of the feed if it's empty
+		epoch       string
+		since       uint64
+		wantResumed bool
+		// wantFirst is the seq of the first change in the backlog, 0 if it's empty
+		wantFirst uint64
+	}{
+		{name: "new watch starts from now", since: 0},
+		{name: "latest seq has no backlog", since: last, wantResumed: true},
+		{name: "recent seq resumes with the backlog", since: last - 5, wantResumed: true, wantFirst: last - 4},
+		{name: "seq right before the history", since: 10, wantResumed: true, wantFirst: 11},
+		{name: "seq older than the history", since: 9},
+		{name: "seq after the latest one", since: last + 1},
+		{name: "seq of another epoch", epoch: "other", since: last - 5},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			epoch := test.epoch
+			if len(epoch) == 0 {
+				epoch = feed.epoch
+			}
+			seq, backlog, resumed, changes := feed.watch(epoch, test.since)
+			defer feed.stop(changes)
+
+			if seq != last {
+				t.Errorf("expected seq %d, got %d", last, seq)
+			}
+			if resumed != test.wantResumed {
+				t.Fatalf("expected resumed %v, got %v", test.wantResumed, resumed)
+			}
+			if test.wantFirst == 0 {
+				if len(backlog) > 0 {
+					t.Errorf("expected no backlog, got %d changes from %d", len(backlog), backlog[0].Seq)
+				}
+				return
+			}
+			if len(backlog) == 0 || backlog[0].Seq != test.wantFirst || backlog[len(backlog)-1].Seq != last {
+				t.Fatalf("expected the backlog from %d to %d, got %d changes", test.wantFirst, last, len(backlog))
+			}
+			for i := 1; i < len(backlog); i++ {
+				if backlog[i].Seq != backlog[i-1].Seq+1 {
+					t.Fatalf("expected the backlog in order, got %d after %d", backlog[i].Seq, backlog[i-1].Seq)
+				}
+			}
+		})
+	}
+}
+
+func TestChangeFeedClosesSlowWatcher(t *testing.T) {
+	feed := newChangeFeed()
+	_, _, _, slow := feed.watch("", 0)
+	_, _, _, fast := feed.watch("", 0)
+	defer feed.stop(fast)
+
+	for i := 0; i <= watcherBufferSize; i++ {
+		feed.publish(&Change{Type: ChangeNode, Node: "n1"})
+		<-fast
+	}
+
+	received := 0
+	for range slow {
+		received++
+	}
+	if received != watcherBufferSize {
+		t.Errorf("expected the slow watcher closed after %d changes, got %d", watcherBufferSize, received)
+	}
+	// stopping the closed watcher is harmless
+	feed.stop(slow)
+}
+
+func TestPublishUnhealthy(t *testing.T) {
+	tests := []struct {
+		name      string
+		unhealthy []map[int]bool
+		// wantChanges are the published unhealthy devices
+		wantChanges [][]int
+	}{
+		{
+			name:      "healthy node is not published",
+			unhealthy: []map[int]bool{{}},
+		},
+		{
+			name:        "unchanged devices are published once",
+			unhealthy:   []map[int]bool{{1: true, 0: true}, {0: true, 1: true}},
+			wantChanges: [][]int{{0, 1}},
+		},
+		{
+			name:        "recovered node is published without devices",
+			unhealthy:   []map[int]bool{{1: true}, {}},
+			wantChanges: [][]int{{1}, {}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			feed := newChangeFeed()
+			for _, unhealthy := range test.unhealthy {
+				feed.publishUnhealthy("n1", unhealthy)
+			}
+			if len(feed.history) != len(test.wantChanges) {
+				t.Fatalf("expected %d changes, got %d", len(test.wantChanges), len(feed.history))
+			}
+			for i, change := range feed.history {
+				if change.Type != ChangeUnhealthy || !equalInts(change.UnhealthyDevices, test.wantChanges[i]) {
+					t.Errorf("expected the unhealthy devices %v, got %s %v", test.wantChanges[i], change.Type, change.UnhealthyDevices)
+				}
+			}
+		})
+	}
+}
+
+func TestPublishUnhealthyConcurrently(t *testing.T) {
+	feed := newChangeFeed()
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			feed.publishUnhealthy("n1", map[int]bool{i % 2: true})
+		}(i)
+	}
+	wg.Wait()
+
+	// the last published devices are the ones compared with the next update
+	last := feed.history[len(feed.history)-1]
+	if !equalInts(last.UnhealthyDevices, feed.unhealthy["n1"]) {
+		t.Errorf("expected the last change %v to be the devices of the node %v", last.UnhealthyDevices, feed.unhealthy["n1"])
+	}
+	for i := 1; i < len(feed.history); i++ {
+		if equalInts(feed.history[i].UnhealthyDevices, feed.history[i-1].UnhealthyDevices) {
+			t.Errorf("expected no repeated change, got %v at %d", feed.history[i].UnhealthyDevices, feed.history[i].Seq)
+		}
+	}
+}
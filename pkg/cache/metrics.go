@@ -0,0 +1,88 @@
+package cache
+
+import (
+	"strconv"
+
+	"github.com/AliyunContainerService/gpushare-scheduler-extender/pkg/utils"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	nodeGPUMemoryTotalDesc = prometheus.NewDesc("gpushare_node_gpu_memory_total",
+		"Total GPU memory of the node.", []string{"node"}, nil)
+	nodeGPUMemoryUsedDesc = prometheus.NewDesc("gpushare_node_gpu_memory_used",
+		"Allocated GPU memory of the node.", []string{"node"}, nil)
+	nodeGPUMemoryFreeDesc = prometheus.NewDesc("gpushare_node_gpu_memory_free",
+		"Free GPU memory of the healthy devices of the node.", []string{"node"}, nil)
+	nodePodsDesc = prometheus.NewDesc("gpushare_node_pods",
+		"Number of GPU sharing pods on the node.", []string{"node"}, nil)
+
+	deviceGPUMemoryTotalDesc = prometheus.NewDesc("gpushare_device_gpu_memory_total",
+		"Total GPU memory of the device.", []string{"node", "device"}, nil)
+	deviceGPUMemoryUsedDesc = prometheus.NewDesc("gpushare_device_gpu_memory_used",
+		"Allocated GPU memory of the device.", []string{"node", "device"}, nil)
+	deviceGPUMemoryFreeDesc = prometheus.NewDesc("gpushare_device_gpu_memory_free",
+		"Free GPU memory of the device, 0 if it's unhealthy.", []string{"node", "device"}, nil)
+	devicePodsDesc = prometheus.NewDesc("gpushare_device_pods",
+		"Number of GPU sharing pods on the device.", []string{"node", "device"}, nil)
+)
+
+// cacheCollector exports the allocation of the nodes and devices when it's scraped
+type cacheCollector struct {
+	cache *SchedulerCache
+}
+
+// NewCollector returns the prometheus collector of the node and device gauges of the cache
+func NewCollector(cache *SchedulerCache) prometheus.Collector {
+	return &cacheCollector{cache: cache}
+}
+
+func (c *cacheCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- nodeGPUMemoryTotalDesc
+	ch <- nodeGPUMemoryUsedDesc
+	ch <- nodeGPUMemoryFreeDesc
+	ch <- nodePodsDesc
+	ch <- deviceGPUMemoryTotalDesc
+	ch <- deviceGPUMemoryUsedDesc
+	ch <- deviceGPUMemoryFreeDesc
+	ch <- devicePodsDesc
+}
+
+// Collect only reads the nodeInfos which are in the cache, so a scrape doesn't build or reset them
+func (c *cacheCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, info := range c.cache.GetNodeinfos() {
+		if info.GetTotalGPUMemory() <= 0 {
+			continue
+		}
+		availableGPUs := info.GetAvailableGPUs()
+		var nodeUsed, nodeFree uint
+		var nodePods int
+		for _, dev := range info.GetDevs() {
+			if dev == nil {
+				continue
+			}
+			device := strconv.Itoa(dev.GetID())
+			used := dev.GetUsedGPUMemory()
+			free := availableGPUs[dev.GetID()]
+			pods := 0
+			for _, pod := range dev.GetPods() {
+				if utils.AssignedNonTerminatedPod(pod) {
+					pods++
+				}
+			}
+
+			ch <- prometheus.MustNewConstMetric(deviceGPUMemoryTotalDesc, prometheus.GaugeValue, float64(dev.GetTotalGPUMemory()), info.GetName(), device)
+			ch <- prometheus.MustNewConstMetric(deviceGPUMemoryUsedDesc, prometheus.GaugeValue, float64(used), info.GetName(), device)
+			ch <- prometheus.MustNewConstMetric(deviceGPUMemoryFreeDesc, prometheus.GaugeValue, float64(free), info.GetName(), device)
+			ch <- prometheus.MustNewConstMetric(devicePodsDesc, prometheus.GaugeValue, float64(pods), info.GetName(), device)
+			nodeUsed += used
+			nodeFree += free
+			nodePods += pods
+		}
+
+		ch <- prometheus.MustNewConstMetric(nodeGPUMemoryTotalDesc, prometheus.GaugeValue, float64(info.GetTotalGPUMemory()), info.GetName())
+		ch <- prometheus.MustNewConstMetric(nodeGPUMemoryUsedDesc, prometheus.GaugeValue, float64(nodeUsed), info.GetName())
+		ch <- prometheus.MustNewConstMetric(nodeGPUMemoryFreeDesc, prometheus.GaugeValue, float64(nodeFree), info.GetName())
+		ch <- prometheus.MustNewConstMetric(nodePodsDesc, prometheus.GaugeValue, float64(nodePods), info.GetName())
+	}
+}
@@ -0,0 +1,92 @@
+package cache
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	clientgocache "k8s.io/client-go/tools/cache"
+)
+
+func TestCollector(t *testing.T) {
+	// n1 has 4 gpu memory used on the device 0, and the device 1 is unhealthy
+	snapshotCache, err := NewSchedulerCacheFromSnapshot(&Snapshot{Version: SnapshotVersion, Nodes: []*NodeSnapshot{newTestNodeSnapshot("n1", 1)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the node of the lister is not built into the cache yet
+	nodes := clientgocache.NewIndexer(clientgocache.MetaNamespaceKeyFunc, clientgocache.Indexers{})
+	err = nodes.Add(&v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "n2"},
+		Status: v1.NodeStatus{Capacity: v1.ResourceList{
+			"aliyun.com/gpu-mem":   resource.MustParse("16"),
+			"aliyun.com/gpu-count": resource.MustParse("2"),
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	unbuiltCache := NewSchedulerCache(corelisters.NewNodeLister(nodes), nil, nil)
+
+	tests := []struct {
+		name  string
+		cache *SchedulerCache
+		want  string
+	}{
+		{
+			name:  "node with an unhealthy device",
+			cache: snapshotCache,
+			want: `
+# HELP gpushare_device_gpu_memory_free Free GPU memory of the device, 0 if it's unhealthy.
+# TYPE gpushare_device_gpu_memory_free gauge
+gpushare_device_gpu_memory_free{device="0",node="n1"} 4
+gpushare_device_gpu_memory_free{device="1",node="n1"} 0
+# HELP gpushare_device_gpu_memory_total Total GPU memory of the device.
+# TYPE gpushare_device_gpu_memory_total gauge
+gpushare_device_gpu_memory_total{device="0",node="n1"} 8
+gpushare_device_gpu_memory_total{device="1",node="n1"} 8
+# HELP gpushare_device_gpu_memory_used Allocated GPU memory of the device.
+# TYPE gpushare_device_gpu_memory_used gauge
+gpushare_device_gpu_memory_used{device="0",node="n1"} 4
+gpushare_device_gpu_memory_used{device="1",node="n1"} 0
+# HELP gpushare_device_pods Number of GPU sharing pods on the device.
+# TYPE gpushare_device_pods gauge
+gpushare_device_pods{device="0",node="n1"} 1
+gpushare_device_pods{device="1",node="n1"} 0
+# HELP gpushare_node_gpu_memory_free Free GPU memory of the healthy devices of the node.
+# TYPE gpushare_node_gpu_memory_free gauge
+gpushare_node_gpu_memory_free{node="n1"} 4
+# HELP gpushare_node_gpu_memory_total Total GPU memory of the node.
+# TYPE gpushare_node_gpu_memory_total gauge
+gpushare_node_gpu_memory_total{node="n1"} 16
+# HELP gpushare_node_gpu_memory_used Allocated GPU memory of the node.
+# TYPE gpushare_node_gpu_memory_used gauge
+gpushare_node_gpu_memory_used{node="n1"} 4
+# HELP gpushare_node_pods Number of GPU sharing pods on the node.
+# TYPE gpushare_node_pods gauge
+gpushare_node_pods{node="n1"} 1
+`,
+		},
+		{
+			name:  "scrape doesn't build the nodes",
+			cache: unbuiltCache,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			collector := NewCollector(test.cache)
+			if err := testutil.CollectAndCompare(collector, strings.NewReader(test.want)); err != nil {
+				t.Error(err)
+			}
+			if nodeInfos := len(test.cache.GetNodeinfos()); test.cache == unbuiltCache && nodeInfos > 0 {
+				t.Errorf("expected no nodeInfo built by the scrape, got %d", nodeInfos)
+			}
+		})
+	}
+}
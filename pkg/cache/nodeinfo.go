@@ -4,9 +4,12 @@ import (
 	"context"
 	"fmt"
 	"github.com/AliyunContainerService/gpushare-scheduler-extender/pkg/log"
-	"strconv"
-	"strings"
+	"github.com/AliyunContainerService/gpushare-scheduler-extender/pkg/metrics"
+	"github.com/AliyunContainerService/gpushare-scheduler-extender/pkg/tracing"
+	"go.opentelemetry.io/otel/attribute"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	v1 "k8s.io/api/core/v1"
 
@@ -15,68 +18,218 @@ import (
 	"github.com/AliyunContainerService/gpushare-scheduler-extender/pkg/utils"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/record"
 )
 
 const (
 	OptimisticLockErrorMsg = "the object has been modified; please apply your changes to the latest version and try again"
+
+	// ReasonGPUAllocated is the event reason when the pod is assigned to a device and bound
+	ReasonGPUAllocated = "GPUAllocated"
+	// ReasonGPUAllocationConflict is the event reason when the pod is changed during the allocation, which is retried
+	ReasonGPUAllocationConflict = "GPUAllocationConflict"
 )
 
 // NodeInfo is node level aggregated information.
 type NodeInfo struct {
-	ctx            context.Context
 	name           string
 	node           *v1.Node
 	devs           map[int]*DeviceInfo
 	gpuCount       int
 	gpuTotalMemory int
 	rwmu           *sync.RWMutex
+	// configMapLister gets the unhealthy GPU configmap of the node
+	configMapLister corelisters.ConfigMapLister
+	// profiles are the ones of the cache, profile is the one whose resource the node has,
+	// the pods on the node are read with it
+	profiles utils.Profiles
+	profile  *utils.Profile
+	// feed is nil for the clones, whose changes are not published
+	feed *changeFeed
 }
 
 // Create Node Level
-func NewNodeInfo(node *v1.Node) *NodeInfo {
-	log.V(10).Info("debug: NewNodeInfo() creates nodeInfo for %s", node.Name)
+func NewNodeInfo(node *v1.Node, profiles utils.Profiles, configMapLister corelisters.ConfigMapLister) *NodeInfo {
+	cacheLog.V(10).Debug("NewNodeInfo() creates nodeInfo", log.Node(node.Name))
 
+	profile := profiles.OfNode(node)
 	devMap := map[int]*DeviceInfo{}
-	for i := 0; i < utils.GetGPUCountInNode(node); i++ {
-		devMap[i] = newDeviceInfo(i, uint(utils.GetTotalGPUMemory(node)/utils.GetGPUCountInNode(node)))
+	uuids := profile.GPUUUIDs(node)
+	for i := 0; i < profile.GPUCount(node); i++ {
+		devMap[i] = newDeviceInfo(i, uuidAt(uuids, i), uint(profile.TotalGPUMemory(node)/profile.GPUCount(node)), profile)
 	}
 
 	if len(devMap) == 0 {
-		log.V(3).Info("warn: node %s with nodeinfo %v has no devices", node.Name, node)
+		cacheLog.V(3).Warn("node has no devices", log.Node(node.Name))
 	}
 
 	return &NodeInfo{
-		ctx:            context.Background(),
-		name:           node.Name,
-		node:           node,
-		devs:           devMap,
-		gpuCount:       utils.GetGPUCountInNode(node),
-		gpuTotalMemory: utils.GetTotalGPUMemory(node),
-		rwmu:           new(sync.RWMutex),
+		name:            node.Name,
+		node:            node,
+		devs:            devMap,
+		gpuCount:        profile.GPUCount(node),
+		gpuTotalMemory:  profile.TotalGPUMemory(node),
+		rwmu:            new(sync.RWMutex),
+		configMapLister: configMapLister,
+		profiles:        profiles,
+		profile:         profile,
 	}
 }
 
-// Only update the devices when the length of devs is 0
-func (n *NodeInfo) Reset(node *v1.Node) {
-	n.gpuCount = utils.GetGPUCountInNode(node)
-	n.gpuTotalMemory = utils.GetTotalGPUMemory(node)
-	n.node = node
+// Reset rebuilds the devices after the GPU count, the GPU memory or the device UUIDs of the node changed.
+// It returns the pods whose device is gone.
+func (n *NodeInfo) Reset(node *v1.Node) (dropped []*v1.Pod) {
+	// the resource of the device plugin may be added after the node
+	n.profile = n.profiles.OfNode(node)
+	n.gpuCount = n.profile.GPUCount(node)
+	n.gpuTotalMemory = n.profile.TotalGPUMemory(node)
+	n.setNode(node)
 	if n.gpuCount == 0 {
-		log.V(3).Info("warn: Reset for node %s but the gpu count is 0", node.Name)
+		cacheLog.V(3).Warn("Reset for node but the gpu count is 0", log.Node(node.Name))
 	}
 
 	if n.gpuTotalMemory == 0 {
-		log.V(3).Info("warn: Reset for node %s but the gpu total memory is 0", node.Name)
+		cacheLog.V(3).Warn("Reset for node but the gpu total memory is 0", log.Node(node.Name))
+	}
+
+	dropped = n.rebuildDevices(n.profile.GPUUUIDs(node))
+	cacheLog.V(3).Info("Reset() update nodeInfo", log.Node(node.Name), log.Int("devices", len(n.devs)))
+	return dropped
+}
+
+// changed checks if the devices of the node differ from the nodeInfo
+func (n *NodeInfo) changed(node *v1.Node) bool {
+	profile := n.profiles.OfNode(node)
+	return n.profile != profile ||
+		n.gpuCount != profile.GPUCount(node) ||
+		n.gpuTotalMemory != profile.TotalGPUMemory(node) ||
+		!n.HasUUIDs(profile.GPUUUIDs(node))
+}
+
+// setNode keeps the labels of the node up to date
+func (n *NodeInfo) setNode(node *v1.Node) {
+	if n.node == node {
+		return
+	}
+	n.rwmu.Lock()
+	defer n.rwmu.Unlock()
+	n.node = node
+}
+
+// Clone returns a snapshot of the nodeInfo, changing it won't affect the cache
+func (n *NodeInfo) Clone() *NodeInfo {
+	n.rwmu.RLock()
+	defer n.rwmu.RUnlock()
+	devMap := make(map[int]*DeviceInfo, len(n.devs))
+	for id, dev := range n.devs {
+		devMap[id] = dev.clone()
 	}
+	return &NodeInfo{
+		name:            n.name,
+		node:            n.node,
+		devs:            devMap,
+		gpuCount:        n.gpuCount,
+		gpuTotalMemory:  n.gpuTotalMemory,
+		rwmu:            new(sync.RWMutex),
+		configMapLister: n.configMapLister,
+		profiles:        n.profiles,
+		profile:         n.profile,
+	}
+}
 
-	if len(n.devs) == 0 && n.gpuCount > 0 {
-		devMap := map[int]*DeviceInfo{}
-		for i := 0; i < utils.GetGPUCountInNode(node); i++ {
-			devMap[i] = newDeviceInfo(i, uint(n.gpuTotalMemory/n.gpuCount))
+// HasUUIDs checks if the devices have the UUIDs in the order of the indexes
+func (n *NodeInfo) HasUUIDs(uuids []string) bool {
+	n.rwmu.RLock()
+	defer n.rwmu.RUnlock()
+	if len(uuids) > 0 && len(uuids) != len(n.devs) {
+		return false
+	}
+	for id, dev := range n.devs {
+		if dev.uuid != uuidAt(uuids, id) {
+			return false
 		}
-		n.devs = devMap
 	}
-	log.V(3).Info("info: Reset() update nodeInfo for %s with devs %v", node.Name, n.devs)
+	return true
+}
+
+// rebuildDevices creates the devices of the GPU count with the UUIDs, e.g. after a reboot reordered or
+// removed the devices, and moves the pods to the devices of their UUIDs. The pods whose device is gone
+// are released and returned.
+func (n *NodeInfo) rebuildDevices(uuids []string) (dropped []*v1.Pod) {
+	n.rwmu.Lock()
+	defer n.rwmu.Unlock()
+
+	pods := []*v1.Pod{}
+	oldIDs := map[types.UID]int{}
+	for id, dev := range n.devs {
+		for _, pod := range dev.GetPods() {
+			pods = append(pods, pod)
+			oldIDs[pod.UID] = id
+		}
+	}
+	devMap := map[int]*DeviceInfo{}
+	for i := 0; i < n.gpuCount; i++ {
+		devMap[i] = newDeviceInfo(i, uuidAt(uuids, i), uint(n.gpuTotalMemory/n.gpuCount), n.profile)
+	}
+	n.devs = devMap
+	cacheLog.V(3).Info("the devices of node changed", log.Node(n.name), log.Int("devices", len(n.devs)), log.Strings("uuids", uuids))
+
+	for _, pod := range pods {
+		id := n.deviceIDOfPod(pod)
+		dev, found := n.devs[id]
+		if id != oldIDs[pod.UID] || !found {
+			n.feed.publishPod(ChangeReleased, n.name, oldIDs[pod.UID], pod, n.profile)
+		}
+		if !found {
+			cacheLog.V(3).Warn("the device of pod is gone", log.Pod(pod.Name), log.Namespace(pod.Namespace), log.String("uuid", n.profile.GPUUUIDFromAnnotation(pod)), log.Node(n.name))
+			dropped = append(dropped, pod)
+			continue
+		}
+		dev.addPod(pod)
+		if id != oldIDs[pod.UID] {
+			n.feed.publishPod(ChangeAllocated, n.name, id, pod, n.profile)
+		}
+	}
+	return dropped
+}
+
+// GetDeviceIDOfPod returns the index of the device allocated to the pod, -1 if it's not on a device of the node
+func (n *NodeInfo) GetDeviceIDOfPod(pod *v1.Pod) int {
+	n.rwmu.RLock()
+	defer n.rwmu.RUnlock()
+	return n.deviceIDOfPod(pod)
+}
+
+// deviceIDOfPod resolves the device by the UUID of the pod. The index is only used for the legacy pods
+// without the UUID, or if the node doesn't report the UUIDs.
+func (n *NodeInfo) deviceIDOfPod(pod *v1.Pod) int {
+	uuid := n.profile.GPUUUIDFromAnnotation(pod)
+	if len(uuid) == 0 {
+		return n.profile.GPUIDFromAnnotation(pod)
+	}
+	known := false
+	for id, dev := range n.devs {
+		if dev.uuid == uuid {
+			return id
+		}
+		known = known || len(dev.uuid) > 0
+	}
+	if known {
+		return -1
+	}
+	return n.profile.GPUIDFromAnnotation(pod)
+}
+
+func uuidAt(uuids []string, id int) string {
+	if id < 0 || id >= len(uuids) {
+		return ""
+	}
+	return uuids[id]
+}
+
+func (n *NodeInfo) publishNode() {
+	n.feed.publish(&Change{Type: ChangeNode, Node: n.name, GPUCount: n.gpuCount, TotalGPUMemory: n.gpuTotalMemory})
 }
 
 func (n *NodeInfo) GetName() string {
@@ -103,20 +256,44 @@ func (n *NodeInfo) GetGPUCount() int {
 	return n.gpuCount
 }
 
+// GetProfile returns the profile of the node
+func (n *NodeInfo) GetProfile() *utils.Profile {
+	return n.profile
+}
+
+// GetProfileOfPod returns the profile of the pod among the profiles of the cache
+func (n *NodeInfo) GetProfileOfPod(pod *v1.Pod) *utils.Profile {
+	return n.profiles.OfPod(pod)
+}
+
+// GetUnhealthyDevs returns the devices which are reported as unhealthy in the configmap
+func (n *NodeInfo) GetUnhealthyDevs() []*DeviceInfo {
+	n.rwmu.RLock()
+	defer n.rwmu.RUnlock()
+
+	devs := []*DeviceInfo{}
+	for id := range n.getUnhealthyGPUs() {
+		if dev, found := n.devs[id]; found {
+			devs = append(devs, dev)
+		}
+	}
+	return devs
+}
+
 func (n *NodeInfo) removePod(pod *v1.Pod) {
 	n.rwmu.Lock()
 	defer n.rwmu.Unlock()
 
-	id := utils.GetGPUIDFromAnnotation(pod)
+	id := n.deviceIDOfPod(pod)
 	if id >= 0 {
 		dev, found := n.devs[id]
 		if !found {
-			log.V(3).Info("warn: Pod %s in ns %s failed to find the GPU ID %d in node %s", pod.Name, pod.Namespace, id, n.name)
-		} else {
-			dev.removePod(pod)
+			cacheLog.V(3).Warn("pod failed to find the GPU ID in node", log.Pod(pod.Name), log.Namespace(pod.Namespace), log.DevID(id), log.Node(n.name))
+		} else if dev.removePod(pod) {
+			n.feed.publishPod(ChangeReleased, n.name, id, pod, n.profile)
 		}
 	} else {
-		log.V(3).Info("warn: Pod %s in ns %s is not set the GPU ID %d in node %s", pod.Name, pod.Namespace, id, n.name)
+		cacheLog.V(3).Warn("pod is not set the GPU ID in node", log.Pod(pod.Name), log.Namespace(pod.Namespace), log.DevID(id), log.Node(n.name))
 	}
 }
 
@@ -125,128 +302,209 @@ func (n *NodeInfo) addOrUpdatePod(pod *v1.Pod) (added bool) {
 	n.rwmu.Lock()
 	defer n.rwmu.Unlock()
 
-	id := utils.GetGPUIDFromAnnotation(pod)
-	log.V(3).Info("debug: addOrUpdatePod() Pod %s in ns %s with the GPU ID %d should be added to device map",
-		pod.Name,
-		pod.Namespace,
-		id)
+	id := n.deviceIDOfPod(pod)
+	cacheLog.V(3).Debug("addOrUpdatePod() pod should be added to device map",
+		log.Pod(pod.Name),
+		log.Namespace(pod.Namespace),
+		log.DevID(id))
 	if id >= 0 {
 		dev, found := n.devs[id]
 		if !found {
-			log.V(3).Info("warn: Pod %s in ns %s failed to find the GPU ID %d in node %s", pod.Name, pod.Namespace, id, n.name)
+			cacheLog.V(3).Warn("pod failed to find the GPU ID in node", log.Pod(pod.Name), log.Namespace(pod.Namespace), log.DevID(id), log.Node(n.name))
 		} else {
-			dev.addPod(pod)
+			if dev.addPod(pod) {
+				n.feed.publishPod(ChangeAllocated, n.name, id, pod, n.profile)
+			}
 			added = true
 		}
 	} else {
-		log.V(3).Info("warn: Pod %s in ns %s is not set the GPU ID %d in node %s", pod.Name, pod.Namespace, id, n.name)
+		cacheLog.V(3).Warn("pod is not set the GPU ID in node", log.Pod(pod.Name), log.Namespace(pod.Namespace), log.DevID(id), log.Node(n.name))
 	}
 	return added
 }
 
 // check if the pod can be allocated on the node
 func (n *NodeInfo) Assume(pod *v1.Pod) (allocatable bool) {
-	allocatable = false
-
-	n.rwmu.RLock()
-	defer n.rwmu.RUnlock()
-
-	availableGPUs := n.getAvailableGPUs()
-	reqGPU := uint(utils.GetGPUMemoryFromPodResource(pod))
-	log.V(10).Info("debug: AvailableGPUs: %v in node %s", availableGPUs, n.name)
-
-	if len(availableGPUs) > 0 {
-		for devID := 0; devID < len(n.devs); devID++ {
-			availableGPU, ok := availableGPUs[devID]
-			if ok {
-				if availableGPU >= reqGPU {
-					allocatable = true
-					break
-				}
-			}
-		}
-	}
-
-	return allocatable
-
+	return n.Reject(pod) == nil
 }
 
-func (n *NodeInfo) Allocate(clientset *kubernetes.Clientset, pod *v1.Pod) (err error) {
+func (n *NodeInfo) Allocate(ctx context.Context, clientset kubernetes.Interface, recorder record.EventRecorder, pod *v1.Pod) (err error) {
 	var newPod *v1.Pod
+	ctx, span := tracing.StartSpan(ctx, "Allocate", append(tracing.Pod(pod.Name, pod.Namespace), tracing.Node(n.name))...)
+	defer func() {
+		tracing.EndSpan(span, err)
+	}()
+	// the wait for the node lock shows the binds which are serialized on the node
+	_, lockSpan := tracing.StartSpan(ctx, "LockNode", tracing.Node(n.name))
 	n.rwmu.Lock()
+	lockSpan.End()
 	defer n.rwmu.Unlock()
-	log.V(3).Info("info: Allocate() ----Begin to allocate GPU for gpu mem for pod %s in ns %s----", pod.Name, pod.Namespace)
+	bindLog.V(3).Info("Allocate() ----Begin to allocate GPU for gpu mem for pod----", log.Pod(pod.Name), log.Namespace(pod.Namespace), log.Node(n.name))
+	// the bind may be cancelled while waiting for the node lock, e.g. the leader lost its lease
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("gave up allocating GPU for pod %s in ns %s: %v", pod.Name, pod.Namespace, err)
+	}
 	// 1. Update the pod spec
 	devId, found := n.allocateGPUID(pod)
+	span.SetAttributes(attribute.Int("devID", devId))
 	if found {
-		log.V(3).Info("info: Allocate() 1. Allocate GPU ID %d to pod %s in ns %s.----", devId, pod.Name, pod.Namespace)
+		bindLog.V(3).Info("Allocate() 1. Allocate GPU ID to pod", log.DevID(devId), log.Pod(pod.Name), log.Namespace(pod.Namespace))
 		// newPod := utils.GetUpdatedPodEnvSpec(pod, devId, nodeInfo.GetTotalGPUMemory()/nodeInfo.GetGPUCount())
 		//newPod = utils.GetUpdatedPodAnnotationSpec(pod, devId, n.GetTotalGPUMemory()/n.GetGPUCount())
-		patchedAnnotationBytes, err := utils.PatchPodAnnotationSpec(pod, devId, n.GetTotalGPUMemory()/n.GetGPUCount())
+		patchedAnnotationBytes, err := n.profile.PatchPodAnnotationSpec(pod, devId, n.devs[devId].uuid, n.GetTotalGPUMemory()/n.GetGPUCount())
 		if err != nil {
-			return fmt.Errorf("failed to generate patched annotations,reason: %v", err)
+			return metrics.WithReason(metrics.ReasonPatch, fmt.Errorf("failed to generate patched annotations,reason: %v", err))
 		}
-		newPod, err = clientset.CoreV1().Pods(pod.Namespace).Patch(n.ctx, pod.Name, types.StrategicMergePatchType, patchedAnnotationBytes, metav1.PatchOptions{})
+		newPod, err = patchPod(ctx, clientset, pod, patchedAnnotationBytes)
 		//_, err = clientset.CoreV1().Pods(newPod.Namespace).Update(newPod)
 		if err != nil {
 			// the object has been modified; please apply your changes to the latest version and try again
 			if err.Error() == OptimisticLockErrorMsg {
 				// retry
-				pod, err = clientset.CoreV1().Pods(pod.Namespace).Get(n.ctx, pod.Name, metav1.GetOptions{})
+				metrics.ConflictRetries.Inc()
+				recorder.Eventf(pod, v1.EventTypeNormal, ReasonGPUAllocationConflict,
+					"Retrying the allocation of GPU %d on node %s after a conflict", devId, n.name)
+				pod, err = clientset.CoreV1().Pods(pod.Namespace).Get(ctx, pod.Name, metav1.GetOptions{})
 				if err != nil {
-					return err
+					return metrics.WithReason(metrics.ReasonGetPod, err)
 				}
 				// newPod = utils.GetUpdatedPodEnvSpec(pod, devId, nodeInfo.GetTotalGPUMemory()/nodeInfo.GetGPUCount())
 				//newPod = utils.GetUpdatedPodAnnotationSpec(pod, devId, n.GetTotalGPUMemory()/n.GetGPUCount())
 				//_, err = clientset.CoreV1().Pods(newPod.Namespace).Update(newPod)
-				newPod, err = clientset.CoreV1().Pods(pod.Namespace).Patch(n.ctx, pod.Name, types.StrategicMergePatchType, patchedAnnotationBytes, metav1.PatchOptions{})
+				newPod, err = patchPod(ctx, clientset, pod, patchedAnnotationBytes)
 				if err != nil {
-					return err
+					return metrics.WithReason(metrics.ReasonPatch, err)
 				}
 			} else {
-				log.V(3).Info("failed to patch pod %v", pod)
-				return err
+				bindLog.V(3).Warn("failed to patch pod", log.Pod(pod.Name), log.Namespace(pod.Namespace), log.Err(err))
+				return metrics.WithReason(metrics.ReasonPatch, err)
 			}
 		}
 	} else {
-		err = fmt.Errorf("The node %s can't place the pod %s in ns %s,and the pod spec is %v", pod.Spec.NodeName, pod.Name, pod.Namespace, pod)
+		err = metrics.WithReason(metrics.ReasonNoDevice,
+			fmt.Errorf("The node %s can't place the pod %s in ns %s,and the pod spec is %v", pod.Spec.NodeName, pod.Name, pod.Namespace, pod))
 	}
 
 	// 2. Bind the pod to the node
+	if err == nil && ctx.Err() != nil {
+		err = fmt.Errorf("gave up binding pod %s in ns %s: %v", pod.Name, pod.Namespace, ctx.Err())
+	}
 	if err == nil {
 		binding := &v1.Binding{
-			ObjectMeta: metav1.ObjectMeta{Name: pod.Name, UID: pod.UID},
+			ObjectMeta: metav1.ObjectMeta{Name: pod.Name, Namespace: pod.Namespace, UID: pod.UID},
 			Target:     v1.ObjectReference{Kind: "Node", Name: n.name},
 		}
-		log.V(3).Info("info: Allocate() 2. Try to bind pod %s in %s namespace to node %s with %v",
-			pod.Name,
-			pod.Namespace,
-			pod.Spec.NodeName,
-			binding)
-		err = clientset.CoreV1().Pods(pod.Namespace).Bind(n.ctx, binding, metav1.CreateOptions{})
+		bindLog.V(3).Info("Allocate() 2. Try to bind pod to node",
+			log.Pod(pod.Name),
+			log.Namespace(pod.Namespace),
+			log.Node(n.name))
+		err = bindPod(ctx, clientset, pod, binding)
 		if err != nil {
-			log.V(3).Info("warn: Failed to bind the pod %s in ns %s due to %v", pod.Name, pod.Namespace, err)
-			return err
+			bindLog.V(3).Warn("failed to bind the pod", log.Pod(pod.Name), log.Namespace(pod.Namespace), log.Node(n.name), log.Err(err))
+			return metrics.WithReason(metrics.ReasonBind, err)
 		}
 	}
 
 	// 3. update the device info if the pod is update successfully
 	if err == nil {
-		log.V(3).Info("info: Allocate() 3. Try to add pod %s in ns %s to dev %d",
-			pod.Name,
-			pod.Namespace,
-			devId)
+		bindLog.V(3).Info("Allocate() 3. Try to add pod to dev",
+			log.Pod(pod.Name),
+			log.Namespace(pod.Namespace),
+			log.DevID(devId))
 		dev, found := n.devs[devId]
 		if !found {
-			log.V(3).Info("warn: Pod %s in ns %s failed to find the GPU ID %d in node %s", pod.Name, pod.Namespace, devId, n.name)
-		} else {
-			dev.addPod(newPod)
+			bindLog.V(3).Warn("pod failed to find the GPU ID in node", log.Pod(pod.Name), log.Namespace(pod.Namespace), log.DevID(devId), log.Node(n.name))
+		} else if dev.addPod(newPod) {
+			n.feed.publishPod(ChangeAllocated, n.name, devId, newPod, n.profile)
 		}
+		recorder.Eventf(pod, v1.EventTypeNormal, ReasonGPUAllocated,
+			"Allocated %d GPU memory on GPU %d of node %s", n.profile.GPUMemoryFromPodResource(pod), devId, n.name)
 	}
-	log.V(3).Info("info: Allocate() ----End to allocate GPU for gpu mem for pod %s in ns %s----", pod.Name, pod.Namespace)
+	bindLog.V(3).Info("Allocate() ----End to allocate GPU for gpu mem for pod----", log.Pod(pod.Name), log.Namespace(pod.Namespace), log.Node(n.name))
 	return err
 }
 
+// AssumePod picks the device for the pod and accounts the pod on it before the API server knows.
+// The returned pod has the allocation annotations to patch, and it is removed by RemovePod if the
+// allocation is given up.
+func (n *NodeInfo) AssumePod(pod *v1.Pod) (assumed *v1.Pod, err error) {
+	n.rwmu.Lock()
+	defer n.rwmu.Unlock()
+
+	devID, found := n.allocateGPUID(pod)
+	if !found {
+		return nil, metrics.WithReason(metrics.ReasonNoDevice,
+			fmt.Errorf("the node %s can't place the pod %s in ns %s", n.name, pod.Name, pod.Namespace))
+	}
+	dev, found := n.devs[devID]
+	if !found {
+		return nil, fmt.Errorf("failed to find the GPU ID %d in node %s", devID, n.name)
+	}
+
+	assumed = n.profile.UpdatedPodAnnotationSpec(pod, devID, dev.uuid, n.GetTotalGPUMemory()/n.GetGPUCount())
+	assumed.Spec.NodeName = n.name
+	if dev.addPod(assumed) {
+		n.feed.publishPod(ChangeAllocated, n.name, devID, assumed, n.profile)
+	}
+	bindLog.V(3).Info("assumed the pod on the device", log.Pod(pod.Name), log.Namespace(pod.Namespace), log.Node(n.name), log.DevID(devID))
+	return assumed, nil
+}
+
+// AssumeGPUID returns the GPU ID which would be allocated to the pod without allocating it
+func (n *NodeInfo) AssumeGPUID(pod *v1.Pod) (devID int, found bool) {
+	n.rwmu.RLock()
+	defer n.rwmu.RUnlock()
+	return n.allocateGPUID(pod)
+}
+
+func patchPod(ctx context.Context, clientset kubernetes.Interface, pod *v1.Pod, patch []byte) (newPod *v1.Pod, err error) {
+	ctx, span := tracing.StartSpan(ctx, "PatchPod", tracing.Pod(pod.Name, pod.Namespace)...)
+	defer func() {
+		tracing.EndSpan(span, err)
+	}()
+	return clientset.CoreV1().Pods(pod.Namespace).Patch(ctx, pod.Name, types.StrategicMergePatchType, patch, metav1.PatchOptions{})
+}
+
+func bindPod(ctx context.Context, clientset kubernetes.Interface, pod *v1.Pod, binding *v1.Binding) (err error) {
+	ctx, span := tracing.StartSpan(ctx, "BindPod", append(tracing.Pod(pod.Name, pod.Namespace), tracing.Node(binding.Target.Name))...)
+	defer func() {
+		tracing.EndSpan(span, err)
+	}()
+	return clientset.CoreV1().Pods(pod.Namespace).Bind(ctx, binding, metav1.CreateOptions{})
+}
+
+// The strategies to pick the device of a node for a pod
+const (
+	// DeviceStrategyBinpack picks the device with the least free memory which fits the pod
+	DeviceStrategyBinpack = "binpack"
+	// DeviceStrategySpread picks the device with the most free memory
+	DeviceStrategySpread = "spread"
+)
+
+// spreadDevices is set by the strategy, it can be changed at runtime
+var spreadDevices int32
+
+// SetDeviceStrategy sets the strategy to pick the device, binpack or spread
+func SetDeviceStrategy(strategy string) error {
+	switch strategy {
+	case DeviceStrategyBinpack:
+		atomic.StoreInt32(&spreadDevices, 0)
+	case DeviceStrategySpread:
+		atomic.StoreInt32(&spreadDevices, 1)
+	default:
+		return fmt.Errorf("unknown device strategy %q, it should be %s or %s", strategy, DeviceStrategyBinpack, DeviceStrategySpread)
+	}
+	return nil
+}
+
+// betterDevice checks if the device with the available memory is preferred over the candidate
+func betterDevice(availableGPU, candidateGPUMemory uint) bool {
+	if atomic.LoadInt32(&spreadDevices) == 1 {
+		return availableGPU > candidateGPUMemory
+	}
+	return availableGPU < candidateGPUMemory
+}
+
 // allocate the GPU ID to the pod
 func (n *NodeInfo) allocateGPUID(pod *v1.Pod) (candidateDevID int, found bool) {
 
@@ -254,19 +512,19 @@ func (n *NodeInfo) allocateGPUID(pod *v1.Pod) (candidateDevID int, found bool) {
 	found = false
 	candidateDevID = -1
 	candidateGPUMemory := uint(0)
-	availableGPUs := n.getAvailableGPUs()
 
-	reqGPU = uint(utils.GetGPUMemoryFromPodResource(pod))
+	reqGPU = uint(n.profile.GPUMemoryFromPodResource(pod))
+	availableGPUs := n.getAvailableGPUsFor(reqGPU)
 
 	if reqGPU > uint(0) {
-		log.V(3).Info("info: reqGPU for pod %s in ns %s: %d", pod.Name, pod.Namespace, reqGPU)
-		log.V(3).Info("info: AvailableGPUs: %v in node %s", availableGPUs, n.name)
+		cacheLog.V(3).Info("reqGPU for pod", log.Pod(pod.Name), log.Namespace(pod.Namespace), log.ReqMem(reqGPU))
+		cacheLog.V(3).Info("AvailableGPUs", log.Any("availableGPUs", availableGPUs), log.Node(n.name))
 		if len(availableGPUs) > 0 {
 			for devID := 0; devID < len(n.devs); devID++ {
 				availableGPU, ok := availableGPUs[devID]
 				if ok {
 					if availableGPU >= reqGPU {
-						if candidateDevID == -1 || candidateGPUMemory > availableGPU {
+						if candidateDevID == -1 || betterDevice(availableGPU, candidateGPUMemory) {
 							candidateDevID = devID
 							candidateGPUMemory = availableGPU
 						}
@@ -278,21 +536,79 @@ func (n *NodeInfo) allocateGPUID(pod *v1.Pod) (candidateDevID int, found bool) {
 		}
 
 		if found {
-			log.V(3).Info("info: Find candidate dev id %d for pod %s in ns %s successfully.",
-				candidateDevID,
-				pod.Name,
-				pod.Namespace)
+			cacheLog.V(3).Info("find candidate dev id for pod successfully",
+				log.DevID(candidateDevID),
+				log.Pod(pod.Name),
+				log.Namespace(pod.Namespace))
 		} else {
-			log.V(3).Info("warn: Failed to find available GPUs %d for the pod %s in the namespace %s",
-				reqGPU,
-				pod.Name,
-				pod.Namespace)
+			cacheLog.V(3).Warn("failed to find available GPUs for the pod",
+				log.ReqMem(reqGPU),
+				log.Pod(pod.Name),
+				log.Namespace(pod.Namespace),
+				log.Node(n.name))
 		}
 	}
 
 	return candidateDevID, found
 }
 
+// GetAvailableGPUs returns the free gpu memory of the healthy devices, device index: gpu memory
+func (n *NodeInfo) GetAvailableGPUs() map[int]uint {
+	n.rwmu.RLock()
+	defer n.rwmu.RUnlock()
+	return n.getAvailableGPUs()
+}
+
+// GetAvailableGPUsFor returns the free gpu memory of the healthy devices which is usable for the request
+func (n *NodeInfo) GetAvailableGPUsFor(reqGPU uint) map[int]uint {
+	n.rwmu.RLock()
+	defer n.rwmu.RUnlock()
+	return n.getAvailableGPUsFor(reqGPU)
+}
+
+// Reserve holds back the gpu memory on the device for the pods which request at least the same amount
+func (n *NodeInfo) Reserve(devID int, gpuMem uint, ttl time.Duration) error {
+	n.rwmu.RLock()
+	defer n.rwmu.RUnlock()
+	dev, found := n.devs[devID]
+	if !found {
+		return fmt.Errorf("failed to find the GPU ID %d in node %s", devID, n.name)
+	}
+	dev.Reserve(gpuMem, ttl)
+	cacheLog.V(3).Info("reserved gpu memory of dev", log.ReqMem(gpuMem), log.DevID(devID), log.Node(n.name), log.Duration("ttl", ttl))
+	return nil
+}
+
+// Unreserve releases the reserved gpu memory on the device, e.g. when the defrag plan is abandoned
+func (n *NodeInfo) Unreserve(devID int) error {
+	n.rwmu.RLock()
+	defer n.rwmu.RUnlock()
+	dev, found := n.devs[devID]
+	if !found {
+		return fmt.Errorf("failed to find the GPU ID %d in node %s", devID, n.name)
+	}
+	dev.Unreserve()
+	cacheLog.V(3).Info("released the reserved gpu memory of dev", log.DevID(devID), log.Node(n.name))
+	return nil
+}
+
+// getAvailableGPUsFor excludes the memory reserved for larger requests from the available GPUs
+func (n *NodeInfo) getAvailableGPUsFor(reqGPU uint) (availableGPUs map[int]uint) {
+	availableGPUs = n.getAvailableGPUs()
+	for id, availableGPU := range availableGPUs {
+		reserved := n.devs[id].GetReservedGPUMemory(reqGPU)
+		if reserved == 0 {
+			continue
+		}
+		if availableGPU > reserved {
+			availableGPUs[id] = availableGPU - reserved
+		} else {
+			availableGPUs[id] = 0
+		}
+	}
+	return availableGPUs
+}
+
 func (n *NodeInfo) getAvailableGPUs() (availableGPUs map[int]uint) {
 	allGPUs := n.getAllGPUs()
 	usedGPUs := n.getUsedGPUs()
@@ -303,12 +619,12 @@ func (n *NodeInfo) getAvailableGPUs() (availableGPUs map[int]uint) {
 			availableGPUs[id] = totalGPUMem - usedGPUMem
 		}
 	}
-	log.V(3).Info("info: available GPU list %v before removing unhealty GPUs", availableGPUs)
+	cacheLog.V(3).Info("available GPU list before removing unhealty GPUs", log.Any("availableGPUs", availableGPUs), log.Node(n.name))
 	for id, _ := range unhealthyGPUs {
-		log.V(3).Info("info: delete dev %d from availble GPU list", id)
+		cacheLog.V(3).Info("delete dev from availble GPU list", log.DevID(id), log.Node(n.name))
 		delete(availableGPUs, id)
 	}
-	log.V(3).Info("info: available GPU list %v after removing unhealty GPUs", availableGPUs)
+	cacheLog.V(3).Info("available GPU list after removing unhealty GPUs", log.Any("availableGPUs", availableGPUs), log.Node(n.name))
 
 	return availableGPUs
 }
@@ -319,7 +635,7 @@ func (n *NodeInfo) getUsedGPUs() (usedGPUs map[int]uint) {
 	for _, dev := range n.devs {
 		usedGPUs[dev.idx] = dev.GetUsedGPUMemory()
 	}
-	log.V(3).Info("info: getUsedGPUs: %v in node %s, and devs %v", usedGPUs, n.name, n.devs)
+	cacheLog.V(3).Info("getUsedGPUs", log.Any("usedGPUs", usedGPUs), log.Node(n.name))
 	return usedGPUs
 }
 
@@ -329,32 +645,25 @@ func (n *NodeInfo) getAllGPUs() (allGPUs map[int]uint) {
 	for _, dev := range n.devs {
 		allGPUs[dev.idx] = dev.totalGPUMem
 	}
-	log.V(3).Info("info: getAllGPUs: %v in node %s, and dev %v", allGPUs, n.name, n.devs)
+	cacheLog.V(3).Info("getAllGPUs", log.Any("allGPUs", allGPUs), log.Node(n.name))
 	return allGPUs
 }
 
 // getUnhealthyGPUs get the unhealthy GPUs from configmap
 func (n *NodeInfo) getUnhealthyGPUs() (unhealthyGPUs map[int]bool) {
 	unhealthyGPUs = map[int]bool{}
-	name := fmt.Sprintf("unhealthy-gpu-%s", n.GetName())
-	log.V(3).Info("info: try to find unhealthy node %s", name)
-	cm := getConfigMap(name)
+	name := UnhealthyConfigMapName(n.GetName())
+	cacheLog.V(3).Info("try to find unhealthy gpus", log.String("configmap", name), log.Node(n.name))
+	cm := getConfigMap(n.configMapLister, name)
 	if cm == nil {
 		return
 	}
 
 	if devicesStr, found := cm.Data["gpus"]; found {
-		log.V(3).Info("warn: the unhelathy gpus %s", devicesStr)
-		idsStr := strings.Split(devicesStr, ",")
-		for _, sid := range idsStr {
-			id, err := strconv.Atoi(sid)
-			if err != nil {
-				log.V(3).Info("warn: failed to parse id %s due to %v", sid, err)
-			}
-			unhealthyGPUs[id] = true
-		}
+		cacheLog.V(3).Warn("the unhelathy gpus", log.String("gpus", devicesStr), log.Node(n.name))
+		unhealthyGPUs = UnhealthyGPUsFromConfigMap(cm)
 	} else {
-		log.V(3).Info("info: skip, because there are no unhealthy gpus")
+		cacheLog.V(3).Info("skip, because there are no unhealthy gpus", log.Node(n.name))
 	}
 
 	return
@@ -0,0 +1,113 @@
+package cache
+
+import (
+	"reflect"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+// withUUIDs annotates the node with the device UUIDs of its device plugin
+func withUUIDs(node *v1.Node, uuids string) *v1.Node {
+	node.Annotations = map[string]string{"ALIYUN_COM_GPU_MEM_UUIDS": uuids}
+	return node
+}
+
+// newTestPodOnDevice is placed on the device of the index, and of the UUID if it's set
+func newTestPodOnDevice(name string, devID int, uuid string) *v1.Pod {
+	pod := newTestPodWithMemory(name, devID, 1)
+	if len(uuid) > 0 {
+		pod.Annotations["ALIYUN_COM_GPU_MEM_UUID"] = uuid
+	}
+	return pod
+}
+
+// devicesOfPods maps the pods to the devices they are placed on
+func devicesOfPods(n *NodeInfo) map[string]int {
+	devices := map[string]int{}
+	for _, dev := range n.GetDevs() {
+		for _, pod := range dev.GetPods() {
+			devices[pod.Name] = dev.GetID()
+		}
+	}
+	return devices
+}
+
+func TestResetReordersDevicesByUUID(t *testing.T) {
+	tests := []struct {
+		name        string
+		updated     *v1.Node
+		wantDevices map[string]int
+		wantUUIDs   []string
+		wantChanges int
+		wantKnown   []string
+	}{
+		{
+			name:        "unchanged devices",
+			updated:     withUUIDs(newTestNode("n1", 2, 16), "GPU-a,GPU-b"),
+			wantDevices: map[string]int{"on-a": 0, "on-b": 1, "legacy": 1},
+			wantUUIDs:   []string{"GPU-a", "GPU-b"},
+			wantChanges: 0,
+			wantKnown:   []string{"on-a", "on-b", "legacy"},
+		},
+		{
+			name:        "reordered devices",
+			updated:     withUUIDs(newTestNode("n1", 2, 16), "GPU-b,GPU-a"),
+			wantDevices: map[string]int{"on-a": 1, "on-b": 0, "legacy": 1},
+			wantUUIDs:   []string{"GPU-b", "GPU-a"},
+			wantChanges: 5,
+			wantKnown:   []string{"on-a", "on-b", "legacy"},
+		},
+		{
+			name:        "removed device",
+			updated:     withUUIDs(newTestNode("n1", 1, 8), "GPU-b"),
+			wantDevices: map[string]int{"on-b": 0},
+			wantUUIDs:   []string{"GPU-b"},
+			wantChanges: 5,
+			wantKnown:   []string{"on-b"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cache, nodeIndexer := newTestCache(t, withUUIDs(newTestNode("n1", 2, 16), "GPU-a,GPU-b"))
+			for _, pod := range []*v1.Pod{newTestPodOnDevice("on-a", 0, "GPU-a"), newTestPodOnDevice("on-b", 1, "GPU-b"), newTestPodOnDevice("legacy", 1, "")} {
+				if err := cache.AddOrUpdatePod(pod); err != nil {
+					t.Fatal(err)
+				}
+			}
+			published := len(cache.feed.history)
+
+			if err := nodeIndexer.Update(test.updated); err != nil {
+				t.Fatal(err)
+			}
+			info, err := cache.GetNodeInfo("n1")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if devices := devicesOfPods(info); !reflect.DeepEqual(devices, test.wantDevices) {
+				t.Errorf("expected the pods on the devices %v, got %v", test.wantDevices, devices)
+			}
+			uuids := []string{}
+			for _, dev := range info.GetDevs() {
+				uuids = append(uuids, dev.GetUUID())
+			}
+			if !reflect.DeepEqual(uuids, test.wantUUIDs) {
+				t.Errorf("expected the UUIDs %v, got %v", test.wantUUIDs, uuids)
+			}
+			if changes := len(cache.feed.history) - published; changes != test.wantChanges {
+				t.Errorf("expected %d changes, got %d", test.wantChanges, changes)
+			}
+			// the dropped pods are forgotten, so they are added back once they are allocated again
+			if known := len(cache.knownPods); known != len(test.wantKnown) {
+				t.Errorf("expected the known pods %v, got %d pods", test.wantKnown, known)
+			}
+			for _, name := range test.wantKnown {
+				if !cache.KnownPod(newTestPodOnDevice(name, 0, "").UID) {
+					t.Errorf("expected the pod %s to be known", name)
+				}
+			}
+		})
+	}
+}
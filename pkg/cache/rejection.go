@@ -0,0 +1,84 @@
+package cache
+
+import (
+	"fmt"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+// Rejection explains why no single device of the node fits the pod. The devices are only excluded as
+// unhealthy or reserved, the nodes which are not for GPU share or provide another profile's resource
+// are rejected as a whole before the devices are checked.
+type Rejection struct {
+	Request uint `json:"request"`
+	// LargestFree is the most usable gpu memory of a single device
+	LargestFree uint `json:"largestFree"`
+	// TotalFree is the usable gpu memory of all the devices, the node is fragmented if it's enough for the request
+	TotalFree uint `json:"totalFree"`
+	// Unhealthy are the devices excluded as unhealthy
+	Unhealthy []int `json:"unhealthy,omitempty"`
+	// Reserved are the devices which would fit the pod, but the memory is reserved for larger requests,
+	// e.g. by a defrag plan
+	Reserved []int `json:"reserved,omitempty"`
+}
+
+// Fragmented checks if the node has enough gpu memory for the pod, but not in one device
+func (r *Rejection) Fragmented() bool {
+	return r.Request > 0 && r.TotalFree >= r.Request
+}
+
+func (r *Rejection) Error() string {
+	reasons := []string{fmt.Sprintf("Insufficient GPU Memory in one device: requested %d, largest free %d", r.Request, r.LargestFree)}
+	if r.Fragmented() {
+		reasons = append(reasons, fmt.Sprintf("fragmented with %d free in total", r.TotalFree))
+	}
+	if len(r.Unhealthy) > 0 {
+		reasons = append(reasons, fmt.Sprintf("unhealthy devices %v", r.Unhealthy))
+	}
+	if len(r.Reserved) > 0 {
+		reasons = append(reasons, fmt.Sprintf("devices %v reserved for larger requests", r.Reserved))
+	}
+	return strings.Join(reasons, "; ")
+}
+
+// Reject returns why the pod can't be allocated on the node, nil if a device fits it
+func (n *NodeInfo) Reject(pod *v1.Pod) *Rejection {
+	n.rwmu.RLock()
+	defer n.rwmu.RUnlock()
+
+	reqGPU := uint(n.profile.GPUMemoryFromPodResource(pod))
+	availableGPUs := n.getAvailableGPUs()
+	r := &Rejection{Request: reqGPU}
+	for devID := 0; devID < len(n.devs); devID++ {
+		dev, found := n.devs[devID]
+		if !found {
+			continue
+		}
+		availableGPU, healthy := availableGPUs[devID]
+		if !healthy {
+			r.Unhealthy = append(r.Unhealthy, devID)
+			continue
+		}
+
+		usable := availableGPU
+		if reserved := dev.GetReservedGPUMemory(reqGPU); reserved > 0 {
+			if usable > reserved {
+				usable -= reserved
+			} else {
+				usable = 0
+			}
+			if availableGPU >= reqGPU && usable < reqGPU {
+				r.Reserved = append(r.Reserved, devID)
+			}
+		}
+		if usable >= reqGPU {
+			return nil
+		}
+		r.TotalFree += usable
+		if usable > r.LargestFree {
+			r.LargestFree = usable
+		}
+	}
+	return r
+}
@@ -0,0 +1,135 @@
+package cache
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	clientgocache "k8s.io/client-go/tools/cache"
+)
+
+// newTestPodWithMemory is a pod of the gpu memory, placed on the device if devID isn't negative
+func newTestPodWithMemory(name string, devID int, gpuMemory int64) *v1.Pod {
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", UID: types.UID("uid-" + name)},
+		Spec: v1.PodSpec{Containers: []v1.Container{{Name: "main", Resources: v1.ResourceRequirements{Limits: v1.ResourceList{
+			"aliyun.com/gpu-mem": *resource.NewQuantity(gpuMemory, resource.DecimalSI),
+		}}}}},
+	}
+	if devID >= 0 {
+		pod.Spec.NodeName = "n1"
+		pod.Status.Phase = v1.PodRunning
+		pod.Annotations = map[string]string{
+			"ALIYUN_COM_GPU_MEM_IDX": strconv.Itoa(devID),
+			"ALIYUN_COM_GPU_MEM_POD": strconv.FormatInt(gpuMemory, 10),
+		}
+	}
+	return pod
+}
+
+// newRejectionCache has the node n1 with 2 devices of 8 gpu memory and the unhealthy devices
+func newRejectionCache(t *testing.T, unhealthy string) *SchedulerCache {
+	nodes := clientgocache.NewIndexer(clientgocache.MetaNamespaceKeyFunc, clientgocache.Indexers{})
+	err := nodes.Add(&v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "n1"},
+		Status: v1.NodeStatus{Capacity: v1.ResourceList{
+			"aliyun.com/gpu-mem":   resource.MustParse("16"),
+			"aliyun.com/gpu-count": resource.MustParse("2"),
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	configMaps := clientgocache.NewIndexer(clientgocache.MetaNamespaceKeyFunc, clientgocache.Indexers{clientgocache.NamespaceIndex: clientgocache.MetaNamespaceIndexFunc})
+	if len(unhealthy) > 0 {
+		err = configMaps.Add(&v1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: UnhealthyConfigMapName("n1"), Namespace: ConfigMapNamespace},
+			Data:       map[string]string{"gpus": unhealthy},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	pods := clientgocache.NewIndexer(clientgocache.MetaNamespaceKeyFunc, clientgocache.Indexers{clientgocache.NamespaceIndex: clientgocache.MetaNamespaceIndexFunc})
+	return NewSchedulerCache(corelisters.NewNodeLister(nodes), corelisters.NewPodLister(pods), corelisters.NewConfigMapLister(configMaps))
+}
+
+func TestReject(t *testing.T) {
+	tests := []struct {
+		name      string
+		used      map[int]int64
+		unhealthy string
+		reserved  map[int]uint
+		request   int64
+		want      *Rejection
+	}{
+		{
+			name:    "device fits",
+			used:    map[int]int64{0: 4},
+			request: 4,
+		},
+		{
+			name:    "fragmented",
+			used:    map[int]int64{0: 5, 1: 5},
+			request: 6,
+			want:    &Rejection{Request: 6, LargestFree: 3, TotalFree: 6},
+		},
+		{
+			name:    "full",
+			used:    map[int]int64{0: 7, 1: 7},
+			request: 4,
+			want:    &Rejection{Request: 4, LargestFree: 1, TotalFree: 2},
+		},
+		{
+			name:      "unhealthy device is excluded",
+			used:      map[int]int64{0: 5},
+			unhealthy: "1",
+			request:   4,
+			want:      &Rejection{Request: 4, LargestFree: 3, TotalFree: 3, Unhealthy: []int{1}},
+		},
+		{
+			name:     "device reserved for larger requests",
+			used:     map[int]int64{1: 6},
+			reserved: map[int]uint{0: 8},
+			request:  4,
+			want:     &Rejection{Request: 4, LargestFree: 2, TotalFree: 2, Reserved: []int{0}},
+		},
+		{
+			name:     "reservation doesn't exclude the larger request",
+			used:     map[int]int64{1: 6},
+			reserved: map[int]uint{0: 8},
+			request:  8,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cache := newRejectionCache(t, test.unhealthy)
+			for devID, gpuMemory := range test.used {
+				if err := cache.AddOrUpdatePod(newTestPodWithMemory("used-"+strconv.Itoa(devID), devID, gpuMemory)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			info, err := cache.GetNodeInfo("n1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			for devID, gpuMemory := range test.reserved {
+				if err := info.Reserve(devID, gpuMemory, time.Minute); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			rejection := info.Reject(newTestPodWithMemory("pod", -1, test.request))
+			if !reflect.DeepEqual(rejection, test.want) {
+				t.Errorf("expected rejection %+v, got %+v", test.want, rejection)
+			}
+		})
+	}
+}
@@ -0,0 +1,292 @@
+package cache
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/AliyunContainerService/gpushare-scheduler-extender/pkg/utils"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	clientgocache "k8s.io/client-go/tools/cache"
+)
+
+// SnapshotVersion is the version of the snapshot document
+const SnapshotVersion = "gpushare.snapshot/v1"
+
+// Snapshot is a self-contained view of the scheduler cache
+type Snapshot struct {
+	Version   string    `json:"version"`
+	Timestamp time.Time `json:"timestamp"`
+	// Profiles are the profiles of the extender, the first one is the default.
+	// Empty means the profiles of the reader.
+	Profiles utils.Profiles  `json:"profiles,omitempty"`
+	Nodes    []*NodeSnapshot `json:"nodes"`
+}
+
+type NodeSnapshot struct {
+	Name   string            `json:"name"`
+	Labels map[string]string `json:"labels,omitempty"`
+	// Resource is the gpu memory resource of the node's profile, empty means the default profile
+	Resource       v1.ResourceName `json:"resource,omitempty"`
+	GPUCount       int             `json:"gpuCount"`
+	TotalGPUMemory int             `json:"totalGPUMemory"`
+	// UnhealthyDevices are the device ids in the unhealthy GPU configmap
+	UnhealthyDevices []int             `json:"unhealthyDevices,omitempty"`
+	Devices          []*DeviceSnapshot `json:"devs"`
+}
+
+type DeviceSnapshot struct {
+	ID int `json:"id"`
+	// UUID is empty if the device plugin doesn't report the UUIDs of the node
+	UUID           string         `json:"uuid,omitempty"`
+	TotalGPUMemory uint           `json:"totalGPUMemory"`
+	Pods           []*PodSnapshot `json:"pods"`
+}
+
+type PodSnapshot struct {
+	Name      string    `json:"name"`
+	Namespace string    `json:"namespace"`
+	UID       types.UID `json:"uid"`
+	// GPUMemory is the requested gpu memory in the annotation
+	GPUMemory uint        `json:"gpuMemory"`
+	Phase     v1.PodPhase `json:"phase,omitempty"`
+	// AssumeTime is the unix nano time when the device was allocated, 0 if unknown
+	AssumeTime int64 `json:"assumeTime,omitempty"`
+	Assigned   bool  `json:"assigned"`
+}
+
+// Snapshot captures all the GPU sharing nodes and their devices
+func (cache *SchedulerCache) Snapshot() (*Snapshot, error) {
+	nodeInfos, err := cache.ListNodeInfos(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(nodeInfos, func(i, j int) bool {
+		return nodeInfos[i].GetName() < nodeInfos[j].GetName()
+	})
+
+	snapshot := &Snapshot{
+		Version:   SnapshotVersion,
+		Timestamp: time.Now(),
+		Profiles:  cache.profiles,
+		Nodes:     []*NodeSnapshot{},
+	}
+	for _, info := range nodeInfos {
+		snapshot.Nodes = append(snapshot.Nodes, info.Snapshot())
+	}
+	return snapshot, nil
+}
+
+// Snapshot captures the devices of the node and the pods on them
+func (n *NodeInfo) Snapshot() *NodeSnapshot {
+	n.rwmu.RLock()
+	defer n.rwmu.RUnlock()
+
+	s := &NodeSnapshot{
+		Name:             n.name,
+		Labels:           n.node.Labels,
+		Resource:         n.profile.ResourceName,
+		GPUCount:         n.gpuCount,
+		TotalGPUMemory:   n.gpuTotalMemory,
+		UnhealthyDevices: []int{},
+		Devices:          []*DeviceSnapshot{},
+	}
+	for id := range n.getUnhealthyGPUs() {
+		s.UnhealthyDevices = append(s.UnhealthyDevices, id)
+	}
+	sort.Ints(s.UnhealthyDevices)
+
+	for id := 0; id < len(n.devs); id++ {
+		dev, found := n.devs[id]
+		if !found {
+			continue
+		}
+		s.Devices = append(s.Devices, dev.snapshot())
+	}
+	return s
+}
+
+func (d *DeviceInfo) snapshot() *DeviceSnapshot {
+	s := &DeviceSnapshot{
+		ID:             d.idx,
+		UUID:           d.uuid,
+		TotalGPUMemory: d.totalGPUMem,
+		Pods:           []*PodSnapshot{},
+	}
+	for _, pod := range d.GetPods() {
+		s.Pods = append(s.Pods, podSnapshot(pod, d.profile))
+	}
+	sort.Slice(s.Pods, func(i, j int) bool {
+		return s.Pods[i].Namespace+"/"+s.Pods[i].Name < s.Pods[j].Namespace+"/"+s.Pods[j].Name
+	})
+	return s
+}
+
+// podSnapshot reads the allocation of the pod with the profile of its node
+func podSnapshot(pod *v1.Pod, profile *utils.Profile) *PodSnapshot {
+	s := &PodSnapshot{
+		Name:      pod.Name,
+		Namespace: pod.Namespace,
+		UID:       pod.UID,
+		GPUMemory: profile.GPUMemoryFromPodAnnotation(pod),
+		Phase:     pod.Status.Phase,
+		Assigned:  pod.Annotations[profile.AssignedKey] == "true",
+	}
+	if assumeTime, err := strconv.ParseInt(pod.Annotations[profile.AssumeTimeKey], 10, 64); err == nil {
+		s.AssumeTime = assumeTime
+	}
+	return s
+}
+
+// Objects converts the snapshot into the nodes, the unhealthy GPU configmaps and the pods
+// with the allocation annotations, which the scheduler cache is built from.
+// The resources and the annotation keys are the ones of the snapshot's profiles.
+func (s *Snapshot) Objects() ([]runtime.Object, error) {
+	if s.Version != SnapshotVersion {
+		return nil, fmt.Errorf("unsupported snapshot version %q, expected %q", s.Version, SnapshotVersion)
+	}
+
+	profiles := s.GetProfiles()
+	objs := []runtime.Object{}
+	for _, n := range s.Nodes {
+		profile := profiles.Default()
+		if len(n.Resource) > 0 {
+			profile = profiles.ByResource(n.Resource)
+			if profile == nil {
+				return nil, fmt.Errorf("node %s has the resource %s of no profile", n.Name, n.Resource)
+			}
+		}
+		node := &v1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: n.Name, Labels: n.Labels},
+			Status: v1.NodeStatus{
+				Capacity: v1.ResourceList{
+					profile.ResourceName: *resource.NewQuantity(int64(n.TotalGPUMemory), resource.DecimalSI),
+					profile.CountName:    *resource.NewQuantity(int64(n.GPUCount), resource.DecimalSI),
+				},
+			},
+		}
+		if uuids := n.uuids(); len(uuids) > 0 {
+			node.Annotations = map[string]string{profile.NodeUUIDsKey: strings.Join(uuids, ",")}
+		}
+		objs = append(objs, node)
+
+		if len(n.UnhealthyDevices) > 0 {
+			ids := []string{}
+			for _, id := range n.UnhealthyDevices {
+				ids = append(ids, strconv.Itoa(id))
+			}
+			objs = append(objs, &v1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{Name: UnhealthyConfigMapName(n.Name), Namespace: ConfigMapNamespace},
+				Data:       map[string]string{"gpus": strings.Join(ids, ",")},
+			})
+		}
+
+		for _, dev := range n.Devices {
+			for _, p := range dev.Pods {
+				objs = append(objs, p.pod(n.Name, dev, profile))
+			}
+		}
+	}
+	return objs, nil
+}
+
+// uuids returns the device UUIDs in the order of the ids, nil unless every device has one
+func (n *NodeSnapshot) uuids() []string {
+	if len(n.Devices) != n.GPUCount {
+		return nil
+	}
+	uuids := make([]string, n.GPUCount)
+	for _, dev := range n.Devices {
+		if len(dev.UUID) == 0 || dev.ID < 0 || dev.ID >= n.GPUCount {
+			return nil
+		}
+		uuids[dev.ID] = dev.UUID
+	}
+	return uuids
+}
+
+func (p *PodSnapshot) pod(nodeName string, dev *DeviceSnapshot, profile *utils.Profile) *v1.Pod {
+	phase := p.Phase
+	if len(phase) == 0 {
+		phase = v1.PodRunning
+	}
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      p.Name,
+			Namespace: p.Namespace,
+			UID:       p.UID,
+			Annotations: map[string]string{
+				profile.IndexKey:      strconv.Itoa(dev.ID),
+				profile.PodKey:        strconv.FormatUint(uint64(p.GPUMemory), 10),
+				profile.DeviceKey:     strconv.FormatUint(uint64(dev.TotalGPUMemory), 10),
+				profile.AssignedKey:   strconv.FormatBool(p.Assigned),
+				profile.AssumeTimeKey: strconv.FormatInt(p.AssumeTime, 10),
+			},
+		},
+		Spec: v1.PodSpec{
+			NodeName: nodeName,
+			Containers: []v1.Container{{
+				Name: "main",
+				Resources: v1.ResourceRequirements{
+					Limits: v1.ResourceList{
+						profile.ResourceName: *resource.NewQuantity(int64(p.GPUMemory), resource.DecimalSI),
+					},
+				},
+			}},
+		},
+		Status: v1.PodStatus{Phase: phase},
+	}
+	if len(dev.UUID) > 0 {
+		pod.Annotations[profile.UUIDKey] = dev.UUID
+	}
+	return pod
+}
+
+// GetProfiles returns the profiles of the snapshot, or the ones of the reader if it has none
+func (s *Snapshot) GetProfiles() utils.Profiles {
+	if len(s.Profiles) == 0 {
+		return utils.GetProfiles()
+	}
+	return s.Profiles
+}
+
+// NewSchedulerCacheFromSnapshot rebuilds the scheduler cache from the snapshot without a kube API server.
+// The cache has its own listers and the profiles of the snapshot, so several snapshots can be loaded in one process.
+func NewSchedulerCacheFromSnapshot(s *Snapshot) (*SchedulerCache, error) {
+	objs, err := s.Objects()
+	if err != nil {
+		return nil, err
+	}
+
+	nodes := clientgocache.NewIndexer(clientgocache.MetaNamespaceKeyFunc, clientgocache.Indexers{})
+	pods := clientgocache.NewIndexer(clientgocache.MetaNamespaceKeyFunc, clientgocache.Indexers{})
+	configMaps := clientgocache.NewIndexer(clientgocache.MetaNamespaceKeyFunc, clientgocache.Indexers{})
+	for _, obj := range objs {
+		switch obj.(type) {
+		case *v1.Node:
+			err = nodes.Add(obj)
+		case *v1.Pod:
+			err = pods.Add(obj)
+		case *v1.ConfigMap:
+			err = configMaps.Add(obj)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	cache := NewSchedulerCache(corelisters.NewNodeLister(nodes), corelisters.NewPodLister(pods), corelisters.NewConfigMapLister(configMaps))
+	cache.profiles = s.GetProfiles()
+	if err := cache.BuildCache(); err != nil {
+		return nil, err
+	}
+	return cache, nil
+}
@@ -0,0 +1,138 @@
+package cache
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/AliyunContainerService/gpushare-scheduler-extender/pkg/log"
+	"github.com/AliyunContainerService/gpushare-scheduler-extender/pkg/utils"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func init() {
+	log.NewLoggerWithLevel(0)
+}
+
+func newTestNodeSnapshot(name string, unhealthy ...int) *NodeSnapshot {
+	return &NodeSnapshot{
+		Name:             name,
+		Labels:           map[string]string{"pool": "gpu"},
+		GPUCount:         2,
+		TotalGPUMemory:   16,
+		UnhealthyDevices: unhealthy,
+		Devices: []*DeviceSnapshot{
+			{ID: 0, TotalGPUMemory: 8, Pods: []*PodSnapshot{
+				{Name: name + "-pod", Namespace: "default", UID: types.UID("uid-" + name), GPUMemory: 4, Phase: v1.PodRunning, AssumeTime: 1, Assigned: true},
+			}},
+			{ID: 1, TotalGPUMemory: 8, Pods: []*PodSnapshot{}},
+		},
+	}
+}
+
+// otherProfile is a profile the reader of the snapshots doesn't know
+var otherProfile = utils.NewProfile("other", "example.com/gpu-mem", "example.com/gpu-count", "EXAMPLE_COM_GPU_MEM")
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	other := newTestNodeSnapshot("n3")
+	other.Resource = otherProfile.ResourceName
+	unknown := newTestNodeSnapshot("n4")
+	unknown.Resource = "example.com/unknown"
+
+	tests := []struct {
+		name      string
+		snapshot  *Snapshot
+		wantError bool
+	}{
+		{
+			name:      "unsupported version",
+			snapshot:  &Snapshot{Version: "gpushare.snapshot/v0", Nodes: []*NodeSnapshot{}},
+			wantError: true,
+		},
+		{
+			name:     "nodes with pods and unhealthy devices",
+			snapshot: &Snapshot{Version: SnapshotVersion, Nodes: []*NodeSnapshot{newTestNodeSnapshot("n1", 1), newTestNodeSnapshot("n2")}},
+		},
+		{
+			name: "nodes of the default and another profile",
+			snapshot: &Snapshot{
+				Version:  SnapshotVersion,
+				Profiles: utils.Profiles{utils.DefaultProfile(), otherProfile},
+				Nodes:    []*NodeSnapshot{newTestNodeSnapshot("n1"), other},
+			},
+		},
+		{
+			name: "node with the resource of no profile",
+			snapshot: &Snapshot{
+				Version:  SnapshotVersion,
+				Profiles: utils.Profiles{utils.DefaultProfile(), otherProfile},
+				Nodes:    []*NodeSnapshot{unknown},
+			},
+			wantError: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			data, err := json.Marshal(test.snapshot)
+			if err != nil {
+				t.Fatal(err)
+			}
+			decoded := &Snapshot{}
+			if err := json.Unmarshal(data, decoded); err != nil {
+				t.Fatal(err)
+			}
+
+			cache, err := NewSchedulerCacheFromSnapshot(decoded)
+			if (err != nil) != test.wantError {
+				t.Fatalf("expected error %v, got %v", test.wantError, err)
+			}
+			if test.wantError {
+				return
+			}
+			if got := utils.GetProfiles(); len(got) != 1 || got.Default().Name != utils.DefaultProfileName {
+				t.Errorf("expected the profiles of the package unchanged, got %d profiles", len(got))
+			}
+			snapshot, err := cache.Snapshot()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(snapshot.Profiles, decoded.GetProfiles()) {
+				t.Errorf("expected the profiles of the snapshot preserved, got %d profiles", len(snapshot.Profiles))
+			}
+			// the healthy nodes are dumped with an empty list, and all the nodes with the resource of their profile
+			for _, node := range test.snapshot.Nodes {
+				if node.UnhealthyDevices == nil {
+					node.UnhealthyDevices = []int{}
+				}
+				if len(node.Resource) == 0 {
+					node.Resource = utils.DefaultResourceName
+				}
+			}
+			if !reflect.DeepEqual(snapshot.Nodes, test.snapshot.Nodes) {
+				got, _ := json.Marshal(snapshot.Nodes)
+				want, _ := json.Marshal(test.snapshot.Nodes)
+				t.Errorf("expected the nodes %s, got %s", want, got)
+			}
+		})
+	}
+}
+
+func TestSnapshotCachesAreIndependent(t *testing.T) {
+	unhealthy, err := NewSchedulerCacheFromSnapshot(&Snapshot{Version: SnapshotVersion, Nodes: []*NodeSnapshot{newTestNodeSnapshot("n1", 1)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewSchedulerCacheFromSnapshot(&Snapshot{Version: SnapshotVersion, Nodes: []*NodeSnapshot{newTestNodeSnapshot("n1")}}); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := unhealthy.GetNodeInfo("n1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if devs := info.GetUnhealthyDevs(); len(devs) != 1 || devs[0].GetID() != 1 {
+		t.Errorf("expected the device 1 unhealthy after loading another snapshot, got %d devices", len(devs))
+	}
+}
@@ -0,0 +1,197 @@
+package certs
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/AliyunContainerService/gpushare-scheduler-extender/pkg/log"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// Reloader serves the certificate and the client CA from the files, and loads them again when the files
+// are modified, so the rotated certificates are used without a restart.
+type Reloader struct {
+	certFile     string
+	keyFile      string
+	clientCAFile string
+	// allowedNames are the common names of the clients, empty allows any client verified by the CA
+	allowedNames map[string]bool
+
+	lock      sync.RWMutex
+	cert      *tls.Certificate
+	clientCAs *x509.CertPool
+	modTimes  map[string]time.Time
+}
+
+// NewReloader loads the files, clientCAFile is optional and enables verifying the client certificates
+func NewReloader(certFile, keyFile, clientCAFile string, allowedNames []string) (*Reloader, error) {
+	r := &Reloader{
+		certFile:     certFile,
+		keyFile:      keyFile,
+		clientCAFile: clientCAFile,
+		allowedNames: map[string]bool{},
+		modTimes:     map[string]time.Time{},
+	}
+	for _, name := range allowedNames {
+		r.allowedNames[name] = true
+	}
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Run checks the modification time of the files every interval until stopCh is closed.
+// The files which fail to load are logged, and the current ones are kept.
+func (r *Reloader) Run(interval time.Duration, stopCh <-chan struct{}) {
+	wait.Until(r.reload, interval, stopCh)
+}
+
+func (r *Reloader) reload() {
+	if !r.modified() {
+		return
+	}
+	if err := r.load(); err != nil {
+		log.V(3).Error("failed to reload the certificates, keep the current ones", log.Err(err))
+		return
+	}
+	log.V(3).Info("reloaded the certificates", log.String("cert", r.certFile), log.String("clientCA", r.clientCAFile))
+}
+
+// TLSConfig returns the config of the server, which always uses the latest certificates.
+// The client certificates are verified by the client CA if verifyClients.
+func (r *Reloader) TLSConfig(verifyClients bool) *tls.Config {
+	config := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: r.getCertificate,
+	}
+	if verifyClients {
+		config.GetConfigForClient = r.getConfigForClient
+	}
+	return config
+}
+
+func (r *Reloader) files() []string {
+	files := []string{r.certFile, r.keyFile}
+	if len(r.clientCAFile) > 0 {
+		files = append(files, r.clientCAFile)
+	}
+	return files
+}
+
+func (r *Reloader) modified() bool {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	for _, file := range r.files() {
+		// Stat follows the symlinks, which are swapped when a mounted secret is updated
+		info, err := os.Stat(file)
+		if err != nil {
+			log.V(3).Warn("failed to stat the certificate file", log.String("file", file), log.Err(err))
+			continue
+		}
+		if !info.ModTime().Equal(r.modTimes[file]) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Reloader) load() error {
+	modTimes := map[string]time.Time{}
+	for _, file := range r.files() {
+		info, err := os.Stat(file)
+		if err != nil {
+			return err
+		}
+		modTimes[file] = info.ModTime()
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load the key pair %s and %s: %v", r.certFile, r.keyFile, err)
+	}
+	var clientCAs *x509.CertPool
+	if len(r.clientCAFile) > 0 {
+		pem, err := os.ReadFile(r.clientCAFile)
+		if err != nil {
+			return err
+		}
+		clientCAs = x509.NewCertPool()
+		if !clientCAs.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no certificate is found in the client CA %s", r.clientCAFile)
+		}
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.cert = &cert
+	r.clientCAs = clientCAs
+	r.modTimes = modTimes
+	return nil
+}
+
+func (r *Reloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	return r.cert, nil
+}
+
+func (r *Reloader) getConfigForClient(*tls.ClientHelloInfo) (*tls.Config, error) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	config := &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{*r.cert},
+	}
+	if r.clientCAs != nil {
+		// the certificate is required by WithClientCert, so the probes can connect without one
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+		config.ClientCAs = r.clientCAs
+		config.VerifyPeerCertificate = r.verifyClientName
+	}
+	return config, nil
+}
+
+// WithClientCert rejects the requests without a verified client certificate if the client CA is set,
+// except the exempted paths, e.g. the probes of the kubelet which has no client certificate
+func (r *Reloader) WithClientCert(handler http.Handler, exemptPaths ...string) http.Handler {
+	if len(r.clientCAFile) == 0 {
+		return handler
+	}
+	exempt := map[string]bool{}
+	for _, path := range exemptPaths {
+		exempt[path] = true
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if !exempt[strings.TrimSuffix(req.URL.Path, "/")] && (req.TLS == nil || len(req.TLS.VerifiedChains) == 0) {
+			http.Error(w, "a client certificate is required", http.StatusUnauthorized)
+			return
+		}
+		handler.ServeHTTP(w, req)
+	})
+}
+
+// verifyClientName runs after the chain is verified by the client CA, the clients without a certificate
+// are rejected by WithClientCert
+func (r *Reloader) verifyClientName(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
+	if len(r.allowedNames) == 0 || len(rawCerts) == 0 {
+		return nil
+	}
+	for _, chain := range verifiedChains {
+		if len(chain) > 0 && r.allowedNames[chain[0].Subject.CommonName] {
+			return nil
+		}
+	}
+	name := ""
+	if len(verifiedChains) > 0 && len(verifiedChains[0]) > 0 {
+		name = verifiedChains[0][0].Subject.CommonName
+	}
+	log.V(4).Warn("reject the client certificate", log.String("commonName", name))
+	return fmt.Errorf("the client %q is not allowed", name)
+}
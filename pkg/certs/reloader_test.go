@@ -0,0 +1,218 @@
+package certs
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	stdlog "log"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/AliyunContainerService/gpushare-scheduler-extender/pkg/log"
+)
+
+func init() {
+	log.NewLoggerWithLevel(0)
+}
+
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+// newTestCert signs a certificate by the parent, or a self-signed CA without the parent
+func newTestCert(t *testing.T, commonName string, parent *testCert, usage x509.ExtKeyUsage) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	signer, signerKey := template, key
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage = x509.KeyUsageCertSign
+	} else {
+		template.ExtKeyUsage = []x509.ExtKeyUsage{usage}
+		template.KeyUsage = x509.KeyUsageDigitalSignature
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCert{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+func (c *testCert) keyPEM(t *testing.T) []byte {
+	der, err := x509.MarshalECPrivateKey(c.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+}
+
+func (c *testCert) tlsCertificate(t *testing.T) tls.Certificate {
+	cert, err := tls.X509KeyPair(c.pem, c.keyPEM(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func writeFile(t *testing.T, path string, data []byte) {
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReloaderVerifiesClients(t *testing.T) {
+	ca := newTestCert(t, "ca", nil, 0)
+	otherCA := newTestCert(t, "other-ca", nil, 0)
+	serverCert := newTestCert(t, "server", ca, x509.ExtKeyUsageServerAuth)
+
+	dir := t.TempDir()
+	certFile, keyFile, caFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key"), filepath.Join(dir, "ca.crt")
+	writeFile(t, certFile, serverCert.pem)
+	writeFile(t, keyFile, serverCert.keyPEM(t))
+	writeFile(t, caFile, ca.pem)
+
+	reloader, err := NewReloader(certFile, keyFile, caFile, []string{"system:kube-scheduler"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := reloader.WithClientCert(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}), "/readyz")
+	server := httptest.NewUnstartedServer(handler)
+	server.TLS = reloader.TLSConfig(true)
+	// the rejected handshakes are expected
+	server.Config.ErrorLog = stdlog.New(io.Discard, "", 0)
+	server.StartTLS()
+	defer server.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	tests := []struct {
+		name       string
+		clientCert *testCert
+		path       string
+		wantCode   int
+		wantError  bool
+	}{
+		{name: "allowed client", clientCert: newTestCert(t, "system:kube-scheduler", ca, x509.ExtKeyUsageClientAuth), path: "/filter", wantCode: http.StatusOK},
+		{name: "probe without certificate", path: "/readyz", wantCode: http.StatusOK},
+		{name: "filter without certificate", path: "/filter", wantCode: http.StatusUnauthorized},
+		{name: "client name not allowed", clientCert: newTestCert(t, "someone", ca, x509.ExtKeyUsageClientAuth), path: "/filter", wantError: true},
+		{name: "client of another CA", clientCert: newTestCert(t, "system:kube-scheduler", otherCA, x509.ExtKeyUsageClientAuth), path: "/readyz", wantError: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tlsConfig := &tls.Config{RootCAs: roots}
+			if test.clientCert != nil {
+				// send the certificate even if the server doesn't accept its CA
+				cert := test.clientCert.tlsCertificate(t)
+				tlsConfig.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+					return &cert, nil
+				}
+			}
+			client := &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}}
+			resp, err := client.Get(server.URL + test.path)
+			if test.wantError {
+				if err == nil {
+					resp.Body.Close()
+					t.Fatalf("expected the handshake to fail, got %d", resp.StatusCode)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != test.wantCode {
+				t.Errorf("expected code %d, got %d", test.wantCode, resp.StatusCode)
+			}
+		})
+	}
+}
+
+func TestReloaderReloadsModifiedFiles(t *testing.T) {
+	ca := newTestCert(t, "ca", nil, 0)
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+	first := newTestCert(t, "first", ca, x509.ExtKeyUsageServerAuth)
+	writeFile(t, certFile, first.pem)
+	writeFile(t, keyFile, first.keyPEM(t))
+
+	reloader, err := NewReloader(certFile, keyFile, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	second := newTestCert(t, "second", ca, x509.ExtKeyUsageServerAuth)
+	tests := []struct {
+		name     string
+		cert     []byte
+		key      []byte
+		wantName string
+	}{
+		{name: "unmodified files", wantName: "first"},
+		{name: "rotated certificate is loaded", cert: second.pem, key: second.keyPEM(t), wantName: "second"},
+		{name: "broken certificate keeps the current one", cert: []byte("broken"), wantName: "second"},
+	}
+	for i, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if test.cert != nil {
+				writeFile(t, certFile, test.cert)
+			}
+			if test.key != nil {
+				writeFile(t, keyFile, test.key)
+			}
+			// the modification time may not change within the resolution of the file system
+			modTime := time.Now().Add(time.Duration(i) * time.Minute)
+			for _, file := range []string{certFile, keyFile} {
+				if test.cert != nil || test.key != nil {
+					if err := os.Chtimes(file, modTime, modTime); err != nil {
+						t.Fatal(err)
+					}
+				}
+			}
+
+			reloader.reload()
+			cert, err := reloader.getCertificate(nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			leaf, err := x509.ParseCertificate(cert.Certificate[0])
+			if err != nil {
+				t.Fatal(err)
+			}
+			if leaf.Subject.CommonName != test.wantName {
+				t.Errorf("expected the certificate %s, got %s", test.wantName, leaf.Subject.CommonName)
+			}
+		})
+	}
+}
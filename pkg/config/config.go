@@ -0,0 +1,399 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/AliyunContainerService/gpushare-scheduler-extender/pkg/cache"
+	"github.com/AliyunContainerService/gpushare-scheduler-extender/pkg/defrag"
+	"github.com/AliyunContainerService/gpushare-scheduler-extender/pkg/log"
+	"github.com/AliyunContainerService/gpushare-scheduler-extender/pkg/scheduler"
+	"github.com/AliyunContainerService/gpushare-scheduler-extender/pkg/tracing"
+	"github.com/AliyunContainerService/gpushare-scheduler-extender/pkg/utils"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/tools/leaderelection"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	APIVersion = "gpushare.aliyun.com/v1alpha1"
+	Kind       = "ExtenderConfiguration"
+)
+
+// The modes of evicting the pods from the unhealthy GPUs
+const (
+	EvictionDisabled = "disabled"
+	EvictionEnabled  = "enabled"
+	EvictionDryRun   = "dryrun"
+)
+
+// Configuration is the versioned configuration file of the extender.
+// The fields marked hot-reload are applied again when the extender receives SIGHUP,
+// the others need a restart.
+type Configuration struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+
+	Server ServerConfiguration `json:"server"`
+	Admin  AdminConfiguration  `json:"admin"`
+	Log    LogConfiguration    `json:"log"`
+	// Threadness is the number of workers syncing the pods, 0 means the number of CPUs
+	Threadness int `json:"threadness"`
+
+	// Resources is the default profile
+	Resources ResourceConfiguration `json:"resources"`
+	// Profiles are served side by side with the default one, for the device plugins of other vendors or domains
+	Profiles []ProfileConfiguration `json:"profiles,omitempty"`
+	// ConfigMapNamespace is where the unhealthy GPU configmaps are
+	ConfigMapNamespace string `json:"configMapNamespace"`
+	// ResyncPeriod of the informers
+	ResyncPeriod metav1.Duration `json:"resyncPeriod"`
+
+	// Strategy picks the device of a node, binpack or spread (hot-reload)
+	Strategy string                `json:"strategy"`
+	Timeouts TimeoutsConfiguration `json:"timeouts"`
+
+	// UnhealthyGPUEviction is disabled, enabled or dryrun
+	UnhealthyGPUEviction string                      `json:"unhealthyGPUEviction"`
+	Defrag               DefragConfiguration         `json:"defrag"`
+	Tracing              TracingConfiguration        `json:"tracing"`
+	LeaderElection       LeaderElectionConfiguration `json:"leaderElection"`
+	NodeShare            NodeShareConfiguration      `json:"nodeShare"`
+}
+
+type ServerConfiguration struct {
+	Port int `json:"port"`
+	// ReadTimeout and WriteTimeout of the requests, 0 means no timeout
+	ReadTimeout  metav1.Duration  `json:"readTimeout"`
+	WriteTimeout metav1.Duration  `json:"writeTimeout"`
+	TLS          TLSConfiguration `json:"tls"`
+}
+
+// AdminConfiguration is the listener of the debug and admin routes, e.g. pprof, inspect and metrics.
+// It uses the certificate of the server without verifying the client certificates.
+type AdminConfiguration struct {
+	// Port 0 disables the admin routes, they are never served on the scheduler port.
+	// The listener needs server.tls, so the bearer tokens are not sent in plain text.
+	Port int `json:"port"`
+	// TokenReview authenticates the bearer tokens by TokenReview, and authorizes all the users
+	// by SubjectAccessReview of the non-resource urls
+	TokenReview bool `json:"tokenReview"`
+	// TokenFile is the static tokens for the local use, in the lines of token,user,uid,"group1,group2".
+	// Its users are authorized by SubjectAccessReview like the others with TokenReview, or all allowed without it.
+	TokenFile string `json:"tokenFile,omitempty"`
+}
+
+// Enabled checks if the admin routes are served by their own listener
+func (c AdminConfiguration) Enabled() bool {
+	return c.Port > 0
+}
+
+// TLSConfiguration enables HTTPS, the files are reloaded when they are modified
+type TLSConfiguration struct {
+	CertFile string `json:"certFile,omitempty"`
+	KeyFile  string `json:"keyFile,omitempty"`
+	// ClientCAFile enables verifying the client certificates
+	ClientCAFile string `json:"clientCAFile,omitempty"`
+	// AllowedClientNames are the common names of the allowed clients, e.g. system:kube-scheduler,
+	// empty allows any client verified by the CA
+	AllowedClientNames []string `json:"allowedClientNames,omitempty"`
+}
+
+// Enabled checks if the server serves HTTPS
+func (c TLSConfiguration) Enabled() bool {
+	return len(c.CertFile) > 0
+}
+
+type LogConfiguration struct {
+	// Level is debug, info, warn, error or a number (hot-reload)
+	Level string `json:"level"`
+	// Format is json or console
+	Format string `json:"format"`
+}
+
+// ResourceConfiguration is the names used by the device plugin
+type ResourceConfiguration struct {
+	ResourceName string                  `json:"resourceName"`
+	CountName    string                  `json:"countName"`
+	Annotations  AnnotationConfiguration `json:"annotations"`
+}
+
+// AnnotationConfiguration is the keys of the annotations set on the pods
+type AnnotationConfiguration struct {
+	Index      string `json:"index"`
+	Pod        string `json:"pod"`
+	Device     string `json:"device"`
+	Assigned   string `json:"assigned"`
+	AssumeTime string `json:"assumeTime"`
+	// UUID is the allocated device's UUID on the pod, Index is only read from the pods without it
+	UUID string `json:"uuid"`
+	// NodeUUIDs is the device UUIDs on the node in the order of the indexes, set by the device plugin
+	NodeUUIDs string `json:"nodeUUIDs"`
+}
+
+// ProfileConfiguration pairs the resources of a device plugin with the prefix of its annotations,
+// e.g. the prefix ALIYUN_COM_GPU_MEM gives the annotations ALIYUN_COM_GPU_MEM_IDX, ALIYUN_COM_GPU_MEM_POD...
+type ProfileConfiguration struct {
+	Name             string `json:"name"`
+	ResourceName     string `json:"resourceName"`
+	CountName        string `json:"countName"`
+	AnnotationPrefix string `json:"annotationPrefix"`
+}
+
+type TimeoutsConfiguration struct {
+	// Bind limits allocating the GPU and binding a pod (hot-reload)
+	Bind metav1.Duration `json:"bind"`
+}
+
+type DefragConfiguration struct {
+	// Executor allows executing the defrag plans
+	Executor bool `json:"executor"`
+	// ReserveTTL is how long the defragmented device is reserved for the pending pod
+	ReserveTTL metav1.Duration `json:"reserveTTL"`
+}
+
+// LeaderElectionConfiguration allows several replicas, only the leader binds the pods,
+// evicts the pods on the unhealthy GPUs and executes the defrag plans
+type LeaderElectionConfiguration struct {
+	Enabled        bool            `json:"enabled"`
+	LeaseName      string          `json:"leaseName"`
+	LeaseNamespace string          `json:"leaseNamespace"`
+	LeaseDuration  metav1.Duration `json:"leaseDuration"`
+	RenewDeadline  metav1.Duration `json:"renewDeadline"`
+	RetryPeriod    metav1.Duration `json:"retryPeriod"`
+}
+
+// NodeShareConfiguration publishes the devices of each node in the status of a GPUNodeShare,
+// the CRD must be installed. Only the leader writes.
+type NodeShareConfiguration struct {
+	Enabled bool `json:"enabled"`
+	// QPS and Burst limit the writes of all the nodes
+	QPS   float64 `json:"qps"`
+	Burst int     `json:"burst"`
+}
+
+type TracingConfiguration struct {
+	// Exporter is none, stdout or otlp
+	Exporter string `json:"exporter"`
+}
+
+// NewDefaultConfiguration returns the configuration which is the same as the extender without a file
+func NewDefaultConfiguration() *Configuration {
+	return &Configuration{
+		APIVersion: APIVersion,
+		Kind:       Kind,
+		Server: ServerConfiguration{
+			Port: 39999,
+		},
+		Admin: AdminConfiguration{
+			TokenReview: true,
+		},
+		Log: LogConfiguration{
+			Level:  "warn",
+			Format: log.FormatJSON,
+		},
+		Resources: ResourceConfiguration{
+			ResourceName: utils.DefaultResourceName,
+			CountName:    utils.DefaultCountName,
+			Annotations: AnnotationConfiguration{
+				Index:      utils.DefaultAnnotationPrefix + "_IDX",
+				Pod:        utils.DefaultAnnotationPrefix + "_POD",
+				Device:     utils.DefaultAnnotationPrefix + "_DEV",
+				Assigned:   utils.DefaultAnnotationPrefix + "_ASSIGNED",
+				AssumeTime: utils.DefaultAnnotationPrefix + "_ASSUME_TIME",
+				UUID:       utils.DefaultAnnotationPrefix + "_UUID",
+				NodeUUIDs:  utils.DefaultAnnotationPrefix + "_UUIDS",
+			},
+		},
+		ConfigMapNamespace:   metav1.NamespaceSystem,
+		ResyncPeriod:         metav1.Duration{Duration: 30 * time.Second},
+		Strategy:             cache.DeviceStrategyBinpack,
+		Timeouts:             TimeoutsConfiguration{Bind: metav1.Duration{Duration: scheduler.DefaultBindTimeout}},
+		UnhealthyGPUEviction: EvictionDisabled,
+		Defrag:               DefragConfiguration{ReserveTTL: metav1.Duration{Duration: defrag.DefaultReserveTTL}},
+		Tracing:              TracingConfiguration{Exporter: tracing.ExporterNone},
+		LeaderElection: LeaderElectionConfiguration{
+			LeaseName:      "gpushare-schd-extender",
+			LeaseNamespace: metav1.NamespaceSystem,
+			LeaseDuration:  metav1.Duration{Duration: 15 * time.Second},
+			RenewDeadline:  metav1.Duration{Duration: 10 * time.Second},
+			RetryPeriod:    metav1.Duration{Duration: 2 * time.Second},
+		},
+		NodeShare: NodeShareConfiguration{
+			QPS:   5,
+			Burst: 10,
+		},
+	}
+}
+
+// Load reads the configuration file over the defaults, then validates it.
+// The unknown fields are rejected so that a typo doesn't fall back to the default silently.
+func Load(path string) (*Configuration, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := NewDefaultConfiguration()
+	if err := yaml.UnmarshalStrict(data, cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", path, err)
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid configuration %s: %v", path, err)
+	}
+	return cfg, nil
+}
+
+// Validate checks all the fields, and returns the first bad one
+func (c *Configuration) Validate() error {
+	if c.APIVersion != APIVersion || c.Kind != Kind {
+		return fmt.Errorf("unsupported apiVersion %q and kind %q, it should be %s %s", c.APIVersion, c.Kind, APIVersion, Kind)
+	}
+	if c.Server.Port <= 0 || c.Server.Port > 65535 {
+		return fmt.Errorf("server.port %d is out of range", c.Server.Port)
+	}
+	if c.Server.ReadTimeout.Duration < 0 || c.Server.WriteTimeout.Duration < 0 {
+		return fmt.Errorf("server timeouts should not be negative")
+	}
+	if tlsConfig := c.Server.TLS; len(tlsConfig.CertFile) == 0 || len(tlsConfig.KeyFile) == 0 {
+		if len(tlsConfig.CertFile) > 0 || len(tlsConfig.KeyFile) > 0 {
+			return fmt.Errorf("server.tls.certFile and server.tls.keyFile should be set together")
+		}
+		if len(tlsConfig.ClientCAFile) > 0 {
+			return fmt.Errorf("server.tls.clientCAFile needs server.tls.certFile and server.tls.keyFile")
+		}
+	}
+	if len(c.Server.TLS.AllowedClientNames) > 0 && len(c.Server.TLS.ClientCAFile) == 0 {
+		return fmt.Errorf("server.tls.allowedClientNames needs server.tls.clientCAFile")
+	}
+	if _, err := log.ParseLevel(c.Log.Level); err != nil {
+		return fmt.Errorf("log.level: %v", err)
+	}
+	if c.Admin.Port < 0 || c.Admin.Port > 65535 || c.Admin.Port == c.Server.Port {
+		return fmt.Errorf("admin.port %d is out of range or the same as server.port", c.Admin.Port)
+	}
+	if c.Admin.Enabled() && !c.Admin.TokenReview && len(c.Admin.TokenFile) == 0 {
+		return fmt.Errorf("admin.tokenReview or admin.tokenFile should be set to authenticate the admin routes")
+	}
+	if c.Admin.Enabled() && !c.Server.TLS.Enabled() {
+		return fmt.Errorf("admin.port needs server.tls, the bearer tokens should not be sent in plain text")
+	}
+	if c.Log.Format != log.FormatJSON && c.Log.Format != log.FormatConsole {
+		return fmt.Errorf("log.format %q should be %s or %s", c.Log.Format, log.FormatJSON, log.FormatConsole)
+	}
+	if c.Threadness < 0 {
+		return fmt.Errorf("threadness %d should not be negative", c.Threadness)
+	}
+
+	names := []struct{ field, name string }{
+		{"resources.resourceName", c.Resources.ResourceName},
+		{"resources.countName", c.Resources.CountName},
+		{"resources.annotations.index", c.Resources.Annotations.Index},
+		{"resources.annotations.pod", c.Resources.Annotations.Pod},
+		{"resources.annotations.device", c.Resources.Annotations.Device},
+		{"resources.annotations.assigned", c.Resources.Annotations.Assigned},
+		{"resources.annotations.assumeTime", c.Resources.Annotations.AssumeTime},
+		{"resources.annotations.uuid", c.Resources.Annotations.UUID},
+		{"resources.annotations.nodeUUIDs", c.Resources.Annotations.NodeUUIDs},
+	}
+	profileNames := map[string]bool{utils.DefaultProfileName: true}
+	resourceNames := map[string]bool{c.Resources.ResourceName: true, c.Resources.CountName: true}
+	for i, p := range c.Profiles {
+		field := fmt.Sprintf("profiles[%d]", i)
+		if len(p.Name) == 0 || profileNames[p.Name] {
+			return fmt.Errorf("%s.name %q should be set and unique, %q is the default profile", field, p.Name, utils.DefaultProfileName)
+		}
+		profileNames[p.Name] = true
+		if resourceNames[p.ResourceName] {
+			return fmt.Errorf("%s.resourceName %q is used by another profile", field, p.ResourceName)
+		}
+		resourceNames[p.ResourceName] = true
+		if resourceNames[p.CountName] {
+			return fmt.Errorf("%s.countName %q is used by another profile", field, p.CountName)
+		}
+		resourceNames[p.CountName] = true
+		names = append(names,
+			struct{ field, name string }{field + ".resourceName", p.ResourceName},
+			struct{ field, name string }{field + ".countName", p.CountName},
+			// the longest annotation key of the prefix
+			struct{ field, name string }{field + ".annotationPrefix", p.AnnotationPrefix + "_ASSUME_TIME"})
+	}
+	for _, n := range names {
+		if errs := validation.IsQualifiedName(n.name); len(errs) > 0 {
+			return fmt.Errorf("%s %q is invalid: %v", n.field, n.name, errs)
+		}
+	}
+
+	if errs := validation.IsDNS1123Label(c.ConfigMapNamespace); len(errs) > 0 {
+		return fmt.Errorf("configMapNamespace %q is invalid: %v", c.ConfigMapNamespace, errs)
+	}
+	if c.ResyncPeriod.Duration <= 0 {
+		return fmt.Errorf("resyncPeriod should be positive")
+	}
+	if c.Strategy != cache.DeviceStrategyBinpack && c.Strategy != cache.DeviceStrategySpread {
+		return fmt.Errorf("strategy %q should be %s or %s", c.Strategy, cache.DeviceStrategyBinpack, cache.DeviceStrategySpread)
+	}
+	if c.Timeouts.Bind.Duration <= 0 {
+		return fmt.Errorf("timeouts.bind should be positive")
+	}
+	switch c.UnhealthyGPUEviction {
+	case EvictionDisabled, EvictionEnabled, EvictionDryRun:
+	default:
+		return fmt.Errorf("unhealthyGPUEviction %q should be %s, %s or %s", c.UnhealthyGPUEviction, EvictionDisabled, EvictionEnabled, EvictionDryRun)
+	}
+	if c.Defrag.ReserveTTL.Duration <= 0 {
+		return fmt.Errorf("defrag.reserveTTL should be positive")
+	}
+	switch c.Tracing.Exporter {
+	case tracing.ExporterNone, tracing.ExporterStdout, tracing.ExporterOTLP:
+	default:
+		return fmt.Errorf("tracing.exporter %q should be %s, %s or %s", c.Tracing.Exporter, tracing.ExporterNone, tracing.ExporterStdout, tracing.ExporterOTLP)
+	}
+	if le := c.LeaderElection; le.Enabled {
+		if errs := validation.IsDNS1123Subdomain(le.LeaseName); len(errs) > 0 {
+			return fmt.Errorf("leaderElection.leaseName %q is invalid: %v", le.LeaseName, errs)
+		}
+		if errs := validation.IsDNS1123Label(le.LeaseNamespace); len(errs) > 0 {
+			return fmt.Errorf("leaderElection.leaseNamespace %q is invalid: %v", le.LeaseNamespace, errs)
+		}
+		// the same rules as the leader elector of client-go
+		if le.RetryPeriod.Duration <= 0 || le.RenewDeadline.Duration <= time.Duration(leaderelection.JitterFactor*float64(le.RetryPeriod.Duration)) ||
+			le.LeaseDuration.Duration <= le.RenewDeadline.Duration {
+			return fmt.Errorf("leaderElection should have leaseDuration > renewDeadline > %v * retryPeriod > 0", leaderelection.JitterFactor)
+		}
+	}
+	if ns := c.NodeShare; ns.Enabled && (ns.QPS <= 0 || ns.Burst <= 0) {
+		return fmt.Errorf("nodeShare.qps and nodeShare.burst should be positive")
+	}
+	return nil
+}
+
+// GetProfiles returns the default profile followed by the other profiles
+func (c *Configuration) GetProfiles() []*utils.Profile {
+	annotations := c.Resources.Annotations
+	profiles := []*utils.Profile{{
+		Name:          utils.DefaultProfileName,
+		ResourceName:  v1.ResourceName(c.Resources.ResourceName),
+		CountName:     v1.ResourceName(c.Resources.CountName),
+		IndexKey:      annotations.Index,
+		PodKey:        annotations.Pod,
+		DeviceKey:     annotations.Device,
+		AssignedKey:   annotations.Assigned,
+		AssumeTimeKey: annotations.AssumeTime,
+		UUIDKey:       annotations.UUID,
+		NodeUUIDsKey:  annotations.NodeUUIDs,
+	}}
+	for _, p := range c.Profiles {
+		profiles = append(profiles, utils.NewProfile(p.Name, v1.ResourceName(p.ResourceName), v1.ResourceName(p.CountName), p.AnnotationPrefix))
+	}
+	return profiles
+}
+
+// GetLogLevel returns the parsed log level, the configuration must be valid
+func (c *Configuration) GetLogLevel() int32 {
+	level, _ := log.ParseLevel(c.Log.Level)
+	return level
+}
@@ -0,0 +1,268 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/AliyunContainerService/gpushare-scheduler-extender/pkg/log"
+)
+
+func withTLS(c *Configuration) {
+	c.Server.TLS = TLSConfiguration{CertFile: "tls.crt", KeyFile: "tls.key"}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(c *Configuration)
+		// wantError is a part of the error, empty if the configuration is valid
+		wantError string
+	}{
+		{
+			name:   "default configuration",
+			modify: func(c *Configuration) {},
+		},
+		{
+			name:      "unsupported kind",
+			modify:    func(c *Configuration) { c.Kind = "Configuration" },
+			wantError: "unsupported apiVersion",
+		},
+		{
+			name:      "server port out of range",
+			modify:    func(c *Configuration) { c.Server.Port = 70000 },
+			wantError: "server.port 70000",
+		},
+		{
+			name: "TLS with client verification",
+			modify: func(c *Configuration) {
+				withTLS(c)
+				c.Server.TLS.ClientCAFile = "ca.crt"
+				c.Server.TLS.AllowedClientNames = []string{"system:kube-scheduler"}
+			},
+		},
+		{
+			name:      "certificate without key",
+			modify:    func(c *Configuration) { c.Server.TLS.CertFile = "tls.crt" },
+			wantError: "should be set together",
+		},
+		{
+			name:      "client CA without certificate",
+			modify:    func(c *Configuration) { c.Server.TLS.ClientCAFile = "ca.crt" },
+			wantError: "server.tls.clientCAFile needs server.tls.certFile",
+		},
+		{
+			name: "client names without client CA",
+			modify: func(c *Configuration) {
+				withTLS(c)
+				c.Server.TLS.AllowedClientNames = []string{"system:kube-scheduler"}
+			},
+			wantError: "server.tls.allowedClientNames needs server.tls.clientCAFile",
+		},
+		{
+			name: "admin listener with TLS",
+			modify: func(c *Configuration) {
+				withTLS(c)
+				c.Admin.Port = 12346
+			},
+		},
+		{
+			name:      "admin listener needs TLS",
+			modify:    func(c *Configuration) { c.Admin.Port = 12346 },
+			wantError: "admin.port needs server.tls",
+		},
+		{
+			name: "admin listener on the server port",
+			modify: func(c *Configuration) {
+				withTLS(c)
+				c.Admin.Port = c.Server.Port
+			},
+			wantError: "the same as server.port",
+		},
+		{
+			name: "admin listener without authentication",
+			modify: func(c *Configuration) {
+				withTLS(c)
+				c.Admin.Port = 12346
+				c.Admin.TokenReview = false
+			},
+			wantError: "admin.tokenReview or admin.tokenFile",
+		},
+		{
+			name:      "negative server timeout",
+			modify:    func(c *Configuration) { c.Server.ReadTimeout.Duration = -time.Second },
+			wantError: "server timeouts",
+		},
+		{
+			name:      "unknown log level",
+			modify:    func(c *Configuration) { c.Log.Level = "verbose" },
+			wantError: "log.level",
+		},
+		{
+			name:      "unknown log format",
+			modify:    func(c *Configuration) { c.Log.Format = "text" },
+			wantError: "log.format",
+		},
+		{
+			name:      "negative threadness",
+			modify:    func(c *Configuration) { c.Threadness = -1 },
+			wantError: "threadness",
+		},
+		{
+			name:      "invalid resource name",
+			modify:    func(c *Configuration) { c.Resources.ResourceName = "gpu mem" },
+			wantError: "resources.resourceName",
+		},
+		{
+			name:      "invalid annotation",
+			modify:    func(c *Configuration) { c.Resources.Annotations.Index = "" },
+			wantError: "resources.annotations.index",
+		},
+		{
+			name:      "invalid node uuids annotation",
+			modify:    func(c *Configuration) { c.Resources.Annotations.NodeUUIDs = "gpu uuids" },
+			wantError: "resources.annotations.nodeUUIDs",
+		},
+		{
+			name:      "invalid configmap namespace",
+			modify:    func(c *Configuration) { c.ConfigMapNamespace = "Kube_System" },
+			wantError: "configMapNamespace",
+		},
+		{
+			name:      "no resync period",
+			modify:    func(c *Configuration) { c.ResyncPeriod.Duration = 0 },
+			wantError: "resyncPeriod",
+		},
+		{
+			name:      "unknown strategy",
+			modify:    func(c *Configuration) { c.Strategy = "random" },
+			wantError: "strategy \"random\"",
+		},
+		{
+			name:      "no bind timeout",
+			modify:    func(c *Configuration) { c.Timeouts.Bind.Duration = 0 },
+			wantError: "timeouts.bind",
+		},
+		{
+			name:      "unknown eviction mode",
+			modify:    func(c *Configuration) { c.UnhealthyGPUEviction = "always" },
+			wantError: "unhealthyGPUEviction",
+		},
+		{
+			name:      "no defrag reservation",
+			modify:    func(c *Configuration) { c.Defrag.ReserveTTL.Duration = 0 },
+			wantError: "defrag.reserveTTL",
+		},
+		{
+			name:      "unknown tracing exporter",
+			modify:    func(c *Configuration) { c.Tracing.Exporter = "jaeger" },
+			wantError: "tracing.exporter",
+		},
+		{
+			name: "profile",
+			modify: func(c *Configuration) {
+				c.Profiles = []ProfileConfiguration{{Name: "other", ResourceName: "example.com/gpu-mem", CountName: "example.com/gpu-count", AnnotationPrefix: "EXAMPLE_COM_GPU_MEM"}}
+			},
+		},
+		{
+			name: "profile named default",
+			modify: func(c *Configuration) {
+				c.Profiles = []ProfileConfiguration{{Name: "default", ResourceName: "example.com/gpu-mem", CountName: "example.com/gpu-count", AnnotationPrefix: "EXAMPLE_COM_GPU_MEM"}}
+			},
+			wantError: "profiles[0].name",
+		},
+		{
+			name: "profile with the resource of the default one",
+			modify: func(c *Configuration) {
+				c.Profiles = []ProfileConfiguration{{Name: "other", ResourceName: c.Resources.ResourceName, CountName: "example.com/gpu-count", AnnotationPrefix: "EXAMPLE_COM_GPU_MEM"}}
+			},
+			wantError: "is used by another profile",
+		},
+		{
+			name: "profiles with the same count name",
+			modify: func(c *Configuration) {
+				c.Profiles = []ProfileConfiguration{
+					{Name: "a", ResourceName: "example.com/gpu-mem", CountName: "example.com/gpu-count", AnnotationPrefix: "EXAMPLE_COM_GPU_MEM"},
+					{Name: "b", ResourceName: "example.org/gpu-mem", CountName: "example.com/gpu-count", AnnotationPrefix: "EXAMPLE_ORG_GPU_MEM"},
+				}
+			},
+			wantError: "profiles[1].countName",
+		},
+		{
+			name: "invalid annotation prefix",
+			modify: func(c *Configuration) {
+				c.Profiles = []ProfileConfiguration{{Name: "other", ResourceName: "example.com/gpu-mem", CountName: "example.com/gpu-count", AnnotationPrefix: "BAD PREFIX"}}
+			},
+			wantError: "profiles[0].annotationPrefix",
+		},
+		{
+			name:   "leader election",
+			modify: func(c *Configuration) { c.LeaderElection.Enabled = true },
+		},
+		{
+			name: "lease shorter than the renew deadline",
+			modify: func(c *Configuration) {
+				c.LeaderElection.Enabled = true
+				c.LeaderElection.LeaseDuration.Duration = 5 * time.Second
+			},
+			wantError: "leaderElection should have",
+		},
+		{
+			name: "node share without qps",
+			modify: func(c *Configuration) {
+				c.NodeShare.Enabled = true
+				c.NodeShare.QPS = 0
+			},
+			wantError: "nodeShare.qps",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg := NewDefaultConfiguration()
+			test.modify(cfg)
+			err := cfg.Validate()
+			if len(test.wantError) == 0 {
+				if err != nil {
+					t.Errorf("expected no error, got %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), test.wantError) {
+				t.Errorf("expected the error %q, got %v", test.wantError, err)
+			}
+		})
+	}
+}
+
+func TestLoadRejectsUnknownFields(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	data := "apiVersion: " + APIVersion + "\nkind: " + Kind + "\nstrategi: spread\n"
+	if err := os.WriteFile(path, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load(path); err == nil {
+		t.Errorf("expected an error for the unknown field")
+	}
+}
+
+func TestLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	data := "apiVersion: " + APIVersion + "\nkind: " + Kind + "\nstrategy: spread\nlog:\n  level: debug\n"
+	if err := os.WriteFile(path, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Strategy != "spread" || cfg.GetLogLevel() != log.LevelDebug {
+		t.Errorf("expected the strategy spread and the debug level, got %s and %d", cfg.Strategy, cfg.GetLogLevel())
+	}
+	// the fields which are not in the file keep their defaults
+	if defaults := NewDefaultConfiguration(); cfg.Server.Port != defaults.Server.Port || cfg.Log.Format != defaults.Log.Format {
+		t.Errorf("expected the default port %d and format %s, got %d and %s", defaults.Server.Port, defaults.Log.Format, cfg.Server.Port, cfg.Log.Format)
+	}
+}
@@ -0,0 +1,38 @@
+package config
+
+import (
+	"os"
+	"strconv"
+
+	"github.com/AliyunContainerService/gpushare-scheduler-extender/pkg/log"
+)
+
+// FromEnv returns the defaults with the environment variables used before the configuration file,
+// so the existing deployments keep working. The bad values are ignored as they were.
+func FromEnv() *Configuration {
+	cfg := NewDefaultConfiguration()
+	if level := os.Getenv("LOG_LEVEL"); len(level) > 0 {
+		if _, err := log.ParseLevel(level); err == nil {
+			cfg.Log.Level = level
+		}
+	}
+	if format := os.Getenv("LOG_FORMAT"); len(format) > 0 {
+		cfg.Log.Format = format
+	}
+	if threadness, err := strconv.Atoi(os.Getenv("THREADNESS")); err == nil && threadness > 0 {
+		cfg.Threadness = threadness
+	}
+	if port, err := strconv.Atoi(os.Getenv("PORT")); err == nil {
+		cfg.Server.Port = port
+	}
+	switch mode := os.Getenv("UNHEALTHY_GPU_EVICTION"); mode {
+	case EvictionEnabled, EvictionDryRun:
+		cfg.UnhealthyGPUEviction = mode
+	}
+	cfg.Defrag.Executor = os.Getenv("DEFRAG_EXECUTOR") == "enabled"
+	cfg.LeaderElection.Enabled = os.Getenv("LEADER_ELECT") == "true"
+	if exporter := os.Getenv("TRACING_EXPORTER"); len(exporter) > 0 {
+		cfg.Tracing.Exporter = exporter
+	}
+	return cfg
+}
@@ -0,0 +1,49 @@
+package config
+
+import (
+	"os"
+	"os/signal"
+	"reflect"
+	"syscall"
+
+	"github.com/AliyunContainerService/gpushare-scheduler-extender/pkg/log"
+)
+
+// WatchReload reloads the file on SIGHUP and calls apply with the new configuration.
+// A bad file is logged and the current configuration is kept. The changes of the fields
+// which are not hot-reloaded are logged, they take effect after a restart.
+func WatchReload(path string, current *Configuration, apply func(*Configuration), stopCh <-chan struct{}) {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGHUP)
+	go func() {
+		defer signal.Stop(c)
+		for {
+			select {
+			case <-stopCh:
+				return
+			case <-c:
+			}
+
+			cfg, err := Load(path)
+			if err != nil {
+				log.V(3).Error("failed to reload the configuration, keep the current one", log.String("path", path), log.Err(err))
+				continue
+			}
+			if !reflect.DeepEqual(withoutHotReload(current), withoutHotReload(cfg)) {
+				log.V(3).Warn("only log.level, strategy and timeouts are reloaded, the other changes need a restart", log.String("path", path))
+			}
+			apply(cfg)
+			current = cfg
+			log.V(3).Info("reloaded the configuration", log.String("path", path))
+		}
+	}()
+}
+
+// withoutHotReload clears the fields which are hot-reloaded
+func withoutHotReload(c *Configuration) Configuration {
+	copied := *c
+	copied.Log.Level = ""
+	copied.Strategy = ""
+	copied.Timeouts = TimeoutsConfiguration{}
+	return copied
+}
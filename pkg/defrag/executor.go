@@ -0,0 +1,119 @@
+package defrag
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/AliyunContainerService/gpushare-scheduler-extender/pkg/cache"
+	"github.com/AliyunContainerService/gpushare-scheduler-extender/pkg/log"
+	"github.com/AliyunContainerService/gpushare-scheduler-extender/pkg/utils"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/record"
+)
+
+const (
+	// ReasonDefragEviction is the event reason when the pod is evicted to defragment the GPU
+	ReasonDefragEviction = "GPUDefragEviction"
+
+	// DefaultReserveTTL is how long the target device is pinned for the requested slot
+	DefaultReserveTTL = 5 * time.Minute
+)
+
+// Executor carries out a plan: the target device is pinned by reserving the requested
+// gpu memory on it, so the evicted pods can't be placed back, then the pods are evicted.
+type Executor struct {
+	clientset  kubernetes.Interface
+	cache      *cache.SchedulerCache
+	recorder   record.EventRecorder
+	reserveTTL time.Duration
+}
+
+// Result is the outcome of the executed plan
+type Result struct {
+	Plan    *Plan    `json:"plan"`
+	Evicted []*Move  `json:"evicted"`
+	Errors  []string `json:"errors,omitempty"`
+}
+
+func NewExecutor(clientset kubernetes.Interface, c *cache.SchedulerCache, recorder record.EventRecorder, reserveTTL time.Duration) *Executor {
+	if reserveTTL <= 0 {
+		reserveTTL = DefaultReserveTTL
+	}
+	return &Executor{
+		clientset:  clientset,
+		cache:      c,
+		recorder:   recorder,
+		reserveTTL: reserveTTL,
+	}
+}
+
+// Execute pins the target device of the plan and evicts the pods to move.
+// It stops at the first failed eviction, the remaining moves are left undone and the device is released.
+// The pods which are already gone are not counted as evicted.
+func (e *Executor) Execute(ctx context.Context, plan *Plan) (*Result, error) {
+	result := &Result{Plan: plan, Evicted: []*Move{}}
+	if !plan.Feasible {
+		return result, fmt.Errorf("the plan is not feasible: %s", plan.Reason)
+	}
+	if len(plan.Moves) == 0 {
+		return result, nil
+	}
+
+	nodeInfo, err := e.cache.GetNodeInfo(plan.Node)
+	if err != nil {
+		return result, err
+	}
+	if err := nodeInfo.Reserve(plan.Device, plan.GPUMemory, e.reserveTTL); err != nil {
+		return result, err
+	}
+
+	for _, move := range plan.Moves {
+		evicted, err := e.evict(ctx, plan, move)
+		if err != nil {
+			result.Errors = append(result.Errors, err.Error())
+			// the slot won't be freed as planned, so it's not held back for the rest of the ttl
+			if err := nodeInfo.Unreserve(plan.Device); err != nil {
+				defragLog.V(3).Warn("failed to release the reserved gpu memory", log.DevID(plan.Device), log.Node(plan.Node), log.Err(err))
+			}
+			return result, err
+		}
+		if evicted {
+			result.Evicted = append(result.Evicted, move)
+		}
+	}
+
+	return result, nil
+}
+
+// evict returns false if the pod is already gone, which frees its gpu memory as well
+func (e *Executor) evict(ctx context.Context, plan *Plan, move *Move) (evicted bool, err error) {
+	pod, err := e.cache.GetPod(move.Name, move.Namespace)
+	if err != nil {
+		return false, err
+	}
+	if pod.UID != move.UID {
+		return false, fmt.Errorf("the pod %s in ns %s has been replaced", move.Name, move.Namespace)
+	}
+
+	err = utils.EvictPod(ctx, e.clientset, pod)
+	if errors.IsNotFound(err) {
+		defragLog.V(3).Info("pod to evict for defrag is already gone", log.Pod(pod.Name), log.Namespace(pod.Namespace))
+		return false, nil
+	}
+	if err != nil {
+		defragLog.V(3).Warn("failed to evict pod for defrag", log.Pod(pod.Name), log.Namespace(pod.Namespace), log.Err(err))
+		return false, err
+	}
+
+	defragLog.V(3).Info("evicted pod from dev of node for defrag",
+		log.Pod(pod.Name),
+		log.Namespace(pod.Namespace),
+		log.DevID(move.FromDevice),
+		log.Node(move.FromNode))
+	e.recorder.Eventf(pod, v1.EventTypeNormal, ReasonDefragEviction,
+		"Evicted from GPU %d on node %s to free %d gpu memory", move.FromDevice, move.FromNode, plan.GPUMemory)
+	return true, nil
+}
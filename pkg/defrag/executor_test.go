@@ -0,0 +1,105 @@
+package defrag
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	policyv1 "k8s.io/api/policy/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestExecute(t *testing.T) {
+	// a/0 has 1 free gpu memory, moving both pods frees 8
+	pods := []testPod{
+		{name: "p1", node: "a", device: 0, gpuMemory: 4},
+		{name: "p2", node: "a", device: 0, gpuMemory: 3},
+	}
+	plan := &Plan{
+		GPUMemory: 8,
+		Feasible:  true,
+		Node:      "a",
+		Device:    0,
+		Moves: []*Move{
+			{Name: "p1", Namespace: "default", UID: "uid-p1", GPUMemory: 4, FromNode: "a", FromDevice: 0, ToNode: "b", ToDevice: 0},
+			{Name: "p2", Namespace: "default", UID: "uid-p2", GPUMemory: 3, FromNode: "a", FromDevice: 0, ToNode: "b", ToDevice: 1},
+		},
+	}
+
+	tests := []struct {
+		name string
+		// evictErrors are returned by the evictions of the pods
+		evictErrors map[string]error
+		wantEvicted []string
+		wantEvents  int
+		wantError   bool
+		// wantReserved is if the device is still held back for the smaller requests
+		wantReserved bool
+	}{
+		{
+			name:         "all pods are evicted",
+			wantEvicted:  []string{"p1", "p2"},
+			wantEvents:   2,
+			wantReserved: true,
+		},
+		{
+			name:         "pod which is already gone is not counted",
+			evictErrors:  map[string]error{"p1": errors.NewNotFound(policyv1.Resource("pods"), "p1")},
+			wantEvicted:  []string{"p2"},
+			wantEvents:   1,
+			wantReserved: true,
+		},
+		{
+			name:        "failed eviction releases the device",
+			evictErrors: map[string]error{"p2": fmt.Errorf("the server is unavailable")},
+			wantEvicted: []string{"p1"},
+			wantEvents:  1,
+			wantError:   true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := newTestCache(t, pods)
+			clientset := fake.NewSimpleClientset()
+			clientset.PrependReactor("create", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+				if action.GetSubresource() != "eviction" {
+					return false, nil, nil
+				}
+				eviction := action.(k8stesting.CreateAction).GetObject().(*policyv1.Eviction)
+				return true, nil, test.evictErrors[eviction.Name]
+			})
+			recorder := record.NewFakeRecorder(10)
+
+			result, err := NewExecutor(clientset, c, recorder, time.Minute).Execute(context.Background(), plan)
+			if (err != nil) != test.wantError {
+				t.Fatalf("expected error %v, got %v", test.wantError, err)
+			}
+
+			evicted := []string{}
+			for _, move := range result.Evicted {
+				evicted = append(evicted, move.Name)
+			}
+			if !reflect.DeepEqual(evicted, test.wantEvicted) {
+				t.Errorf("expected the evicted pods %v, got %v", test.wantEvicted, evicted)
+			}
+			if events := len(recorder.Events); events != test.wantEvents {
+				t.Errorf("expected %d events, got %d", test.wantEvents, events)
+			}
+
+			info, err := c.GetNodeInfo("a")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if reserved := info.GetDevs()[0].GetReservedGPUMemory(1) > 0; reserved != test.wantReserved {
+				t.Errorf("expected the device reserved %v, got %v", test.wantReserved, reserved)
+			}
+		})
+	}
+}
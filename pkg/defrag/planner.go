@@ -0,0 +1,456 @@
+package defrag
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/AliyunContainerService/gpushare-scheduler-extender/pkg/cache"
+	"github.com/AliyunContainerService/gpushare-scheduler-extender/pkg/log"
+	"github.com/AliyunContainerService/gpushare-scheduler-extender/pkg/utils"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	policylisters "k8s.io/client-go/listers/policy/v1"
+)
+
+var defragLog = log.Named(log.SubsystemDefrag)
+
+// Request describes the free slot which should be created
+type Request struct {
+	// GPUMemory is the size of the free slot in one device
+	GPUMemory uint
+	// MaxMoves is the max number of pods to move, 0 means no limit
+	MaxMoves int
+	// NodeSelector selects the nodes which are considered, nil means all the nodes
+	NodeSelector labels.Selector
+}
+
+// Move is a pod which should leave its device. The target is where the pod
+// fits in the planned state, it's a hint since the pod is rescheduled after eviction.
+type Move struct {
+	Name       string    `json:"name"`
+	Namespace  string    `json:"namespace"`
+	UID        types.UID `json:"uid"`
+	GPUMemory  uint      `json:"gpuMemory"`
+	FromNode   string    `json:"fromNode"`
+	FromDevice int       `json:"fromDevice"`
+	ToNode     string    `json:"toNode"`
+	ToDevice   int       `json:"toDevice"`
+}
+
+// Plan is the set of moves which creates a free slot of the requested size on the target device
+type Plan struct {
+	GPUMemory uint    `json:"gpuMemory"`
+	Feasible  bool    `json:"feasible"`
+	Node      string  `json:"node,omitempty"`
+	Device    int     `json:"device"`
+	Moves     []*Move `json:"moves"`
+	Reason    string  `json:"reason,omitempty"`
+}
+
+// Planner computes the defragmentation plans from the scheduler cache
+type Planner struct {
+	cache     *cache.SchedulerCache
+	pdbLister policylisters.PodDisruptionBudgetLister
+}
+
+func NewPlanner(c *cache.SchedulerCache, pdbLister policylisters.PodDisruptionBudgetLister) *Planner {
+	return &Planner{
+		cache:     c,
+		pdbLister: pdbLister,
+	}
+}
+
+// slot is the planned state of a healthy device
+type slot struct {
+	node  string
+	devID int
+	total uint
+	free  uint
+	pods  []*v1.Pod
+}
+
+// maxExactPods bounds the exhaustive search of the moves out of a device,
+// the devices with more movable pods are planned greedily
+const maxExactPods = 12
+
+// Plan computes a plan which frees the requested GPU memory in one device with the fewest moves,
+// then the least moved GPU memory. For each device, the sets of its movable pods are searched from
+// the smallest, and a set is taken if its pods fit in the other devices. A device with more than
+// maxExactPods movable pods is planned greedily by moving its largest pods first, so only then the
+// plan may not be the fewest moves.
+func (p *Planner) Plan(req Request) (*Plan, error) {
+	if req.GPUMemory == 0 {
+		return nil, fmt.Errorf("the requested gpu memory should be positive")
+	}
+	selector := req.NodeSelector
+	if selector == nil {
+		selector = labels.Everything()
+	}
+
+	slots, err := p.buildSlots(selector)
+	if err != nil {
+		return nil, err
+	}
+
+	plan := &Plan{GPUMemory: req.GPUMemory, Device: -1, Moves: []*Move{}}
+
+	// No move is needed if there is already a free slot, prefer the best fit one
+	if s := bestFit(slots, freeOf(slots), req.GPUMemory, nil); s != nil {
+		plan.Feasible = true
+		plan.Node = s.node
+		plan.Device = s.devID
+		return plan, nil
+	}
+
+	budgets := map[string]int32{}
+	var best []*Move
+	var bestSlot *slot
+	for _, target := range slots {
+		if target.total < req.GPUMemory {
+			continue
+		}
+		maxMoves := req.MaxMoves
+		if best != nil && (maxMoves <= 0 || len(best) < maxMoves) {
+			// a set larger than the best plan can't be better
+			maxMoves = len(best)
+		}
+		moves, ok := p.planSlot(target, slots, req.GPUMemory, maxMoves, budgets)
+		if !ok {
+			continue
+		}
+		if best == nil || isBetter(moves, best) {
+			best = moves
+			bestSlot = target
+		}
+	}
+
+	if bestSlot == nil {
+		plan.Reason = fmt.Sprintf("no device can free %d gpu memory", req.GPUMemory)
+		if req.MaxMoves > 0 {
+			plan.Reason = fmt.Sprintf("%s within %d moves", plan.Reason, req.MaxMoves)
+		}
+		return plan, nil
+	}
+
+	plan.Feasible = true
+	plan.Node = bestSlot.node
+	plan.Device = bestSlot.devID
+	plan.Moves = best
+	defragLog.V(10).Debug("defrag plan for gpu memory on dev of node",
+		log.ReqMem(req.GPUMemory),
+		log.DevID(plan.Device),
+		log.Node(plan.Node),
+		log.Int("moves", len(plan.Moves)))
+	return plan, nil
+}
+
+func (p *Planner) buildSlots(selector labels.Selector) ([]*slot, error) {
+	nodeInfos, err := p.cache.ListNodeInfos(selector)
+	if err != nil {
+		return nil, err
+	}
+
+	slots := []*slot{}
+	for _, info := range nodeInfos {
+		availableGPUs := info.GetAvailableGPUs()
+		for _, dev := range info.GetDevs() {
+			if dev == nil {
+				continue
+			}
+			free, healthy := availableGPUs[dev.GetID()]
+			if !healthy {
+				continue
+			}
+			pods := []*v1.Pod{}
+			for _, pod := range dev.GetPods() {
+				if utils.AssignedNonTerminatedPod(pod) {
+					pods = append(pods, pod)
+				}
+			}
+			slots = append(slots, &slot{
+				node:  info.GetName(),
+				devID: dev.GetID(),
+				total: dev.GetTotalGPUMemory(),
+				free:  free,
+				pods:  pods,
+			})
+		}
+	}
+
+	// keep the plan stable between calls
+	sort.Slice(slots, func(i, j int) bool {
+		if slots[i].node != slots[j].node {
+			return slots[i].node < slots[j].node
+		}
+		return slots[i].devID < slots[j].devID
+	})
+	return slots, nil
+}
+
+// candidate is a movable pod on the target device
+type candidate struct {
+	pod    *v1.Pod
+	gpuMem uint
+	// pdbs are the keys of the PodDisruptionBudgets covering the pod
+	pdbs []string
+}
+
+// planSlot finds the fewest moves, at most maxMoves if it's positive, which free the requested
+// gpu memory in the target. The budgets are the allowed disruptions of the PodDisruptionBudgets.
+func (p *Planner) planSlot(target *slot, slots []*slot, gpuMem uint, maxMoves int, budgets map[string]int32) (moves []*Move, ok bool) {
+	candidates := []*candidate{}
+	movable := target.free
+	for _, pod := range target.pods {
+		if !isMovable(pod) {
+			continue
+		}
+		pdbs, allowed := p.checkPDBs(pod, budgets)
+		if !allowed {
+			continue
+		}
+		c := &candidate{pod: pod, gpuMem: utils.GetGPUMemoryFromPodAnnotation(pod), pdbs: pdbs}
+		candidates = append(candidates, c)
+		movable += c.gpuMem
+	}
+	if movable < gpuMem {
+		return nil, false
+	}
+	// the largest pods first, so the sets are placed by best fit decreasing,
+	// and by name as the pods of a device are not ordered
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].gpuMem != candidates[j].gpuMem {
+			return candidates[i].gpuMem > candidates[j].gpuMem
+		}
+		return candidates[i].pod.Namespace+"/"+candidates[i].pod.Name < candidates[j].pod.Namespace+"/"+candidates[j].pod.Name
+	})
+	if len(candidates) > maxExactPods {
+		return planGreedy(target, slots, candidates, gpuMem, maxMoves, budgets)
+	}
+
+	maxSize := len(candidates)
+	if maxMoves > 0 && maxMoves < maxSize {
+		maxSize = maxMoves
+	}
+	for size := 1; size <= maxSize; size++ {
+		var best []*Move
+		forEachSubset(len(candidates), size, func(indexes []int) {
+			set := make([]*candidate, 0, size)
+			freed := target.free
+			for _, i := range indexes {
+				set = append(set, candidates[i])
+				freed += candidates[i].gpuMem
+			}
+			if freed < gpuMem || !withinBudgets(set, budgets) {
+				return
+			}
+			to := make([]*slot, len(set))
+			if !place(set, 0, slots, freeOf(slots), target, to) {
+				return
+			}
+			if setMoves := newMoves(target, set, to); best == nil || movedGPUMemory(setMoves) < movedGPUMemory(best) {
+				best = setMoves
+			}
+		})
+		if best != nil {
+			return best, true
+		}
+	}
+	return nil, false
+}
+
+// planGreedy moves the largest candidates out of the target to the best fit devices until the requested memory is free
+func planGreedy(target *slot, slots []*slot, candidates []*candidate, gpuMem uint, maxMoves int, budgets map[string]int32) (moves []*Move, ok bool) {
+	free := freeOf(slots)
+	planned := map[string]int32{}
+	set, to := []*candidate{}, []*slot{}
+
+	freed := target.free
+	for _, c := range candidates {
+		if freed >= gpuMem {
+			break
+		}
+		if maxMoves > 0 && len(set) >= maxMoves {
+			break
+		}
+		allowed := true
+		for _, key := range c.pdbs {
+			if budgets[key]-planned[key] <= 0 {
+				allowed = false
+			}
+		}
+		if !allowed {
+			continue
+		}
+		s := bestFit(slots, free, c.gpuMem, target)
+		if s == nil {
+			continue
+		}
+
+		free[s] -= c.gpuMem
+		for _, key := range c.pdbs {
+			planned[key]++
+		}
+		freed += c.gpuMem
+		set = append(set, c)
+		to = append(to, s)
+	}
+
+	return newMoves(target, set, to), freed >= gpuMem
+}
+
+// place assigns the devices to the candidates from the i-th one, it tries the best fit device first,
+// and only one device of each free gpu memory since the others are the same for the rest
+func place(set []*candidate, i int, slots []*slot, free map[*slot]uint, exclude *slot, to []*slot) bool {
+	if i == len(set) {
+		return true
+	}
+	fits := map[uint]*slot{}
+	for _, s := range slots {
+		if s == exclude || free[s] < set[i].gpuMem {
+			continue
+		}
+		if _, found := fits[free[s]]; !found {
+			fits[free[s]] = s
+		}
+	}
+	sizes := make([]uint, 0, len(fits))
+	for size := range fits {
+		sizes = append(sizes, size)
+	}
+	sort.Slice(sizes, func(a, b int) bool { return sizes[a] < sizes[b] })
+
+	for _, size := range sizes {
+		s := fits[size]
+		free[s] -= set[i].gpuMem
+		to[i] = s
+		if place(set, i+1, slots, free, exclude, to) {
+			return true
+		}
+		free[s] += set[i].gpuMem
+	}
+	return false
+}
+
+// withinBudgets checks if the PodDisruptionBudgets allow moving all the candidates
+func withinBudgets(set []*candidate, budgets map[string]int32) bool {
+	planned := map[string]int32{}
+	for _, c := range set {
+		for _, key := range c.pdbs {
+			planned[key]++
+			if planned[key] > budgets[key] {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// forEachSubset calls fn with the indexes of every subset of the size out of n, in lexicographic order
+func forEachSubset(n, size int, fn func(indexes []int)) {
+	indexes := make([]int, size)
+	var walk func(pos, from int)
+	walk = func(pos, from int) {
+		if pos == size {
+			fn(indexes)
+			return
+		}
+		for i := from; i <= n-(size-pos); i++ {
+			indexes[pos] = i
+			walk(pos+1, i+1)
+		}
+	}
+	walk(0, 0)
+}
+
+func newMoves(target *slot, set []*candidate, to []*slot) []*Move {
+	moves := make([]*Move, 0, len(set))
+	for i, c := range set {
+		moves = append(moves, &Move{
+			Name:       c.pod.Name,
+			Namespace:  c.pod.Namespace,
+			UID:        c.pod.UID,
+			GPUMemory:  c.gpuMem,
+			FromNode:   target.node,
+			FromDevice: target.devID,
+			ToNode:     to[i].node,
+			ToDevice:   to[i].devID,
+		})
+	}
+	return moves
+}
+
+// checkPDBs returns the keys of the PodDisruptionBudgets covering the pod, and false if one of them
+// doesn't allow any disruption. The allowed disruptions of the budgets are recorded in the budgets.
+// As in policy/v1, an empty selector covers all the pods in the namespace.
+func (p *Planner) checkPDBs(pod *v1.Pod, budgets map[string]int32) (keys []string, allowed bool) {
+	if p.pdbLister == nil {
+		return nil, true
+	}
+
+	pdbs, err := p.pdbLister.PodDisruptionBudgets(pod.Namespace).List(labels.Everything())
+	if err != nil {
+		defragLog.V(3).Warn("failed to list pdbs", log.Namespace(pod.Namespace), log.Err(err))
+		return nil, false
+	}
+
+	for _, pdb := range pdbs {
+		selector, err := metav1.LabelSelectorAsSelector(pdb.Spec.Selector)
+		if err != nil || !selector.Matches(labels.Set(pod.Labels)) {
+			continue
+		}
+		key := pdb.Namespace + "/" + pdb.Name
+		budgets[key] = pdb.Status.DisruptionsAllowed
+		if pdb.Status.DisruptionsAllowed <= 0 {
+			defragLog.V(10).Debug("pod can't be moved due to pdb", log.Pod(pod.Name), log.Namespace(pod.Namespace), log.String("pdb", key))
+			return nil, false
+		}
+		keys = append(keys, key)
+	}
+	return keys, true
+}
+
+// isMovable checks if the pod is recreated by its controller after eviction
+func isMovable(pod *v1.Pod) bool {
+	owner := metav1.GetControllerOf(pod)
+	return owner != nil && owner.Kind != "DaemonSet"
+}
+
+// bestFit returns the slot with the least free memory which fits the request, except the excluded one
+func bestFit(slots []*slot, free map[*slot]uint, gpuMem uint, exclude *slot) *slot {
+	var found *slot
+	for _, s := range slots {
+		if s == exclude || free[s] < gpuMem {
+			continue
+		}
+		if found == nil || free[s] < free[found] {
+			found = s
+		}
+	}
+	return found
+}
+
+// freeOf returns the free gpu memory of the slots, which is updated during planning
+func freeOf(slots []*slot) map[*slot]uint {
+	free := map[*slot]uint{}
+	for _, s := range slots {
+		free[s] = s.free
+	}
+	return free
+}
+
+// isBetter prefers fewer moves, then less moved gpu memory
+func isBetter(moves, than []*Move) bool {
+	if len(moves) != len(than) {
+		return len(moves) < len(than)
+	}
+	return movedGPUMemory(moves) < movedGPUMemory(than)
+}
+
+func movedGPUMemory(moves []*Move) (gpuMem uint) {
+	for _, m := range moves {
+		gpuMem += m.GPUMemory
+	}
+	return gpuMem
+}
@@ -0,0 +1,248 @@
+package defrag
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/AliyunContainerService/gpushare-scheduler-extender/pkg/cache"
+	"github.com/AliyunContainerService/gpushare-scheduler-extender/pkg/log"
+	v1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	policylisters "k8s.io/client-go/listers/policy/v1"
+	clientgocache "k8s.io/client-go/tools/cache"
+)
+
+func init() {
+	log.NewLoggerWithLevel(0)
+}
+
+// testPod is a running pod on the device, the nodes "a" and "b" have 2 devices of 8 gpu memory
+type testPod struct {
+	name      string
+	node      string
+	device    int
+	gpuMemory int
+	// daemonSet pods are not movable
+	daemonSet bool
+	app       string
+}
+
+func (p testPod) pod() *v1.Pod {
+	controller := true
+	kind := "ReplicaSet"
+	if p.daemonSet {
+		kind = "DaemonSet"
+	}
+	return &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            p.name,
+			Namespace:       "default",
+			UID:             types.UID("uid-" + p.name),
+			Labels:          map[string]string{"app": p.app},
+			OwnerReferences: []metav1.OwnerReference{{Kind: kind, Name: "owner", Controller: &controller}},
+			Annotations: map[string]string{
+				"ALIYUN_COM_GPU_MEM_IDX": strconv.Itoa(p.device),
+				"ALIYUN_COM_GPU_MEM_POD": strconv.Itoa(p.gpuMemory),
+			},
+		},
+		Spec:   v1.PodSpec{NodeName: p.node},
+		Status: v1.PodStatus{Phase: v1.PodRunning},
+	}
+}
+
+func newTestCache(t *testing.T, pods []testPod) *cache.SchedulerCache {
+	nodeIndexer := clientgocache.NewIndexer(clientgocache.MetaNamespaceKeyFunc, clientgocache.Indexers{})
+	for _, name := range []string{"a", "b"} {
+		err := nodeIndexer.Add(&v1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Status: v1.NodeStatus{Capacity: v1.ResourceList{
+				"aliyun.com/gpu-mem":   resource.MustParse("16"),
+				"aliyun.com/gpu-count": resource.MustParse("2"),
+			}},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	podIndexer := clientgocache.NewIndexer(clientgocache.MetaNamespaceKeyFunc, clientgocache.Indexers{clientgocache.NamespaceIndex: clientgocache.MetaNamespaceIndexFunc})
+	c := cache.NewSchedulerCache(corelisters.NewNodeLister(nodeIndexer), corelisters.NewPodLister(podIndexer), nil)
+	for _, p := range pods {
+		pod := p.pod()
+		if err := podIndexer.Add(pod); err != nil {
+			t.Fatal(err)
+		}
+		if err := c.AddOrUpdatePod(pod); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return c
+}
+
+func newPDBLister(t *testing.T, selector *metav1.LabelSelector, disruptionsAllowed int32) policylisters.PodDisruptionBudgetLister {
+	indexer := clientgocache.NewIndexer(clientgocache.MetaNamespaceKeyFunc, clientgocache.Indexers{clientgocache.NamespaceIndex: clientgocache.MetaNamespaceIndexFunc})
+	err := indexer.Add(&policyv1.PodDisruptionBudget{
+		ObjectMeta: metav1.ObjectMeta{Name: "pdb", Namespace: "default"},
+		Spec:       policyv1.PodDisruptionBudgetSpec{Selector: selector},
+		Status:     policyv1.PodDisruptionBudgetStatus{DisruptionsAllowed: disruptionsAllowed},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return policylisters.NewPodDisruptionBudgetLister(indexer)
+}
+
+func TestPlan(t *testing.T) {
+	// every device is partially used, a/0 frees 6 with the fewest moved gpu memory
+	spread := []testPod{
+		{name: "p1", node: "a", device: 0, gpuMemory: 3, app: "web"},
+		{name: "p2", node: "a", device: 0, gpuMemory: 2},
+		{name: "p3", node: "a", device: 1, gpuMemory: 5},
+		{name: "p4", node: "b", device: 0, gpuMemory: 4},
+		{name: "p5", node: "b", device: 1, gpuMemory: 4},
+	}
+	// only a/0 can be freed, by moving both web pods to b
+	shared := []testPod{
+		{name: "p1", node: "a", device: 0, gpuMemory: 4, app: "web"},
+		{name: "p2", node: "a", device: 0, gpuMemory: 3, app: "web"},
+		{name: "p3", node: "a", device: 1, gpuMemory: 6, daemonSet: true},
+		{name: "p4", node: "b", device: 0, gpuMemory: 4, daemonSet: true},
+		{name: "p5", node: "b", device: 1, gpuMemory: 4, daemonSet: true},
+	}
+	// only a/0 can be freed, the largest pod isn't needed
+	crowded := []testPod{
+		{name: "p1", node: "a", device: 0, gpuMemory: 3},
+		{name: "p2", node: "a", device: 0, gpuMemory: 2},
+		{name: "p3", node: "a", device: 0, gpuMemory: 2},
+		{name: "p4", node: "a", device: 1, gpuMemory: 4, daemonSet: true},
+		{name: "p5", node: "b", device: 0, gpuMemory: 4, daemonSet: true},
+		{name: "p6", node: "b", device: 1, gpuMemory: 4, daemonSet: true},
+	}
+	web := &metav1.LabelSelector{MatchLabels: map[string]string{"app": "web"}}
+
+	tests := []struct {
+		name        string
+		pods        []testPod
+		pdbSelector *metav1.LabelSelector
+		pdbAllowed  int32
+		req         Request
+		wantNode    string
+		wantDevice  int
+		wantMoves   []string
+		wantReason  string
+		notFeasible bool
+	}{
+		{
+			name:       "free slot needs no move",
+			pods:       []testPod{{name: "p1", node: "a", device: 0, gpuMemory: 4}},
+			req:        Request{GPUMemory: 4},
+			wantNode:   "a",
+			wantDevice: 0,
+			wantMoves:  []string{},
+		},
+		{
+			name:       "largest pod moves to the best fit device",
+			pods:       spread,
+			req:        Request{GPUMemory: 6},
+			wantNode:   "a",
+			wantDevice: 0,
+			wantMoves:  []string{"p1 a/0->a/1"},
+		},
+		{
+			name:        "PodDisruptionBudget without disruptions keeps the pod",
+			pods:        spread,
+			pdbSelector: web,
+			pdbAllowed:  0,
+			req:         Request{GPUMemory: 6},
+			wantNode:    "b",
+			wantDevice:  0,
+			wantMoves:   []string{"p4 b/0->b/1"},
+		},
+		{
+			name:        "PodDisruptionBudget allows the planned disruptions",
+			pods:        shared,
+			pdbSelector: web,
+			pdbAllowed:  2,
+			req:         Request{GPUMemory: 8},
+			wantNode:    "a",
+			wantDevice:  0,
+			wantMoves:   []string{"p1 a/0->b/0", "p2 a/0->b/1"},
+		},
+		{
+			name:        "planned disruptions count against the PodDisruptionBudget",
+			pods:        shared,
+			pdbSelector: web,
+			pdbAllowed:  1,
+			req:         Request{GPUMemory: 8},
+			notFeasible: true,
+			wantReason:  "no device can free 8 gpu memory",
+		},
+		{
+			name:        "MaxMoves limits the moves",
+			pods:        shared,
+			req:         Request{GPUMemory: 8, MaxMoves: 1},
+			notFeasible: true,
+			wantReason:  "no device can free 8 gpu memory within 1 moves",
+		},
+		{
+			name:        "PodDisruptionBudget with an empty selector covers all the pods",
+			pods:        spread,
+			pdbSelector: &metav1.LabelSelector{},
+			pdbAllowed:  0,
+			req:         Request{GPUMemory: 6},
+			notFeasible: true,
+			wantReason:  "no device can free 6 gpu memory",
+		},
+		{
+			name:       "fewest moves with the least moved gpu memory",
+			pods:       crowded,
+			req:        Request{GPUMemory: 5},
+			wantNode:   "a",
+			wantDevice: 0,
+			wantMoves:  []string{"p2 a/0->a/1", "p3 a/0->a/1"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var pdbLister policylisters.PodDisruptionBudgetLister
+			if test.pdbSelector != nil {
+				pdbLister = newPDBLister(t, test.pdbSelector, test.pdbAllowed)
+			}
+			plan, err := NewPlanner(newTestCache(t, test.pods), pdbLister).Plan(test.req)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if plan.Feasible == test.notFeasible {
+				t.Fatalf("expected feasible %v, got %+v", !test.notFeasible, plan)
+			}
+			if plan.Reason != test.wantReason {
+				t.Errorf("expected reason %q, got %q", test.wantReason, plan.Reason)
+			}
+			if test.notFeasible {
+				return
+			}
+			if plan.Node != test.wantNode || plan.Device != test.wantDevice {
+				t.Errorf("expected the device %s/%d, got %s/%d", test.wantNode, test.wantDevice, plan.Node, plan.Device)
+			}
+			moves := []string{}
+			for _, m := range plan.Moves {
+				moves = append(moves, m.Name+" "+m.FromNode+"/"+strconv.Itoa(m.FromDevice)+"->"+m.ToNode+"/"+strconv.Itoa(m.ToDevice))
+			}
+			if !reflect.DeepEqual(moves, test.wantMoves) {
+				t.Errorf("expected the moves %v, got %v", test.wantMoves, moves)
+			}
+		})
+	}
+}
+
+func TestPlanRejectsEmptyRequest(t *testing.T) {
+	if _, err := NewPlanner(newTestCache(t, nil), nil).Plan(Request{}); err == nil {
+		t.Errorf("expected an error for the request without gpu memory")
+	}
+}
@@ -1,15 +1,20 @@
 package gpushare
 
 import (
+	"context"
 	"fmt"
 	"github.com/AliyunContainerService/gpushare-scheduler-extender/pkg/log"
 	"golang.org/x/time/rate"
+	"sync/atomic"
 	"time"
 
 	"github.com/AliyunContainerService/gpushare-scheduler-extender/pkg/cache"
+	"github.com/AliyunContainerService/gpushare-scheduler-extender/pkg/leader"
 	"github.com/AliyunContainerService/gpushare-scheduler-extender/pkg/utils"
 	"k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
 	"k8s.io/apimachinery/pkg/util/runtime"
 	"k8s.io/apimachinery/pkg/util/wait"
 	kubeinformers "k8s.io/client-go/informers"
@@ -23,12 +28,17 @@ import (
 	"k8s.io/client-go/tools/record"
 )
 
+var controllerLog = log.Named(log.SubsystemController)
+
 var (
 	KeyFunc = clientgocache.DeletionHandlingMetaNamespaceKeyFunc
 )
 
+// ReasonUnhealthyGPU is the event reason on the node when a device is reported as unhealthy
+const ReasonUnhealthyGPU = "UnhealthyGPU"
+
 type Controller struct {
-	clientset *kubernetes.Clientset
+	clientset kubernetes.Interface
 
 	// podLister can list/get pods from the shared informer's store.
 	podLister corelisters.PodLister
@@ -57,10 +67,29 @@ type Controller struct {
 
 	// The cache to store the pod to be removed
 	removePodCache map[string]*v1.Pod
+
+	// cacheBuilt and workersStarted are 1 after BuildCache and Run
+	cacheBuilt     int32
+	workersStarted int32
+	// lastSync and lastProcessed are the unix nano time when a pod was synced successfully
+	// and when a worker finished an item
+	lastSync      int64
+	lastProcessed int64
+}
+
+// Status is the state of the informers, the cache and the workers for the health checks
+type Status struct {
+	InformersSynced bool `json:"informersSynced"`
+	CacheBuilt      bool `json:"cacheBuilt"`
+	WorkersStarted  bool `json:"workersStarted"`
+	QueueDepth      int  `json:"queueDepth"`
+	// LastSync is nil before the first pod is synced
+	LastSync      *time.Time `json:"lastSync,omitempty"`
+	LastProcessed *time.Time `json:"lastProcessed,omitempty"`
 }
 
-func NewController(clientset *kubernetes.Clientset, kubeInformerFactory kubeinformers.SharedInformerFactory, stopCh <-chan struct{}) (*Controller, error) {
-	log.V(100).Info("info: Creating event broadcaster")
+func NewController(clientset kubernetes.Interface, kubeInformerFactory kubeinformers.SharedInformerFactory, stopCh <-chan struct{}) (*Controller, error) {
+	controllerLog.V(100).Info("creating event broadcaster")
 	eventBroadcaster := record.NewBroadcaster()
 	// eventBroadcaster.StartLogging(log.Infof)
 	eventBroadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: clientset.CoreV1().Events("")})
@@ -82,11 +111,11 @@ func NewController(clientset *kubernetes.Clientset, kubeInformerFactory kubeinfo
 		FilterFunc: func(obj interface{}) bool {
 			switch t := obj.(type) {
 			case *v1.Pod:
-				// log.V(100).Info("debug: added pod %s in ns %s", t.Name, t.Namespace)
+				// controllerLog.V(100).Debug("added pod", log.Pod(t.Name), log.Namespace(t.Namespace))
 				return utils.IsGPUsharingPod(t)
 			case clientgocache.DeletedFinalStateUnknown:
 				if pod, ok := t.Obj.(*v1.Pod); ok {
-					log.V(100).Info("debug: delete pod %s in ns %s", pod.Name, pod.Namespace)
+					controllerLog.V(100).Debug("delete pod", log.Pod(pod.Name), log.Namespace(pod.Namespace))
 					return utils.IsGPUsharingPod(pod)
 				}
 				runtime.HandleError(fmt.Errorf("unable to convert object %T to *v1.Pod in %T", obj, c))
@@ -113,64 +142,141 @@ func NewController(clientset *kubernetes.Clientset, kubeInformerFactory kubeinfo
 
 	// Create configMap informer
 	cmInformer := kubeInformerFactory.Core().V1().ConfigMaps()
-	cache.ConfigMapLister = cmInformer.Lister()
 	cache.ConfigMapInformerSynced = cmInformer.Informer().HasSynced
+	cmInformer.Informer().AddEventHandler(clientgocache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			c.recordUnhealthyGPUs(nil, obj)
+		},
+		UpdateFunc: c.recordUnhealthyGPUs,
+	})
+	cmInformer.Informer().AddEventHandler(clientgocache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			c.updateUnhealthyGPUs(obj, false)
+		},
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			c.updateUnhealthyGPUs(newObj, false)
+		},
+		DeleteFunc: func(obj interface{}) {
+			c.updateUnhealthyGPUs(obj, true)
+		},
+	})
+
+	// Create scheduler Cache before the informers call the handlers
+	c.schedulerCache = cache.NewSchedulerCache(c.nodeLister, c.podLister, cmInformer.Lister())
 
 	// Start informer goroutines.
 	go kubeInformerFactory.Start(stopCh)
 
-	// Create scheduler Cache
-	c.schedulerCache = cache.NewSchedulerCache(c.nodeLister, c.podLister)
+	return c, nil
+}
 
-	log.V(100).Info("info: begin to wait for cache")
+// WaitForCacheSync blocks until the informers are synced, the cache can be built after it
+func (c *Controller) WaitForCacheSync(stopCh <-chan struct{}) error {
+	controllerLog.V(100).Info("begin to wait for cache")
 
 	if ok := clientgocache.WaitForCacheSync(stopCh, c.nodeInformerSynced); !ok {
-		return nil, fmt.Errorf("failed to wait for node caches to sync")
+		return fmt.Errorf("failed to wait for node caches to sync")
 	} else {
-		log.V(100).Info("info: init the node cache successfully")
+		controllerLog.V(100).Info("init the node cache successfully")
 	}
 
 	if ok := clientgocache.WaitForCacheSync(stopCh, c.podInformerSynced); !ok {
-		return nil, fmt.Errorf("failed to wait for pod caches to sync")
+		return fmt.Errorf("failed to wait for pod caches to sync")
 	} else {
-		log.V(100).Info("info: init the pod cache successfully")
+		controllerLog.V(100).Info("init the pod cache successfully")
 	}
 
 	if ok := clientgocache.WaitForCacheSync(stopCh, cache.ConfigMapInformerSynced); !ok {
-		return nil, fmt.Errorf("failed to wait for configmap caches to sync")
+		return fmt.Errorf("failed to wait for configmap caches to sync")
 	} else {
-		log.V(100).Info("info: init the configmap cache successfully")
+		controllerLog.V(100).Info("init the configmap cache successfully")
 	}
 
-	log.V(100).Info("info: end to wait for cache")
+	controllerLog.V(100).Info("end to wait for cache")
 
-	return c, nil
+	return nil
 }
 
 func (c *Controller) BuildCache() error {
-	return c.schedulerCache.BuildCache()
+	if err := c.schedulerCache.BuildCache(); err != nil {
+		return err
+	}
+	atomic.StoreInt32(&c.cacheBuilt, 1)
+	return nil
+}
+
+// Resync adds the assigned pods read from the API server to the cache, rather than from the informer.
+// A new leader calls it before binding, so the allocations of the previous leader which the informer
+// hasn't observed yet are not allocated again.
+func (c *Controller) Resync(ctx context.Context) error {
+	// the informer may lag behind the list, its events must not go back to older versions of the pods
+	if err := c.WaitForCacheSync(ctx.Done()); err != nil {
+		return err
+	}
+	pods, err := c.clientset.CoreV1().Pods(metav1.NamespaceAll).List(ctx, metav1.ListOptions{
+		FieldSelector: fields.OneTermNotEqualSelector("spec.nodeName", "").String(),
+	})
+	if err != nil {
+		return err
+	}
+
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		if utils.GetGPUMemoryFromPodAnnotation(pod) <= uint(0) || utils.IsCompletePod(pod) {
+			continue
+		}
+		if err := c.schedulerCache.AddOrUpdatePod(pod); err != nil {
+			return err
+		}
+	}
+	controllerLog.V(3).Info("resynced the cache from the API server", log.Int("pods", len(pods.Items)))
+	return nil
+}
+
+// Status returns the state for the health checks
+func (c *Controller) Status() Status {
+	status := Status{
+		InformersSynced: c.nodeInformerSynced() && c.podInformerSynced() && cache.ConfigMapInformerSynced(),
+		CacheBuilt:      atomic.LoadInt32(&c.cacheBuilt) == 1,
+		WorkersStarted:  atomic.LoadInt32(&c.workersStarted) == 1,
+		QueueDepth:      c.podQueue.Len(),
+	}
+	if lastSync := atomic.LoadInt64(&c.lastSync); lastSync > 0 {
+		t := time.Unix(0, lastSync)
+		status.LastSync = &t
+	}
+	if lastProcessed := atomic.LoadInt64(&c.lastProcessed); lastProcessed > 0 {
+		t := time.Unix(0, lastProcessed)
+		status.LastProcessed = &t
+	}
+	return status
 }
 
 func (c *Controller) GetSchedulerCache() *cache.SchedulerCache {
 	return c.schedulerCache
 }
 
+func (c *Controller) GetRecorder() record.EventRecorder {
+	return c.recorder
+}
+
 // Run will set up the event handlers
 func (c *Controller) Run(threadiness int, stopCh <-chan struct{}) error {
 	defer runtime.HandleCrash()
 	defer c.podQueue.ShutDown()
 
-	log.V(9).Info("info: Starting GPU Sharing Controller.")
-	log.V(9).Info("info: Waiting for informer caches to sync")
+	controllerLog.V(9).Info("starting GPU Sharing Controller")
+	controllerLog.V(9).Info("waiting for informer caches to sync")
 
-	log.V(9).Info("info: Starting %v workers.", threadiness)
+	controllerLog.V(9).Info("starting workers", log.Int("threadiness", threadiness))
 	for i := 0; i < threadiness; i++ {
 		go wait.Until(c.runWorker, time.Second, stopCh)
 	}
+	atomic.StoreInt32(&c.workersStarted, 1)
 
-	log.V(3).Info("info: Started workers")
+	controllerLog.V(3).Info("started workers")
 	<-stopCh
-	log.V(3).Info("info: Shutting down workers")
+	controllerLog.V(3).Info("shutting down workers")
 
 	return nil
 }
@@ -188,7 +294,7 @@ func (c *Controller) runWorker() {
 // invoked concurrently with the same key.
 func (c *Controller) syncPod(key string) (forget bool, err error) {
 	ns, name, err := clientgocache.SplitMetaNamespaceKey(key)
-	log.V(9).Info("debug: begin to sync gpushare pod %s in ns %s", name, ns)
+	controllerLog.V(9).Debug("begin to sync gpushare pod", log.Pod(name), log.Namespace(ns))
 	if err != nil {
 		return false, err
 	}
@@ -196,17 +302,17 @@ func (c *Controller) syncPod(key string) (forget bool, err error) {
 	pod, err := c.podLister.Pods(ns).Get(name)
 	switch {
 	case errors.IsNotFound(err):
-		log.V(10).Info("debug: pod %s in ns %s has been deleted.", name, ns)
+		controllerLog.V(10).Debug("pod has been deleted", log.Pod(name), log.Namespace(ns))
 		pod, found := c.removePodCache[key]
 		if found {
 			c.schedulerCache.RemovePod(pod)
 			delete(c.removePodCache, key)
 		}
 	case err != nil:
-		log.V(10).Info("warn: unable to retrieve pod %v from the store: %v", key, err)
+		controllerLog.V(10).Warn("unable to retrieve pod from the store", log.String("key", key), log.Err(err))
 	default:
 		if utils.IsCompletePod(pod) {
-			log.V(10).Info("debug: pod %s in ns %s has completed.", name, ns)
+			controllerLog.V(10).Debug("pod has completed", log.Pod(name), log.Namespace(ns))
 			c.schedulerCache.RemovePod(pod)
 		} else {
 			err := c.schedulerCache.AddOrUpdatePod(pod)
@@ -222,43 +328,96 @@ func (c *Controller) syncPod(key string) (forget bool, err error) {
 // processNextWorkItem will read a single work item off the podQueue and
 // attempt to process it.
 func (c *Controller) processNextWorkItem() bool {
-	log.V(100).Info("debug: begin processNextWorkItem()")
+	controllerLog.V(100).Debug("begin processNextWorkItem()")
 	key, quit := c.podQueue.Get()
 	if quit {
 		return false
 	}
 	defer c.podQueue.Done(key)
-	defer log.V(100).Info("debug: end processNextWorkItem()")
+	defer controllerLog.V(100).Debug("end processNextWorkItem()")
 	forget, err := c.syncPod(key.(string))
+	atomic.StoreInt64(&c.lastProcessed, time.Now().UnixNano())
 	if err == nil {
+		atomic.StoreInt64(&c.lastSync, time.Now().UnixNano())
 		if forget {
 			c.podQueue.Forget(key)
 		}
 		return true
 	}
 
-	log.V(3).Info("Error syncing pods: %v", err)
+	controllerLog.V(3).Error("error syncing pods", log.Err(err))
 	runtime.HandleError(fmt.Errorf("Error syncing pod: %v", err))
 	c.podQueue.AddRateLimited(key)
 
 	return true
 }
 
+// recordUnhealthyGPUs records an event on the node for each device which becomes unhealthy.
+// The configmaps listed at startup are skipped, and only the leader records the events.
+func (c *Controller) recordUnhealthyGPUs(oldObj, newObj interface{}) {
+	if atomic.LoadInt32(&c.cacheBuilt) == 0 || !leader.IsLeader() {
+		return
+	}
+	cm, ok := newObj.(*v1.ConfigMap)
+	if !ok {
+		return
+	}
+	nodeName, ok := cache.NodeNameFromUnhealthyConfigMap(cm)
+	if !ok {
+		return
+	}
+	oldCM, _ := oldObj.(*v1.ConfigMap)
+	oldUnhealthy := cache.UnhealthyGPUsFromConfigMap(oldCM)
+
+	node, err := c.nodeLister.Get(nodeName)
+	if err != nil {
+		controllerLog.V(10).Debug("skip the unhealthy GPU event of the unknown node", log.Node(nodeName), log.Err(err))
+		return
+	}
+	for id := range cache.UnhealthyGPUsFromConfigMap(cm) {
+		if oldUnhealthy[id] {
+			continue
+		}
+		controllerLog.V(3).Warn("GPU becomes unhealthy", log.Node(nodeName), log.DevID(id))
+		c.recorder.Eventf(node, v1.EventTypeWarning, ReasonUnhealthyGPU, "GPU %d on node %s is unhealthy", id, nodeName)
+	}
+}
+
+// updateUnhealthyGPUs passes the unhealthy devices to the cache's change feed, none if the configmap is deleted
+func (c *Controller) updateUnhealthyGPUs(obj interface{}, deleted bool) {
+	if tombstone, ok := obj.(clientgocache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	cm, ok := obj.(*v1.ConfigMap)
+	if !ok {
+		return
+	}
+	nodeName, ok := cache.NodeNameFromUnhealthyConfigMap(cm)
+	if !ok {
+		return
+	}
+	unhealthyGPUs := map[int]bool{}
+	if !deleted {
+		unhealthyGPUs = cache.UnhealthyGPUsFromConfigMap(cm)
+	}
+	c.schedulerCache.UpdateUnhealthyGPUs(nodeName, unhealthyGPUs)
+}
+
 func (c *Controller) addPodToCache(obj interface{}) {
 	pod, ok := obj.(*v1.Pod)
 	if !ok {
-		log.V(3).Info("warn: cannot convert to *v1.Pod: %v", obj)
+		controllerLog.V(3).Warn("cannot convert to *v1.Pod", log.String("type", fmt.Sprintf("%T", obj)))
 		return
 	}
 
 	// if !assignedNonTerminatedPod(t) {
-	// 	log.V(100).Info("debug: skip pod %s due to it's terminated.", pod.Name)
+	// 	controllerLog.V(100).Debug("skip pod due to it's terminated", log.Pod(pod.Name))
 	// 	return
 	// }
 
 	podKey, err := KeyFunc(pod)
 	if err != nil {
-		log.V(3).Info("warn: Failed to get the jobkey: %v", err)
+		controllerLog.V(3).Warn("failed to get the jobkey", log.Err(err))
 		return
 	}
 
@@ -271,12 +430,12 @@ func (c *Controller) addPodToCache(obj interface{}) {
 func (c *Controller) updatePodInCache(oldObj, newObj interface{}) {
 	oldPod, ok := oldObj.(*v1.Pod)
 	if !ok {
-		log.V(3).Info("warn: cannot convert oldObj to *v1.Pod: %v", oldObj)
+		controllerLog.V(3).Warn("cannot convert oldObj to *v1.Pod", log.String("type", fmt.Sprintf("%T", oldObj)))
 		return
 	}
 	newPod, ok := newObj.(*v1.Pod)
 	if !ok {
-		log.V(3).Info("warn: cannot convert newObj to *v1.Pod: %v", newObj)
+		controllerLog.V(3).Warn("cannot convert newObj to *v1.Pod", log.String("type", fmt.Sprintf("%T", newObj)))
 		return
 	}
 	needUpdate := false
@@ -294,25 +453,25 @@ func (c *Controller) updatePodInCache(oldObj, newObj interface{}) {
 	if needUpdate {
 		podKey, err := KeyFunc(newPod)
 		if err != nil {
-			log.V(3).Info("warn: Failed to get the jobkey: %v", err)
+			controllerLog.V(3).Warn("failed to get the jobkey", log.Err(err))
 			return
 		}
-		log.V(3).Info("info: Need to update pod name %s in ns %s and old status is %v, new status is %v; its old annotation %v and new annotation %v",
-			newPod.Name,
-			newPod.Namespace,
-			oldPod.Status.Phase,
-			newPod.Status.Phase,
-			oldPod.Annotations,
-			newPod.Annotations)
+		controllerLog.V(3).Info("need to update pod",
+			log.Pod(newPod.Name),
+			log.Namespace(newPod.Namespace),
+			log.String("oldPhase", string(oldPod.Status.Phase)),
+			log.String("newPhase", string(newPod.Status.Phase)),
+			log.Any("oldAnnotations", oldPod.Annotations),
+			log.Any("newAnnotations", newPod.Annotations))
 		c.podQueue.Add(podKey)
 	} else {
-		log.V(100).Info("debug: No need to update pod name %s in ns %s and old status is %v, new status is %v; its old annotation %v and new annotation %v",
-			newPod.Name,
-			newPod.Namespace,
-			oldPod.Status.Phase,
-			newPod.Status.Phase,
-			oldPod.Annotations,
-			newPod.Annotations)
+		controllerLog.V(100).Debug("no need to update pod",
+			log.Pod(newPod.Name),
+			log.Namespace(newPod.Namespace),
+			log.String("oldPhase", string(oldPod.Status.Phase)),
+			log.String("newPhase", string(newPod.Status.Phase)),
+			log.Any("oldAnnotations", oldPod.Annotations),
+			log.Any("newAnnotations", newPod.Annotations))
 	}
 
 	return
@@ -327,18 +486,18 @@ func (c *Controller) deletePodFromCache(obj interface{}) {
 		var ok bool
 		pod, ok = t.Obj.(*v1.Pod)
 		if !ok {
-			log.V(3).Info("warn: cannot convert to *v1.Pod: %v", t.Obj)
+			controllerLog.V(3).Warn("cannot convert to *v1.Pod", log.String("type", fmt.Sprintf("%T", t.Obj)))
 			return
 		}
 	default:
-		log.V(3).Info("warn: cannot convert to *v1.Pod: %v", t)
+		controllerLog.V(3).Warn("cannot convert to *v1.Pod", log.String("type", fmt.Sprintf("%T", t)))
 		return
 	}
 
-	log.V(100).Info("debug: delete pod %s in ns %s", pod.Name, pod.Namespace)
+	controllerLog.V(100).Debug("delete pod", log.Pod(pod.Name), log.Namespace(pod.Namespace))
 	podKey, err := KeyFunc(pod)
 	if err != nil {
-		log.V(3).Info("warn: Failed to get the jobkey: %v", err)
+		controllerLog.V(3).Warn("failed to get the jobkey", log.Err(err))
 		return
 	}
 	c.podQueue.Add(podKey)
@@ -0,0 +1,93 @@
+package gpushare
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/AliyunContainerService/gpushare-scheduler-extender/pkg/cache"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	clientgocache "k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+)
+
+func newUnhealthyConfigMap(name string, gpus string) *v1.ConfigMap {
+	return &v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: cache.ConfigMapNamespace},
+		Data:       map[string]string{"gpus": gpus},
+	}
+}
+
+func TestRecordUnhealthyGPUs(t *testing.T) {
+	tests := []struct {
+		name       string
+		cacheBuilt bool
+		old        *v1.ConfigMap
+		new        *v1.ConfigMap
+		// wantEvents are "<type> <reason> <message>"
+		wantEvents []string
+	}{
+		{
+			name:       "configmap is created",
+			cacheBuilt: true,
+			new:        newUnhealthyConfigMap(cache.UnhealthyConfigMapName("n1"), "1"),
+			wantEvents: []string{"Warning UnhealthyGPU GPU 1 on node n1 is unhealthy"},
+		},
+		{
+			name:       "another device becomes unhealthy",
+			cacheBuilt: true,
+			old:        newUnhealthyConfigMap(cache.UnhealthyConfigMapName("n1"), "0"),
+			new:        newUnhealthyConfigMap(cache.UnhealthyConfigMapName("n1"), "0, 1"),
+			wantEvents: []string{"Warning UnhealthyGPU GPU 1 on node n1 is unhealthy"},
+		},
+		{
+			name:       "device recovers",
+			cacheBuilt: true,
+			old:        newUnhealthyConfigMap(cache.UnhealthyConfigMapName("n1"), "0,1"),
+			new:        newUnhealthyConfigMap(cache.UnhealthyConfigMapName("n1"), "0"),
+		},
+		{
+			name: "configmap is listed at startup",
+			new:  newUnhealthyConfigMap(cache.UnhealthyConfigMapName("n1"), "1"),
+		},
+		{
+			name:       "unknown node",
+			cacheBuilt: true,
+			new:        newUnhealthyConfigMap(cache.UnhealthyConfigMapName("n2"), "1"),
+		},
+		{
+			name:       "other configmap",
+			cacheBuilt: true,
+			new:        newUnhealthyConfigMap("other", "1"),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			nodes := clientgocache.NewIndexer(clientgocache.MetaNamespaceKeyFunc, clientgocache.Indexers{})
+			if err := nodes.Add(&v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "n1"}}); err != nil {
+				t.Fatal(err)
+			}
+			recorder := record.NewFakeRecorder(10)
+			c := &Controller{nodeLister: corelisters.NewNodeLister(nodes), recorder: recorder}
+			if test.cacheBuilt {
+				c.cacheBuilt = 1
+			}
+			var old interface{}
+			if test.old != nil {
+				old = test.old
+			}
+
+			c.recordUnhealthyGPUs(old, test.new)
+			close(recorder.Events)
+			events := []string{}
+			for event := range recorder.Events {
+				events = append(events, event)
+			}
+			if !reflect.DeepEqual(events, append([]string{}, test.wantEvents...)) {
+				t.Errorf("expected the events %q, got %q", test.wantEvents, events)
+			}
+		})
+	}
+}
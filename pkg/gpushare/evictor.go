@@ -0,0 +1,233 @@
+package gpushare
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/AliyunContainerService/gpushare-scheduler-extender/pkg/cache"
+	"github.com/AliyunContainerService/gpushare-scheduler-extender/pkg/leader"
+	"github.com/AliyunContainerService/gpushare-scheduler-extender/pkg/log"
+	"github.com/AliyunContainerService/gpushare-scheduler-extender/pkg/utils"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	kubeinformers "k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	clientgocache "k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/workqueue"
+)
+
+const (
+	// ReasonUnhealthyGPUEviction is the event reason when the pod is evicted from an unhealthy GPU
+	ReasonUnhealthyGPUEviction = "UnhealthyGPUEviction"
+	// ReasonUnhealthyGPUEvictionBlocked is the event reason when the eviction is blocked, e.g. by a PodDisruptionBudget
+	ReasonUnhealthyGPUEvictionBlocked = "UnhealthyGPUEvictionBlocked"
+	// ReasonUnhealthyGPUEvictionDryRun is the event reason when the pod would be evicted in dry-run mode
+	ReasonUnhealthyGPUEvictionDryRun = "UnhealthyGPUEvictionDryRun"
+)
+
+// Evictor watches the unhealthy GPU configmaps and evicts the pods running on the unhealthy devices.
+// It's opt-in, and in dry-run mode it only reports what it would evict.
+type Evictor struct {
+	clientset kubernetes.Interface
+
+	schedulerCache *cache.SchedulerCache
+
+	// nodeQueue is a rate limited work queue of node names whose unhealthy GPUs changed
+	nodeQueue workqueue.RateLimitingInterface
+
+	recorder record.EventRecorder
+
+	dryRun bool
+
+	mu sync.Mutex
+	// reported is the pods on the unhealthy devices of each node reported in dry-run mode,
+	// so the resyncs of the configmaps don't repeat the events
+	reported map[string]string
+}
+
+func NewEvictor(clientset kubernetes.Interface,
+	kubeInformerFactory kubeinformers.SharedInformerFactory,
+	schedulerCache *cache.SchedulerCache,
+	recorder record.EventRecorder,
+	dryRun bool) *Evictor {
+	e := &Evictor{
+		clientset:      clientset,
+		schedulerCache: schedulerCache,
+		nodeQueue:      workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "unhealthyGPUQueue"),
+		recorder:       recorder,
+		dryRun:         dryRun,
+		reported:       map[string]string{},
+	}
+
+	cmInformer := kubeInformerFactory.Core().V1().ConfigMaps()
+	cmInformer.Informer().AddEventHandler(clientgocache.ResourceEventHandlerFuncs{
+		AddFunc: e.enqueueConfigMap,
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			e.enqueueConfigMap(newObj)
+		},
+	})
+
+	return e
+}
+
+// Run starts the workers, and blocks until stopCh is closed
+func (e *Evictor) Run(threadiness int, stopCh <-chan struct{}) {
+	defer runtime.HandleCrash()
+	defer e.nodeQueue.ShutDown()
+
+	controllerLog.V(9).Info("starting unhealthy GPU evictor", log.Bool("dryRun", e.dryRun))
+	for i := 0; i < threadiness; i++ {
+		go wait.Until(e.runWorker, time.Second, stopCh)
+	}
+
+	<-stopCh
+	controllerLog.V(3).Info("shutting down unhealthy GPU evictor")
+}
+
+func (e *Evictor) enqueueConfigMap(obj interface{}) {
+	cm, ok := obj.(*v1.ConfigMap)
+	if !ok {
+		controllerLog.V(3).Warn("cannot convert to *v1.ConfigMap", log.String("type", fmt.Sprintf("%T", obj)))
+		return
+	}
+
+	nodeName, ok := cache.NodeNameFromUnhealthyConfigMap(cm)
+	if !ok {
+		return
+	}
+
+	controllerLog.V(10).Debug("unhealthy GPUs of node changed", log.Node(nodeName), log.String("gpus", cm.Data["gpus"]))
+	e.nodeQueue.Add(nodeName)
+}
+
+func (e *Evictor) runWorker() {
+	for e.processNextWorkItem() {
+	}
+}
+
+func (e *Evictor) processNextWorkItem() bool {
+	key, quit := e.nodeQueue.Get()
+	if quit {
+		return false
+	}
+	defer e.nodeQueue.Done(key)
+
+	err := e.syncNode(key.(string))
+	if err == nil {
+		e.nodeQueue.Forget(key)
+		return true
+	}
+
+	controllerLog.V(3).Warn("failed to evict pods from unhealthy GPUs of node", log.Node(key.(string)), log.Err(err))
+	e.nodeQueue.AddRateLimited(key)
+
+	return true
+}
+
+// syncNode evicts all the pods on the unhealthy devices of the node. It returns an error
+// if any eviction is blocked or failed, so the node will be retried later.
+func (e *Evictor) syncNode(nodeName string) error {
+	if !leader.IsLeader() {
+		// the leader evicts the pods, the node is enqueued again by the resync of the informer
+		return nil
+	}
+	nodeInfo, err := e.schedulerCache.GetNodeInfo(nodeName)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			controllerLog.V(10).Debug("node has been deleted, skip eviction", log.Node(nodeName))
+			return nil
+		}
+		return err
+	}
+
+	pods := map[*v1.Pod]int{}
+	for _, dev := range nodeInfo.GetUnhealthyDevs() {
+		for _, pod := range dev.GetPods() {
+			if utils.AssignedNonTerminatedPod(pod) {
+				pods[pod] = dev.GetID()
+			}
+		}
+	}
+	if e.dryRun && !e.changed(nodeName, pods) {
+		return nil
+	}
+
+	var failed int
+	for pod, devID := range pods {
+		if err := e.evictPod(pod, nodeName, devID); err != nil {
+			failed++
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d pods on the unhealthy GPUs of node %s are not evicted", failed, nodeName)
+	}
+	return nil
+}
+
+// changed checks if the pods on the unhealthy devices of the node differ from the last reported ones
+func (e *Evictor) changed(nodeName string, pods map[*v1.Pod]int) bool {
+	keys := []string{}
+	for pod, devID := range pods {
+		keys = append(keys, fmt.Sprintf("%d/%s", devID, pod.UID))
+	}
+	sort.Strings(keys)
+	key := strings.Join(keys, ",")
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.reported[nodeName] == key {
+		return false
+	}
+	if len(key) == 0 {
+		delete(e.reported, nodeName)
+	} else {
+		e.reported[nodeName] = key
+	}
+	return true
+}
+
+func (e *Evictor) evictPod(pod *v1.Pod, nodeName string, devID int) error {
+	if e.dryRun {
+		controllerLog.V(3).Info("dry-run: would evict pod from unhealthy GPU",
+			log.Pod(pod.Name),
+			log.Namespace(pod.Namespace),
+			log.DevID(devID),
+			log.Node(nodeName))
+		e.recorder.Eventf(pod, v1.EventTypeWarning, ReasonUnhealthyGPUEvictionDryRun,
+			"Would evict pod from unhealthy GPU %d on node %s", devID, nodeName)
+		return nil
+	}
+
+	err := utils.EvictPod(context.Background(), e.clientset, pod)
+	switch {
+	case err == nil:
+		controllerLog.V(3).Info("evicted pod from unhealthy GPU",
+			log.Pod(pod.Name),
+			log.Namespace(pod.Namespace),
+			log.DevID(devID),
+			log.Node(nodeName))
+		e.recorder.Eventf(pod, v1.EventTypeWarning, ReasonUnhealthyGPUEviction,
+			"Evicted pod from unhealthy GPU %d on node %s", devID, nodeName)
+		return nil
+	case errors.IsNotFound(err) || errors.IsConflict(err):
+		// the pod is gone or replaced by a new one with the same name
+		return nil
+	case errors.IsTooManyRequests(err):
+		// the eviction is disallowed by a PodDisruptionBudget, retry later
+		controllerLog.V(3).Warn("eviction of pod is blocked", log.Pod(pod.Name), log.Namespace(pod.Namespace), log.Err(err))
+		e.recorder.Eventf(pod, v1.EventTypeWarning, ReasonUnhealthyGPUEvictionBlocked,
+			"Eviction from unhealthy GPU %d on node %s is blocked: %v", devID, nodeName, err)
+		return err
+	default:
+		controllerLog.V(3).Error("failed to evict pod", log.Pod(pod.Name), log.Namespace(pod.Namespace), log.Err(err))
+		return err
+	}
+}
@@ -0,0 +1,209 @@
+package gpushare
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"testing"
+
+	"github.com/AliyunContainerService/gpushare-scheduler-extender/pkg/cache"
+	"github.com/AliyunContainerService/gpushare-scheduler-extender/pkg/log"
+	v1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	k8stesting "k8s.io/client-go/testing"
+	clientgocache "k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+)
+
+func init() {
+	log.NewLoggerWithLevel(0)
+}
+
+// newEvictorCache has the node n1 with 2 devices of 8 gpu memory, the device 1 is unhealthy
+func newEvictorCache(t *testing.T, pods map[string]int) *cache.SchedulerCache {
+	nodes := clientgocache.NewIndexer(clientgocache.MetaNamespaceKeyFunc, clientgocache.Indexers{})
+	err := nodes.Add(&v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "n1"},
+		Status: v1.NodeStatus{Capacity: v1.ResourceList{
+			"aliyun.com/gpu-mem":   resource.MustParse("16"),
+			"aliyun.com/gpu-count": resource.MustParse("2"),
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	configMaps := clientgocache.NewIndexer(clientgocache.MetaNamespaceKeyFunc, clientgocache.Indexers{clientgocache.NamespaceIndex: clientgocache.MetaNamespaceIndexFunc})
+	err = configMaps.Add(&v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: cache.UnhealthyConfigMapName("n1"), Namespace: cache.ConfigMapNamespace},
+		Data:       map[string]string{"gpus": "1"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	podIndexer := clientgocache.NewIndexer(clientgocache.MetaNamespaceKeyFunc, clientgocache.Indexers{clientgocache.NamespaceIndex: clientgocache.MetaNamespaceIndexFunc})
+	c := cache.NewSchedulerCache(corelisters.NewNodeLister(nodes), corelisters.NewPodLister(podIndexer), corelisters.NewConfigMapLister(configMaps))
+	for name, devID := range pods {
+		pod := newEvictorPod(name, devID)
+		if err := podIndexer.Add(pod); err != nil {
+			t.Fatal(err)
+		}
+		if err := c.AddOrUpdatePod(pod); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return c
+}
+
+func newEvictorPod(name string, devID int) *v1.Pod {
+	return &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "default",
+			UID:       types.UID("uid-" + name),
+			Annotations: map[string]string{
+				"ALIYUN_COM_GPU_MEM_IDX": strconv.Itoa(devID),
+				"ALIYUN_COM_GPU_MEM_POD": "2",
+			},
+		},
+		Spec:   v1.PodSpec{NodeName: "n1"},
+		Status: v1.PodStatus{Phase: v1.PodRunning},
+	}
+}
+
+func TestEvictorSyncNode(t *testing.T) {
+	tests := []struct {
+		name   string
+		pods   map[string]int
+		dryRun bool
+		// evictError is returned by all the evictions
+		evictError  error
+		wantEvicted []string
+		wantEvents  []string
+		wantError   bool
+	}{
+		{
+			name:       "dry-run only reports the pods",
+			pods:       map[string]int{"p1": 1, "p2": 0},
+			dryRun:     true,
+			wantEvents: []string{ReasonUnhealthyGPUEvictionDryRun},
+		},
+		{
+			name:        "pods on the unhealthy device are evicted",
+			pods:        map[string]int{"p1": 1, "p2": 1, "p3": 0},
+			wantEvicted: []string{"p1", "p2"},
+			wantEvents:  []string{ReasonUnhealthyGPUEviction, ReasonUnhealthyGPUEviction},
+		},
+		{
+			name:        "eviction blocked by a PodDisruptionBudget is retried",
+			pods:        map[string]int{"p1": 1},
+			evictError:  errors.NewTooManyRequests("disruption budget", 10),
+			wantEvicted: []string{"p1"},
+			wantEvents:  []string{ReasonUnhealthyGPUEvictionBlocked},
+			wantError:   true,
+		},
+		{
+			name:        "pod which is already gone",
+			pods:        map[string]int{"p1": 1},
+			evictError:  errors.NewNotFound(v1.Resource("pods"), "p1"),
+			wantEvicted: []string{"p1"},
+		},
+		{
+			name: "no pod on the unhealthy device",
+			pods: map[string]int{"p1": 0},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			evicted := []string{}
+			clientset := fake.NewSimpleClientset()
+			clientset.PrependReactor("create", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+				if action.GetSubresource() != "eviction" {
+					return false, nil, nil
+				}
+				eviction := action.(k8stesting.CreateAction).GetObject().(*policyv1.Eviction)
+				evicted = append(evicted, eviction.Name)
+				return true, nil, test.evictError
+			})
+			recorder := record.NewFakeRecorder(10)
+			e := &Evictor{
+				clientset:      clientset,
+				schedulerCache: newEvictorCache(t, test.pods),
+				recorder:       recorder,
+				dryRun:         test.dryRun,
+				reported:       map[string]string{},
+			}
+
+			err := e.syncNode("n1")
+			if (err != nil) != test.wantError {
+				t.Fatalf("expected error %v, got %v", test.wantError, err)
+			}
+			sort.Strings(evicted)
+			if fmt.Sprint(evicted) != fmt.Sprint(test.wantEvicted) {
+				t.Errorf("expected the evicted pods %v, got %v", test.wantEvicted, evicted)
+			}
+			if events := readReasons(recorder); fmt.Sprint(events) != fmt.Sprint(test.wantEvents) {
+				t.Errorf("expected the events %v, got %v", test.wantEvents, events)
+			}
+		})
+	}
+}
+
+func TestEvictorDryRunReportsChanges(t *testing.T) {
+	c := newEvictorCache(t, map[string]int{"p1": 1})
+	recorder := record.NewFakeRecorder(10)
+	e := &Evictor{
+		clientset:      fake.NewSimpleClientset(),
+		schedulerCache: c,
+		recorder:       recorder,
+		dryRun:         true,
+		reported:       map[string]string{},
+	}
+
+	steps := []struct {
+		name       string
+		addPod     string
+		wantEvents int
+	}{
+		{name: "first sync", wantEvents: 1},
+		{name: "resync of the same pods", wantEvents: 0},
+		{name: "another pod on the unhealthy device", addPod: "p2", wantEvents: 2},
+		{name: "resync after the change", wantEvents: 0},
+	}
+	for _, step := range steps {
+		if len(step.addPod) > 0 {
+			if err := c.AddOrUpdatePod(newEvictorPod(step.addPod, 1)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := e.syncNode("n1"); err != nil {
+			t.Fatal(err)
+		}
+		if events := len(readReasons(recorder)); events != step.wantEvents {
+			t.Errorf("%s: expected %d events, got %d", step.name, step.wantEvents, events)
+		}
+	}
+}
+
+// readReasons drains the recorded events and returns their reasons
+func readReasons(recorder *record.FakeRecorder) []string {
+	reasons := []string{}
+	for {
+		select {
+		case event := <-recorder.Events:
+			var eventType, reason string
+			fmt.Sscanf(event, "%s %s", &eventType, &reason)
+			reasons = append(reasons, reason)
+		default:
+			return reasons
+		}
+	}
+}
@@ -0,0 +1,222 @@
+package leader
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/AliyunContainerService/gpushare-scheduler-extender/pkg/log"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/rand"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+// Label marks the pod of the leader, the leader Service selects it so the binds only reach the leader
+const Label = "gpushare.aliyun.com/leader"
+
+// Config is the Lease of the leader election
+type Config struct {
+	Namespace     string
+	Name          string
+	LeaseDuration time.Duration
+	RenewDeadline time.Duration
+	RetryPeriod   time.Duration
+	// PodName and PodNamespace are the pod of this replica, which is labeled while it leads.
+	// The pod isn't labeled if they are empty.
+	PodName      string
+	PodNamespace string
+}
+
+var (
+	// enabled is 0 without the leader election, then every replica is the leader
+	enabled  int32
+	leading  int32
+	identity string
+	holder   atomic.Value
+
+	// term is cancelled when this replica stops leading
+	termLock sync.RWMutex
+	term     context.Context
+)
+
+// IsLeader checks if this replica can bind the pods, it's always true without the leader election
+func IsLeader() bool {
+	return atomic.LoadInt32(&enabled) == 0 || atomic.LoadInt32(&leading) == 1
+}
+
+// WithLeadership returns a context which is cancelled when this replica stops leading, so a bind
+// started by the leader is given up once another replica may allocate the same devices.
+// Without the leader election it's only cancelled with ctx.
+func WithLeadership(ctx context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(ctx)
+	if !Enabled() {
+		return ctx, cancel
+	}
+	termLock.RLock()
+	t := term
+	termLock.RUnlock()
+	if t == nil || !IsLeader() {
+		cancel()
+		return ctx, cancel
+	}
+	go func() {
+		select {
+		case <-t.Done():
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
+
+// Enabled checks if the leader election runs
+func Enabled() bool {
+	return atomic.LoadInt32(&enabled) == 1
+}
+
+// Identity is the identity of this replica in the Lease
+func Identity() string {
+	return identity
+}
+
+// Holder is the identity of the current leader, empty if it's unknown
+func Holder() string {
+	h, _ := holder.Load().(string)
+	return h
+}
+
+// Run campaigns for the Lease until stopCh is closed, and campaigns again after the Lease is lost.
+// prepare runs after the Lease is acquired and before this replica serves as the leader, it's retried
+// until it succeeds. The replicas which are not the leader keep their caches warm.
+func Run(clientset kubernetes.Interface, config Config, prepare func(ctx context.Context) error, stopCh <-chan struct{}) error {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return err
+	}
+	identity = hostname + "_" + rand.String(8)
+	lock, err := resourcelock.New(resourcelock.LeasesResourceLock,
+		config.Namespace,
+		config.Name,
+		clientset.CoreV1(),
+		clientset.CoordinationV1(),
+		resourcelock.ResourceLockConfig{Identity: identity})
+	if err != nil {
+		return err
+	}
+
+	elector, err := leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
+		Lock:            lock,
+		Name:            config.Name,
+		LeaseDuration:   config.LeaseDuration,
+		RenewDeadline:   config.RenewDeadline,
+		RetryPeriod:     config.RetryPeriod,
+		ReleaseOnCancel: true,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(ctx context.Context) {
+				for {
+					err := prepare(ctx)
+					if err == nil {
+						break
+					}
+					log.V(3).Error("failed to prepare for leading, retry", log.Err(err))
+					select {
+					case <-ctx.Done():
+						return
+					case <-time.After(config.RetryPeriod):
+					}
+				}
+				if ctx.Err() != nil {
+					// the Lease is lost while preparing
+					return
+				}
+				termLock.Lock()
+				term = ctx
+				termLock.Unlock()
+				atomic.StoreInt32(&leading, 1)
+				log.V(3).Info("started leading", log.String("identity", identity))
+				for ctx.Err() == nil {
+					err := labelLeaderPod(ctx, clientset, config)
+					if err == nil {
+						break
+					}
+					log.V(3).Error("failed to label the pod of the leader, retry", log.Err(err))
+					select {
+					case <-ctx.Done():
+					case <-time.After(config.RetryPeriod):
+					}
+				}
+			},
+			OnStoppedLeading: func() {
+				atomic.StoreInt32(&leading, 0)
+				log.V(3).Info("stopped leading", log.String("identity", identity))
+				ctx, cancel := context.WithTimeout(context.Background(), config.RenewDeadline)
+				defer cancel()
+				if err := unlabelPod(ctx, clientset, config.PodNamespace, config.PodName); err != nil {
+					// the next leader removes the label
+					log.V(3).Warn("failed to unlabel the pod of the former leader", log.Err(err))
+				}
+			},
+			OnNewLeader: func(id string) {
+				holder.Store(id)
+				log.V(3).Info("new leader elected", log.String("leader", id))
+			},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("invalid leader election config: %v", err)
+	}
+	atomic.StoreInt32(&enabled, 1)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-stopCh
+		cancel()
+	}()
+	for ctx.Err() == nil {
+		elector.Run(ctx)
+	}
+	return nil
+}
+
+// labelLeaderPod moves the label to the pod of this replica from the pods of the former leaders
+func labelLeaderPod(ctx context.Context, clientset kubernetes.Interface, config Config) error {
+	if len(config.PodName) == 0 {
+		return nil
+	}
+	pods, err := clientset.CoreV1().Pods(config.PodNamespace).List(ctx, metav1.ListOptions{LabelSelector: Label + "=true"})
+	if err != nil {
+		return err
+	}
+	for _, pod := range pods.Items {
+		if pod.Name == config.PodName {
+			continue
+		}
+		if err := unlabelPod(ctx, clientset, pod.Namespace, pod.Name); err != nil {
+			return err
+		}
+	}
+
+	patch := fmt.Sprintf(`{"metadata":{"labels":{%q:"true"}}}`, Label)
+	_, err = clientset.CoreV1().Pods(config.PodNamespace).Patch(ctx, config.PodName, types.MergePatchType, []byte(patch), metav1.PatchOptions{})
+	return err
+}
+
+func unlabelPod(ctx context.Context, clientset kubernetes.Interface, namespace, name string) error {
+	if len(name) == 0 {
+		return nil
+	}
+	patch := fmt.Sprintf(`{"metadata":{"labels":{%q:null}}}`, Label)
+	_, err := clientset.CoreV1().Pods(namespace).Patch(ctx, name, types.MergePatchType, []byte(patch), metav1.PatchOptions{})
+	if errors.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
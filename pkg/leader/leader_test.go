@@ -0,0 +1,178 @@
+package leader
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	coordinationv1 "k8s.io/api/coordination/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/AliyunContainerService/gpushare-scheduler-extender/pkg/log"
+)
+
+func init() {
+	log.NewLoggerWithLevel(0)
+}
+
+func newPod(name string, leader bool) *v1.Pod {
+	pod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "kube-system", Labels: map[string]string{"app": "gpushare"}}}
+	if leader {
+		pod.Labels[Label] = "true"
+	}
+	return pod
+}
+
+// leaders returns the names of the labeled pods
+func leaders(t *testing.T, clientset kubernetes.Interface) []string {
+	pods, err := clientset.CoreV1().Pods("kube-system").List(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := []string{}
+	for _, pod := range pods.Items {
+		if pod.Labels[Label] == "true" {
+			names = append(names, pod.Name)
+		}
+		if pod.Labels["app"] != "gpushare" {
+			t.Errorf("expected the other labels of %s kept, got %v", pod.Name, pod.Labels)
+		}
+	}
+	return names
+}
+
+func TestLabelLeaderPod(t *testing.T) {
+	tests := []struct {
+		name        string
+		pods        []*v1.Pod
+		podName     string
+		wantLeaders string
+	}{
+		{name: "label this replica", pods: []*v1.Pod{newPod("a", false), newPod("b", false)}, podName: "a", wantLeaders: "a"},
+		{name: "move the label from the former leader", pods: []*v1.Pod{newPod("a", false), newPod("b", true)}, podName: "a", wantLeaders: "a"},
+		{name: "already labeled", pods: []*v1.Pod{newPod("a", true), newPod("b", false)}, podName: "a", wantLeaders: "a"},
+		{name: "no pod name", pods: []*v1.Pod{newPod("a", false), newPod("b", true)}, wantLeaders: "b"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			clientset := fake.NewSimpleClientset()
+			for _, pod := range test.pods {
+				if _, err := clientset.CoreV1().Pods(pod.Namespace).Create(context.Background(), pod, metav1.CreateOptions{}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := labelLeaderPod(context.Background(), clientset, Config{PodName: test.podName, PodNamespace: "kube-system"}); err != nil {
+				t.Fatal(err)
+			}
+			if got := leaders(t, clientset); len(got) != 1 || got[0] != test.wantLeaders {
+				t.Errorf("expected the leader pod %s, got %v", test.wantLeaders, got)
+			}
+		})
+	}
+}
+
+func TestUnlabelPod(t *testing.T) {
+	clientset := fake.NewSimpleClientset(newPod("a", true))
+	if err := unlabelPod(context.Background(), clientset, "kube-system", "a"); err != nil {
+		t.Fatal(err)
+	}
+	if got := leaders(t, clientset); len(got) != 0 {
+		t.Errorf("expected no leader pod, got %v", got)
+	}
+	// the pod of the former leader may be deleted already
+	if err := unlabelPod(context.Background(), clientset, "kube-system", "deleted"); err != nil {
+		t.Errorf("expected no error for a deleted pod, got %v", err)
+	}
+}
+
+func TestRunTransitions(t *testing.T) {
+	defer atomic.StoreInt32(&enabled, 0)
+	if !IsLeader() || Enabled() {
+		t.Fatalf("expected every replica to be the leader without the leader election")
+	}
+
+	clientset := fake.NewSimpleClientset(newPod("a", false), newPod("b", true))
+	config := Config{
+		Namespace:     "kube-system",
+		Name:          "gpushare-schd-extender",
+		LeaseDuration: 2 * time.Second,
+		RenewDeadline: time.Second,
+		RetryPeriod:   100 * time.Millisecond,
+		PodName:       "a",
+		PodNamespace:  "kube-system",
+	}
+	var prepared int32
+	prepare := func(ctx context.Context) error {
+		// the first attempt fails, the leader serves only after a successful one
+		if atomic.AddInt32(&prepared, 1) == 1 {
+			return context.DeadlineExceeded
+		}
+		return nil
+	}
+	stopCh := make(chan struct{})
+	done := make(chan error)
+	go func() {
+		done <- Run(clientset, config, prepare, stopCh)
+	}()
+
+	// acquire
+	waitFor(t, "the lease is acquired", func() bool { return IsLeader() && len(leaders(t, clientset)) == 1 && leaders(t, clientset)[0] == "a" })
+	if n := atomic.LoadInt32(&prepared); n != 2 {
+		t.Errorf("expected 2 attempts to prepare, got %d", n)
+	}
+	if Holder() != Identity() {
+		t.Errorf("expected the holder %s, got %s", Identity(), Holder())
+	}
+	ctx, cancel := WithLeadership(context.Background())
+	defer cancel()
+	if ctx.Err() != nil {
+		t.Fatalf("expected the context of the leader not cancelled")
+	}
+
+	// lose, another replica takes over the lease
+	lease, err := clientset.CoordinationV1().Leases(config.Namespace).Get(context.Background(), config.Name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	other := "other"
+	now := metav1.NewMicroTime(time.Now())
+	lease.Spec = coordinationv1.LeaseSpec{HolderIdentity: &other, RenewTime: &now, AcquireTime: &now, LeaseDurationSeconds: lease.Spec.LeaseDurationSeconds}
+	if _, err := clientset.CoordinationV1().Leases(config.Namespace).Update(context.Background(), lease, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "the lease is lost", func() bool { return !IsLeader() && len(leaders(t, clientset)) == 0 })
+	select {
+	case <-ctx.Done():
+	case <-time.After(time.Second):
+		t.Errorf("expected the context of the former leader cancelled")
+	}
+	if ctx, cancel := WithLeadership(context.Background()); ctx.Err() == nil {
+		t.Errorf("expected the context of a follower cancelled")
+		cancel()
+	}
+
+	close(stopCh)
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("expected no error, got %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatalf("expected Run to return after stopCh is closed")
+	}
+}
+
+func waitFor(t *testing.T, what string, condition func() bool) {
+	err := wait.PollImmediate(50*time.Millisecond, 10*time.Second, func() (bool, error) {
+		return condition(), nil
+	})
+	if err != nil {
+		t.Fatalf("expected %s: %v", what, err)
+	}
+}
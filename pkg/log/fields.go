@@ -0,0 +1,78 @@
+package log
+
+import (
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Field is a key/value pair attached to a log entry
+type Field = zap.Field
+
+// The keys of the fields shared by the call sites, the log pipeline filters on them
+const (
+	KeyPod       = "pod"
+	KeyNamespace = "namespace"
+	KeyNode      = "node"
+	KeyDevID     = "devID"
+	KeyReqMem    = "reqMem"
+)
+
+// Pod is the name of the pod
+func Pod(name string) Field {
+	return zap.String(KeyPod, name)
+}
+
+// Namespace is the namespace of the pod
+func Namespace(namespace string) Field {
+	return zap.String(KeyNamespace, namespace)
+}
+
+// Node is the name of the node
+func Node(name string) Field {
+	return zap.String(KeyNode, name)
+}
+
+// DevID is the index of the GPU device
+func DevID(id int) Field {
+	return zap.Int(KeyDevID, id)
+}
+
+// ReqMem is the requested GPU memory
+func ReqMem(mem uint) Field {
+	return zap.Uint(KeyReqMem, mem)
+}
+
+// Err is the error
+func Err(err error) Field {
+	return zap.Error(err)
+}
+
+func String(key string, value string) Field {
+	return zap.String(key, value)
+}
+
+func Strings(key string, values []string) Field {
+	return zap.Strings(key, values)
+}
+
+func Int(key string, value int) Field {
+	return zap.Int(key, value)
+}
+
+func Uint(key string, value uint) Field {
+	return zap.Uint(key, value)
+}
+
+func Bool(key string, value bool) Field {
+	return zap.Bool(key, value)
+}
+
+func Duration(key string, value time.Duration) Field {
+	return zap.Duration(key, value)
+}
+
+// Any is the value encoded by its type, e.g. a struct as JSON
+func Any(key string, value interface{}) Field {
+	return zap.Any(key, value)
+}
@@ -3,30 +3,70 @@ package log
 import (
 	"fmt"
 	"os"
+	"strconv"
 	"sync"
+	"sync/atomic"
 
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 )
 
+// The named levels, which are set by LOG_LEVEL
+const (
+	LevelDebug int32 = 101
+	LevelInfo  int32 = 50
+	LevelWarn  int32 = 10
+	LevelError int32 = 5
+)
+
+// The output formats of the logger
+const (
+	FormatJSON    = "json"
+	FormatConsole = "console"
+)
+
 type levelLogger struct {
 	level *int32
 	mu    sync.Mutex
 	log   *zap.Logger
 }
 
-type verbose bool
+// verbose decides if the entry is logged, a disabled entry is still logged when it matches a trace
+type verbose struct {
+	level   int32
+	enabled bool
+}
 
 var l *levelLogger
 
+// NewLoggerWithLevel creates the global logger which writes JSON to stdout
 func NewLoggerWithLevel(level int32, option ...zap.Option) {
+	if err := NewLogger(level, FormatJSON, option...); err != nil {
+		panic(err)
+	}
+}
+
+// NewLogger creates the global logger which writes to stdout in the format, json or console
+func NewLogger(level int32, format string, option ...zap.Option) error {
 	cfg := zap.NewProductionEncoderConfig()
 	cfg.EncodeTime = zapcore.ISO8601TimeEncoder
 
+	var encoder zapcore.Encoder
+	switch format {
+	case "", FormatJSON:
+		encoder = zapcore.NewJSONEncoder(cfg)
+	case FormatConsole:
+		cfg.EncodeLevel = zapcore.CapitalLevelEncoder
+		encoder = zapcore.NewConsoleEncoder(cfg)
+	default:
+		return fmt.Errorf("unknown log format %q, it should be %s or %s", format, FormatJSON, FormatConsole)
+	}
+
+	// The verbosity is filtered by V, so the core accepts all the severities
 	core := zapcore.NewCore(
-		zapcore.NewJSONEncoder(cfg),
+		encoder,
 		zapcore.Lock(os.Stdout),
-		zap.NewAtomicLevel(),
+		zap.NewAtomicLevelAt(zapcore.DebugLevel),
 	)
 
 	if option == nil {
@@ -38,6 +78,7 @@ func NewLoggerWithLevel(level int32, option ...zap.Option) {
 		mu:    sync.Mutex{},
 		log:   zap.New(core, option...),
 	}
+	return nil
 }
 
 /*
@@ -55,16 +96,71 @@ example level:
 	db result 15
 */
 func V(level int32) verbose {
-	return level < *l.level
+	return verbose{level: level, enabled: level < GetLevel()}
+}
+
+// GetLevel returns the global log level
+func GetLevel() int32 {
+	return atomic.LoadInt32(l.level)
+}
+
+// SetLevel changes the global log level at runtime
+func SetLevel(level int32) {
+	atomic.StoreInt32(l.level, level)
 }
 
-func (v verbose) Info(format string, args ...interface{}) {
-	if v {
-		l.log.Info(fmt.Sprintf(format, args...))
+// ParseLevel parses the level name debug, info, warn or error, or a number
+func ParseLevel(s string) (int32, error) {
+	switch s {
+	case "debug":
+		return LevelDebug, nil
+	case "info":
+		return LevelInfo, nil
+	case "warn":
+		return LevelWarn, nil
+	case "error":
+		return LevelError, nil
+	}
+	level, err := strconv.ParseInt(s, 10, 32)
+	if err != nil || level < 0 {
+		return 0, fmt.Errorf("invalid log level %q, it should be debug, info, warn, error or a non-negative number", s)
+	}
+	return int32(level), nil
+}
+
+func (v verbose) on(fields []Field) bool {
+	return v.enabled || traced(v.level, fields)
+}
+
+// Debug logs the message with the fields at debug severity
+func (v verbose) Debug(msg string, fields ...Field) {
+	if v.on(fields) {
+		l.log.Debug(msg, fields...)
+	}
+}
+
+// Info logs the message with the fields at info severity
+func (v verbose) Info(msg string, fields ...Field) {
+	if v.on(fields) {
+		l.log.Info(msg, fields...)
 	}
 }
 
-func Fatal(format string, args ...interface{}) {
-	l.log.Fatal(fmt.Sprintf(format, args...))
+// Warn logs the message with the fields at warn severity
+func (v verbose) Warn(msg string, fields ...Field) {
+	if v.on(fields) {
+		l.log.Warn(msg, fields...)
+	}
+}
+
+// Error logs the message with the fields at error severity
+func (v verbose) Error(msg string, fields ...Field) {
+	if v.on(fields) {
+		l.log.Error(msg, fields...)
+	}
+}
 
+// Fatal logs the message with the fields, then exits
+func Fatal(msg string, fields ...Field) {
+	l.log.Fatal(msg, fields...)
 }
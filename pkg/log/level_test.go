@@ -0,0 +1,147 @@
+package log
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+// observe replaces the output of the logger with the level by an observer of the entries
+func observe(t *testing.T, level int32) *observer.ObservedLogs {
+	core, logs := observer.New(zapcore.DebugLevel)
+	if err := NewLogger(level, FormatJSON, zap.WrapCore(func(zapcore.Core) zapcore.Core { return core })); err != nil {
+		t.Fatal(err)
+	}
+	return logs
+}
+
+func TestNewLogger(t *testing.T) {
+	tests := []struct {
+		format    string
+		wantError bool
+	}{
+		{format: ""},
+		{format: FormatJSON},
+		{format: FormatConsole},
+		{format: "text", wantError: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.format, func(t *testing.T) {
+			err := NewLogger(3, test.format)
+			if (err != nil) != test.wantError {
+				t.Errorf("expected error %v, got %v", test.wantError, err)
+			}
+		})
+	}
+}
+
+func TestV(t *testing.T) {
+	tests := []struct {
+		name  string
+		level int32
+		v     int32
+		want  bool
+	}{
+		{name: "lower verbosity is logged", level: 3, v: 2, want: true},
+		{name: "same verbosity is not logged", level: 3, v: 3},
+		{name: "higher verbosity is not logged", level: 3, v: 10},
+		{name: "debug level", level: 11, v: 10, want: true},
+		{name: "level 0 logs nothing", level: 0, v: 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			logs := observe(t, test.level)
+			if v := V(test.v); v.enabled != test.want {
+				t.Errorf("expected V(%d) %v at level %d, got %v", test.v, test.want, test.level, v.enabled)
+			}
+			V(test.v).Debug("debug")
+			V(test.v).Info("info")
+			V(test.v).Warn("warn")
+			V(test.v).Error("error")
+			want := 0
+			if test.want {
+				want = 4
+			}
+			if logs.Len() != want {
+				t.Errorf("expected %d entries, got %d", want, logs.Len())
+			}
+		})
+	}
+}
+
+func TestSeverityAndFields(t *testing.T) {
+	logs := observe(t, 3)
+	V(0).Debug("debug")
+	V(0).Info("info")
+	V(0).Warn("warn", Pod("p1"), Namespace("default"), Node("n1"), DevID(1), ReqMem(4), Err(errors.New("failed")))
+
+	entries := logs.AllUntimed()
+	levels := []zapcore.Level{}
+	for _, entry := range entries {
+		levels = append(levels, entry.Level)
+	}
+	if want := []zapcore.Level{zapcore.DebugLevel, zapcore.InfoLevel, zapcore.WarnLevel}; !reflect.DeepEqual(levels, want) {
+		t.Fatalf("expected the severities %v, got %v", want, levels)
+	}
+
+	want := map[string]interface{}{
+		KeyPod:       "p1",
+		KeyNamespace: "default",
+		KeyNode:      "n1",
+		KeyDevID:     int64(1),
+		KeyReqMem:    uint64(4),
+		"error":      "failed",
+	}
+	if fields := entries[2].ContextMap(); !reflect.DeepEqual(fields, want) {
+		t.Errorf("expected the fields %v, got %v", want, fields)
+	}
+}
+
+func TestParseLevel(t *testing.T) {
+	tests := []struct {
+		level     string
+		want      int32
+		wantError bool
+	}{
+		{level: "debug", want: LevelDebug},
+		{level: "info", want: LevelInfo},
+		{level: "warn", want: LevelWarn},
+		{level: "error", want: LevelError},
+		{level: "0", want: 0},
+		{level: "42", want: 42},
+		{level: "-1", wantError: true},
+		{level: "verbose", wantError: true},
+		{level: "", wantError: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.level, func(t *testing.T) {
+			level, err := ParseLevel(test.level)
+			if (err != nil) != test.wantError {
+				t.Fatalf("expected error %v, got %v", test.wantError, err)
+			}
+			if level != test.want {
+				t.Errorf("expected level %d, got %d", test.want, level)
+			}
+		})
+	}
+}
+
+func TestSetLevel(t *testing.T) {
+	logs := observe(t, 3)
+	V(5).Info("hidden")
+	SetLevel(10)
+	if level := GetLevel(); level != 10 {
+		t.Errorf("expected level 10, got %d", level)
+	}
+	V(5).Info("shown")
+	if logs.Len() != 1 || logs.All()[0].Message != "shown" {
+		t.Errorf("expected only the entry after the level change, got %v", logs.All())
+	}
+}
@@ -0,0 +1,97 @@
+package log
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// The subsystems whose level can be changed separately from the global level
+const (
+	SubsystemCache      = "cache"
+	SubsystemController = "controller"
+	SubsystemPredicate  = "predicate"
+	SubsystemBind       = "bind"
+	SubsystemRoutes     = "routes"
+	SubsystemDefrag     = "defrag"
+	SubsystemPlugin     = "plugin"
+	SubsystemNodeShare  = "nodeshare"
+)
+
+// inheritLevel means the subsystem follows the global level
+const inheritLevel int32 = -1
+
+// Logger logs for a subsystem, it uses the global level unless the level of the subsystem is set
+type Logger struct {
+	name  string
+	level int32
+}
+
+var (
+	subsystemsLock sync.RWMutex
+	subsystems     = map[string]*Logger{}
+)
+
+// Named returns the logger of the subsystem, the loggers of the same name are shared
+func Named(name string) *Logger {
+	subsystemsLock.Lock()
+	defer subsystemsLock.Unlock()
+	if lg, found := subsystems[name]; found {
+		return lg
+	}
+	lg := &Logger{name: name, level: inheritLevel}
+	subsystems[name] = lg
+	return lg
+}
+
+// V is the same as the global V but with the level of the subsystem
+func (lg *Logger) V(level int32) verbose {
+	current := atomic.LoadInt32(&lg.level)
+	if current == inheritLevel {
+		current = GetLevel()
+	}
+	return verbose{level: level, enabled: level < current}
+}
+
+// SetSubsystemLevel sets the level of the subsystem, a negative level makes it follow the global level again
+func SetSubsystemLevel(name string, level int32) error {
+	subsystemsLock.RLock()
+	lg, found := subsystems[name]
+	subsystemsLock.RUnlock()
+	if !found {
+		return fmt.Errorf("unknown subsystem %q, it should be one of %v", name, subsystemNames())
+	}
+	if level < 0 {
+		level = inheritLevel
+	}
+	atomic.StoreInt32(&lg.level, level)
+	return nil
+}
+
+// GetSubsystemLevels returns the levels of the subsystems, nil means the global level is used
+func GetSubsystemLevels() map[string]*int32 {
+	subsystemsLock.RLock()
+	defer subsystemsLock.RUnlock()
+	levels := map[string]*int32{}
+	for name, lg := range subsystems {
+		level := atomic.LoadInt32(&lg.level)
+		if level == inheritLevel {
+			levels[name] = nil
+			continue
+		}
+		levels[name] = &level
+	}
+	return levels
+}
+
+func subsystemNames() []string {
+	subsystemsLock.RLock()
+	defer subsystemsLock.RUnlock()
+	names := make([]string, 0, len(subsystems))
+	for name := range subsystems {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
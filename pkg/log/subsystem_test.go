@@ -0,0 +1,56 @@
+package log
+
+import (
+	"testing"
+)
+
+func TestSubsystemLevel(t *testing.T) {
+	observe(t, 3)
+	lg := Named("test")
+	if Named("test") != lg {
+		t.Fatal("expected the loggers of the same name to be shared")
+	}
+
+	steps := []struct {
+		name string
+		// set is the level of the subsystem, or the global level if global is true
+		set        int32
+		global     bool
+		wantError  bool
+		wantLevel  *int32
+		wantV5     bool
+		wantGlobV5 bool
+	}{
+		{name: "subsystem follows the global level", set: -1},
+		{name: "subsystem level overrides the global level", set: 10, wantLevel: int32Ptr(10), wantV5: true},
+		{name: "global level doesn't change the subsystem", set: 4, global: true, wantLevel: int32Ptr(10), wantV5: true},
+		{name: "lower subsystem level", set: 2, wantLevel: int32Ptr(2)},
+		{name: "global level is followed again", set: -1},
+		{name: "raised global level", set: 6, global: true, wantV5: true, wantGlobV5: true},
+	}
+	for _, step := range steps {
+		if step.global {
+			SetLevel(step.set)
+		} else if err := SetSubsystemLevel("test", step.set); err != nil {
+			t.Fatalf("%s: %v", step.name, err)
+		}
+		level := GetSubsystemLevels()["test"]
+		if (level == nil) != (step.wantLevel == nil) || (level != nil && *level != *step.wantLevel) {
+			t.Errorf("%s: expected the subsystem level %v, got %v", step.name, step.wantLevel, level)
+		}
+		if enabled := lg.V(5).enabled; enabled != step.wantV5 {
+			t.Errorf("%s: expected V(5) of the subsystem %v, got %v", step.name, step.wantV5, enabled)
+		}
+		if enabled := V(5).enabled; enabled != step.wantGlobV5 {
+			t.Errorf("%s: expected the global V(5) %v, got %v", step.name, step.wantGlobV5, enabled)
+		}
+	}
+
+	if err := SetSubsystemLevel("unknown", 10); err == nil {
+		t.Error("expected an error for the unknown subsystem")
+	}
+}
+
+func int32Ptr(i int32) *int32 {
+	return &i
+}
@@ -0,0 +1,140 @@
+package log
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap/zapcore"
+)
+
+// Trace raises the level of the entries about a node or a pod until it expires,
+// so a single scheduling decision can be debugged without the noise of the others.
+type Trace struct {
+	ID int64 `json:"id"`
+	// Node matches the entries with the node field
+	Node string `json:"node,omitempty"`
+	// Pod matches the entries with the pod field, namespace/name also matches the namespace field
+	Pod     string    `json:"pod,omitempty"`
+	Level   int32     `json:"level"`
+	Expires time.Time `json:"expires"`
+}
+
+var (
+	tracesLock sync.RWMutex
+	traces     = []*Trace{}
+	// tracesCount avoids the lock on every disabled entry when there is no trace
+	tracesCount int32
+	lastTraceID int64
+)
+
+// AddTrace logs the entries of the node or the pod up to the level for the duration
+func AddTrace(node, pod string, level int32, duration time.Duration) (*Trace, error) {
+	if len(node) == 0 && len(pod) == 0 {
+		return nil, fmt.Errorf("the trace needs a node or a pod")
+	}
+	if duration <= 0 {
+		return nil, fmt.Errorf("the duration of the trace should be positive, got %v", duration)
+	}
+
+	tracesLock.Lock()
+	defer tracesLock.Unlock()
+	removeExpiredTraces(time.Now())
+	t := &Trace{
+		ID:      atomic.AddInt64(&lastTraceID, 1),
+		Node:    node,
+		Pod:     pod,
+		Level:   level,
+		Expires: time.Now().Add(duration),
+	}
+	traces = append(traces, t)
+	atomic.StoreInt32(&tracesCount, int32(len(traces)))
+	return t, nil
+}
+
+// RemoveTrace stops the trace before it expires
+func RemoveTrace(id int64) bool {
+	tracesLock.Lock()
+	defer tracesLock.Unlock()
+	for i, t := range traces {
+		if t.ID == id {
+			traces = append(traces[:i], traces[i+1:]...)
+			atomic.StoreInt32(&tracesCount, int32(len(traces)))
+			return true
+		}
+	}
+	return false
+}
+
+// GetTraces returns the traces which are not expired
+func GetTraces() []Trace {
+	tracesLock.Lock()
+	defer tracesLock.Unlock()
+	removeExpiredTraces(time.Now())
+	result := make([]Trace, 0, len(traces))
+	for _, t := range traces {
+		result = append(result, *t)
+	}
+	return result
+}
+
+func removeExpiredTraces(now time.Time) {
+	active := traces[:0]
+	for _, t := range traces {
+		if now.Before(t.Expires) {
+			active = append(active, t)
+		}
+	}
+	traces = active
+	atomic.StoreInt32(&tracesCount, int32(len(traces)))
+}
+
+// traced checks if a trace of the node or the pod in the fields allows the level
+func traced(level int32, fields []Field) bool {
+	if atomic.LoadInt32(&tracesCount) == 0 {
+		return false
+	}
+
+	var node, pod, namespace string
+	for _, f := range fields {
+		if f.Type != zapcore.StringType {
+			continue
+		}
+		switch f.Key {
+		case KeyNode:
+			node = f.String
+		case KeyPod:
+			pod = f.String
+		case KeyNamespace:
+			namespace = f.String
+		}
+	}
+	if len(node) == 0 && len(pod) == 0 {
+		return false
+	}
+
+	now := time.Now()
+	tracesLock.RLock()
+	defer tracesLock.RUnlock()
+	for _, t := range traces {
+		if level >= t.Level || now.After(t.Expires) {
+			continue
+		}
+		if len(t.Node) > 0 && t.Node == node {
+			return true
+		}
+		if len(t.Pod) > 0 && len(pod) > 0 && t.matchPod(pod, namespace) {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *Trace) matchPod(pod, namespace string) bool {
+	if i := strings.Index(t.Pod, "/"); i >= 0 {
+		return t.Pod[:i] == namespace && t.Pod[i+1:] == pod
+	}
+	return t.Pod == pod
+}
@@ -0,0 +1,123 @@
+package log
+
+import (
+	"testing"
+	"time"
+)
+
+// resetTraces removes the traces of the test when it ends
+func resetTraces(t *testing.T) {
+	t.Cleanup(func() {
+		for _, trace := range GetTraces() {
+			RemoveTrace(trace.ID)
+		}
+	})
+}
+
+func TestAddTrace(t *testing.T) {
+	resetTraces(t)
+	tests := []struct {
+		name      string
+		node      string
+		pod       string
+		duration  time.Duration
+		wantError bool
+	}{
+		{name: "node", node: "n1", duration: time.Minute},
+		{name: "pod", pod: "default/p1", duration: time.Minute},
+		{name: "neither node nor pod", duration: time.Minute, wantError: true},
+		{name: "no duration", node: "n1", wantError: true},
+		{name: "negative duration", node: "n1", duration: -time.Minute, wantError: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			trace, err := AddTrace(test.node, test.pod, LevelDebug, test.duration)
+			if (err != nil) != test.wantError {
+				t.Fatalf("expected error %v, got %v", test.wantError, err)
+			}
+			if err == nil && (trace.Node != test.node || trace.Pod != test.pod) {
+				t.Errorf("expected the trace of node %q and pod %q, got %+v", test.node, test.pod, trace)
+			}
+		})
+	}
+}
+
+func TestTraced(t *testing.T) {
+	tests := []struct {
+		name   string
+		node   string
+		pod    string
+		level  int32
+		fields []Field
+		want   bool
+	}{
+		{name: "node matches", node: "n1", level: LevelDebug, fields: []Field{Node("n1")}, want: true},
+		{name: "other node", node: "n1", level: LevelDebug, fields: []Field{Node("n2")}},
+		{name: "pod matches", pod: "p1", level: LevelDebug, fields: []Field{Pod("p1")}, want: true},
+		{name: "pod of the namespace matches", pod: "default/p1", level: LevelDebug, fields: []Field{Pod("p1"), Namespace("default")}, want: true},
+		{name: "pod of another namespace", pod: "default/p1", level: LevelDebug, fields: []Field{Pod("p1"), Namespace("kube-system")}},
+		{name: "pod without namespace field", pod: "default/p1", level: LevelDebug, fields: []Field{Pod("p1")}},
+		{name: "level above the trace", node: "n1", level: 5, fields: []Field{Node("n1")}},
+		{name: "entry without node or pod", node: "n1", level: LevelDebug, fields: []Field{String("configmap", "n1")}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resetTraces(t)
+			if _, err := AddTrace(test.node, test.pod, test.level, time.Minute); err != nil {
+				t.Fatal(err)
+			}
+			if traced := traced(10, test.fields); traced != test.want {
+				t.Errorf("expected traced %v, got %v", test.want, traced)
+			}
+		})
+	}
+}
+
+func TestTraceLogsDisabledEntries(t *testing.T) {
+	resetTraces(t)
+	logs := observe(t, 3)
+	trace, err := AddTrace("n1", "", LevelDebug, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	V(10).Debug("traced", Node("n1"))
+	V(10).Debug("not traced", Node("n2"))
+	if logs.Len() != 1 || logs.All()[0].Message != "traced" {
+		t.Errorf("expected only the entry of the traced node, got %v", logs.All())
+	}
+
+	if !RemoveTrace(trace.ID) {
+		t.Fatalf("expected the trace %d to be removed", trace.ID)
+	}
+	if RemoveTrace(trace.ID) {
+		t.Errorf("expected the trace %d to be removed only once", trace.ID)
+	}
+	V(10).Debug("traced", Node("n1"))
+	if logs.Len() != 1 {
+		t.Errorf("expected no entry after the trace is removed, got %d entries", logs.Len())
+	}
+}
+
+func TestTraceExpires(t *testing.T) {
+	resetTraces(t)
+	trace, err := AddTrace("n1", "", LevelDebug, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !traced(10, []Field{Node("n1")}) {
+		t.Fatal("expected the node to be traced")
+	}
+
+	tracesLock.Lock()
+	traces[0].Expires = time.Now().Add(-time.Second)
+	tracesLock.Unlock()
+	if traced(10, []Field{Node("n1")}) {
+		t.Error("expected the expired trace not to match")
+	}
+	if active := GetTraces(); len(active) != 0 {
+		t.Errorf("expected the expired trace %d to be removed, got %v", trace.ID, active)
+	}
+}
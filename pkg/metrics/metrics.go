@@ -0,0 +1,103 @@
+package metrics
+
+import (
+	"errors"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+)
+
+const namespace = "gpushare"
+
+var (
+	// Registry holds all the metrics of the extender, which are served on /metrics
+	Registry = prometheus.NewRegistry()
+
+	HTTPRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Latency of the extender HTTP requests by path and status code.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"path", "code"})
+
+	FilterDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "filter_duration_seconds",
+		Help:      "Latency of filtering the nodes for a pod.",
+		Buckets:   prometheus.ExponentialBuckets(0.0005, 2, 15),
+	})
+
+	BindDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "bind_duration_seconds",
+		Help:      "Latency of allocating the GPU and binding a pod.",
+		Buckets:   prometheus.ExponentialBuckets(0.001, 2, 15),
+	})
+
+	BindFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "bind_failures_total",
+		Help:      "Number of failed binds by reason.",
+	}, []string{"reason"})
+
+	ConflictRetries = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "bind_conflict_retries_total",
+		Help:      "Number of pod patches retried after an optimistic lock conflict.",
+	})
+)
+
+// The reasons of the bind failures
+const (
+	ReasonGetPod   = "get_pod"
+	ReasonGetNode  = "get_node"
+	ReasonNoDevice = "no_device"
+	ReasonPatch    = "patch"
+	ReasonBind     = "bind"
+	// ReasonNotLeader is the bind given up after the lease is lost
+	ReasonNotLeader = "not_leader"
+	ReasonUnknown   = "unknown"
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		HTTPRequestDuration,
+		FilterDuration,
+		BindDuration,
+		BindFailures,
+		ConflictRetries,
+	)
+}
+
+// ReasonError is an error with the reason which is counted in the metrics
+type ReasonError struct {
+	Reason string
+	Err    error
+}
+
+func (e *ReasonError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *ReasonError) Unwrap() error {
+	return e.Err
+}
+
+// WithReason attaches the reason to the error, nil stays nil
+func WithReason(reason string, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &ReasonError{Reason: reason, Err: err}
+}
+
+// ReasonOf returns the reason attached to the error
+func ReasonOf(err error) string {
+	var reasonErr *ReasonError
+	if errors.As(err, &reasonErr) {
+		return reasonErr.Reason
+	}
+	return ReasonUnknown
+}
@@ -0,0 +1,55 @@
+package metrics
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"k8s.io/client-go/util/workqueue"
+)
+
+func TestReasonOf(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{name: "error with a reason", err: WithReason(ReasonPatch, fmt.Errorf("conflict")), want: ReasonPatch},
+		{name: "wrapped error with a reason", err: fmt.Errorf("failed to bind: %w", WithReason(ReasonBind, fmt.Errorf("timeout"))), want: ReasonBind},
+		{name: "error without a reason", err: fmt.Errorf("unknown"), want: ReasonUnknown},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if reason := ReasonOf(test.err); reason != test.want {
+				t.Errorf("expected reason %q, got %q", test.want, reason)
+			}
+		})
+	}
+
+	if err := WithReason(ReasonBind, nil); err != nil {
+		t.Errorf("expected nil stays nil, got %v", err)
+	}
+}
+
+func TestWorkqueueMetrics(t *testing.T) {
+	queue := workqueue.NewNamed("test")
+	defer queue.ShutDown()
+	queue.Add("a")
+	queue.Add("b")
+	item, _ := queue.Get()
+	queue.Done(item)
+
+	want := `
+# HELP workqueue_adds_total Total number of adds handled by the workqueue.
+# TYPE workqueue_adds_total counter
+workqueue_adds_total{name="test"} 2
+# HELP workqueue_depth Current depth of the workqueue.
+# TYPE workqueue_depth gauge
+workqueue_depth{name="test"} 1
+`
+	if err := testutil.GatherAndCompare(Registry, strings.NewReader(want), "workqueue_adds_total", "workqueue_depth"); err != nil {
+		t.Error(err)
+	}
+}
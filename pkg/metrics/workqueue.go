@@ -0,0 +1,97 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/client-go/util/workqueue"
+)
+
+// The workqueue metrics follow the names of the kubernetes components
+var (
+	workqueueDepth = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Subsystem: "workqueue",
+		Name:      "depth",
+		Help:      "Current depth of the workqueue.",
+	}, []string{"name"})
+
+	workqueueAdds = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Subsystem: "workqueue",
+		Name:      "adds_total",
+		Help:      "Total number of adds handled by the workqueue.",
+	}, []string{"name"})
+
+	workqueueLatency = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Subsystem: "workqueue",
+		Name:      "queue_duration_seconds",
+		Help:      "How long in seconds an item stays in the workqueue before being requested.",
+		Buckets:   prometheus.ExponentialBuckets(10e-9, 10, 10),
+	}, []string{"name"})
+
+	workqueueWorkDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Subsystem: "workqueue",
+		Name:      "work_duration_seconds",
+		Help:      "How long in seconds processing an item from the workqueue takes.",
+		Buckets:   prometheus.ExponentialBuckets(10e-9, 10, 10),
+	}, []string{"name"})
+
+	workqueueUnfinishedWork = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Subsystem: "workqueue",
+		Name:      "unfinished_work_seconds",
+		Help:      "How many seconds of work has been done that is in progress and hasn't been observed by work_duration.",
+	}, []string{"name"})
+
+	workqueueLongestRunningProcessor = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Subsystem: "workqueue",
+		Name:      "longest_running_processor_seconds",
+		Help:      "How many seconds has the longest running processor for the workqueue been running.",
+	}, []string{"name"})
+
+	workqueueRetries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Subsystem: "workqueue",
+		Name:      "retries_total",
+		Help:      "Total number of retries handled by the workqueue.",
+	}, []string{"name"})
+)
+
+func init() {
+	Registry.MustRegister(
+		workqueueDepth,
+		workqueueAdds,
+		workqueueLatency,
+		workqueueWorkDuration,
+		workqueueUnfinishedWork,
+		workqueueLongestRunningProcessor,
+		workqueueRetries,
+	)
+	// The provider must be set before the queues are created, e.g. podQueue
+	workqueue.SetProvider(workqueueMetricsProvider{})
+}
+
+type workqueueMetricsProvider struct{}
+
+func (workqueueMetricsProvider) NewDepthMetric(name string) workqueue.GaugeMetric {
+	return workqueueDepth.WithLabelValues(name)
+}
+
+func (workqueueMetricsProvider) NewAddsMetric(name string) workqueue.CounterMetric {
+	return workqueueAdds.WithLabelValues(name)
+}
+
+func (workqueueMetricsProvider) NewLatencyMetric(name string) workqueue.HistogramMetric {
+	return workqueueLatency.WithLabelValues(name)
+}
+
+func (workqueueMetricsProvider) NewWorkDurationMetric(name string) workqueue.HistogramMetric {
+	return workqueueWorkDuration.WithLabelValues(name)
+}
+
+func (workqueueMetricsProvider) NewUnfinishedWorkSecondsMetric(name string) workqueue.SettableGaugeMetric {
+	return workqueueUnfinishedWork.WithLabelValues(name)
+}
+
+func (workqueueMetricsProvider) NewLongestRunningProcessorSecondsMetric(name string) workqueue.SettableGaugeMetric {
+	return workqueueLongestRunningProcessor.WithLabelValues(name)
+}
+
+func (workqueueMetricsProvider) NewRetriesMetric(name string) workqueue.CounterMetric {
+	return workqueueRetries.WithLabelValues(name)
+}
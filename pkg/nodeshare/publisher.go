@@ -0,0 +1,272 @@
+package nodeshare
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/AliyunContainerService/gpushare-scheduler-extender/pkg/cache"
+	"github.com/AliyunContainerService/gpushare-scheduler-extender/pkg/leader"
+	"github.com/AliyunContainerService/gpushare-scheduler-extender/pkg/log"
+	"golang.org/x/time/rate"
+	v1 "k8s.io/api/core/v1"
+	apiequality "k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/util/workqueue"
+)
+
+var publisherLog = log.Named(log.SubsystemNodeShare)
+
+// Publisher keeps the status of the GPUNodeShare objects updated from the scheduler cache.
+// The changes of a node are coalesced in the queue, and the writes share a token bucket
+// so a burst of allocations doesn't flood the API server.
+type Publisher struct {
+	client         dynamic.NamespaceableResourceInterface
+	schedulerCache *cache.SchedulerCache
+
+	// nodeQueue is the names of the nodes whose status may be stale
+	nodeQueue workqueue.RateLimitingInterface
+	// limiter throttles the writes of all the nodes
+	limiter *rate.Limiter
+	// resyncPeriod enqueues all the nodes, e.g. after this replica becomes the leader
+	resyncPeriod time.Duration
+}
+
+func NewPublisher(client dynamic.Interface, schedulerCache *cache.SchedulerCache, qps float64, burst int, resyncPeriod time.Duration) *Publisher {
+	return &Publisher{
+		client:         client.Resource(Resource),
+		schedulerCache: schedulerCache,
+		nodeQueue:      workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "gpuNodeShareQueue"),
+		limiter:        rate.NewLimiter(rate.Limit(qps), burst),
+		resyncPeriod:   resyncPeriod,
+	}
+}
+
+// Run starts the workers, and blocks until stopCh is closed. The cache must be built.
+func (p *Publisher) Run(threadiness int, stopCh <-chan struct{}) {
+	defer utilruntime.HandleCrash()
+	defer p.nodeQueue.ShutDown()
+
+	publisherLog.V(9).Info("starting GPUNodeShare publisher")
+	go wait.Until(func() { p.watchCache(stopCh) }, time.Second, stopCh)
+	go wait.Until(p.enqueueAll, p.resyncPeriod, stopCh)
+	for i := 0; i < threadiness; i++ {
+		go wait.Until(p.runWorker, time.Second, stopCh)
+	}
+
+	<-stopCh
+	publisherLog.V(3).Info("shutting down GPUNodeShare publisher")
+}
+
+// watchCache enqueues the nodes of the changes until stopCh is closed or the watch falls behind
+func (p *Publisher) watchCache(stopCh <-chan struct{}) {
+	w, err := p.schedulerCache.Watch("", 0)
+	if err != nil {
+		publisherLog.V(3).Warn("failed to watch the cache", log.Err(err))
+		return
+	}
+	defer w.Stop()
+
+	// the nodes deleted while not watching are removed by the resync
+	for _, node := range w.Snapshot.Nodes {
+		p.nodeQueue.Add(node.Name)
+	}
+	for {
+		select {
+		case change, ok := <-w.Changes:
+			if !ok {
+				publisherLog.V(3).Warn("the cache watch fell behind, watch again")
+				return
+			}
+			p.nodeQueue.Add(change.Node)
+		case <-stopCh:
+			return
+		}
+	}
+}
+
+// enqueueAll enqueues the nodes in the cache and the objects of the nodes which are gone
+func (p *Publisher) enqueueAll() {
+	nodeInfos, err := p.schedulerCache.ListNodeInfos(labels.Everything())
+	if err != nil {
+		publisherLog.V(3).Warn("failed to list the nodes", log.Err(err))
+		return
+	}
+	for _, info := range nodeInfos {
+		p.nodeQueue.Add(info.GetName())
+	}
+
+	if !leader.IsLeader() {
+		return
+	}
+	list, err := p.client.List(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		publisherLog.V(3).Warn("failed to list the GPUNodeShares", log.Err(err))
+		return
+	}
+	for _, item := range list.Items {
+		p.nodeQueue.Add(item.GetName())
+	}
+}
+
+func (p *Publisher) runWorker() {
+	for p.processNextWorkItem() {
+	}
+}
+
+func (p *Publisher) processNextWorkItem() bool {
+	key, quit := p.nodeQueue.Get()
+	if quit {
+		return false
+	}
+	defer p.nodeQueue.Done(key)
+
+	err := p.syncNode(key.(string))
+	if err == nil {
+		p.nodeQueue.Forget(key)
+		return true
+	}
+
+	publisherLog.V(3).Warn("failed to publish the GPUNodeShare of node", log.Node(key.(string)), log.Err(err))
+	p.nodeQueue.AddRateLimited(key)
+
+	return true
+}
+
+// syncNode creates, updates or deletes the GPUNodeShare of the node, it writes nothing if the status is up to date
+func (p *Publisher) syncNode(nodeName string) error {
+	if !leader.IsLeader() {
+		// the leader publishes the status, the node is enqueued again by the resync
+		return nil
+	}
+	ctx := context.Background()
+
+	info, err := p.schedulerCache.GetNodeInfo(nodeName)
+	if errors.IsNotFound(err) {
+		return p.delete(ctx, nodeName, "removed")
+	}
+	if err != nil {
+		return err
+	}
+	if info.GetTotalGPUMemory() <= 0 {
+		return p.delete(ctx, nodeName, "no longer GPU sharing")
+	}
+	status := buildStatus(info.Snapshot())
+
+	existing, err := p.client.Get(ctx, nodeName, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		existing, err = p.create(ctx, info.GetNode())
+	}
+	if err != nil {
+		return err
+	}
+
+	share := &GPUNodeShare{}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(existing.Object, share); err != nil {
+		return fmt.Errorf("failed to convert the GPUNodeShare %s: %v", nodeName, err)
+	}
+	status.LastUpdateTime = share.Status.LastUpdateTime
+	if apiequality.Semantic.DeepEqual(share.Status, status) {
+		return nil
+	}
+	status.LastUpdateTime = metav1.Now()
+	share.Status = status
+
+	obj, err := runtime.DefaultUnstructuredConverter.ToUnstructured(share)
+	if err != nil {
+		return err
+	}
+	if err := p.wait(ctx); err != nil {
+		return err
+	}
+	// a conflict is retried with the latest object
+	if _, err := p.client.UpdateStatus(ctx, &unstructured.Unstructured{Object: obj}, metav1.UpdateOptions{}); err != nil {
+		return err
+	}
+	publisherLog.V(5).Info("updated the GPUNodeShare status", log.Node(nodeName), log.Int("usedGPUMemory", status.UsedGPUMemory))
+	return nil
+}
+
+// delete removes the GPUNodeShare of the node which is removed or no longer GPU sharing
+func (p *Publisher) delete(ctx context.Context, nodeName string, reason string) error {
+	if err := p.wait(ctx); err != nil {
+		return err
+	}
+	err := p.client.Delete(ctx, nodeName, metav1.DeleteOptions{})
+	if err == nil {
+		publisherLog.V(5).Info("deleted the GPUNodeShare of the node", log.Node(nodeName), log.String("reason", reason))
+	}
+	if errors.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+// create adds an empty GPUNodeShare owned by the node, so it's garbage collected with the node
+func (p *Publisher) create(ctx context.Context, node *v1.Node) (*unstructured.Unstructured, error) {
+	share := &GPUNodeShare{
+		TypeMeta: metav1.TypeMeta{APIVersion: Group + "/" + Version, Kind: Kind},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            node.Name,
+			OwnerReferences: []metav1.OwnerReference{*metav1.NewControllerRef(node, v1.SchemeGroupVersion.WithKind("Node"))},
+		},
+	}
+	obj, err := runtime.DefaultUnstructuredConverter.ToUnstructured(share)
+	if err != nil {
+		return nil, err
+	}
+	if err := p.wait(ctx); err != nil {
+		return nil, err
+	}
+	return p.client.Create(ctx, &unstructured.Unstructured{Object: obj}, metav1.CreateOptions{})
+}
+
+func (p *Publisher) wait(ctx context.Context) error {
+	return p.limiter.Wait(ctx)
+}
+
+func buildStatus(node *cache.NodeSnapshot) GPUNodeShareStatus {
+	unhealthy := map[int]bool{}
+	for _, id := range node.UnhealthyDevices {
+		unhealthy[id] = true
+	}
+
+	status := GPUNodeShareStatus{
+		Resource:         string(node.Resource),
+		GPUCount:         node.GPUCount,
+		TotalGPUMemory:   node.TotalGPUMemory,
+		UnhealthyDevices: len(node.UnhealthyDevices),
+		Devices:          []DeviceStatus{},
+	}
+	for _, dev := range node.Devices {
+		d := DeviceStatus{
+			ID:             dev.ID,
+			UUID:           dev.UUID,
+			TotalGPUMemory: int64(dev.TotalGPUMemory),
+			Healthy:        !unhealthy[dev.ID],
+		}
+		for _, pod := range dev.Pods {
+			d.Pods = append(d.Pods, PodReference{
+				Namespace: pod.Namespace,
+				Name:      pod.Name,
+				UID:       pod.UID,
+				GPUMemory: int64(pod.GPUMemory),
+				Assigned:  pod.Assigned,
+			})
+			// the same as the cache, the terminated pods don't use the device
+			if pod.Phase != v1.PodSucceeded && pod.Phase != v1.PodFailed {
+				d.UsedGPUMemory += int64(pod.GPUMemory)
+			}
+		}
+		status.UsedGPUMemory += int(d.UsedGPUMemory)
+		status.Devices = append(status.Devices, d)
+	}
+	return status
+}
@@ -0,0 +1,135 @@
+package nodeshare
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/AliyunContainerService/gpushare-scheduler-extender/pkg/cache"
+	"github.com/AliyunContainerService/gpushare-scheduler-extender/pkg/log"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic/fake"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	clientgocache "k8s.io/client-go/tools/cache"
+)
+
+func init() {
+	log.NewLoggerWithLevel(0)
+}
+
+func newTestNode(name string, gpuMemory int64) *v1.Node {
+	node := &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: name, UID: types.UID("uid-" + name)},
+		Status:     v1.NodeStatus{Capacity: v1.ResourceList{}},
+	}
+	if gpuMemory > 0 {
+		node.Status.Capacity["aliyun.com/gpu-mem"] = *resource.NewQuantity(gpuMemory, resource.DecimalSI)
+		node.Status.Capacity["aliyun.com/gpu-count"] = *resource.NewQuantity(2, resource.DecimalSI)
+	}
+	return node
+}
+
+func newTestShare(t *testing.T, name string) runtime.Object {
+	obj, err := runtime.DefaultUnstructuredConverter.ToUnstructured(&GPUNodeShare{
+		TypeMeta:   metav1.TypeMeta{APIVersion: Group + "/" + Version, Kind: Kind},
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &unstructured.Unstructured{Object: obj}
+}
+
+func TestSyncNode(t *testing.T) {
+	tests := []struct {
+		name     string
+		node     *v1.Node
+		existing bool
+		// wantWrites are the writes of two syncs, the second one finds the status up to date
+		wantWrites []string
+		wantExists bool
+	}{
+		{
+			name:       "GPU sharing node creates the object",
+			node:       newTestNode("n1", 16),
+			wantWrites: []string{"create", "update/status"},
+			wantExists: true,
+		},
+		{
+			name:       "GPU sharing node updates the stale status",
+			node:       newTestNode("n1", 16),
+			existing:   true,
+			wantWrites: []string{"update/status"},
+			wantExists: true,
+		},
+		{
+			name:       "node which stops GPU sharing deletes the object",
+			node:       newTestNode("n1", 0),
+			existing:   true,
+			wantWrites: []string{"delete", "delete"},
+		},
+		{
+			name:       "removed node deletes the object",
+			existing:   true,
+			wantWrites: []string{"delete", "delete"},
+		},
+		{
+			name:       "node without GPU sharing creates nothing",
+			node:       newTestNode("n1", 0),
+			wantWrites: []string{"delete", "delete"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			nodeIndexer := clientgocache.NewIndexer(clientgocache.MetaNamespaceKeyFunc, clientgocache.Indexers{})
+			if test.node != nil {
+				if err := nodeIndexer.Add(test.node); err != nil {
+					t.Fatal(err)
+				}
+			}
+			podIndexer := clientgocache.NewIndexer(clientgocache.MetaNamespaceKeyFunc, clientgocache.Indexers{clientgocache.NamespaceIndex: clientgocache.MetaNamespaceIndexFunc})
+			configMaps := corelisters.NewConfigMapLister(clientgocache.NewIndexer(clientgocache.MetaNamespaceKeyFunc, clientgocache.Indexers{}))
+			schedulerCache := cache.NewSchedulerCache(corelisters.NewNodeLister(nodeIndexer), corelisters.NewPodLister(podIndexer), configMaps)
+
+			objects := []runtime.Object{}
+			if test.existing {
+				objects = append(objects, newTestShare(t, "n1"))
+			}
+			client := fake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{Resource: Kind + "List"}, objects...)
+			p := NewPublisher(client, schedulerCache, 100, 10, time.Hour)
+
+			for i := 0; i < 2; i++ {
+				if err := p.syncNode("n1"); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			writes := []string{}
+			for _, action := range client.Actions() {
+				verb := action.GetVerb()
+				if verb == "get" || verb == "list" {
+					continue
+				}
+				if action.GetSubresource() != "" {
+					verb += "/" + action.GetSubresource()
+				}
+				writes = append(writes, verb)
+			}
+			if !reflect.DeepEqual(writes, test.wantWrites) {
+				t.Errorf("expected the writes %v, got %v", test.wantWrites, writes)
+			}
+			_, err := client.Resource(Resource).Get(context.Background(), "n1", metav1.GetOptions{})
+			if exists := err == nil; exists != test.wantExists {
+				t.Errorf("expected the GPUNodeShare exists %v, got %v", test.wantExists, exists)
+			}
+		})
+	}
+}
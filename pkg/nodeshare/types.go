@@ -0,0 +1,59 @@
+package nodeshare
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+const (
+	Group   = "gpushare.aliyun.com"
+	Version = "v1alpha1"
+	Kind    = "GPUNodeShare"
+)
+
+// Resource is the cluster-scoped GPUNodeShare, one object per gpushare node with the node's name
+var Resource = schema.GroupVersionResource{Group: Group, Version: Version, Resource: "gpunodeshares"}
+
+// GPUNodeShare is the device inventory and usage of a node seen by the extender.
+// The extender owns the status, the other controllers only read it.
+type GPUNodeShare struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Status GPUNodeShareStatus `json:"status,omitempty"`
+}
+
+type GPUNodeShareStatus struct {
+	// Resource is the gpu memory resource of the node's profile
+	Resource       string `json:"resource,omitempty"`
+	GPUCount       int    `json:"gpuCount"`
+	TotalGPUMemory int    `json:"totalGPUMemory"`
+	// UsedGPUMemory is allocated to the pods which are not terminated
+	UsedGPUMemory int `json:"usedGPUMemory"`
+	// UnhealthyDevices is the number of the devices in the unhealthy GPU configmap
+	UnhealthyDevices int            `json:"unhealthyDevices"`
+	Devices          []DeviceStatus `json:"devices"`
+	// LastUpdateTime is when the status last changed
+	LastUpdateTime metav1.Time `json:"lastUpdateTime,omitempty"`
+}
+
+type DeviceStatus struct {
+	ID int `json:"id"`
+	// UUID is empty if the device plugin doesn't report the UUIDs of the node
+	UUID           string `json:"uuid,omitempty"`
+	TotalGPUMemory int64  `json:"totalGPUMemory"`
+	UsedGPUMemory  int64  `json:"usedGPUMemory"`
+	Healthy        bool   `json:"healthy"`
+	// Pods are all the pods allocated to the device, including the terminated ones not removed yet
+	Pods []PodReference `json:"pods,omitempty"`
+}
+
+type PodReference struct {
+	Namespace string    `json:"namespace"`
+	Name      string    `json:"name"`
+	UID       types.UID `json:"uid"`
+	GPUMemory int64     `json:"gpuMemory"`
+	// Assigned is true after the device plugin allocated the device to the container
+	Assigned bool `json:"assigned"`
+}
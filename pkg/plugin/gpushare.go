@@ -0,0 +1,289 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/kubernetes/pkg/scheduler/framework"
+	frameworkruntime "k8s.io/kubernetes/pkg/scheduler/framework/runtime"
+
+	"github.com/AliyunContainerService/gpushare-scheduler-extender/pkg/cache"
+	"github.com/AliyunContainerService/gpushare-scheduler-extender/pkg/gpushare"
+	"github.com/AliyunContainerService/gpushare-scheduler-extender/pkg/log"
+	"github.com/AliyunContainerService/gpushare-scheduler-extender/pkg/scheduler"
+)
+
+var pluginLog = log.Named(log.SubsystemPlugin)
+
+const (
+	// Name is the name of the plugin in the scheduler profiles
+	Name = "GPUShare"
+
+	// stateKey keeps the pod assumed in Reserve for PreBind and Unreserve
+	stateKey framework.StateKey = Name
+
+	defaultThreadiness = 1
+)
+
+// Args are the arguments of the plugin in the scheduler configuration
+type Args struct {
+	// Strategy picks the node and the device, binpack (default) or spread
+	Strategy string `json:"strategy,omitempty"`
+	// Threadiness is the number of the workers syncing the pods into the cache
+	Threadiness int `json:"threadiness,omitempty"`
+}
+
+// GPUShare is the scheduler framework plugin of GPU sharing. It runs the extender's controller
+// on the scheduler's informers, so the cache, the filter and the device allocation are the same.
+type GPUShare struct {
+	handle     framework.Handle
+	controller *gpushare.Controller
+	cache      *cache.SchedulerCache
+	spread     bool
+	// ready is 1 after the cache is built
+	ready int32
+	// failure is the error which keeps the cache from being built, reported by the filter
+	failure atomic.Value
+}
+
+var (
+	_ framework.FilterPlugin  = &GPUShare{}
+	_ framework.ScorePlugin   = &GPUShare{}
+	_ framework.ReservePlugin = &GPUShare{}
+	_ framework.PreBindPlugin = &GPUShare{}
+)
+
+// assumedState is the pod with the allocation annotations, which is accounted in the cache
+type assumedState struct {
+	pod *v1.Pod
+}
+
+func (s *assumedState) Clone() framework.StateData {
+	return s
+}
+
+// NewFactory returns the factory of the plugin, whose controller stops when the context is done.
+// The framework handle carries no context in this scheduler version, so the binary passes its own.
+func NewFactory(ctx context.Context) frameworkruntime.PluginFactory {
+	return func(obj runtime.Object, handle framework.Handle) (framework.Plugin, error) {
+		return New(ctx, obj, handle)
+	}
+}
+
+// New builds the plugin, the cache is built in the background once the informers are synced
+func New(ctx context.Context, obj runtime.Object, handle framework.Handle) (framework.Plugin, error) {
+	args := &Args{Strategy: cache.DeviceStrategyBinpack, Threadiness: defaultThreadiness}
+	if obj != nil {
+		if err := frameworkruntime.DecodeInto(obj, args); err != nil {
+			return nil, err
+		}
+	}
+	if err := cache.SetDeviceStrategy(args.Strategy); err != nil {
+		return nil, err
+	}
+	if args.Threadiness <= 0 {
+		return nil, fmt.Errorf("threadiness %d should be positive", args.Threadiness)
+	}
+
+	// the controller lives as long as the scheduler
+	stopCh := ctx.Done()
+	controller, err := gpushare.NewController(handle.ClientSet(), handle.SharedInformerFactory(), stopCh)
+	if err != nil {
+		return nil, err
+	}
+	p := &GPUShare{
+		handle:     handle,
+		controller: controller,
+		cache:      controller.GetSchedulerCache(),
+		spread:     args.Strategy == cache.DeviceStrategySpread,
+	}
+	go p.run(args.Threadiness, stopCh)
+	return p, nil
+}
+
+// run builds the cache and syncs the pods into it. The scheduler keeps running if it fails,
+// the plugin stays not ready and the filter reports the failure to the GPU sharing pods.
+func (p *GPUShare) run(threadiness int, stopCh <-chan struct{}) {
+	if err := p.controller.WaitForCacheSync(stopCh); err != nil {
+		if !isStopped(stopCh) {
+			p.fail("failed to sync the informers of the gpushare plugin", err)
+		}
+		return
+	}
+	if err := p.controller.BuildCache(); err != nil {
+		p.fail("failed to build the cache of the gpushare plugin", err)
+		return
+	}
+	atomic.StoreInt32(&p.ready, 1)
+	pluginLog.V(3).Info("the gpushare cache is built")
+	if err := p.controller.Run(threadiness, stopCh); err != nil {
+		atomic.StoreInt32(&p.ready, 0)
+		p.fail("failed to run the controller of the gpushare plugin", err)
+	}
+}
+
+func (p *GPUShare) fail(msg string, err error) {
+	pluginLog.V(3).Error(msg, log.Err(err))
+	p.failure.Store(fmt.Sprintf("%s: %v", msg, err))
+}
+
+func isStopped(stopCh <-chan struct{}) bool {
+	select {
+	case <-stopCh:
+		return true
+	default:
+		return false
+	}
+}
+
+// gpuMemory is the gpu memory requested by the pod in the resource of its profile
+func (p *GPUShare) gpuMemory(pod *v1.Pod) int {
+	return p.cache.GetProfiles().OfPod(pod).GPUMemoryFromPodResource(pod)
+}
+
+func (p *GPUShare) Name() string {
+	return Name
+}
+
+// Filter rejects the node if no single healthy device has enough gpu memory for the pod
+func (p *GPUShare) Filter(ctx context.Context, state *framework.CycleState, pod *v1.Pod, nodeInfo *framework.NodeInfo) *framework.Status {
+	if p.gpuMemory(pod) <= 0 {
+		return nil
+	}
+	if atomic.LoadInt32(&p.ready) == 0 {
+		if failure, ok := p.failure.Load().(string); ok {
+			return framework.NewStatus(framework.Error, failure)
+		}
+		return framework.NewStatus(framework.Error, "the gpushare cache is not built yet")
+	}
+	node := nodeInfo.Node()
+	if node == nil {
+		return framework.NewStatus(framework.Error, "node not found")
+	}
+
+	info, err := p.cache.GetNodeInfo(node.Name)
+	if err != nil {
+		return framework.AsStatus(err)
+	}
+	if _, err := scheduler.CheckNodeInfo(pod, info); err != nil {
+		if scheduler.Unresolvable(err) {
+			return framework.NewStatus(framework.UnschedulableAndUnresolvable, err.Error())
+		}
+		return framework.NewStatus(framework.Unschedulable, err.Error())
+	}
+	return nil
+}
+
+// Score prefers the node with the least free gpu memory after placing the pod, or the most with spread
+func (p *GPUShare) Score(ctx context.Context, state *framework.CycleState, pod *v1.Pod, nodeName string) (int64, *framework.Status) {
+	if p.gpuMemory(pod) <= 0 {
+		return 0, nil
+	}
+	info, err := p.cache.GetNodeInfo(nodeName)
+	if err != nil {
+		return 0, framework.AsStatus(err)
+	}
+	total := int64(info.GetTotalGPUMemory())
+	if total <= 0 {
+		return 0, nil
+	}
+
+	free := int64(0)
+	for _, availableGPU := range info.GetAvailableGPUs() {
+		free += int64(availableGPU)
+	}
+	free -= int64(p.gpuMemory(pod))
+	if free < 0 {
+		free = 0
+	}
+	score := (total - free) * framework.MaxNodeScore / total
+	if p.spread {
+		score = framework.MaxNodeScore - score
+	}
+	return score, nil
+}
+
+func (p *GPUShare) ScoreExtensions() framework.ScoreExtensions {
+	return nil
+}
+
+// Reserve picks the device and accounts the pod on it, so the following pods see the memory as used
+func (p *GPUShare) Reserve(ctx context.Context, state *framework.CycleState, pod *v1.Pod, nodeName string) *framework.Status {
+	if p.gpuMemory(pod) <= 0 {
+		return nil
+	}
+	assumed, err := p.cache.AssumePod(pod, nodeName)
+	if err != nil {
+		return framework.NewStatus(framework.Unschedulable, err.Error())
+	}
+	state.Write(stateKey, &assumedState{pod: assumed})
+	return nil
+}
+
+// Unreserve releases the device if the pod isn't bound
+func (p *GPUShare) Unreserve(ctx context.Context, state *framework.CycleState, pod *v1.Pod, nodeName string) {
+	assumed, err := readAssumed(state)
+	if err != nil {
+		return
+	}
+	pluginLog.V(3).Info("release the assumed device of the pod",
+		log.Pod(pod.Name),
+		log.Namespace(pod.Namespace),
+		log.Node(nodeName),
+		log.DevID(p.cache.GetProfiles().OfPod(assumed).GPUIDFromAnnotation(assumed)))
+	p.cache.RemovePod(assumed)
+}
+
+// PreBind writes the allocation annotations, which the device plugin reads, before the pod is bound
+func (p *GPUShare) PreBind(ctx context.Context, state *framework.CycleState, pod *v1.Pod, nodeName string) *framework.Status {
+	assumed, err := readAssumed(state)
+	if err != nil {
+		// the pod doesn't request gpu memory
+		return nil
+	}
+
+	devID := p.cache.GetProfiles().OfPod(assumed).GPUIDFromAnnotation(assumed)
+	info, err := p.cache.GetNodeInfo(nodeName)
+	if err != nil {
+		return framework.AsStatus(err)
+	}
+	// the node may have stopped sharing its GPUs since Reserve
+	if info.GetGPUCount() == 0 {
+		return framework.NewStatus(framework.Error, fmt.Sprintf("the node %s has no GPU to allocate", nodeName))
+	}
+	profile := info.GetProfileOfPod(assumed)
+	patch, err := profile.PatchPodAnnotationSpec(pod, devID, profile.GPUUUIDFromAnnotation(assumed), info.GetTotalGPUMemory()/info.GetGPUCount())
+	if err != nil {
+		return framework.AsStatus(err)
+	}
+	startTime := time.Now()
+	if _, err := p.handle.ClientSet().CoreV1().Pods(pod.Namespace).Patch(ctx, pod.Name, types.StrategicMergePatchType, patch, metav1.PatchOptions{}); err != nil {
+		pluginLog.V(3).Warn("failed to patch the allocation of the pod", log.Pod(pod.Name), log.Namespace(pod.Namespace), log.Err(err))
+		return framework.AsStatus(err)
+	}
+	pluginLog.V(3).Info("allocated the device to the pod",
+		log.Pod(pod.Name),
+		log.Namespace(pod.Namespace),
+		log.Node(nodeName),
+		log.DevID(devID),
+		log.Duration("cost_time", time.Since(startTime)))
+	return nil
+}
+
+func readAssumed(state *framework.CycleState) (*v1.Pod, error) {
+	data, err := state.Read(stateKey)
+	if err != nil {
+		return nil, err
+	}
+	s, ok := data.(*assumedState)
+	if !ok {
+		return nil, fmt.Errorf("unexpected state %T of %s", data, stateKey)
+	}
+	return s.pod, nil
+}
@@ -0,0 +1,213 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strconv"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	clientgocache "k8s.io/client-go/tools/cache"
+	"k8s.io/kubernetes/pkg/scheduler/framework"
+
+	"github.com/AliyunContainerService/gpushare-scheduler-extender/pkg/cache"
+	"github.com/AliyunContainerService/gpushare-scheduler-extender/pkg/log"
+)
+
+func init() {
+	log.NewLoggerWithLevel(0)
+}
+
+func newNode(name string, gpuCount, gpuMemory int64) *v1.Node {
+	node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: name}, Status: v1.NodeStatus{Capacity: v1.ResourceList{}}}
+	if gpuMemory > 0 {
+		node.Status.Capacity["aliyun.com/gpu-mem"] = *resource.NewQuantity(gpuMemory, resource.DecimalSI)
+		node.Status.Capacity["aliyun.com/gpu-count"] = *resource.NewQuantity(gpuCount, resource.DecimalSI)
+	}
+	return node
+}
+
+// newPod is a pod of the gpu memory, running on the device of the node if the node is set
+func newPod(name string, gpuMemory int64, nodeName string, devID int) *v1.Pod {
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", UID: types.UID("uid-" + name)},
+		Spec:       v1.PodSpec{Containers: []v1.Container{{Name: "main"}}},
+	}
+	if gpuMemory > 0 {
+		pod.Spec.Containers[0].Resources.Limits = v1.ResourceList{"aliyun.com/gpu-mem": *resource.NewQuantity(gpuMemory, resource.DecimalSI)}
+	}
+	if len(nodeName) > 0 {
+		pod.Spec.NodeName = nodeName
+		pod.Status.Phase = v1.PodRunning
+		pod.Annotations = map[string]string{
+			"ALIYUN_COM_GPU_MEM_IDX": strconv.Itoa(devID),
+			"ALIYUN_COM_GPU_MEM_POD": strconv.FormatInt(gpuMemory, 10),
+		}
+	}
+	return pod
+}
+
+// newTestPlugin is the ready plugin of the node a with 6 of 8 gpu memory used on the device 0,
+// the empty node b of 2 devices of 8 gpu memory and the node none without GPU
+func newTestPlugin(t *testing.T) (*GPUShare, map[string]*v1.Node) {
+	nodes := map[string]*v1.Node{"a": newNode("a", 2, 16), "b": newNode("b", 2, 16), "none": newNode("none", 0, 0)}
+	nodeIndexer := clientgocache.NewIndexer(clientgocache.MetaNamespaceKeyFunc, clientgocache.Indexers{})
+	for _, node := range nodes {
+		if err := nodeIndexer.Add(node); err != nil {
+			t.Fatal(err)
+		}
+	}
+	podIndexer := clientgocache.NewIndexer(clientgocache.MetaNamespaceKeyFunc, clientgocache.Indexers{clientgocache.NamespaceIndex: clientgocache.MetaNamespaceIndexFunc})
+	if err := podIndexer.Add(newPod("running", 6, "a", 0)); err != nil {
+		t.Fatal(err)
+	}
+	configMaps := clientgocache.NewIndexer(clientgocache.MetaNamespaceKeyFunc, clientgocache.Indexers{clientgocache.NamespaceIndex: clientgocache.MetaNamespaceIndexFunc})
+	c := cache.NewSchedulerCache(corelisters.NewNodeLister(nodeIndexer), corelisters.NewPodLister(podIndexer), corelisters.NewConfigMapLister(configMaps))
+	if err := c.BuildCache(); err != nil {
+		t.Fatal(err)
+	}
+	return &GPUShare{cache: c, ready: 1}, nodes
+}
+
+func TestFilter(t *testing.T) {
+	tests := []struct {
+		name     string
+		pod      *v1.Pod
+		node     string
+		notReady bool
+		// failure is the error of building the cache
+		failure  error
+		wantCode framework.Code
+	}{
+		{name: "pod without gpu memory", pod: newPod("p", 0, "", 0), node: "none", notReady: true, wantCode: framework.Success},
+		{name: "device with enough memory", pod: newPod("p", 4, "", 0), node: "a", wantCode: framework.Success},
+		{name: "memory on no single device", pod: newPod("p", 10, "", 0), node: "a", wantCode: framework.Unschedulable},
+		{name: "node without GPU", pod: newPod("p", 4, "", 0), node: "none", wantCode: framework.UnschedulableAndUnresolvable},
+		{name: "cache not built yet", pod: newPod("p", 4, "", 0), node: "a", notReady: true, wantCode: framework.Error},
+		{name: "cache failed to build", pod: newPod("p", 4, "", 0), node: "a", notReady: true, failure: errors.New("the api server is gone"), wantCode: framework.Error},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p, nodes := newTestPlugin(t)
+			if test.notReady {
+				p.ready = 0
+			}
+			if test.failure != nil {
+				p.fail("failed to build the cache", test.failure)
+			}
+			nodeInfo := framework.NewNodeInfo()
+			nodeInfo.SetNode(nodes[test.node])
+
+			status := p.Filter(context.Background(), framework.NewCycleState(), test.pod, nodeInfo)
+			if code := status.Code(); code != test.wantCode {
+				t.Fatalf("expected code %v, got %v: %v", test.wantCode, code, status.Message())
+			}
+			if test.failure != nil {
+				if want := "failed to build the cache: the api server is gone"; status.Message() != want {
+					t.Errorf("expected the message %q, got %q", want, status.Message())
+				}
+			}
+		})
+	}
+}
+
+func TestScore(t *testing.T) {
+	// the free gpu memory after placing the pod of 4: a 16-6-4=6, b 16-4=12
+	tests := []struct {
+		name   string
+		spread bool
+		want   map[string]int64
+	}{
+		{name: "binpack prefers the fuller node", want: map[string]int64{"a": 62, "b": 25, "none": 0}},
+		{name: "spread prefers the emptier node", spread: true, want: map[string]int64{"a": 38, "b": 75, "none": 0}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p, _ := newTestPlugin(t)
+			p.spread = test.spread
+			got := map[string]int64{}
+			for name := range test.want {
+				score, status := p.Score(context.Background(), framework.NewCycleState(), newPod("p", 4, "", 0), name)
+				if !status.IsSuccess() {
+					t.Fatalf("expected the score of %s, got %v", name, status.Message())
+				}
+				got[name] = score
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("expected the scores %v, got %v", test.want, got)
+			}
+		})
+	}
+}
+
+func TestReserveAndUnreserve(t *testing.T) {
+	p, _ := newTestPlugin(t)
+	info, err := p.cache.GetNodeInfo("b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	free := map[int]uint{0: 8, 1: 8}
+
+	// the pods after the reserved one see its memory as used
+	state := framework.NewCycleState()
+	if status := p.Reserve(context.Background(), state, newPod("p1", 6, "", 0), "b"); !status.IsSuccess() {
+		t.Fatalf("expected the pod reserved, got %v", status.Message())
+	}
+	if got, want := info.GetAvailableGPUs(), map[int]uint{0: 2, 1: 8}; !reflect.DeepEqual(got, want) {
+		t.Errorf("expected the available gpu memory %v after Reserve, got %v", want, got)
+	}
+	if status := p.Reserve(context.Background(), framework.NewCycleState(), newPod("p2", 10, "", 0), "b"); status.Code() != framework.Unschedulable {
+		t.Errorf("expected the pod of 10 unschedulable, got %v", status.Code())
+	}
+
+	p.Unreserve(context.Background(), state, newPod("p1", 6, "", 0), "b")
+	if got := info.GetAvailableGPUs(); !reflect.DeepEqual(got, free) {
+		t.Errorf("expected the available gpu memory %v after Unreserve, got %v", free, got)
+	}
+	// the pod which wasn't reserved is ignored
+	p.Unreserve(context.Background(), framework.NewCycleState(), newPod("p3", 6, "", 0), "b")
+	if got := info.GetAvailableGPUs(); !reflect.DeepEqual(got, free) {
+		t.Errorf("expected the available gpu memory %v, got %v", free, got)
+	}
+}
+
+func TestPreBindWithoutGPU(t *testing.T) {
+	c, err := cache.NewSchedulerCacheFromSnapshot(&cache.Snapshot{
+		Version: cache.SnapshotVersion,
+		Nodes:   []*cache.NodeSnapshot{{Name: "no-gpu"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &GPUShare{cache: c}
+	pod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "pod", Namespace: "default"}}
+
+	tests := []struct {
+		name     string
+		assumed  bool
+		wantCode framework.Code
+	}{
+		{name: "pod without gpu memory is skipped", wantCode: framework.Success},
+		{name: "node without GPU is an error", assumed: true, wantCode: framework.Error},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			state := framework.NewCycleState()
+			if test.assumed {
+				state.Write(stateKey, &assumedState{pod: pod})
+			}
+			status := p.PreBind(context.Background(), state, pod, "no-gpu")
+			if code := status.Code(); code != test.wantCode {
+				t.Errorf("expected code %v, got %v: %v", test.wantCode, code, status.Message())
+			}
+		})
+	}
+}
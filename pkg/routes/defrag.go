@@ -0,0 +1,98 @@
+package routes
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/AliyunContainerService/gpushare-scheduler-extender/pkg/defrag"
+	"github.com/AliyunContainerService/gpushare-scheduler-extender/pkg/leader"
+	"github.com/AliyunContainerService/gpushare-scheduler-extender/pkg/log"
+	"github.com/julienschmidt/httprouter"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+const (
+	defragPlanPrefix    = apiPrefix + "/defrag/plan"
+	defragExecutePrefix = apiPrefix + "/defrag/execute"
+)
+
+// parseDefragRequest parses the query ?size=<gpu memory>&maxMoves=<n>&nodeSelector=<label selector>
+func parseDefragRequest(r *http.Request) (defrag.Request, error) {
+	req := defrag.Request{}
+	query := r.URL.Query()
+
+	size, err := strconv.ParseUint(query.Get("size"), 10, 32)
+	if err != nil || size == 0 {
+		return req, fmt.Errorf("invalid size %q, it should be a positive gpu memory", query.Get("size"))
+	}
+	req.GPUMemory = uint(size)
+
+	if s := query.Get("maxMoves"); len(s) > 0 {
+		req.MaxMoves, err = strconv.Atoi(s)
+		if err != nil || req.MaxMoves < 0 {
+			return req, fmt.Errorf("invalid maxMoves %q", s)
+		}
+	}
+
+	if s := query.Get("nodeSelector"); len(s) > 0 {
+		req.NodeSelector, err = labels.Parse(s)
+		if err != nil {
+			return req, fmt.Errorf("invalid nodeSelector %q: %v", s, err)
+		}
+	}
+	return req, nil
+}
+
+func DefragPlanRoute(planner *defrag.Planner) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		req, err := parseDefragRequest(r)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		plan, err := planner.Plan(req)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, plan)
+	}
+}
+
+func DefragExecuteRoute(planner *defrag.Planner, executor *defrag.Executor) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		if !leader.IsLeader() {
+			writeError(w, http.StatusServiceUnavailable, fmt.Errorf("only the leader %q executes the defrag plans", leader.Holder()))
+			return
+		}
+		req, err := parseDefragRequest(r)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		plan, err := planner.Plan(req)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		result, err := executor.Execute(r.Context(), plan)
+		if err != nil {
+			routesLog.V(3).Warn("failed to execute defrag plan", log.Err(err))
+			writeJSON(w, http.StatusConflict, result)
+			return
+		}
+		writeJSON(w, http.StatusOK, result)
+	}
+}
+
+// AddDefrag adds the defrag plan route, and the execute route if the executor is enabled
+func AddDefrag(router *httprouter.Router, planner *defrag.Planner, executor *defrag.Executor) {
+	router.GET(defragPlanPrefix, DebugLogging(DefragPlanRoute(planner), defragPlanPrefix))
+	if executor != nil {
+		router.POST(defragExecutePrefix, DebugLogging(DefragExecuteRoute(planner, executor), defragExecutePrefix))
+	}
+}
@@ -0,0 +1,126 @@
+package routes
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/AliyunContainerService/gpushare-scheduler-extender/pkg/gpushare"
+	"github.com/julienschmidt/httprouter"
+)
+
+const (
+	healthzPath = "/healthz"
+	readyzPath  = "/readyz"
+	livezPath   = "/livez"
+
+	// workersStallTimeout is how long the queue may have items without a worker finishing any of them
+	workersStallTimeout = 3 * time.Minute
+)
+
+// ControllerStatus reports the state of the informers, the cache and the workers, e.g. *gpushare.Controller
+type ControllerStatus interface {
+	Status() gpushare.Status
+}
+
+// Health checks the informers, the cache and the workers, and reports the leader lock.
+// The followers are ready too, they serve filter and inspect with their warm caches.
+type Health struct {
+	controller ControllerStatus
+	// isLeader is nil without the leader election
+	isLeader func() bool
+}
+
+func NewHealth(controller ControllerStatus, isLeader func() bool) *Health {
+	return &Health{controller: controller, isLeader: isLeader}
+}
+
+// HealthStatus is the body of the health routes, the checks are ok or the reason of the failure
+type HealthStatus struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks"`
+	// Controller is the state of the informers, the cache and the workers
+	Controller gpushare.Status `json:"controller"`
+	Leader     *bool           `json:"leader,omitempty"`
+}
+
+// Ready checks if the extender can serve kube-scheduler
+func (h *Health) Ready() bool {
+	status := h.controller.Status()
+	return status.InformersSynced && status.CacheBuilt
+}
+
+func (h *Health) readyChecks(status gpushare.Status, checks map[string]string) {
+	checks["informers"] = check(status.InformersSynced, "the informers are not synced")
+	checks["cache"] = check(status.CacheBuilt, "the cache is not built")
+}
+
+func (h *Health) liveChecks(status gpushare.Status, checks map[string]string) {
+	// The workers block on an empty queue, so they are only stuck if there are items which are not processed.
+	// Before the workers start, the queue fills up while the cache is built.
+	stalled := status.WorkersStarted && status.QueueDepth > 0 &&
+		(status.LastProcessed == nil || time.Since(*status.LastProcessed) > workersStallTimeout)
+	checks["workers"] = check(!stalled, fmt.Sprintf("%d pods are queued but no worker finished one in %v", status.QueueDepth, workersStallTimeout))
+}
+
+func check(ok bool, reason string) string {
+	if ok {
+		return "ok"
+	}
+	return reason
+}
+
+// HealthRoute reports the checks of readyz, livez or both for healthz, with 503 if any fails
+func HealthRoute(h *Health, ready, live bool) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		status := h.controller.Status()
+		result := HealthStatus{Status: "ok", Checks: map[string]string{}, Controller: status}
+		if ready {
+			h.readyChecks(status, result.Checks)
+		}
+		if live {
+			h.liveChecks(status, result.Checks)
+		}
+		if h.isLeader != nil {
+			leader := h.isLeader()
+			result.Leader = &leader
+		}
+
+		code := http.StatusOK
+		for _, c := range result.Checks {
+			if c != "ok" {
+				result.Status = "failure"
+				code = http.StatusServiceUnavailable
+			}
+		}
+		writeJSON(w, code, result)
+	}
+}
+
+// WaitForReady rejects the requests with 503 until the extender is ready, except the health routes,
+// so kube-scheduler doesn't get the decisions of an empty cache.
+func WaitForReady(handler http.Handler, h *Health) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch strings.TrimSuffix(r.URL.Path, "/") {
+		case healthzPath, readyzPath, livezPath:
+		default:
+			if !h.Ready() {
+				writeError(w, http.StatusServiceUnavailable, fmt.Errorf("the extender is not ready"))
+				return
+			}
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// HealthPaths are the routes of the probes, which are served without a client certificate
+func HealthPaths() []string {
+	return []string{healthzPath, readyzPath, livezPath}
+}
+
+func AddHealth(router *httprouter.Router, h *Health) {
+	router.GET(healthzPath, HealthRoute(h, true, true))
+	router.GET(readyzPath, HealthRoute(h, true, false))
+	router.GET(livezPath, HealthRoute(h, false, true))
+}
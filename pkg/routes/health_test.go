@@ -0,0 +1,170 @@
+package routes
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+
+	"github.com/AliyunContainerService/gpushare-scheduler-extender/pkg/gpushare"
+)
+
+type fakeController struct {
+	status gpushare.Status
+}
+
+func (c *fakeController) Status() gpushare.Status {
+	return c.status
+}
+
+func timeAgo(d time.Duration) *time.Time {
+	t := time.Now().Add(-d)
+	return &t
+}
+
+func TestHealthRoutes(t *testing.T) {
+	ready := gpushare.Status{InformersSynced: true, CacheBuilt: true, WorkersStarted: true}
+	stalled := ready
+	stalled.QueueDepth = 3
+	stalled.LastProcessed = timeAgo(workersStallTimeout + time.Minute)
+	busy := ready
+	busy.QueueDepth = 3
+	busy.LastProcessed = timeAgo(time.Second)
+	building := gpushare.Status{InformersSynced: true, QueueDepth: 10}
+
+	tests := []struct {
+		name   string
+		status gpushare.Status
+		// isLeader is nil without the leader election
+		isLeader   func() bool
+		path       string
+		wantCode   int
+		wantChecks map[string]string
+		wantLeader *bool
+	}{
+		{
+			name:       "ready",
+			status:     ready,
+			path:       readyzPath,
+			wantCode:   http.StatusOK,
+			wantChecks: map[string]string{"informers": "ok", "cache": "ok"},
+		},
+		{
+			name:       "cache is not built",
+			status:     building,
+			path:       readyzPath,
+			wantCode:   http.StatusServiceUnavailable,
+			wantChecks: map[string]string{"informers": "ok", "cache": "the cache is not built"},
+		},
+		{
+			name:       "queue fills up before the workers start",
+			status:     building,
+			path:       livezPath,
+			wantCode:   http.StatusOK,
+			wantChecks: map[string]string{"workers": "ok"},
+		},
+		{
+			name:       "workers are processing",
+			status:     busy,
+			path:       livezPath,
+			wantCode:   http.StatusOK,
+			wantChecks: map[string]string{"workers": "ok"},
+		},
+		{
+			name:       "workers are stalled",
+			status:     stalled,
+			path:       livezPath,
+			wantCode:   http.StatusServiceUnavailable,
+			wantChecks: map[string]string{"workers": "3 pods are queued but no worker finished one in 3m0s"},
+		},
+		{
+			name:     "healthz runs all the checks",
+			status:   stalled,
+			path:     healthzPath,
+			wantCode: http.StatusServiceUnavailable,
+			wantChecks: map[string]string{
+				"informers": "ok",
+				"cache":     "ok",
+				"workers":   "3 pods are queued but no worker finished one in 3m0s",
+			},
+		},
+		{
+			name:       "follower is ready and reports the leader lock",
+			status:     ready,
+			isLeader:   func() bool { return false },
+			path:       readyzPath,
+			wantCode:   http.StatusOK,
+			wantChecks: map[string]string{"informers": "ok", "cache": "ok"},
+			wantLeader: new(bool),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			router := httprouter.New()
+			AddHealth(router, NewHealth(&fakeController{status: test.status}, test.isLeader))
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, test.path, nil))
+			if w.Code != test.wantCode {
+				t.Errorf("expected status %d, got %d", test.wantCode, w.Code)
+			}
+			var result HealthStatus
+			if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil {
+				t.Fatal(err)
+			}
+			if len(result.Checks) != len(test.wantChecks) {
+				t.Errorf("expected the checks %v, got %v", test.wantChecks, result.Checks)
+			}
+			for name, want := range test.wantChecks {
+				if got := result.Checks[name]; got != want {
+					t.Errorf("expected the check %s %q, got %q", name, want, got)
+				}
+			}
+			if (result.Leader == nil) != (test.wantLeader == nil) || (result.Leader != nil && *result.Leader != *test.wantLeader) {
+				t.Errorf("expected leader %v, got %v", test.wantLeader, result.Leader)
+			}
+			if result.Controller.QueueDepth != test.status.QueueDepth {
+				t.Errorf("expected the queue depth %d, got %d", test.status.QueueDepth, result.Controller.QueueDepth)
+			}
+		})
+	}
+}
+
+func TestWaitForReady(t *testing.T) {
+	controller := &fakeController{status: gpushare.Status{InformersSynced: true}}
+	health := NewHealth(controller, nil)
+	router := httprouter.New()
+	AddHealth(router, health)
+	router.POST(predicatesPrefix, func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		w.WriteHeader(http.StatusOK)
+	})
+	handler := WaitForReady(router, health)
+
+	tests := []struct {
+		name     string
+		built    bool
+		method   string
+		path     string
+		wantCode int
+	}{
+		{name: "filter is rejected before the cache is built", method: http.MethodPost, path: predicatesPrefix, wantCode: http.StatusServiceUnavailable},
+		{name: "probe is served before the cache is built", method: http.MethodGet, path: livezPath, wantCode: http.StatusOK},
+		// the router redirects it to the probe
+		{name: "probe with a trailing slash is not rejected", method: http.MethodGet, path: livezPath + "/", wantCode: http.StatusMovedPermanently},
+		{name: "filter is served when ready", built: true, method: http.MethodPost, path: predicatesPrefix, wantCode: http.StatusOK},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			controller.status.CacheBuilt = test.built
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest(test.method, test.path, nil))
+			if w.Code != test.wantCode {
+				t.Errorf("expected status %d, got %d: %s", test.wantCode, w.Code, w.Body.String())
+			}
+		})
+	}
+}
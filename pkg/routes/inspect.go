@@ -0,0 +1,189 @@
+package routes
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
+
+	"github.com/AliyunContainerService/gpushare-scheduler-extender/pkg/log"
+	"github.com/AliyunContainerService/gpushare-scheduler-extender/pkg/scheduler"
+)
+
+const (
+	formatJSON       = "json"
+	formatTable      = "table"
+	formatCSV        = "csv"
+	formatPrometheus = "prometheus"
+)
+
+// inspectFormats render the inspect result in the format other than json
+var inspectFormats = map[string]struct {
+	contentType string
+	render      func(*scheduler.Result) ([]byte, error)
+}{
+	formatTable:      {"text/plain; charset=utf-8", renderInspectTable},
+	formatCSV:        {"text/csv; charset=utf-8", renderInspectCSV},
+	formatPrometheus: {"text/plain; version=0.0.4; charset=utf-8", renderInspectPrometheus},
+}
+
+// InspectRoute returns the gpu usage of the node, or of the nodes filtered by the query:
+// nodeSelector, namespace, minFree, unhealthy, limit and continue.
+// The format query selects json (default), table, csv or prometheus.
+func InspectRoute(inspect *scheduler.Inspect) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		query := r.URL.Query()
+		format := query.Get("format")
+		if len(format) == 0 {
+			format = formatJSON
+		}
+		if _, found := inspectFormats[format]; !found && format != formatJSON {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid format %q, it should be one of json, table, csv and prometheus", format))
+			return
+		}
+
+		opts, err := parseInspectOptions(query)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		name := ps.ByName("nodename")
+		result, err := inspect.Handler(name, opts)
+		if apierrors.IsNotFound(err) {
+			writeError(w, http.StatusNotFound, fmt.Errorf("node %s is not found", name))
+			return
+		}
+		if err != nil {
+			routesLog.V(3).Warn("failed to inspect the nodes", log.Node(name), log.Err(err))
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		if format == formatJSON {
+			writeJSON(w, http.StatusOK, result)
+			return
+		}
+		body, err := inspectFormats[format].render(result)
+		if err != nil {
+			routesLog.V(3).Warn("failed to render the inspect result", log.String("format", format), log.Err(err))
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		w.Header().Set("Content-Type", inspectFormats[format].contentType)
+		w.WriteHeader(http.StatusOK)
+		w.Write(body)
+	}
+}
+
+func parseInspectOptions(query url.Values) (opts scheduler.InspectOptions, err error) {
+	opts.Selector = labels.Everything()
+	if s := query.Get("nodeSelector"); len(s) > 0 {
+		opts.Selector, err = labels.Parse(s)
+		if err != nil {
+			return opts, fmt.Errorf("invalid nodeSelector %q: %v", s, err)
+		}
+	}
+	opts.Namespace = query.Get("namespace")
+	if s := query.Get("minFree"); len(s) > 0 {
+		minFree, err := strconv.ParseUint(s, 10, 32)
+		if err != nil {
+			return opts, fmt.Errorf("invalid minFree %q, it should be a gpu memory", s)
+		}
+		opts.MinFreeGPU = uint(minFree)
+	}
+	if s := query.Get("unhealthy"); len(s) > 0 {
+		opts.UnhealthyOnly, err = strconv.ParseBool(s)
+		if err != nil {
+			return opts, fmt.Errorf("invalid unhealthy %q, it should be true or false", s)
+		}
+	}
+	if s := query.Get("limit"); len(s) > 0 {
+		opts.Limit, err = strconv.Atoi(s)
+		if err != nil || opts.Limit < 0 {
+			return opts, fmt.Errorf("invalid limit %q, it should be a non-negative number", s)
+		}
+	}
+	opts.Continue = query.Get("continue")
+	return opts, nil
+}
+
+// renderInspectTable writes the table of the kubectl plugin
+func renderInspectTable(result *scheduler.Result) ([]byte, error) {
+	var buf bytes.Buffer
+	result.Print(&buf)
+	return buf.Bytes(), nil
+}
+
+// renderInspectCSV writes a record for each device, the pods are separated by space
+func renderInspectCSV(result *scheduler.Result) ([]byte, error) {
+	var buf bytes.Buffer
+	cw := csv.NewWriter(&buf)
+	cw.Write([]string{"node", "device", "totalGPU", "usedGPU", "healthy", "pods"})
+	for _, node := range result.Nodes {
+		for _, dev := range node.Devices {
+			cw.Write([]string{
+				node.Name,
+				strconv.Itoa(dev.ID),
+				strconv.FormatUint(uint64(dev.TotalGPU), 10),
+				strconv.FormatUint(uint64(dev.UsedGPU), 10),
+				strconv.FormatBool(!dev.Unhealthy),
+				dev.PodNames(),
+			})
+		}
+	}
+	cw.Flush()
+	return buf.Bytes(), cw.Error()
+}
+
+// renderInspectPrometheus writes the gauges in the prometheus text format
+func renderInspectPrometheus(result *scheduler.Result) ([]byte, error) {
+	var buf bytes.Buffer
+	gauge := func(name, help string, each func(node *scheduler.Node, dev *scheduler.Device)) {
+		fmt.Fprintf(&buf, "# HELP %s %s\n# TYPE %s gauge\n", name, help, name)
+		for _, node := range result.Nodes {
+			for _, dev := range node.Devices {
+				each(node, dev)
+			}
+		}
+	}
+	sample := func(name string, value interface{}, labelPairs ...string) {
+		pairs := []string{}
+		for i := 0; i+1 < len(labelPairs); i += 2 {
+			pairs = append(pairs, fmt.Sprintf("%s=%s", labelPairs[i], strconv.Quote(labelPairs[i+1])))
+		}
+		fmt.Fprintf(&buf, "%s{%s} %v\n", name, strings.Join(pairs, ","), value)
+	}
+
+	gauge("gpushare_inspect_device_gpu_memory_total", "The gpu memory of the device.", func(node *scheduler.Node, dev *scheduler.Device) {
+		sample("gpushare_inspect_device_gpu_memory_total", dev.TotalGPU, "node", node.Name, "device", strconv.Itoa(dev.ID))
+	})
+	gauge("gpushare_inspect_device_gpu_memory_used", "The gpu memory allocated to the pods on the device.", func(node *scheduler.Node, dev *scheduler.Device) {
+		sample("gpushare_inspect_device_gpu_memory_used", dev.UsedGPU, "node", node.Name, "device", strconv.Itoa(dev.ID))
+	})
+	gauge("gpushare_inspect_device_healthy", "Whether the device is healthy.", func(node *scheduler.Node, dev *scheduler.Device) {
+		healthy := 1
+		if dev.Unhealthy {
+			healthy = 0
+		}
+		sample("gpushare_inspect_device_healthy", healthy, "node", node.Name, "device", strconv.Itoa(dev.ID))
+	})
+	gauge("gpushare_inspect_pod_gpu_memory", "The gpu memory allocated to the pod.", func(node *scheduler.Node, dev *scheduler.Device) {
+		for _, pod := range dev.Pods {
+			sample("gpushare_inspect_pod_gpu_memory", pod.UsedGPU, "node", node.Name, "device", strconv.Itoa(dev.ID), "namespace", pod.Namespace, "pod", pod.Name)
+		}
+	})
+	return buf.Bytes(), nil
+}
+
+func AddInspect(router *httprouter.Router, inspect *scheduler.Inspect) {
+	router.GET(inspectPrefix, DebugLogging(InspectRoute(inspect), inspectPrefix))
+	router.GET(inspectListPrefix, DebugLogging(InspectRoute(inspect), inspectListPrefix))
+}
@@ -0,0 +1,129 @@
+package routes
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+
+	"github.com/AliyunContainerService/gpushare-scheduler-extender/pkg/scheduler"
+)
+
+// testResult has the node n1 with a pod on the device 0 and the unhealthy device 1
+var testResult = &scheduler.Result{
+	Nodes: []*scheduler.Node{{
+		Name: "n1", TotalGPU: 16, UsedGPU: 4, FreeGPU: 4, UnhealthyDevices: 1,
+		Devices: []*scheduler.Device{
+			{ID: 0, TotalGPU: 8, UsedGPU: 4, Pods: []*scheduler.Pod{{Namespace: "default", Name: "p1", UsedGPU: 4}}},
+			{ID: 1, TotalGPU: 8, Unhealthy: true, Pods: []*scheduler.Pod{}},
+		},
+	}},
+	Continue: "n1",
+}
+
+func TestRenderInspect(t *testing.T) {
+	tests := []struct {
+		name   string
+		render func(*scheduler.Result) ([]byte, error)
+		want   string
+	}{
+		{
+			name:   "table",
+			render: renderInspectTable,
+			want: "NODE  DEVICE  USED/TOTAL  HEALTHY  PODS\n" +
+				"n1    0       4/8         true     default/p1(4)\n" +
+				"n1    1       0/8         false    <none>\n" +
+				"\n" +
+				"continue: n1\n",
+		},
+		{
+			name:   "csv",
+			render: renderInspectCSV,
+			want: "node,device,totalGPU,usedGPU,healthy,pods\n" +
+				"n1,0,8,4,true,default/p1(4)\n" +
+				"n1,1,8,0,false,\n",
+		},
+		{
+			name:   "prometheus",
+			render: renderInspectPrometheus,
+			want: "# HELP gpushare_inspect_device_gpu_memory_total The gpu memory of the device.\n" +
+				"# TYPE gpushare_inspect_device_gpu_memory_total gauge\n" +
+				"gpushare_inspect_device_gpu_memory_total{node=\"n1\",device=\"0\"} 8\n" +
+				"gpushare_inspect_device_gpu_memory_total{node=\"n1\",device=\"1\"} 8\n" +
+				"# HELP gpushare_inspect_device_gpu_memory_used The gpu memory allocated to the pods on the device.\n" +
+				"# TYPE gpushare_inspect_device_gpu_memory_used gauge\n" +
+				"gpushare_inspect_device_gpu_memory_used{node=\"n1\",device=\"0\"} 4\n" +
+				"gpushare_inspect_device_gpu_memory_used{node=\"n1\",device=\"1\"} 0\n" +
+				"# HELP gpushare_inspect_device_healthy Whether the device is healthy.\n" +
+				"# TYPE gpushare_inspect_device_healthy gauge\n" +
+				"gpushare_inspect_device_healthy{node=\"n1\",device=\"0\"} 1\n" +
+				"gpushare_inspect_device_healthy{node=\"n1\",device=\"1\"} 0\n" +
+				"# HELP gpushare_inspect_pod_gpu_memory The gpu memory allocated to the pod.\n" +
+				"# TYPE gpushare_inspect_pod_gpu_memory gauge\n" +
+				"gpushare_inspect_pod_gpu_memory{node=\"n1\",device=\"0\",namespace=\"default\",pod=\"p1\"} 4\n",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := test.render(testResult)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != test.want {
+				t.Errorf("expected\n%s\ngot\n%s", test.want, got)
+			}
+		})
+	}
+}
+
+func TestInspectRoute(t *testing.T) {
+	router := httprouter.New()
+	AddInspect(router, scheduler.NewGPUShareInspect(newTestCache(t)))
+
+	tests := []struct {
+		name     string
+		path     string
+		wantCode int
+		// wantContentType is checked if the request succeeds
+		wantContentType string
+	}{
+		{name: "json", path: inspectListPrefix, wantCode: http.StatusOK, wantContentType: "application/json"},
+		{name: "table", path: inspectListPrefix + "?format=table", wantCode: http.StatusOK, wantContentType: "text/plain; charset=utf-8"},
+		{name: "csv", path: inspectListPrefix + "?format=csv", wantCode: http.StatusOK, wantContentType: "text/csv; charset=utf-8"},
+		{name: "prometheus", path: inspectListPrefix + "?format=prometheus", wantCode: http.StatusOK, wantContentType: "text/plain; version=0.0.4; charset=utf-8"},
+		{name: "node", path: apiPrefix + "/inspect/n1", wantCode: http.StatusOK, wantContentType: "application/json"},
+		{name: "unknown node", path: apiPrefix + "/inspect/unknown", wantCode: http.StatusNotFound},
+		{name: "unknown format", path: inspectListPrefix + "?format=yaml", wantCode: http.StatusBadRequest},
+		{name: "invalid node selector", path: inspectListPrefix + "?nodeSelector=a%3D%3D%3D", wantCode: http.StatusBadRequest},
+		{name: "invalid min free", path: inspectListPrefix + "?minFree=-1", wantCode: http.StatusBadRequest},
+		{name: "invalid unhealthy", path: inspectListPrefix + "?unhealthy=yes", wantCode: http.StatusBadRequest},
+		{name: "negative limit", path: inspectListPrefix + "?limit=-1", wantCode: http.StatusBadRequest},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, test.path, nil))
+			if w.Code != test.wantCode {
+				t.Fatalf("expected status %d, got %d: %s", test.wantCode, w.Code, w.Body.String())
+			}
+			if w.Code != http.StatusOK {
+				var body map[string]string
+				if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil || len(body["error"]) == 0 {
+					t.Errorf("expected an error in the body, got %s", w.Body.String())
+				}
+				return
+			}
+			if got := w.Header().Get("Content-Type"); got != test.wantContentType {
+				t.Errorf("expected the content type %q, got %q", test.wantContentType, got)
+			}
+			if !strings.Contains(w.Body.String(), "n1") {
+				t.Errorf("expected the node n1 in the result, got %s", w.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,116 @@
+package routes
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/AliyunContainerService/gpushare-scheduler-extender/pkg/log"
+	"github.com/julienschmidt/httprouter"
+)
+
+const (
+	logLevelPath = "/debug/loglevel"
+	tracePath    = logLevelPath + "/traces/:id"
+
+	defaultTraceDuration = 10 * time.Minute
+)
+
+// LogLevelStatus is the current log level, the levels of the subsystems and the active traces
+type LogLevelStatus struct {
+	Level int32 `json:"level"`
+	// Subsystems without a level follow the global level
+	Subsystems map[string]*int32 `json:"subsystems"`
+	Traces     []log.Trace       `json:"traces"`
+}
+
+func logLevelStatus() LogLevelStatus {
+	return LogLevelStatus{
+		Level:      log.GetLevel(),
+		Subsystems: log.GetSubsystemLevels(),
+		Traces:     log.GetTraces(),
+	}
+}
+
+func GetLogLevelRoute(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	writeJSON(w, http.StatusOK, logLevelStatus())
+}
+
+// SetLogLevelRoute changes the level by the query:
+//
+//	level=debug                       the global level
+//	level=debug&subsystem=cache       the level of the subsystem, an empty level makes it follow the global level
+//	node=n1 or pod=ns/name            a trace of the node or the pod, with level (default debug) and duration (default 10m)
+func SetLogLevelRoute(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	query := r.URL.Query()
+	levelStr := query.Get("level")
+	subsystem := query.Get("subsystem")
+	node, pod := query.Get("node"), query.Get("pod")
+
+	level := log.LevelDebug
+	if len(levelStr) > 0 {
+		var err error
+		level, err = log.ParseLevel(levelStr)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+
+	switch {
+	case len(node) > 0 || len(pod) > 0:
+		duration := defaultTraceDuration
+		if s := query.Get("duration"); len(s) > 0 {
+			var err error
+			duration, err = time.ParseDuration(s)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, fmt.Errorf("invalid duration %q: %v", s, err))
+				return
+			}
+		}
+		trace, err := log.AddTrace(node, pod, level, duration)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		routesLog.V(3).Info("start log trace", log.Any("trace", trace))
+		writeJSON(w, http.StatusCreated, trace)
+		return
+	case len(subsystem) > 0:
+		if len(levelStr) == 0 {
+			level = -1
+		}
+		if err := log.SetSubsystemLevel(subsystem, level); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+	case len(levelStr) > 0:
+		log.SetLevel(level)
+	default:
+		writeError(w, http.StatusBadRequest, fmt.Errorf("level, subsystem, node or pod should be specified"))
+		return
+	}
+
+	routesLog.V(3).Info("log level changed", log.String("subsystem", subsystem), log.String("level", levelStr))
+	writeJSON(w, http.StatusOK, logLevelStatus())
+}
+
+func DeleteTraceRoute(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	id, err := strconv.ParseInt(ps.ByName("id"), 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid trace id %q", ps.ByName("id")))
+		return
+	}
+	if !log.RemoveTrace(id) {
+		writeError(w, http.StatusNotFound, fmt.Errorf("trace %d is not found", id))
+		return
+	}
+	writeJSON(w, http.StatusOK, logLevelStatus())
+}
+
+func AddLogLevel(router *httprouter.Router) {
+	router.GET(logLevelPath, DebugLogging(GetLogLevelRoute, logLevelPath))
+	router.PUT(logLevelPath, DebugLogging(SetLogLevelRoute, logLevelPath))
+	router.DELETE(tracePath, DebugLogging(DeleteTraceRoute, tracePath))
+}
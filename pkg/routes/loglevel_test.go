@@ -0,0 +1,110 @@
+package routes
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+
+	"github.com/AliyunContainerService/gpushare-scheduler-extender/pkg/log"
+)
+
+func doLogLevel(t *testing.T, router *httprouter.Router, method, target string) (int, LogLevelStatus, log.Trace) {
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(method, target, nil))
+	var status LogLevelStatus
+	var trace log.Trace
+	switch w.Code {
+	case http.StatusOK:
+		if err := json.Unmarshal(w.Body.Bytes(), &status); err != nil {
+			t.Fatal(err)
+		}
+	case http.StatusCreated:
+		if err := json.Unmarshal(w.Body.Bytes(), &trace); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return w.Code, status, trace
+}
+
+func TestLogLevelRoutes(t *testing.T) {
+	level := log.GetLevel()
+	defer func() {
+		log.SetLevel(level)
+		log.SetSubsystemLevel(log.SubsystemRoutes, -1)
+		for _, trace := range log.GetTraces() {
+			log.RemoveTrace(trace.ID)
+		}
+	}()
+	router := httprouter.New()
+	AddLogLevel(router)
+	warn := log.LevelWarn
+
+	tests := []struct {
+		name   string
+		method string
+		target string
+		// the id of the last trace replaces %d in the target
+		useTrace       bool
+		wantCode       int
+		wantLevel      int32
+		wantSubsystem  *int32
+		wantTraces     int
+		wantTraceLevel int32
+	}{
+		{name: "get", method: http.MethodGet, target: "/debug/loglevel", wantCode: http.StatusOK, wantLevel: level},
+		{name: "set the global level", method: http.MethodPut, target: "/debug/loglevel?level=debug", wantCode: http.StatusOK, wantLevel: log.LevelDebug},
+		{name: "set the global level by number", method: http.MethodPut, target: "/debug/loglevel?level=7", wantCode: http.StatusOK, wantLevel: 7},
+		{name: "invalid level", method: http.MethodPut, target: "/debug/loglevel?level=loud", wantCode: http.StatusBadRequest},
+		{name: "negative level", method: http.MethodPut, target: "/debug/loglevel?level=-3", wantCode: http.StatusBadRequest},
+		{name: "nothing to set", method: http.MethodPut, target: "/debug/loglevel", wantCode: http.StatusBadRequest},
+		{name: "set the subsystem level", method: http.MethodPut, target: "/debug/loglevel?subsystem=routes&level=warn", wantCode: http.StatusOK, wantLevel: 7, wantSubsystem: &warn},
+		{name: "unknown subsystem", method: http.MethodPut, target: "/debug/loglevel?subsystem=gpu&level=warn", wantCode: http.StatusBadRequest},
+		{name: "subsystem follows the global level again", method: http.MethodPut, target: "/debug/loglevel?subsystem=routes", wantCode: http.StatusOK, wantLevel: 7},
+		{name: "invalid duration of the trace", method: http.MethodPut, target: "/debug/loglevel?node=n1&duration=soon", wantCode: http.StatusBadRequest},
+		{name: "negative duration of the trace", method: http.MethodPut, target: "/debug/loglevel?node=n1&duration=-1m", wantCode: http.StatusBadRequest},
+		{name: "trace of the pod", method: http.MethodPut, target: "/debug/loglevel?pod=default/p1&level=info&duration=1m", wantCode: http.StatusCreated, wantTraceLevel: log.LevelInfo},
+		{name: "trace is listed", method: http.MethodGet, target: "/debug/loglevel", wantCode: http.StatusOK, wantLevel: 7, wantTraces: 1},
+		{name: "invalid trace id", method: http.MethodDelete, target: "/debug/loglevel/traces/last", wantCode: http.StatusBadRequest},
+		{name: "delete the trace", method: http.MethodDelete, target: "/debug/loglevel/traces/%d", useTrace: true, wantCode: http.StatusOK, wantLevel: 7},
+		{name: "trace is already deleted", method: http.MethodDelete, target: "/debug/loglevel/traces/%d", useTrace: true, wantCode: http.StatusNotFound},
+	}
+
+	var lastTrace int64
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			target := test.target
+			if test.useTrace {
+				target = fmt.Sprintf(target, lastTrace)
+			}
+			code, status, trace := doLogLevel(t, router, test.method, target)
+			if code != test.wantCode {
+				t.Fatalf("expected status %d, got %d", test.wantCode, code)
+			}
+			switch code {
+			case http.StatusOK:
+				if status.Level != test.wantLevel {
+					t.Errorf("expected level %d, got %d", test.wantLevel, status.Level)
+				}
+				subsystem, found := status.Subsystems[log.SubsystemRoutes]
+				if !found {
+					t.Fatalf("expected the subsystem %s in %v", log.SubsystemRoutes, status.Subsystems)
+				}
+				if (subsystem == nil) != (test.wantSubsystem == nil) || (subsystem != nil && *subsystem != *test.wantSubsystem) {
+					t.Errorf("expected the subsystem level %v, got %v", test.wantSubsystem, subsystem)
+				}
+				if len(status.Traces) != test.wantTraces {
+					t.Errorf("expected %d traces, got %v", test.wantTraces, status.Traces)
+				}
+			case http.StatusCreated:
+				if trace.Pod != "default/p1" || trace.Level != test.wantTraceLevel {
+					t.Errorf("expected the trace of default/p1 at level %d, got %+v", test.wantTraceLevel, trace)
+				}
+				lastTrace = trace.ID
+			}
+		})
+	}
+}
@@ -7,22 +7,37 @@ import (
 	"github.com/AliyunContainerService/gpushare-scheduler-extender/pkg/log"
 	"io"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/julienschmidt/httprouter"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 
+	"github.com/AliyunContainerService/gpushare-scheduler-extender/pkg/cache"
+	"github.com/AliyunContainerService/gpushare-scheduler-extender/pkg/metrics"
 	"github.com/AliyunContainerService/gpushare-scheduler-extender/pkg/scheduler"
+	"github.com/AliyunContainerService/gpushare-scheduler-extender/pkg/tracing"
 
+	"k8s.io/apimachinery/pkg/labels"
 	schedulerapi "k8s.io/kube-scheduler/extender/v1"
 )
 
+var routesLog = log.Named(log.SubsystemRoutes)
+
 const (
 	versionPath       = "/version"
 	apiPrefix         = "/gpushare-scheduler"
 	bindPrefix        = apiPrefix + "/bind"
 	predicatesPrefix  = apiPrefix + "/filter"
+	metricsPath       = "/metrics"
 	inspectPrefix     = apiPrefix + "/inspect/:nodename"
 	inspectListPrefix = apiPrefix + "/inspect"
+	simulatePrefix    = apiPrefix + "/simulate"
+	capacityPrefix    = apiPrefix + "/capacity"
+	snapshotPrefix    = apiPrefix + "/snapshot"
+	podPrefix         = apiPrefix + "/pods/:namespace/:name"
+	podListPrefix     = apiPrefix + "/pods/:namespace"
+	podListAllPrefix  = apiPrefix + "/pods"
 )
 
 var (
@@ -37,28 +52,29 @@ func checkBody(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func InspectRoute(inspect *scheduler.Inspect) httprouter.Handle {
+// PodRoute returns the gpu allocation of the pod, or of the pods in the namespace if the name is not given
+func PodRoute(inspect *scheduler.Inspect) httprouter.Handle {
 	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-		result := inspect.Handler(ps.ByName("nodename"))
+		namespace, name := ps.ByName("namespace"), ps.ByName("name")
+		if len(name) == 0 {
+			writeJSON(w, http.StatusOK, inspect.PodsHandler(namespace))
+			return
+		}
 
-		if resultBody, err := json.Marshal(result); err != nil {
-			// panic(err)
-			log.V(3).Info("warn: Failed due to %v", err)
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusInternalServerError)
-			errMsg := fmt.Sprintf("{'error':'%s'}", err.Error())
-			w.Write([]byte(errMsg))
-		} else {
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusOK)
-			w.Write(resultBody)
+		allocation := inspect.PodHandler(namespace, name)
+		if allocation == nil {
+			writeError(w, http.StatusNotFound, fmt.Errorf("pod %s/%s has no gpu memory allocated", namespace, name))
+			return
 		}
+		writeJSON(w, http.StatusOK, allocation)
 	}
 }
 
 func PredicateRoute(predicate *scheduler.Predicate) httprouter.Handle {
 	return func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
 		checkBody(w, r)
+		ctx, span := tracing.StartServerSpan(r, "PredicateRoute")
+		defer span.End()
 
 		// mu.RLock()
 		// defer mu.RUnlock()
@@ -70,26 +86,29 @@ func PredicateRoute(predicate *scheduler.Predicate) httprouter.Handle {
 		var extenderFilterResult *schedulerapi.ExtenderFilterResult
 
 		if err := json.NewDecoder(body).Decode(&extenderArgs); err != nil {
-			log.V(3).Info("warn: failed to parse request due to error %v", err)
+			routesLog.V(3).Warn("failed to parse request", log.Err(err))
 			extenderFilterResult = &schedulerapi.ExtenderFilterResult{
 				Nodes:       nil,
 				FailedNodes: nil,
 				Error:       err.Error(),
 			}
 		} else {
-			log.V(90).Info("debug: gpusharingfilter ExtenderArgs =%v", extenderArgs)
-			extenderFilterResult = predicate.Handler(&extenderArgs)
+			routesLog.V(90).Debug("gpusharingfilter ExtenderArgs", log.Any("extenderArgs", extenderArgs))
+			if extenderArgs.Pod != nil {
+				span.SetAttributes(tracing.Pod(extenderArgs.Pod.Name, extenderArgs.Pod.Namespace)...)
+			}
+			extenderFilterResult = predicate.Handler(ctx, &extenderArgs)
 		}
 
 		if resultBody, err := json.Marshal(extenderFilterResult); err != nil {
 			// panic(err)
-			log.V(3).Info("warn: Failed due to %v", err)
+			routesLog.V(3).Warn("failed to handle the request", log.Err(err))
 			w.Header().Set("Content-Type", "application/json")
 			w.WriteHeader(http.StatusInternalServerError)
 			errMsg := fmt.Sprintf("{'error':'%s'}", err.Error())
 			w.Write([]byte(errMsg))
 		} else {
-			log.V(100).Info("predicate: %s,  extenderFilterResult = %s ", predicate.Name, resultBody)
+			routesLog.V(100).Debug("extenderFilterResult", log.String("predicate", predicate.Name), log.String("result", string(resultBody)))
 			w.Header().Set("Content-Type", "application/json")
 			w.WriteHeader(http.StatusOK)
 			w.Write(resultBody)
@@ -100,6 +119,8 @@ func PredicateRoute(predicate *scheduler.Predicate) httprouter.Handle {
 func BindRoute(bind *scheduler.Bind) httprouter.Handle {
 	return func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
 		checkBody(w, r)
+		ctx, span := tracing.StartServerSpan(r, "BindRoute")
+		defer span.End()
 
 		// mu.Lock()
 		// defer mu.Unlock()
@@ -116,8 +137,10 @@ func BindRoute(bind *scheduler.Bind) httprouter.Handle {
 			}
 			failed = true
 		} else {
-			log.V(10).Info("debug: gpusharingBind ExtenderArgs =%v", extenderBindingArgs)
-			extenderBindingResult = bind.Handler(extenderBindingArgs)
+			routesLog.V(10).Debug("gpusharingBind ExtenderArgs", log.Pod(extenderBindingArgs.PodName), log.Namespace(extenderBindingArgs.PodNamespace), log.Node(extenderBindingArgs.Node))
+			span.SetAttributes(append(tracing.Pod(extenderBindingArgs.PodName, extenderBindingArgs.PodNamespace),
+				tracing.Node(extenderBindingArgs.Node))...)
+			extenderBindingResult = bind.Handler(ctx, extenderBindingArgs)
 		}
 
 		if len(extenderBindingResult.Error) > 0 {
@@ -125,14 +148,14 @@ func BindRoute(bind *scheduler.Bind) httprouter.Handle {
 		}
 
 		if resultBody, err := json.Marshal(extenderBindingResult); err != nil {
-			log.V(3).Info("warn: Failed due to %v", err)
+			routesLog.V(3).Warn("failed to handle the request", log.Err(err))
 			// panic(err)
 			w.Header().Set("Content-Type", "application/json")
 			w.WriteHeader(http.StatusInternalServerError)
 			errMsg := fmt.Sprintf("{'error':'%s'}", err.Error())
 			w.Write([]byte(errMsg))
 		} else {
-			log.V(3).Info("info: extenderBindingResult = %s", resultBody)
+			routesLog.V(3).Info("extenderBindingResult", log.String("result", string(resultBody)))
 			w.Header().Set("Content-Type", "application/json")
 			if failed {
 				w.WriteHeader(http.StatusInternalServerError)
@@ -145,20 +168,119 @@ func BindRoute(bind *scheduler.Bind) httprouter.Handle {
 	}
 }
 
+func SimulateRoute(simulate *scheduler.Simulate) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		checkBody(w, r)
+
+		var args scheduler.SimulateArgs
+		if err := json.NewDecoder(r.Body).Decode(&args); err != nil {
+			routesLog.V(3).Warn("failed to parse request", log.Err(err))
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		result := simulate.Handler(&args)
+		if len(result.Error) > 0 {
+			writeJSON(w, http.StatusBadRequest, result)
+			return
+		}
+		writeJSON(w, http.StatusOK, result)
+	}
+}
+
+// CapacityRoute handles the query ?size=<gpu memory>&size=<gpu memory>&nodeSelector=<label selector>
+func CapacityRoute(capacity *scheduler.Capacity) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		query := r.URL.Query()
+		if len(query["size"]) == 0 {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("at least one size should be specified"))
+			return
+		}
+
+		sizes := []uint{}
+		for _, s := range query["size"] {
+			size, err := strconv.ParseUint(s, 10, 32)
+			if err != nil || size == 0 {
+				writeError(w, http.StatusBadRequest, fmt.Errorf("invalid size %q, it should be a positive gpu memory", s))
+				return
+			}
+			sizes = append(sizes, uint(size))
+		}
+
+		selector := labels.Everything()
+		if s := query.Get("nodeSelector"); len(s) > 0 {
+			var err error
+			selector, err = labels.Parse(s)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, fmt.Errorf("invalid nodeSelector %q: %v", s, err))
+				return
+			}
+		}
+
+		result := capacity.Handler(sizes, selector)
+		if len(result.Error) > 0 {
+			writeJSON(w, http.StatusInternalServerError, result)
+			return
+		}
+		writeJSON(w, http.StatusOK, result)
+	}
+}
+
+func SnapshotRoute(c *cache.SchedulerCache) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		snapshot, err := c.Snapshot()
+		if err != nil {
+			routesLog.V(3).Warn("failed to snapshot the cache", log.Err(err))
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, snapshot)
+	}
+}
+
 func VersionRoute(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
 	fmt.Fprint(w, fmt.Sprint(version))
 }
 
+// AddMetrics serves the metrics of the extender, and the allocation of the cache
+func AddMetrics(router *httprouter.Router, c *cache.SchedulerCache) {
+	metrics.Registry.MustRegister(cache.NewCollector(c))
+	handler := promhttp.HandlerFor(metrics.Registry, promhttp.HandlerOpts{})
+	router.GET(metricsPath, func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		handler.ServeHTTP(w, r)
+	})
+}
+
 func AddVersion(router *httprouter.Router) {
 	router.GET(versionPath, DebugLogging(VersionRoute, versionPath))
 }
 
 func DebugLogging(h httprouter.Handle, path string) httprouter.Handle {
 	return func(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
-		log.V(90).Info("path: %s, request body = %s", path, r.Body)
+		routesLog.V(90).Debug("request", log.String("path", path), log.String("method", r.Method))
 		startTime := time.Now()
-		h(w, r, p)
-		log.V(90).Info("path: %s, response: %v, cost_time: %v", path, w, time.Now().Sub(startTime))
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		h(sw, r, p)
+		metrics.HTTPRequestDuration.WithLabelValues(path, strconv.Itoa(sw.status)).Observe(time.Since(startTime).Seconds())
+		routesLog.V(90).Debug("response", log.String("path", path), log.Int("code", sw.status), log.Duration("cost_time", time.Now().Sub(startTime)))
+	}
+}
+
+// statusWriter records the status code for the metrics
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// Flush keeps the streaming responses working through the wrapper
+func (w *statusWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
 	}
 }
 
@@ -169,13 +291,45 @@ func AddPredicate(router *httprouter.Router, predicate *scheduler.Predicate) {
 
 func AddBind(router *httprouter.Router, bind *scheduler.Bind) {
 	if handle, _, _ := router.Lookup("POST", bindPrefix); handle != nil {
-		log.V(3).Info("warning: AddBind was called more then once!")
+		routesLog.V(3).Warn("AddBind was called more then once!")
 	} else {
 		router.POST(bindPrefix, DebugLogging(BindRoute(bind), bindPrefix))
 	}
 }
 
-func AddInspect(router *httprouter.Router, inspect *scheduler.Inspect) {
-	router.GET(inspectPrefix, DebugLogging(InspectRoute(inspect), inspectPrefix))
-	router.GET(inspectListPrefix, DebugLogging(InspectRoute(inspect), inspectListPrefix))
+func AddPods(router *httprouter.Router, inspect *scheduler.Inspect) {
+	router.GET(podPrefix, DebugLogging(PodRoute(inspect), podPrefix))
+	router.GET(podListPrefix, DebugLogging(PodRoute(inspect), podListPrefix))
+	router.GET(podListAllPrefix, DebugLogging(PodRoute(inspect), podListAllPrefix))
+}
+
+func AddSimulate(router *httprouter.Router, simulate *scheduler.Simulate) {
+	router.POST(simulatePrefix, DebugLogging(SimulateRoute(simulate), simulatePrefix))
+}
+
+func AddCapacity(router *httprouter.Router, capacity *scheduler.Capacity) {
+	router.GET(capacityPrefix, DebugLogging(CapacityRoute(capacity), capacityPrefix))
+}
+
+func AddSnapshot(router *httprouter.Router, c *cache.SchedulerCache) {
+	router.GET(snapshotPrefix, DebugLogging(SnapshotRoute(c), snapshotPrefix))
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		routesLog.V(3).Warn("failed to handle the request", log.Err(err))
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(body)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	body, _ := json.Marshal(map[string]string{"error": err.Error()})
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(body)
 }
@@ -0,0 +1,230 @@
+package routes
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	k8stesting "k8s.io/client-go/testing"
+	clientgocache "k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+	schedulerapi "k8s.io/kube-scheduler/extender/v1"
+
+	"github.com/AliyunContainerService/gpushare-scheduler-extender/pkg/cache"
+	"github.com/AliyunContainerService/gpushare-scheduler-extender/pkg/log"
+	"github.com/AliyunContainerService/gpushare-scheduler-extender/pkg/scheduler"
+)
+
+func init() {
+	log.NewLoggerWithLevel(0)
+}
+
+// newTestCache has the node n1 with 2 devices of 8 gpu memory
+func newTestCache(t *testing.T) *cache.SchedulerCache {
+	nodes := clientgocache.NewIndexer(clientgocache.MetaNamespaceKeyFunc, clientgocache.Indexers{})
+	err := nodes.Add(&v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "n1"},
+		Status: v1.NodeStatus{Capacity: v1.ResourceList{
+			"aliyun.com/gpu-mem":   resource.MustParse("16"),
+			"aliyun.com/gpu-count": resource.MustParse("2"),
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pods := clientgocache.NewIndexer(clientgocache.MetaNamespaceKeyFunc, clientgocache.Indexers{clientgocache.NamespaceIndex: clientgocache.MetaNamespaceIndexFunc})
+	configMaps := clientgocache.NewIndexer(clientgocache.MetaNamespaceKeyFunc, clientgocache.Indexers{clientgocache.NamespaceIndex: clientgocache.MetaNamespaceIndexFunc})
+	c := cache.NewSchedulerCache(corelisters.NewNodeLister(nodes), corelisters.NewPodLister(pods), corelisters.NewConfigMapLister(configMaps))
+	if err := c.BuildCache(); err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func newTestPod() *v1.Pod {
+	return &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "p1", Namespace: "default", UID: types.UID("uid-p1")},
+		Spec: v1.PodSpec{Containers: []v1.Container{{
+			Name: "main",
+			Resources: v1.ResourceRequirements{Limits: v1.ResourceList{
+				"aliyun.com/gpu-mem": resource.MustParse("4"),
+			}},
+		}}},
+	}
+}
+
+// recordSpans records the spans of the extender until the test ends
+func recordSpans(t *testing.T) *tracetest.SpanRecorder {
+	recorder := tracetest.NewSpanRecorder()
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	t.Cleanup(func() {
+		otel.SetTracerProvider(previous)
+	})
+	return recorder
+}
+
+func post(t *testing.T, router *httprouter.Router, path string, args interface{}) *httptest.ResponseRecorder {
+	body, err := json.Marshal(args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+// spansByName returns the ended spans, the parent of each one must be recorded too
+func spansByName(recorder *tracetest.SpanRecorder) map[string]sdktrace.ReadOnlySpan {
+	spans := map[string]sdktrace.ReadOnlySpan{}
+	for _, span := range recorder.Ended() {
+		spans[span.Name()] = span
+	}
+	return spans
+}
+
+func checkSpan(t *testing.T, spans map[string]sdktrace.ReadOnlySpan, name, parent string, attrs ...attribute.KeyValue) {
+	span, found := spans[name]
+	if !found {
+		t.Errorf("expected the span %s, got none", name)
+		return
+	}
+	if len(parent) > 0 {
+		if p, found := spans[parent]; !found || span.Parent().SpanID() != p.SpanContext().SpanID() {
+			t.Errorf("expected the span %s to be a child of %s", name, parent)
+		}
+	} else if span.Parent().IsValid() {
+		t.Errorf("expected the span %s to be a root span", name)
+	}
+	got := map[attribute.Key]attribute.Value{}
+	for _, attr := range span.Attributes() {
+		got[attr.Key] = attr.Value
+	}
+	for _, attr := range attrs {
+		if value, found := got[attr.Key]; !found || value != attr.Value {
+			t.Errorf("expected the attribute %s=%s of the span %s, got %s", attr.Key, attr.Value.Emit(), name, value.Emit())
+		}
+	}
+}
+
+func TestPredicateSpans(t *testing.T) {
+	recorder := recordSpans(t)
+	router := httprouter.New()
+	AddPredicate(router, scheduler.NewGPUsharePredicate(fake.NewSimpleClientset(), newTestCache(t), &record.FakeRecorder{}))
+
+	w := post(t, router, predicatesPrefix, &schedulerapi.ExtenderArgs{Pod: newTestPod(), NodeNames: &[]string{"n1"}})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+
+	spans := spansByName(recorder)
+	checkSpan(t, spans, "PredicateRoute", "",
+		attribute.String("pod", "p1"), attribute.String("namespace", "default"), attribute.String("http.target", predicatesPrefix))
+	checkSpan(t, spans, "checkNode", "PredicateRoute", attribute.String("node", "n1"))
+}
+
+func TestBindSpans(t *testing.T) {
+	recorder := recordSpans(t)
+	clientset := fake.NewSimpleClientset(newTestPod())
+	clientset.PrependReactor("create", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return action.GetSubresource() == "binding", nil, nil
+	})
+	router := httprouter.New()
+	AddBind(router, scheduler.NewGPUShareBind(clientset, newTestCache(t), &record.FakeRecorder{}))
+
+	w := post(t, router, bindPrefix, &schedulerapi.ExtenderBindingArgs{PodName: "p1", PodNamespace: "default", PodUID: "uid-p1", Node: "n1"})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+
+	spans := spansByName(recorder)
+	pod := []attribute.KeyValue{attribute.String("pod", "p1"), attribute.String("namespace", "default")}
+	checkSpan(t, spans, "BindRoute", "", append(pod, attribute.String("node", "n1"))...)
+	checkSpan(t, spans, "getPod", "BindRoute", pod...)
+	checkSpan(t, spans, "Allocate", "BindRoute", append(pod, attribute.String("node", "n1"), attribute.Int("devID", 0))...)
+	checkSpan(t, spans, "LockNode", "Allocate", attribute.String("node", "n1"))
+	checkSpan(t, spans, "PatchPod", "Allocate", pod...)
+	checkSpan(t, spans, "BindPod", "Allocate", append(pod, attribute.String("node", "n1"))...)
+}
+
+func TestPodRoutes(t *testing.T) {
+	c := newTestCache(t)
+	for _, name := range []string{"p2", "p1"} {
+		pod := newTestPod()
+		pod.Name, pod.UID = name, types.UID("uid-"+name)
+		pod.Spec.NodeName = "n1"
+		pod.Status.Phase = v1.PodRunning
+		pod.Annotations = map[string]string{"ALIYUN_COM_GPU_MEM_IDX": "1", "ALIYUN_COM_GPU_MEM_ASSIGNED": "true"}
+		if err := c.AddOrUpdatePod(pod); err != nil {
+			t.Fatal(err)
+		}
+	}
+	router := httprouter.New()
+	AddPods(router, scheduler.NewGPUShareInspect(c))
+
+	tests := []struct {
+		name     string
+		path     string
+		wantCode int
+		// single is true if the result is one pod instead of a list
+		single bool
+		// wantPods are the names of the pods in the result
+		wantPods []string
+	}{
+		{name: "pod", path: apiPrefix + "/pods/default/p1", wantCode: http.StatusOK, single: true, wantPods: []string{"p1"}},
+		{name: "unknown pod", path: apiPrefix + "/pods/default/unknown", wantCode: http.StatusNotFound},
+		{name: "namespace", path: apiPrefix + "/pods/default", wantCode: http.StatusOK, wantPods: []string{"p1", "p2"}},
+		{name: "namespace without pods", path: apiPrefix + "/pods/other", wantCode: http.StatusOK, wantPods: []string{}},
+		{name: "all the namespaces", path: apiPrefix + "/pods", wantCode: http.StatusOK, wantPods: []string{"p1", "p2"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, test.path, nil))
+			if w.Code != test.wantCode {
+				t.Fatalf("expected status %d, got %d: %s", test.wantCode, w.Code, w.Body.String())
+			}
+			if test.wantPods == nil {
+				return
+			}
+			allocations := []*scheduler.PodAllocation{}
+			if test.single {
+				allocation := &scheduler.PodAllocation{}
+				if err := json.Unmarshal(w.Body.Bytes(), allocation); err != nil {
+					t.Fatal(err)
+				}
+				allocations = append(allocations, allocation)
+			} else if err := json.Unmarshal(w.Body.Bytes(), &allocations); err != nil {
+				t.Fatal(err)
+			}
+			got := []string{}
+			for _, allocation := range allocations {
+				got = append(got, allocation.Name)
+				if allocation.Node != "n1" || allocation.Device != 1 || allocation.GPUMemory != 4 {
+					t.Errorf("expected %s with 4 gpu memory on n1/1, got %+v", allocation.Name, allocation)
+				}
+			}
+			if !reflect.DeepEqual(got, test.wantPods) {
+				t.Errorf("expected the pods %v, got %v", test.wantPods, got)
+			}
+		})
+	}
+}
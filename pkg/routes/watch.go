@@ -0,0 +1,163 @@
+package routes
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+
+	"github.com/AliyunContainerService/gpushare-scheduler-extender/pkg/cache"
+	"github.com/AliyunContainerService/gpushare-scheduler-extender/pkg/log"
+)
+
+const (
+	watchPrefix = apiPrefix + "/watch"
+
+	formatSSE   = "sse"
+	formatJSONL = "jsonl"
+
+	messageSnapshot  = "Snapshot"
+	messageHeartbeat = "Heartbeat"
+
+	// watchHeartbeatInterval keeps the idle streams open through the proxies
+	watchHeartbeatInterval = 30 * time.Second
+)
+
+// watchMessage is the snapshot and the heartbeat in the stream, the changes are sent as they are
+type watchMessage struct {
+	Type     string          `json:"type"`
+	Epoch    string          `json:"epoch"`
+	Seq      uint64          `json:"seq"`
+	Snapshot *cache.Snapshot `json:"snapshot,omitempty"`
+}
+
+// WatchRoute streams the changes of the cache as Server-Sent Events, or JSON lines with format=jsonl.
+// The stream starts with a snapshot, or with the missed changes if it's resumed by the since query
+// or the Last-Event-ID header, which are <epoch>:<seq>. A resume of another epoch starts with a snapshot.
+// The stream ends when the client falls behind, and it can be resumed.
+func WatchRoute(c *cache.SchedulerCache) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			writeError(w, http.StatusInternalServerError, fmt.Errorf("streaming is not supported"))
+			return
+		}
+
+		format := r.URL.Query().Get("format")
+		if len(format) == 0 {
+			format = formatJSONL
+			if strings.Contains(r.Header.Get("Accept"), "text/event-stream") {
+				format = formatSSE
+			}
+		}
+		if format != formatSSE && format != formatJSONL {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid format %q, it should be sse or jsonl", format))
+			return
+		}
+
+		s := r.URL.Query().Get("since")
+		if len(s) == 0 {
+			s = r.Header.Get("Last-Event-ID")
+		}
+		epoch, since, err := parseEventID(s)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		watch, err := c.Watch(epoch, since)
+		if err != nil {
+			routesLog.V(3).Warn("failed to watch the cache", log.Err(err))
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		defer watch.Stop()
+
+		if format == formatSSE {
+			w.Header().Set("Content-Type", "text/event-stream")
+		} else {
+			w.Header().Set("Content-Type", "application/x-ndjson")
+		}
+		w.Header().Set("Cache-Control", "no-cache")
+		w.WriteHeader(http.StatusOK)
+
+		seq := watch.Seq
+		send := func(event string, id uint64, v interface{}) error {
+			data, err := json.Marshal(v)
+			if err != nil {
+				return err
+			}
+			if format == formatSSE {
+				_, err = fmt.Fprintf(w, "id: %s:%d\nevent: %s\ndata: %s\n\n", watch.Epoch, id, event, data)
+			} else {
+				_, err = fmt.Fprintf(w, "%s\n", data)
+			}
+			return err
+		}
+
+		if watch.Snapshot != nil {
+			if err := send(messageSnapshot, seq, &watchMessage{Type: messageSnapshot, Epoch: watch.Epoch, Seq: seq, Snapshot: watch.Snapshot}); err != nil {
+				return
+			}
+		}
+		for _, change := range watch.Backlog {
+			if err := send(string(change.Type), change.Seq, change); err != nil {
+				return
+			}
+			seq = change.Seq
+		}
+		flusher.Flush()
+
+		heartbeat := time.NewTicker(watchHeartbeatInterval)
+		defer heartbeat.Stop()
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case change, ok := <-watch.Changes:
+				if !ok {
+					routesLog.V(3).Info("close the watch stream", log.Int("seq", int(seq)))
+					return
+				}
+				if err := send(string(change.Type), change.Seq, change); err != nil {
+					return
+				}
+				seq = change.Seq
+			case <-heartbeat.C:
+				var err error
+				if format == formatSSE {
+					_, err = fmt.Fprint(w, ": heartbeat\n\n")
+				} else {
+					err = send(messageHeartbeat, seq, &watchMessage{Type: messageHeartbeat, Epoch: watch.Epoch, Seq: seq})
+				}
+				if err != nil {
+					return
+				}
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+// parseEventID parses the <epoch>:<seq> to resume the watch from, none if it's empty
+func parseEventID(id string) (epoch string, seq uint64, err error) {
+	if len(id) == 0 {
+		return "", 0, nil
+	}
+	epoch, s, found := strings.Cut(id, ":")
+	if found && len(epoch) > 0 {
+		seq, err = strconv.ParseUint(s, 10, 64)
+	}
+	if !found || len(epoch) == 0 || err != nil {
+		return "", 0, fmt.Errorf("invalid since %q, it should be <epoch>:<seq> of a change", id)
+	}
+	return epoch, seq, nil
+}
+
+func AddWatch(router *httprouter.Router, c *cache.SchedulerCache) {
+	router.GET(watchPrefix, DebugLogging(WatchRoute(c), watchPrefix))
+}
@@ -0,0 +1,321 @@
+package routes
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+
+	"github.com/AliyunContainerService/gpushare-scheduler-extender/pkg/cache"
+)
+
+// streamRecorder records the messages of a stream, each write of the route is a message
+type streamRecorder struct {
+	header http.Header
+	code   int
+	// messages receives the writes
+	messages chan string
+	// gate blocks the writes until it's closed, if it's set
+	gate chan struct{}
+	// blocked is closed when the first write waits for the gate
+	blocked chan struct{}
+}
+
+func newStreamRecorder(gated bool) *streamRecorder {
+	w := &streamRecorder{header: http.Header{}, messages: make(chan string, 1024), blocked: make(chan struct{})}
+	if gated {
+		w.gate = make(chan struct{})
+	}
+	return w
+}
+
+func (w *streamRecorder) Header() http.Header {
+	return w.header
+}
+
+func (w *streamRecorder) WriteHeader(code int) {
+	w.code = code
+}
+
+func (w *streamRecorder) Write(p []byte) (int, error) {
+	if w.gate != nil {
+		select {
+		case <-w.blocked:
+		default:
+			close(w.blocked)
+		}
+		<-w.gate
+	}
+	w.messages <- string(p)
+	return len(p), nil
+}
+
+func (w *streamRecorder) Flush() {}
+
+// next returns the next message of the stream
+func (w *streamRecorder) next(t *testing.T) string {
+	select {
+	case message := <-w.messages:
+		return message
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected a message in the stream")
+		return ""
+	}
+}
+
+// serveWatch serves the watch until the returned cancel is called, the returned channel is closed when it returns
+func serveWatch(c *cache.SchedulerCache, w *streamRecorder, target string, header http.Header) (context.CancelFunc, chan struct{}) {
+	ctx, cancel := context.WithCancel(context.Background())
+	r := httptest.NewRequest(http.MethodGet, target, nil).WithContext(ctx)
+	for k, v := range header {
+		r.Header[k] = v
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		WatchRoute(c)(w, r, httprouter.Params{})
+	}()
+	return cancel, done
+}
+
+func waitDone(t *testing.T, done chan struct{}) {
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected the stream to end")
+	}
+}
+
+// currentWatch returns the epoch and the seq of the cache
+func currentWatch(t *testing.T, c *cache.SchedulerCache) (string, uint64) {
+	// the nodeInfos are built with their changes by the snapshot of the first watch
+	var watch *cache.Watch
+	for i := 0; i < 2; i++ {
+		var err error
+		if watch, err = c.Watch("", 0); err != nil {
+			t.Fatal(err)
+		}
+		watch.Stop()
+	}
+	return watch.Epoch, watch.Seq
+}
+
+// parseSSE splits the event of the stream into its id, its name and its data
+func parseSSE(t *testing.T, message string) (id, event string, data []byte) {
+	if !strings.HasSuffix(message, "\n\n") {
+		t.Fatalf("expected the event to end with an empty line, got %q", message)
+	}
+	lines := strings.Split(strings.TrimSuffix(message, "\n\n"), "\n")
+	if len(lines) != 3 || !strings.HasPrefix(lines[0], "id: ") || !strings.HasPrefix(lines[1], "event: ") || !strings.HasPrefix(lines[2], "data: ") {
+		t.Fatalf("expected the id, event and data lines, got %q", message)
+	}
+	return strings.TrimPrefix(lines[0], "id: "), strings.TrimPrefix(lines[1], "event: "), []byte(strings.TrimPrefix(lines[2], "data: "))
+}
+
+// parseJSONL checks the message is one line of JSON
+func parseJSONL(t *testing.T, message string) []byte {
+	if !strings.HasSuffix(message, "\n") || strings.Count(message, "\n") != 1 {
+		t.Fatalf("expected one line, got %q", message)
+	}
+	return []byte(message)
+}
+
+func TestWatchRouteFraming(t *testing.T) {
+	tests := []struct {
+		name            string
+		target          string
+		accept          string
+		wantContentType string
+	}{
+		{name: "jsonl by default", target: watchPrefix, wantContentType: "application/x-ndjson"},
+		{name: "sse by format", target: watchPrefix + "?format=sse", wantContentType: "text/event-stream"},
+		{name: "sse by accept", target: watchPrefix, accept: "text/event-stream", wantContentType: "text/event-stream"},
+		{name: "format over accept", target: watchPrefix + "?format=jsonl", accept: "text/event-stream", wantContentType: "application/x-ndjson"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := newTestCache(t)
+			epoch, seq := currentWatch(t, c)
+			w := newStreamRecorder(false)
+			header := http.Header{}
+			if len(test.accept) > 0 {
+				header.Set("Accept", test.accept)
+			}
+			cancel, done := serveWatch(c, w, test.target, header)
+			defer waitDone(t, done)
+			defer cancel()
+
+			snapshotMessage := w.next(t)
+			c.UpdateUnhealthyGPUs("n1", map[int]bool{1: true})
+			changeMessage := w.next(t)
+
+			if w.code != http.StatusOK {
+				t.Fatalf("expected status %d, got %d", http.StatusOK, w.code)
+			}
+			if got := w.header.Get("Content-Type"); got != test.wantContentType {
+				t.Errorf("expected the content type %q, got %q", test.wantContentType, got)
+			}
+
+			var snapshotData, changeData []byte
+			if test.wantContentType == "text/event-stream" {
+				var id, event string
+				id, event, snapshotData = parseSSE(t, snapshotMessage)
+				if wantID := fmt.Sprintf("%s:%d", epoch, seq); id != wantID || event != messageSnapshot {
+					t.Errorf("expected the snapshot event of id %s, got %s %s", wantID, event, id)
+				}
+				id, event, changeData = parseSSE(t, changeMessage)
+				if wantID := fmt.Sprintf("%s:%d", epoch, seq+1); id != wantID || event != string(cache.ChangeUnhealthy) {
+					t.Errorf("expected the unhealthy event of id %s, got %s %s", wantID, event, id)
+				}
+			} else {
+				snapshotData = parseJSONL(t, snapshotMessage)
+				changeData = parseJSONL(t, changeMessage)
+			}
+
+			var snapshot watchMessage
+			if err := json.Unmarshal(snapshotData, &snapshot); err != nil {
+				t.Fatal(err)
+			}
+			if snapshot.Type != messageSnapshot || snapshot.Epoch != epoch || snapshot.Seq != seq || snapshot.Snapshot == nil || len(snapshot.Snapshot.Nodes) != 1 {
+				t.Errorf("expected the snapshot of the node n1 at %s:%d, got %s", epoch, seq, snapshotData)
+			}
+			var change cache.Change
+			if err := json.Unmarshal(changeData, &change); err != nil {
+				t.Fatal(err)
+			}
+			if change.Type != cache.ChangeUnhealthy || change.Epoch != epoch || change.Seq != seq+1 || !reflect.DeepEqual(change.UnhealthyDevices, []int{1}) {
+				t.Errorf("expected the unhealthy device 1 at %s:%d, got %s", epoch, seq+1, changeData)
+			}
+		})
+	}
+}
+
+func TestWatchRouteResume(t *testing.T) {
+	tests := []struct {
+		name string
+		// since and lastEventID are formatted with the epoch and the seq of the cache
+		since       func(epoch string, seq uint64) string
+		lastEventID func(epoch string, seq uint64) string
+		// wantSnapshot is true if the stream starts with a snapshot instead of the missed change
+		wantSnapshot bool
+	}{
+		{name: "new watch", wantSnapshot: true},
+		{name: "resume by since", since: func(epoch string, seq uint64) string { return epoch + ":" + strconv.FormatUint(seq, 10) }},
+		{name: "resume by Last-Event-ID", lastEventID: func(epoch string, seq uint64) string { return epoch + ":" + strconv.FormatUint(seq, 10) }},
+		{name: "since of another epoch", since: func(_ string, seq uint64) string { return "other:" + strconv.FormatUint(seq, 10) }, wantSnapshot: true},
+		{name: "since over Last-Event-ID", since: func(_ string, seq uint64) string { return "other:" + strconv.FormatUint(seq, 10) },
+			lastEventID: func(epoch string, seq uint64) string { return epoch + ":" + strconv.FormatUint(seq, 10) }, wantSnapshot: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := newTestCache(t)
+			epoch, seq := currentWatch(t, c)
+			// the change missed by the client
+			c.UpdateUnhealthyGPUs("n1", map[int]bool{1: true})
+
+			target := watchPrefix
+			if test.since != nil {
+				target += "?since=" + test.since(epoch, seq)
+			}
+			header := http.Header{}
+			if test.lastEventID != nil {
+				header.Set("Last-Event-ID", test.lastEventID(epoch, seq))
+			}
+			w := newStreamRecorder(false)
+			cancel, done := serveWatch(c, w, target, header)
+			defer waitDone(t, done)
+			defer cancel()
+
+			var first struct {
+				Type  string `json:"type"`
+				Epoch string `json:"epoch"`
+				Seq   uint64 `json:"seq"`
+			}
+			if err := json.Unmarshal([]byte(w.next(t)), &first); err != nil {
+				t.Fatal(err)
+			}
+			want := string(cache.ChangeUnhealthy)
+			if test.wantSnapshot {
+				want = messageSnapshot
+			}
+			if first.Type != want || first.Epoch != epoch || first.Seq != seq+1 {
+				t.Errorf("expected %s at %s:%d first, got %s at %s:%d", want, epoch, seq+1, first.Type, first.Epoch, first.Seq)
+			}
+		})
+	}
+}
+
+func TestWatchRouteInvalidRequest(t *testing.T) {
+	router := httprouter.New()
+	AddWatch(router, newTestCache(t))
+
+	tests := []struct {
+		name        string
+		target      string
+		lastEventID string
+	}{
+		{name: "unknown format", target: watchPrefix + "?format=xml"},
+		{name: "since without epoch", target: watchPrefix + "?since=12"},
+		{name: "since with empty epoch", target: watchPrefix + "?since=:12"},
+		{name: "since with invalid seq", target: watchPrefix + "?since=e:x"},
+		{name: "since with negative seq", target: watchPrefix + "?since=e:-1"},
+		{name: "invalid Last-Event-ID", target: watchPrefix, lastEventID: "e"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, test.target, nil)
+			if len(test.lastEventID) > 0 {
+				r.Header.Set("Last-Event-ID", test.lastEventID)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, r)
+			if w.Code != http.StatusBadRequest {
+				t.Fatalf("expected status %d, got %d: %s", http.StatusBadRequest, w.Code, w.Body.String())
+			}
+			var body map[string]string
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil || len(body["error"]) == 0 {
+				t.Errorf("expected an error in the body, got %s", w.Body.String())
+			}
+		})
+	}
+}
+
+func TestWatchRouteClosesLaggingStream(t *testing.T) {
+	c := newTestCache(t)
+	w := newStreamRecorder(true)
+	cancel, done := serveWatch(c, w, watchPrefix, http.Header{})
+	defer cancel()
+
+	// the stream is stuck writing the snapshot while the changes pile up
+	select {
+	case <-w.blocked:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected the stream to write the snapshot")
+	}
+	for i := 0; i < 300; i++ {
+		c.UpdateUnhealthyGPUs("n1", map[int]bool{i % 2: true})
+	}
+	close(w.gate)
+	waitDone(t, done)
+
+	// the snapshot and the 256 changes which fit in the buffer of the watcher are sent before the stream ends
+	close(w.messages)
+	messages := 0
+	for range w.messages {
+		messages++
+	}
+	if messages != 1+256 {
+		t.Errorf("expected the stream to end after %d messages, got %d", 1+256, messages)
+	}
+}
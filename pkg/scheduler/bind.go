@@ -1,24 +1,81 @@
 package scheduler
 
 import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+
 	"github.com/AliyunContainerService/gpushare-scheduler-extender/pkg/cache"
+	"github.com/AliyunContainerService/gpushare-scheduler-extender/pkg/leader"
+	"github.com/AliyunContainerService/gpushare-scheduler-extender/pkg/metrics"
+	v1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
 	schedulerapi "k8s.io/kube-scheduler/extender/v1"
 )
 
+const (
+	// DefaultBindTimeout limits allocating the GPU and binding a pod
+	DefaultBindTimeout = 30 * time.Second
+
+	// ReasonGPUBindFailed is the event reason when the allocation or the binding of the pod fails
+	ReasonGPUBindFailed = "GPUBindFailed"
+)
+
+var bindTimeout = int64(DefaultBindTimeout)
+
+// SetBindTimeout changes the bind timeout, it can be changed at runtime
+func SetBindTimeout(timeout time.Duration) {
+	atomic.StoreInt64(&bindTimeout, int64(timeout))
+}
+
 // Bind is responsible for binding node and pod
 type Bind struct {
-	Name  string
-	Func  func(podName string, podNamespace string, podUID types.UID, node string, cache *cache.SchedulerCache) error
-	cache *cache.SchedulerCache
+	Name     string
+	Func     func(ctx context.Context, podName string, podNamespace string, podUID types.UID, node string, cache *cache.SchedulerCache) error
+	cache    *cache.SchedulerCache
+	recorder record.EventRecorder
+	// isLeader and withLeadership are the ones of pkg/leader if they are nil
+	isLeader       func() bool
+	withLeadership func(ctx context.Context) (context.Context, context.CancelFunc)
 }
 
 // Handler handles the Bind request
-func (b Bind) Handler(args schedulerapi.ExtenderBindingArgs) *schedulerapi.ExtenderBindingResult {
-	err := b.Func(args.PodName, args.PodNamespace, args.PodUID, args.Node, b.cache)
+func (b Bind) Handler(ctx context.Context, args schedulerapi.ExtenderBindingArgs) *schedulerapi.ExtenderBindingResult {
+	isLeader, withLeadership := b.isLeader, b.withLeadership
+	if isLeader == nil {
+		isLeader = leader.IsLeader
+	}
+	if withLeadership == nil {
+		withLeadership = leader.WithLeadership
+	}
+	if !isLeader() {
+		// the followers only serve the read-only requests, kube-scheduler retries the pod
+		return &schedulerapi.ExtenderBindingResult{
+			Error: fmt.Sprintf("%s is not the leader, the leader is %q", leader.Identity(), leader.Holder()),
+		}
+	}
+
+	startTime := time.Now()
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(atomic.LoadInt64(&bindTimeout)))
+	defer cancel()
+	// the lease may be lost while waiting for the node lock, then the new leader allocates the devices
+	ctx, stop := withLeadership(ctx)
+	defer stop()
+	err := b.Func(ctx, args.PodName, args.PodNamespace, args.PodUID, args.Node, b.cache)
+	if err != nil && !isLeader() {
+		err = metrics.WithReason(metrics.ReasonNotLeader, fmt.Errorf("lost the leadership while binding pod %s in ns %s: %v", args.PodName, args.PodNamespace, err))
+	}
+	metrics.BindDuration.Observe(time.Since(startTime).Seconds())
 	errMsg := ""
 	if err != nil {
 		errMsg = err.Error()
+		metrics.BindFailures.WithLabelValues(metrics.ReasonOf(err)).Inc()
+		// the pod may not be found, so the event refers to it by the args
+		pod := &v1.ObjectReference{Kind: "Pod", Namespace: args.PodNamespace, Name: args.PodName, UID: args.PodUID}
+		b.recorder.Eventf(pod, v1.EventTypeWarning, ReasonGPUBindFailed,
+			"Failed to bind to node %s (%s): %v", args.Node, metrics.ReasonOf(err), err)
 	}
 	return &schedulerapi.ExtenderBindingResult{
 		Error: errMsg,
@@ -0,0 +1,142 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/record"
+	schedulerapi "k8s.io/kube-scheduler/extender/v1"
+
+	"github.com/AliyunContainerService/gpushare-scheduler-extender/pkg/cache"
+)
+
+func TestBindHandlerLeadership(t *testing.T) {
+	tests := []struct {
+		name   string
+		leader bool
+		// loseLease loses the lease while the bind waits for the node lock
+		loseLease bool
+		wantError string
+		// wantActions are the verbs of the API calls to the pods
+		wantActions string
+	}{
+		{name: "follower rejects the bind", wantError: "is not the leader"},
+		{name: "leader binds", leader: true, wantActions: "get patch create"},
+		{name: "leader which loses the lease gives up before patching", leader: true, loseLease: true, wantError: "lost the leadership while binding pod p1", wantActions: "get"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			pod := newGPUPod("p1", 4)
+			clientset := fake.NewSimpleClientset(pod)
+			clientset.PrependReactor("create", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+				return action.GetSubresource() == "binding", nil, nil
+			})
+			leading := test.leader
+			b := NewGPUShareBind(clientset, newTestCache(t, testNode{name: "n1"}), record.NewFakeRecorder(10))
+			b.isLeader = func() bool { return leading }
+			b.withLeadership = func(ctx context.Context) (context.Context, context.CancelFunc) {
+				ctx, cancel := context.WithCancel(ctx)
+				if test.loseLease {
+					leading = false
+					cancel()
+				}
+				return ctx, cancel
+			}
+
+			result := b.Handler(context.Background(), schedulerapi.ExtenderBindingArgs{PodName: "p1", PodNamespace: "default", PodUID: pod.UID, Node: "n1"})
+			if len(test.wantError) == 0 && len(result.Error) > 0 {
+				t.Errorf("expected no error, got %q", result.Error)
+			}
+			if !strings.Contains(result.Error, test.wantError) {
+				t.Errorf("expected error containing %q, got %q", test.wantError, result.Error)
+			}
+			verbs := []string{}
+			for _, action := range clientset.Actions() {
+				verbs = append(verbs, action.GetVerb())
+			}
+			if got := strings.Join(verbs, " "); got != test.wantActions {
+				t.Errorf("expected the actions %q, got %q", test.wantActions, got)
+			}
+		})
+	}
+}
+
+func TestBindHandlerEvents(t *testing.T) {
+	tests := []struct {
+		name string
+		node string
+		// patchConflicts is the number of patches which fail with a conflict
+		patchConflicts int
+		bindError      error
+		// wantEvents are "<type> <reason> <message>", the message may be a prefix
+		wantEvents []string
+	}{
+		{
+			name:       "allocated",
+			node:       "n1",
+			wantEvents: []string{"Normal GPUAllocated Allocated 4 GPU memory on GPU 0 of node n1"},
+		},
+		{
+			name:           "allocated after a conflict",
+			node:           "n1",
+			patchConflicts: 1,
+			wantEvents: []string{
+				"Normal GPUAllocationConflict Retrying the allocation of GPU 0 on node n1 after a conflict",
+				"Normal GPUAllocated Allocated 4 GPU memory on GPU 0 of node n1",
+			},
+		},
+		{
+			name:       "binding fails",
+			node:       "n1",
+			bindError:  errors.New("the node is gone"),
+			wantEvents: []string{"Warning GPUBindFailed Failed to bind to node n1 (bind): the node is gone"},
+		},
+		{
+			name:       "unknown node",
+			node:       "n2",
+			wantEvents: []string{"Warning GPUBindFailed Failed to bind to node n2 (get_node)"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			pod := newGPUPod("p1", 4)
+			clientset := fake.NewSimpleClientset(pod)
+			conflicts := test.patchConflicts
+			clientset.PrependReactor("patch", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+				if conflicts > 0 {
+					conflicts--
+					return true, nil, errors.New(cache.OptimisticLockErrorMsg)
+				}
+				return false, nil, nil
+			})
+			clientset.PrependReactor("create", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+				return action.GetSubresource() == "binding", nil, test.bindError
+			})
+			recorder := record.NewFakeRecorder(10)
+			b := NewGPUShareBind(clientset, newTestCache(t, testNode{name: "n1"}), recorder)
+			b.isLeader = func() bool { return true }
+
+			b.Handler(context.Background(), schedulerapi.ExtenderBindingArgs{PodName: "p1", PodNamespace: "default", PodUID: pod.UID, Node: test.node})
+			close(recorder.Events)
+			events := []string{}
+			for event := range recorder.Events {
+				events = append(events, event)
+			}
+			if len(events) != len(test.wantEvents) {
+				t.Fatalf("expected the events %q, got %q", test.wantEvents, events)
+			}
+			for i, want := range test.wantEvents {
+				if !strings.HasPrefix(events[i], want) {
+					t.Errorf("expected the event %q, got %q", want, events[i])
+				}
+			}
+		})
+	}
+}
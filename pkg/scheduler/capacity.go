@@ -0,0 +1,60 @@
+package scheduler
+
+import (
+	"sort"
+
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// Handler counts the pods of each size which still fit on the nodes matching the selector.
+// A pod is placed in one device, so the devices are packed greedily one by one, and the
+// unhealthy devices and the memory reserved for larger pods are not counted.
+func (c Capacity) Handler(sizes []uint, selector labels.Selector) *CapacityResult {
+	result := &CapacityResult{Sizes: []*SizeCapacity{}}
+	if selector == nil {
+		selector = labels.Everything()
+	}
+
+	nodeInfos, err := c.cache.ListNodeInfos(selector)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	sort.Slice(nodeInfos, func(i, j int) bool {
+		return nodeInfos[i].GetName() < nodeInfos[j].GetName()
+	})
+
+	for _, size := range sizes {
+		sizeCapacity := &SizeCapacity{GPUMemory: size, Nodes: []*NodeCapacity{}}
+		for _, info := range nodeInfos {
+			availableGPUs := info.GetAvailableGPUsFor(size)
+			nodeCapacity := &NodeCapacity{Name: info.GetName(), Devices: []*DeviceCapacity{}}
+			for devID := 0; devID < info.GetGPUCount(); devID++ {
+				availableGPU, healthy := availableGPUs[devID]
+				if !healthy {
+					continue
+				}
+				dev := &DeviceCapacity{
+					ID:           devID,
+					AvailableGPU: availableGPU,
+					Count:        pack(availableGPU, size),
+				}
+				nodeCapacity.Devices = append(nodeCapacity.Devices, dev)
+				nodeCapacity.Count += dev.Count
+			}
+			sizeCapacity.Nodes = append(sizeCapacity.Nodes, nodeCapacity)
+			sizeCapacity.Count += nodeCapacity.Count
+		}
+		result.Sizes = append(result.Sizes, sizeCapacity)
+	}
+
+	return result
+}
+
+// pack returns how many pods of the size can be placed in the available gpu memory
+func pack(availableGPU uint, size uint) int {
+	if size == 0 {
+		return 0
+	}
+	return int(availableGPU / size)
+}
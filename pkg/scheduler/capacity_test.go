@@ -0,0 +1,91 @@
+package scheduler
+
+import (
+	"reflect"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+func TestPack(t *testing.T) {
+	tests := []struct {
+		name         string
+		availableGPU uint
+		size         uint
+		want         int
+	}{
+		{name: "several pods", availableGPU: 8, size: 3, want: 2},
+		{name: "exact fit", availableGPU: 8, size: 8, want: 1},
+		{name: "too large", availableGPU: 7, size: 8, want: 0},
+		{name: "no memory", availableGPU: 0, size: 1, want: 0},
+		{name: "empty size", availableGPU: 8, size: 0, want: 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if count := pack(test.availableGPU, test.size); count != test.want {
+				t.Errorf("expected %d pods, got %d", test.want, count)
+			}
+		})
+	}
+}
+
+func TestCapacity(t *testing.T) {
+	// a has 3 and 8 free, the device 1 of b is unhealthy
+	c := newTestCache(t,
+		testNode{name: "a", used: []uint{5, 0}},
+		testNode{name: "b", labels: map[string]string{"pool": "b"}, unhealthy: []int{1}})
+
+	tests := []struct {
+		name     string
+		sizes    []uint
+		selector labels.Selector
+		// wantCounts are the counts of each size, and of each node in the order of the names
+		wantCounts     []int
+		wantNodeCounts [][]int
+	}{
+		{
+			name:           "unhealthy device is not counted",
+			sizes:          []uint{4, 3},
+			wantCounts:     []int{4, 5},
+			wantNodeCounts: [][]int{{2, 2}, {3, 2}},
+		},
+		{
+			name:           "selector limits the nodes",
+			sizes:          []uint{4},
+			selector:       labels.SelectorFromSet(labels.Set{"pool": "b"}),
+			wantCounts:     []int{2},
+			wantNodeCounts: [][]int{{2}},
+		},
+		{
+			name:           "size larger than a device",
+			sizes:          []uint{9},
+			wantCounts:     []int{0},
+			wantNodeCounts: [][]int{{0, 0}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result := NewGPUShareCapacity(c).Handler(test.sizes, test.selector)
+			if len(result.Error) > 0 {
+				t.Fatal(result.Error)
+			}
+			counts, nodeCounts := []int{}, [][]int{}
+			for _, size := range result.Sizes {
+				counts = append(counts, size.Count)
+				nodes := []int{}
+				for _, node := range size.Nodes {
+					nodes = append(nodes, node.Count)
+				}
+				nodeCounts = append(nodeCounts, nodes)
+			}
+			if !reflect.DeepEqual(counts, test.wantCounts) {
+				t.Errorf("expected the counts %v, got %v", test.wantCounts, counts)
+			}
+			if !reflect.DeepEqual(nodeCounts, test.wantNodeCounts) {
+				t.Errorf("expected the counts of the nodes %v, got %v", test.wantNodeCounts, nodeCounts)
+			}
+		})
+	}
+}
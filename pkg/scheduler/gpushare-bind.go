@@ -4,6 +4,8 @@ import (
 	"context"
 	"fmt"
 	"github.com/AliyunContainerService/gpushare-scheduler-extender/pkg/log"
+	"github.com/AliyunContainerService/gpushare-scheduler-extender/pkg/metrics"
+	"github.com/AliyunContainerService/gpushare-scheduler-extender/pkg/tracing"
 
 	"github.com/AliyunContainerService/gpushare-scheduler-extender/pkg/cache"
 	"k8s.io/api/core/v1"
@@ -11,39 +13,48 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/record"
 )
 
+var bindLog = log.Named(log.SubsystemBind)
+
 const (
 	OptimisticLockErrorMsg = "the object has been modified; please apply your changes to the latest version and try again"
 )
 
-func NewGPUShareBind(ctx context.Context, clientset *kubernetes.Clientset, c *cache.SchedulerCache) *Bind {
+func NewGPUShareBind(clientset kubernetes.Interface, c *cache.SchedulerCache, recorder record.EventRecorder) *Bind {
 	return &Bind{
 		Name: "gpusharingbinding",
-		Func: func(name string, namespace string, podUID types.UID, node string, c *cache.SchedulerCache) error {
+		Func: func(ctx context.Context, name string, namespace string, podUID types.UID, node string, c *cache.SchedulerCache) error {
 			pod, err := getPod(ctx, name, namespace, podUID, clientset, c)
 			if err != nil {
-				log.V(9).Info("warn: Failed to handle pod %s in ns %s due to error %v", name, namespace, err)
-				return err
+				bindLog.V(9).Warn("failed to handle pod", log.Pod(name), log.Namespace(namespace), log.Node(node), log.Err(err))
+				return metrics.WithReason(metrics.ReasonGetPod, err)
 			}
 
 			nodeInfo, err := c.GetNodeInfo(node)
 			if err != nil {
-				log.V(9).Info("warn: Failed to handle pod %s in ns %s due to error %v", name, namespace, err)
-				return err
+				bindLog.V(9).Warn("failed to handle pod", log.Pod(name), log.Namespace(namespace), log.Node(node), log.Err(err))
+				return metrics.WithReason(metrics.ReasonGetNode, err)
 			}
-			err = nodeInfo.Allocate(clientset, pod)
+			err = nodeInfo.Allocate(ctx, clientset, recorder, pod)
 			if err != nil {
-				log.V(9).Info("warn: Failed to handle pod %s in ns %s due to error %v", name, namespace, err)
+				bindLog.V(9).Warn("failed to handle pod", log.Pod(name), log.Namespace(namespace), log.Node(node), log.Err(err))
 				return err
 			}
 			return nil
 		},
-		cache: c,
+		cache:    c,
+		recorder: recorder,
 	}
 }
 
-func getPod(ctx context.Context, name string, namespace string, podUID types.UID, clientset *kubernetes.Clientset, c *cache.SchedulerCache) (pod *v1.Pod, err error) {
+func getPod(ctx context.Context, name string, namespace string, podUID types.UID, clientset kubernetes.Interface, c *cache.SchedulerCache) (pod *v1.Pod, err error) {
+	ctx, span := tracing.StartSpan(ctx, "getPod", tracing.Pod(name, namespace)...)
+	defer func() {
+		tracing.EndSpan(span, err)
+	}()
+
 	pod, err = c.GetPod(name, namespace)
 	if errors.IsNotFound(err) {
 		pod, err = clientset.CoreV1().Pods(namespace).Get(ctx, name, metav1.GetOptions{})
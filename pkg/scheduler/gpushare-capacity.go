@@ -0,0 +1,41 @@
+package scheduler
+
+import (
+	"github.com/AliyunContainerService/gpushare-scheduler-extender/pkg/cache"
+)
+
+func NewGPUShareCapacity(c *cache.SchedulerCache) *Capacity {
+	return &Capacity{
+		Name:  "gpusharecapacity",
+		cache: c,
+	}
+}
+
+type CapacityResult struct {
+	Sizes []*SizeCapacity `json:"sizes"`
+	Error string          `json:"error,omitempty"`
+}
+
+// SizeCapacity is how many pods requesting GPUMemory can still be placed
+type SizeCapacity struct {
+	GPUMemory uint            `json:"gpuMemory"`
+	Count     int             `json:"count"`
+	Nodes     []*NodeCapacity `json:"nodes"`
+}
+
+type NodeCapacity struct {
+	Name    string            `json:"name"`
+	Count   int               `json:"count"`
+	Devices []*DeviceCapacity `json:"devs"`
+}
+
+type DeviceCapacity struct {
+	ID           int  `json:"id"`
+	AvailableGPU uint `json:"availableGPU"`
+	Count        int  `json:"count"`
+}
+
+type Capacity struct {
+	Name  string
+	cache *cache.SchedulerCache
+}
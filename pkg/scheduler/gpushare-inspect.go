@@ -2,6 +2,7 @@ package scheduler
 
 import (
 	"github.com/AliyunContainerService/gpushare-scheduler-extender/pkg/cache"
+	"k8s.io/apimachinery/pkg/labels"
 )
 
 func NewGPUShareInspect(c *cache.SchedulerCache) *Inspect {
@@ -11,23 +12,45 @@ func NewGPUShareInspect(c *cache.SchedulerCache) *Inspect {
 	}
 }
 
+// InspectOptions filters and pages the nodes of the inspect
+type InspectOptions struct {
+	Selector labels.Selector
+	// Namespace keeps only the pods in the namespace, and the nodes running any of them
+	Namespace string
+	// MinFreeGPU keeps the nodes with at least the free gpu memory on the healthy devices
+	MinFreeGPU uint
+	// UnhealthyOnly keeps the nodes with any unhealthy device
+	UnhealthyOnly bool
+	// Limit is the max number of the nodes, 0 means no limit
+	Limit int
+	// Continue is the last node name of the previous page
+	Continue string
+}
+
 type Result struct {
 	Nodes []*Node `json:"nodes"`
-	Error string  `json:"error,omitempty"`
+	// Continue is set to page the remaining nodes
+	Continue string `json:"continue,omitempty"`
 }
 
 type Node struct {
-	Name     string    `json:"name"`
-	TotalGPU uint      `json:"totalGPU"`
-	UsedGPU  uint      `json:"usedGPU"`
-	Devices  []*Device `json:"devs"`
+	Name     string `json:"name"`
+	TotalGPU uint   `json:"totalGPU"`
+	UsedGPU  uint   `json:"usedGPU"`
+	// FreeGPU is the free gpu memory of the healthy devices
+	FreeGPU          uint      `json:"freeGPU"`
+	UnhealthyDevices int       `json:"unhealthyDevices,omitempty"`
+	Devices          []*Device `json:"devs"`
 }
 
 type Device struct {
-	ID       int    `json:"id"`
-	TotalGPU uint   `json:"totalGPU"`
-	UsedGPU  uint   `json:"usedGPU"`
-	Pods     []*Pod `json:"pods"`
+	ID int `json:"id"`
+	// UUID is empty if the device plugin doesn't report the UUIDs of the node
+	UUID      string `json:"uuid,omitempty"`
+	TotalGPU  uint   `json:"totalGPU"`
+	UsedGPU   uint   `json:"usedGPU"`
+	Unhealthy bool   `json:"unhealthy,omitempty"`
+	Pods      []*Pod `json:"pods"`
 }
 
 type Pod struct {
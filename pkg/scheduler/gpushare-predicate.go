@@ -3,8 +3,9 @@ package scheduler
 import (
 	"github.com/AliyunContainerService/gpushare-scheduler-extender/pkg/cache"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/record"
 )
 
-func NewGPUsharePredicate(clientset *kubernetes.Clientset, c *cache.SchedulerCache) *Predicate {
-	return &Predicate{Name: "gpusharingfilter", cache: c}
+func NewGPUsharePredicate(clientset kubernetes.Interface, c *cache.SchedulerCache, recorder record.EventRecorder) *Predicate {
+	return &Predicate{Name: "gpusharingfilter", cache: c, recorder: recorder}
 }
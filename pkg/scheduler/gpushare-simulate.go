@@ -0,0 +1,40 @@
+package scheduler
+
+import (
+	"github.com/AliyunContainerService/gpushare-scheduler-extender/pkg/cache"
+	"k8s.io/api/core/v1"
+)
+
+func NewGPUShareSimulate(c *cache.SchedulerCache) *Simulate {
+	return &Simulate{
+		Name:  "gpusharesimulate",
+		cache: c,
+	}
+}
+
+// SimulateArgs is the candidate pod, and the nodes to consider. All the GPU sharing nodes are considered if NodeNames is empty.
+type SimulateArgs struct {
+	Pod       *v1.Pod  `json:"pod"`
+	NodeNames []string `json:"nodeNames,omitempty"`
+}
+
+type SimulateResult struct {
+	// Node and Device are where the pod would land, Device is -1 if no node fits
+	Node        string            `json:"node,omitempty"`
+	Device      int               `json:"device"`
+	Candidates  []*Candidate      `json:"candidates"`
+	FailedNodes map[string]string `json:"failedNodes"`
+	Error       string            `json:"error,omitempty"`
+}
+
+// Candidate is a node which passes the filter, and the device which would be allocated on it
+type Candidate struct {
+	Node         string `json:"node"`
+	Device       int    `json:"device"`
+	AvailableGPU uint   `json:"availableGPU"`
+}
+
+type Simulate struct {
+	Name  string
+	cache *cache.SchedulerCache
+}
@@ -0,0 +1,100 @@
+package scheduler
+
+import (
+	"sort"
+	"strconv"
+
+	"github.com/AliyunContainerService/gpushare-scheduler-extender/pkg/utils"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// PodAllocation is where the pod's gpu memory is allocated
+type PodAllocation struct {
+	Name      string    `json:"name"`
+	Namespace string    `json:"namespace"`
+	UID       types.UID `json:"uid"`
+	Node      string    `json:"node"`
+	Device    int       `json:"device"`
+	// DeviceUUID is empty for the legacy pods allocated by the index only
+	DeviceUUID string `json:"deviceUUID,omitempty"`
+	// GPUMemory is the requested gpu memory
+	GPUMemory int `json:"gpuMemory"`
+	// AssumeTime is the unix nano time when the device was allocated, 0 if unknown
+	AssumeTime int64 `json:"assumeTime,omitempty"`
+	Assigned   bool  `json:"assigned"`
+	// Neighbors are the other pods sharing the device
+	Neighbors []*Pod `json:"neighbors"`
+}
+
+// PodHandler returns the allocation of the pod, nil if the pod is not allocated
+func (in Inspect) PodHandler(namespace, name string) *PodAllocation {
+	pod := in.cache.GetKnownPod(namespace, name)
+	if pod == nil {
+		return nil
+	}
+	return in.buildPodAllocation(pod)
+}
+
+// PodsHandler returns the allocations of the pods in the namespace, all the namespaces if it's empty
+func (in Inspect) PodsHandler(namespace string) []*PodAllocation {
+	pods := in.cache.ListKnownPods(namespace)
+	sort.Slice(pods, func(i, j int) bool {
+		if pods[i].Namespace != pods[j].Namespace {
+			return pods[i].Namespace < pods[j].Namespace
+		}
+		return pods[i].Name < pods[j].Name
+	})
+
+	allocations := []*PodAllocation{}
+	for _, pod := range pods {
+		allocations = append(allocations, in.buildPodAllocation(pod))
+	}
+	return allocations
+}
+
+func (in Inspect) buildPodAllocation(pod *v1.Pod) *PodAllocation {
+	profiles := in.cache.GetProfiles()
+	profile := profiles.OfPod(pod)
+	allocation := &PodAllocation{
+		Name:       pod.Name,
+		Namespace:  pod.Namespace,
+		UID:        pod.UID,
+		Node:       pod.Spec.NodeName,
+		Device:     profile.GPUIDFromAnnotation(pod),
+		DeviceUUID: profile.GPUUUIDFromAnnotation(pod),
+		GPUMemory:  profile.GPUMemoryFromPodResource(pod),
+		Assigned:   pod.Annotations[profile.AssignedKey] == "true",
+		Neighbors:  []*Pod{},
+	}
+	if assumeTime, err := strconv.ParseInt(pod.Annotations[profile.AssumeTimeKey], 10, 64); err == nil {
+		allocation.AssumeTime = assumeTime
+	}
+
+	info, err := in.cache.GetNodeInfo(pod.Spec.NodeName)
+	if err != nil {
+		return allocation
+	}
+	// the index in the annotation is stale if the devices are reordered
+	allocation.Device = info.GetDeviceIDOfPod(pod)
+	devs := info.GetDevs()
+	if allocation.Device < 0 || allocation.Device >= len(devs) || devs[allocation.Device] == nil {
+		return allocation
+	}
+	dev := devs[allocation.Device]
+	for _, neighbor := range dev.GetPods() {
+		if neighbor.UID == pod.UID || !utils.AssignedNonTerminatedPod(neighbor) {
+			continue
+		}
+		allocation.Neighbors = append(allocation.Neighbors, &Pod{
+			Namespace: neighbor.Namespace,
+			Name:      neighbor.Name,
+			UsedGPU:   profiles.OfPod(neighbor).GPUMemoryFromPodResource(neighbor),
+		})
+	}
+	sort.Slice(allocation.Neighbors, func(i, j int) bool {
+		return allocation.Neighbors[i].Namespace+"/"+allocation.Neighbors[i].Name <
+			allocation.Neighbors[j].Namespace+"/"+allocation.Neighbors[j].Name
+	})
+	return allocation
+}
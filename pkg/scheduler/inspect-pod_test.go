@@ -0,0 +1,148 @@
+package scheduler
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	"github.com/AliyunContainerService/gpushare-scheduler-extender/pkg/cache"
+)
+
+// newAllocatedPod is a running pod of the gpu memory on the device of the node
+func newAllocatedPod(namespace, name, node string, devID int, gpuMem uint, created time.Time) *v1.Pod {
+	pod := newGPUPod(name, gpuMem)
+	pod.Namespace = namespace
+	pod.UID = types.UID("uid-" + namespace + "-" + name + "-" + strconv.FormatInt(created.Unix(), 10))
+	pod.CreationTimestamp = metav1.NewTime(created)
+	pod.Spec.NodeName = node
+	pod.Status.Phase = v1.PodRunning
+	pod.Annotations = map[string]string{
+		"ALIYUN_COM_GPU_MEM_IDX":         strconv.Itoa(devID),
+		"ALIYUN_COM_GPU_MEM_POD":         strconv.Itoa(int(gpuMem)),
+		"ALIYUN_COM_GPU_MEM_ASSIGNED":    "true",
+		"ALIYUN_COM_GPU_MEM_ASSUME_TIME": "1000",
+	}
+	return pod
+}
+
+// newPodLookupCache has the pods a-0 on a/0 and a-1 on a/1 of the default namespace,
+// team/p1 on a/0, team/p2 on b/1 and team/done on a/0 which has succeeded
+func newPodLookupCache(t *testing.T) *cache.SchedulerCache {
+	c := newTestCache(t, testNode{name: "a", used: []uint{4, 2}}, testNode{name: "b"})
+	now := time.Now()
+	pods := []*v1.Pod{
+		newAllocatedPod("team", "p1", "a", 0, 2, now),
+		newAllocatedPod("team", "p2", "b", 1, 3, now),
+	}
+	done := newAllocatedPod("team", "done", "a", 0, 1, now)
+	done.Status.Phase = v1.PodSucceeded
+	pods = append(pods, done)
+	for _, pod := range pods {
+		if err := c.AddOrUpdatePod(pod); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return c
+}
+
+func TestPodHandler(t *testing.T) {
+	c := newPodLookupCache(t)
+	in := NewGPUShareInspect(c)
+
+	tests := []struct {
+		name      string
+		namespace string
+		podName   string
+		// want is nil if the pod is not found
+		want *PodAllocation
+	}{
+		{
+			name:      "pod sharing the device",
+			namespace: "default",
+			podName:   "a-0",
+			want: &PodAllocation{
+				Name: "a-0", Namespace: "default", UID: "uid-a-0", Node: "a", Device: 0, GPUMemory: 4, AssumeTime: 0, Assigned: true,
+				// the succeeded pod is not a neighbor
+				Neighbors: []*Pod{{Namespace: "team", Name: "p1", UsedGPU: 2}},
+			},
+		},
+		{
+			name:      "pod alone on the device",
+			namespace: "default",
+			podName:   "a-1",
+			want:      &PodAllocation{Name: "a-1", Namespace: "default", UID: "uid-a-1", Node: "a", Device: 1, GPUMemory: 2, Assigned: true, Neighbors: []*Pod{}},
+		},
+		{name: "pod in another namespace", namespace: "team", podName: "a-0"},
+		{name: "unknown pod", namespace: "default", podName: "unknown"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := in.PodHandler(test.namespace, test.podName)
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("expected %+v, got %+v", test.want, got)
+			}
+		})
+	}
+}
+
+func TestPodHandlerNeighborsAndAssumeTime(t *testing.T) {
+	c := newPodLookupCache(t)
+	got := NewGPUShareInspect(c).PodHandler("team", "p1")
+	if got == nil {
+		t.Fatalf("expected the pod team/p1")
+	}
+	if got.AssumeTime != 1000 {
+		t.Errorf("expected the assume time 1000, got %d", got.AssumeTime)
+	}
+	want := []*Pod{{Namespace: "default", Name: "a-0", UsedGPU: 4}}
+	if !reflect.DeepEqual(got.Neighbors, want) {
+		t.Errorf("expected the neighbors %+v, got %+v", want, got.Neighbors)
+	}
+}
+
+func TestPodHandlerReturnsLatestPod(t *testing.T) {
+	c := newPodLookupCache(t)
+	// the old pod of the same name is not deleted from the cache yet
+	old := newAllocatedPod("team", "p1", "b", 0, 6, time.Now().Add(-time.Hour))
+	if err := c.AddOrUpdatePod(old); err != nil {
+		t.Fatal(err)
+	}
+	got := NewGPUShareInspect(c).PodHandler("team", "p1")
+	if got == nil || got.Node != "a" {
+		t.Errorf("expected the latest pod team/p1 on node a, got %+v", got)
+	}
+}
+
+func TestPodsHandler(t *testing.T) {
+	c := newPodLookupCache(t)
+	in := NewGPUShareInspect(c)
+
+	tests := []struct {
+		name      string
+		namespace string
+		// want are <namespace>/<name> in the order of the result
+		want []string
+	}{
+		{name: "namespace", namespace: "team", want: []string{"team/done", "team/p1", "team/p2"}},
+		{name: "all the namespaces", want: []string{"default/a-0", "default/a-1", "team/done", "team/p1", "team/p2"}},
+		{name: "namespace without pods", namespace: "other", want: []string{}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := []string{}
+			for _, allocation := range in.PodsHandler(test.namespace) {
+				got = append(got, allocation.Namespace+"/"+allocation.Name)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("expected the pods %v, got %v", test.want, got)
+			}
+		})
+	}
+}
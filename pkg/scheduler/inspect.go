@@ -1,50 +1,130 @@
 package scheduler
 
 import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
 	"github.com/AliyunContainerService/gpushare-scheduler-extender/pkg/cache"
 	"github.com/AliyunContainerService/gpushare-scheduler-extender/pkg/utils"
+	"k8s.io/apimachinery/pkg/labels"
 )
 
-func (in Inspect) Handler(name string) *Result {
-	nodes := []*Node{}
-	errMsg := ""
-	if len(name) == 0 {
-		nodeInfos := in.cache.GetNodeinfos()
-		for _, info := range nodeInfos {
-			nodes = append(nodes, buildNode(info))
+// Handler returns the node of the name, or the nodes matching the options if the name is empty.
+// The nodes are sorted by name, and a page ends with the name to continue from.
+func (in Inspect) Handler(name string, opts InspectOptions) (*Result, error) {
+	if len(name) > 0 {
+		info, err := in.cache.GetNodeInfo(name)
+		if err != nil {
+			return nil, err
 		}
+		return &Result{Nodes: []*Node{buildNode(info, opts.Namespace)}}, nil
+	}
 
-	} else {
-		node, err := in.cache.GetNodeInfo(name)
-		if err != nil {
-			errMsg = err.Error()
+	selector := opts.Selector
+	if selector == nil {
+		selector = labels.Everything()
+	}
+	nodeInfos, err := in.cache.ListNodeInfos(selector)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(nodeInfos, func(i, j int) bool {
+		return nodeInfos[i].GetName() < nodeInfos[j].GetName()
+	})
+
+	result := &Result{Nodes: []*Node{}}
+	for _, info := range nodeInfos {
+		if len(opts.Continue) > 0 && info.GetName() <= opts.Continue {
+			continue
 		}
-		// nodeInfos = append(nodeInfos, node)
-		nodes = append(nodes, buildNode(node))
+		node := buildNode(info, opts.Namespace)
+		if !opts.matches(node) {
+			continue
+		}
+		if opts.Limit > 0 && len(result.Nodes) == opts.Limit {
+			result.Continue = result.Nodes[len(result.Nodes)-1].Name
+			break
+		}
+		result.Nodes = append(result.Nodes, node)
 	}
+	return result, nil
+}
 
-	return &Result{
-		Nodes: nodes,
-		Error: errMsg,
+// Print writes a row for each device
+func (r *Result) Print(w io.Writer) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "NODE\tDEVICE\tUSED/TOTAL\tHEALTHY\tPODS")
+	for _, node := range r.Nodes {
+		for _, dev := range node.Devices {
+			pods := dev.PodNames()
+			if len(pods) == 0 {
+				pods = "<none>"
+			}
+			fmt.Fprintf(tw, "%s\t%d\t%d/%d\t%t\t%s\n", node.Name, dev.ID, dev.UsedGPU, dev.TotalGPU, !dev.Unhealthy, pods)
+		}
+	}
+	tw.Flush()
+	if len(r.Continue) > 0 {
+		fmt.Fprintf(w, "\ncontinue: %s\n", r.Continue)
 	}
 }
 
-func buildNode(info *cache.NodeInfo) *Node {
+// PodNames returns the pods on the device as namespace/name(gpu memory)
+func (d *Device) PodNames() string {
+	names := []string{}
+	for _, pod := range d.Pods {
+		names = append(names, fmt.Sprintf("%s/%s(%d)", pod.Namespace, pod.Name, pod.UsedGPU))
+	}
+	return strings.Join(names, " ")
+}
+
+func (opts InspectOptions) matches(node *Node) bool {
+	if node.FreeGPU < opts.MinFreeGPU {
+		return false
+	}
+	if opts.UnhealthyOnly && node.UnhealthyDevices == 0 {
+		return false
+	}
+	if len(opts.Namespace) > 0 {
+		for _, dev := range node.Devices {
+			if len(dev.Pods) > 0 {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
 
-	devInfos := info.GetDevs()
+// buildNode keeps only the pods in the namespace if it's not empty
+func buildNode(info *cache.NodeInfo, namespace string) *Node {
+	availableGPUs := info.GetAvailableGPUs()
 	devs := []*Device{}
-	var usedGPU uint
+	var usedGPU, freeGPU uint
+	unhealthyDevices := 0
 
-	for i, devInfo := range devInfos {
+	for i, devInfo := range info.GetDevs() {
+		if devInfo == nil {
+			continue
+		}
+		availableGPU, healthy := availableGPUs[i]
 		dev := &Device{
-			ID:       i,
-			TotalGPU: devInfo.GetTotalGPUMemory(),
-			UsedGPU:  devInfo.GetUsedGPUMemory(),
+			ID:        i,
+			UUID:      devInfo.GetUUID(),
+			TotalGPU:  devInfo.GetTotalGPUMemory(),
+			UsedGPU:   devInfo.GetUsedGPUMemory(),
+			Unhealthy: !healthy,
 		}
 
 		podInfos := devInfo.GetPods()
 		pods := []*Pod{}
 		for _, podInfo := range podInfos {
+			if len(namespace) > 0 && podInfo.Namespace != namespace {
+				continue
+			}
 			if utils.AssignedNonTerminatedPod(podInfo) {
 				pod := &Pod{
 					Namespace: podInfo.Namespace,
@@ -54,16 +134,25 @@ func buildNode(info *cache.NodeInfo) *Node {
 				pods = append(pods, pod)
 			}
 		}
+		sort.Slice(pods, func(i, j int) bool {
+			return pods[i].Namespace+"/"+pods[i].Name < pods[j].Namespace+"/"+pods[j].Name
+		})
 		dev.Pods = pods
 		devs = append(devs, dev)
 		usedGPU += devInfo.GetUsedGPUMemory()
+		freeGPU += availableGPU
+		if !healthy {
+			unhealthyDevices++
+		}
 	}
 
 	return &Node{
-		Name:     info.GetName(),
-		TotalGPU: uint(info.GetTotalGPUMemory()),
-		UsedGPU:  usedGPU,
-		Devices:  devs,
+		Name:             info.GetName(),
+		TotalGPU:         uint(info.GetTotalGPUMemory()),
+		UsedGPU:          usedGPU,
+		FreeGPU:          freeGPU,
+		UnhealthyDevices: unhealthyDevices,
+		Devices:          devs,
 	}
 
 }
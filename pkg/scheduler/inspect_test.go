@@ -0,0 +1,92 @@
+package scheduler
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+func TestInspectHandler(t *testing.T) {
+	// the free gpu memory of the healthy devices: a 12, b 0, c 5 (the device 1 is unhealthy), d 16
+	c := newTestCache(t,
+		testNode{name: "a", labels: map[string]string{"pool": "x"}, used: []uint{4, 0}},
+		testNode{name: "b", labels: map[string]string{"pool": "y"}, used: []uint{8, 8}},
+		testNode{name: "c", labels: map[string]string{"pool": "x"}, used: []uint{2, 0}, unhealthy: []int{1}},
+		testNode{name: "d"},
+	)
+	if err := c.AddOrUpdatePod(newAllocatedPod("team", "p1", "c", 0, 1, time.Now())); err != nil {
+		t.Fatal(err)
+	}
+	in := NewGPUShareInspect(c)
+
+	tests := []struct {
+		name      string
+		nodeName  string
+		opts      InspectOptions
+		wantNodes []string
+		// wantContinue is the continue value of the result
+		wantContinue string
+	}{
+		{name: "all the nodes", wantNodes: []string{"a", "b", "c", "d"}},
+		{name: "node selector", opts: InspectOptions{Selector: labels.SelectorFromSet(labels.Set{"pool": "x"})}, wantNodes: []string{"a", "c"}},
+		{name: "namespace", opts: InspectOptions{Namespace: "team"}, wantNodes: []string{"c"}},
+		{name: "min free", opts: InspectOptions{MinFreeGPU: 10}, wantNodes: []string{"a", "d"}},
+		{name: "unhealthy", opts: InspectOptions{UnhealthyOnly: true}, wantNodes: []string{"c"}},
+		{name: "first page", opts: InspectOptions{Limit: 2}, wantNodes: []string{"a", "b"}, wantContinue: "b"},
+		{name: "last page", opts: InspectOptions{Limit: 2, Continue: "b"}, wantNodes: []string{"c", "d"}},
+		{name: "page is full and another node matches", opts: InspectOptions{MinFreeGPU: 10, Limit: 1}, wantNodes: []string{"a"}, wantContinue: "a"},
+		{name: "page is full and no other node matches", opts: InspectOptions{MinFreeGPU: 10, Limit: 2}, wantNodes: []string{"a", "d"}},
+		{name: "page of the filtered nodes", opts: InspectOptions{MinFreeGPU: 10, Limit: 1, Continue: "a"}, wantNodes: []string{"d"}},
+		{name: "node by name ignores the filters", nodeName: "b", opts: InspectOptions{MinFreeGPU: 10}, wantNodes: []string{"b"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result, err := in.Handler(test.nodeName, test.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := []string{}
+			for _, node := range result.Nodes {
+				got = append(got, node.Name)
+			}
+			if !reflect.DeepEqual(got, test.wantNodes) {
+				t.Errorf("expected the nodes %v, got %v", test.wantNodes, got)
+			}
+			if result.Continue != test.wantContinue {
+				t.Errorf("expected continue %q, got %q", test.wantContinue, result.Continue)
+			}
+		})
+	}
+}
+
+func TestInspectHandlerNode(t *testing.T) {
+	c := newTestCache(t, testNode{name: "c", used: []uint{2, 0}, unhealthy: []int{1}})
+	if err := c.AddOrUpdatePod(newAllocatedPod("team", "p1", "c", 0, 1, time.Now())); err != nil {
+		t.Fatal(err)
+	}
+	in := NewGPUShareInspect(c)
+
+	result, err := in.Handler("c", InspectOptions{Namespace: "team"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &Node{
+		Name: "c", TotalGPU: 16, UsedGPU: 3, FreeGPU: 5, UnhealthyDevices: 1,
+		Devices: []*Device{
+			// only the pods in the namespace are kept
+			{ID: 0, TotalGPU: 8, UsedGPU: 3, Pods: []*Pod{{Namespace: "team", Name: "p1", UsedGPU: 1}}},
+			{ID: 1, TotalGPU: 8, Unhealthy: true, Pods: []*Pod{}},
+		},
+	}
+	if len(result.Nodes) != 1 || !reflect.DeepEqual(result.Nodes[0], want) {
+		t.Errorf("expected the node %+v, got %+v", want, result.Nodes)
+	}
+
+	if _, err := in.Handler("unknown", InspectOptions{}); !apierrors.IsNotFound(err) {
+		t.Errorf("expected a not found error, got %v", err)
+	}
+}
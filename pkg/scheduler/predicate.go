@@ -1,47 +1,91 @@
 package scheduler
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"strings"
+	"time"
+
 	"github.com/AliyunContainerService/gpushare-scheduler-extender/pkg/cache"
 	"github.com/AliyunContainerService/gpushare-scheduler-extender/pkg/log"
-	"github.com/AliyunContainerService/gpushare-scheduler-extender/pkg/utils"
+	"github.com/AliyunContainerService/gpushare-scheduler-extender/pkg/metrics"
+	"github.com/AliyunContainerService/gpushare-scheduler-extender/pkg/tracing"
 	"k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/record"
 	schedulerapi "k8s.io/kube-scheduler/extender/v1"
 )
 
+var predicateLog = log.Named(log.SubsystemPredicate)
+
+// ReasonInsufficientGPUMemory is the event reason when no node has a single device with enough gpu memory for the pod,
+// the message summarizes the reasons of all the nodes
+const ReasonInsufficientGPUMemory = "InsufficientGPUMemory"
+
+var (
+	errNotGPUShareNode = fmt.Errorf("not for GPU share")
+	errOtherResource   = fmt.Errorf("for another GPU resource")
+)
+
 type Predicate struct {
-	Name  string
-	cache *cache.SchedulerCache
+	Name     string
+	cache    *cache.SchedulerCache
+	recorder record.EventRecorder
 }
 
-func (p Predicate) checkNode(pod *v1.Pod, nodeName string, c *cache.SchedulerCache) (*v1.Node, error) {
-	log.V(10).Info("info: check if the pod name %s can be scheduled on node %s", pod.Name, nodeName)
+func (p Predicate) checkNode(ctx context.Context, pod *v1.Pod, nodeName string, c *cache.SchedulerCache) (node *v1.Node, err error) {
+	_, span := tracing.StartSpan(ctx, "checkNode", tracing.Node(nodeName))
+	defer func() {
+		tracing.EndSpan(span, err)
+	}()
+
+	predicateLog.V(10).Info("check if the pod can be scheduled on node", log.Pod(pod.Name), log.Namespace(pod.Namespace), log.Node(nodeName))
 	nodeInfo, err := c.GetNodeInfo(nodeName)
 	if err != nil {
 		return nil, err
 	}
 
+	return CheckNodeInfo(pod, nodeInfo)
+}
+
+// CheckNodeInfo checks if the pod can be scheduled on the node
+func CheckNodeInfo(pod *v1.Pod, nodeInfo *cache.NodeInfo) (*v1.Node, error) {
+	nodeName := nodeInfo.GetName()
 	node := nodeInfo.GetNode()
 	if node == nil {
 		return nil, fmt.Errorf("failed get node with name %s", nodeName)
 	}
-	if !utils.IsGPUSharingNode(node) {
-		return nil, fmt.Errorf("The node %s is not for GPU share, need skip", nodeName)
+	if nodeInfo.GetProfile().TotalGPUMemory(node) <= 0 {
+		return nil, fmt.Errorf("The node %s is %w, need skip", nodeName, errNotGPUShareNode)
+	}
+	if nodeProfile, podProfile := nodeInfo.GetProfile(), nodeInfo.GetProfileOfPod(pod); nodeProfile.ResourceName != podProfile.ResourceName {
+		return nil, fmt.Errorf("The node %s is %w, it provides %s but the pod requests %s",
+			nodeName, errOtherResource, nodeProfile.ResourceName, podProfile.ResourceName)
 	}
 
-	allocatable := nodeInfo.Assume(pod)
-	if !allocatable {
-		return nil, fmt.Errorf("Insufficient GPU Memory in one device")
+	if rejection := nodeInfo.Reject(pod); rejection != nil {
+		return nil, rejection
 	} else {
-		log.V(10).Info("info: The pod %s in the namespace %s can be scheduled on %s",
-			pod.Name,
-			pod.Namespace,
-			nodeName)
+		predicateLog.V(10).Info("the pod can be scheduled on node",
+			log.Pod(pod.Name),
+			log.Namespace(pod.Namespace),
+			log.Node(nodeName))
 	}
 	return node, nil
 }
 
-func (p Predicate) Handler(args *schedulerapi.ExtenderArgs) *schedulerapi.ExtenderFilterResult {
+// Unresolvable checks if the node rejects the pod whatever is allocated on it,
+// i.e. the node is not for GPU share or provides another GPU resource
+func Unresolvable(err error) bool {
+	return errors.Is(err, errNotGPUShareNode) || errors.Is(err, errOtherResource)
+}
+
+func (p Predicate) Handler(ctx context.Context, args *schedulerapi.ExtenderArgs) *schedulerapi.ExtenderFilterResult {
+	startTime := time.Now()
+	defer func() {
+		metrics.FilterDuration.Observe(time.Since(startTime).Seconds())
+	}()
+
 	if args == nil || args.Pod == nil {
 		return &schedulerapi.ExtenderFilterResult{Error: fmt.Sprintf("arg or pod is nil")}
 	}
@@ -50,23 +94,25 @@ func (p Predicate) Handler(args *schedulerapi.ExtenderArgs) *schedulerapi.Extend
 	var nodeNames []string
 	if args.NodeNames != nil {
 		nodeNames = *args.NodeNames
-		log.V(3).Info("extender args NodeNames is not nil, result %+v", nodeNames)
+		predicateLog.V(3).Info("extender args NodeNames is not nil", log.Strings("nodeNames", nodeNames))
 	} else if args.Nodes != nil {
 		for _, n := range args.Nodes.Items {
 			nodeNames = append(nodeNames, n.Name)
 		}
-		log.V(3).Info("extender args Nodes is not nil, names is %+v", nodeNames)
+		predicateLog.V(3).Info("extender args Nodes is not nil", log.Strings("nodeNames", nodeNames))
 	} else {
 		return &schedulerapi.ExtenderFilterResult{Error: fmt.Sprintf("cannot get node names")}
 	}
 	canSchedule := make([]string, 0, len(nodeNames))
 	canNotSchedule := make(map[string]string)
 	canScheduleNodes := &v1.NodeList{}
+	rejections := []error{}
 
 	for _, nodeName := range nodeNames {
-		node, err := p.checkNode(pod, nodeName, p.cache)
+		node, err := p.checkNode(ctx, pod, nodeName, p.cache)
 		if err != nil {
 			canNotSchedule[nodeName] = err.Error()
+			rejections = append(rejections, err)
 		} else {
 			if node != nil {
 				canSchedule = append(canSchedule, nodeName)
@@ -75,6 +121,11 @@ func (p Predicate) Handler(args *schedulerapi.ExtenderArgs) *schedulerapi.Extend
 		}
 	}
 
+	if summary, insufficient := summarizeRejections(rejections); len(canSchedule) == 0 && insufficient {
+		p.recorder.Eventf(pod, v1.EventTypeWarning, ReasonInsufficientGPUMemory,
+			"0/%d nodes are available for %d GPU memory: %s", len(nodeNames), p.cache.GetProfiles().OfPod(pod).GPUMemoryFromPodResource(pod), summary)
+	}
+
 	result := schedulerapi.ExtenderFilterResult{
 		NodeNames:   &canSchedule,
 		Nodes:       canScheduleNodes,
@@ -82,6 +133,55 @@ func (p Predicate) Handler(args *schedulerapi.ExtenderArgs) *schedulerapi.Extend
 		Error:       "",
 	}
 
-	log.V(100).Info("predicate result for %s, is %+v", pod.Name, result)
+	predicateLog.V(100).Debug("predicate result", log.Pod(pod.Name), log.Namespace(pod.Namespace), log.Any("result", result))
 	return &result
 }
+
+// summarizeRejections counts the nodes by the reasons, e.g. "2 fragmented, 1 full, 1 not for GPU share",
+// so the fragmentation can be told from a full cluster. insufficient is true if any node lacks gpu memory.
+func summarizeRejections(rejections []error) (summary string, insufficient bool) {
+	var fragmented, full, unhealthy, reserved, notGPUShare, otherResource, others int
+	for _, err := range rejections {
+		var rejection *cache.Rejection
+		switch {
+		case errors.As(err, &rejection):
+			insufficient = true
+			if rejection.Fragmented() {
+				fragmented++
+			} else {
+				full++
+			}
+			if len(rejection.Unhealthy) > 0 {
+				unhealthy++
+			}
+			if len(rejection.Reserved) > 0 {
+				reserved++
+			}
+		case errors.Is(err, errNotGPUShareNode):
+			notGPUShare++
+		case errors.Is(err, errOtherResource):
+			otherResource++
+		default:
+			others++
+		}
+	}
+
+	reasons := []string{}
+	for _, r := range []struct {
+		count  int
+		reason string
+	}{
+		{fragmented, "fragmented (enough free GPU memory but not in one device)"},
+		{full, "full"},
+		{unhealthy, "with unhealthy devices"},
+		{reserved, "with devices reserved for larger requests"},
+		{notGPUShare, "not for GPU share"},
+		{otherResource, "for another GPU resource"},
+		{others, "failed to check"},
+	} {
+		if r.count > 0 {
+			reasons = append(reasons, fmt.Sprintf("%d %s", r.count, r.reason))
+		}
+	}
+	return strings.Join(reasons, ", "), insufficient
+}
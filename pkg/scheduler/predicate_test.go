@@ -0,0 +1,209 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+	schedulerapi "k8s.io/kube-scheduler/extender/v1"
+
+	"github.com/AliyunContainerService/gpushare-scheduler-extender/pkg/cache"
+	"github.com/AliyunContainerService/gpushare-scheduler-extender/pkg/utils"
+)
+
+func TestCheckNodeInfoProfiles(t *testing.T) {
+	other := utils.NewProfile("other", "example.com/gpu-mem", "example.com/gpu-count", "EXAMPLE_COM_GPU_MEM")
+	c, err := cache.NewSchedulerCacheFromSnapshot(&cache.Snapshot{
+		Version:  cache.SnapshotVersion,
+		Profiles: utils.Profiles{utils.DefaultProfile(), other},
+		Nodes: []*cache.NodeSnapshot{
+			{Name: "default-node", GPUCount: 2, TotalGPUMemory: 16, Devices: []*cache.DeviceSnapshot{{ID: 0, TotalGPUMemory: 8}, {ID: 1, TotalGPUMemory: 8}}},
+			{Name: "other-node", Resource: other.ResourceName, GPUCount: 2, TotalGPUMemory: 16, Devices: []*cache.DeviceSnapshot{{ID: 0, TotalGPUMemory: 8}, {ID: 1, TotalGPUMemory: 8}}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherPod := newGPUPod("other-pod", 0)
+	otherPod.Spec.Containers[0].Resources.Limits = v1.ResourceList{other.ResourceName: resource.MustParse("4")}
+
+	tests := []struct {
+		name      string
+		node      string
+		pod       *v1.Pod
+		wantError string
+	}{
+		{name: "default pod on the default node", node: "default-node", pod: newGPUPod("p1", 4)},
+		{name: "pod of another profile on its node", node: "other-node", pod: otherPod},
+		{name: "default pod on the node of another profile", node: "other-node", pod: newGPUPod("p1", 4), wantError: "provides example.com/gpu-mem but the pod requests aliyun.com/gpu-mem"},
+		{name: "pod of another profile on the default node", node: "default-node", pod: otherPod, wantError: "provides aliyun.com/gpu-mem but the pod requests example.com/gpu-mem"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			nodeInfo, err := c.GetNodeInfo(test.node)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = CheckNodeInfo(test.pod.DeepCopy(), nodeInfo.Clone())
+			if len(test.wantError) == 0 {
+				if err != nil {
+					t.Errorf("expected no error, got %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), test.wantError) {
+				t.Errorf("expected error containing %q, got %v", test.wantError, err)
+			}
+		})
+	}
+}
+
+func TestSummarizeRejections(t *testing.T) {
+	tests := []struct {
+		name             string
+		rejections       []error
+		wantSummary      string
+		wantInsufficient bool
+	}{
+		{
+			name:        "no rejection",
+			wantSummary: "",
+		},
+		{
+			name: "fragmented and full nodes",
+			rejections: []error{
+				&cache.Rejection{Request: 6, LargestFree: 3, TotalFree: 6},
+				&cache.Rejection{Request: 6, LargestFree: 3, TotalFree: 6},
+				&cache.Rejection{Request: 6, LargestFree: 1, TotalFree: 2},
+			},
+			wantSummary:      "2 fragmented (enough free GPU memory but not in one device), 1 full",
+			wantInsufficient: true,
+		},
+		{
+			name: "excluded devices are counted besides the free memory",
+			rejections: []error{
+				&cache.Rejection{Request: 6, LargestFree: 1, TotalFree: 1, Unhealthy: []int{1}},
+				&cache.Rejection{Request: 6, LargestFree: 2, TotalFree: 2, Reserved: []int{0}},
+			},
+			wantSummary:      "2 full, 1 with unhealthy devices, 1 with devices reserved for larger requests",
+			wantInsufficient: true,
+		},
+		{
+			name: "nodes rejected as a whole are not insufficient",
+			rejections: []error{
+				fmt.Errorf("The node n1 is %w, need skip", errNotGPUShareNode),
+				fmt.Errorf("The node n2 is %w", errOtherResource),
+				fmt.Errorf("node n3 not found"),
+			},
+			wantSummary: "1 not for GPU share, 1 for another GPU resource, 1 failed to check",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			summary, insufficient := summarizeRejections(test.rejections)
+			if summary != test.wantSummary {
+				t.Errorf("expected summary %q, got %q", test.wantSummary, summary)
+			}
+			if insufficient != test.wantInsufficient {
+				t.Errorf("expected insufficient %v, got %v", test.wantInsufficient, insufficient)
+			}
+		})
+	}
+}
+
+func TestPredicateHandlerEvents(t *testing.T) {
+	// a has 2 free on each device, b is unknown
+	c := newTestCache(t, testNode{name: "a", used: []uint{6, 6}})
+
+	tests := []struct {
+		name      string
+		gpuMem    uint
+		nodeNames []string
+		// wantEvents are "<type> <reason> <message>"
+		wantEvents []string
+	}{
+		{name: "pod fits", gpuMem: 2, nodeNames: []string{"a"}},
+		{
+			name:       "no single device fits the pod",
+			gpuMem:     4,
+			nodeNames:  []string{"a", "b"},
+			wantEvents: []string{"Warning InsufficientGPUMemory 0/2 nodes are available for 4 GPU memory: 1 fragmented (enough free GPU memory but not in one device), 1 failed to check"},
+		},
+		{name: "no node is insufficient", gpuMem: 4, nodeNames: []string{"b"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			recorder := record.NewFakeRecorder(10)
+			p := NewGPUsharePredicate(fake.NewSimpleClientset(), c, recorder)
+			p.Handler(context.Background(), &schedulerapi.ExtenderArgs{Pod: newGPUPod("p1", test.gpuMem), NodeNames: &test.nodeNames})
+			close(recorder.Events)
+			events := []string{}
+			for event := range recorder.Events {
+				events = append(events, event)
+			}
+			if !reflect.DeepEqual(events, append([]string{}, test.wantEvents...)) {
+				t.Errorf("expected the events %q, got %q", test.wantEvents, events)
+			}
+		})
+	}
+}
+
+// newSnapshotNode has 2 devices of 8 gpu memory, with a pod of the used gpu memory on each device
+func newSnapshotNode(name string, used ...uint) *cache.NodeSnapshot {
+	node := &cache.NodeSnapshot{Name: name, GPUCount: 2, TotalGPUMemory: 16}
+	for id := 0; id < 2; id++ {
+		dev := &cache.DeviceSnapshot{ID: id, TotalGPUMemory: 8}
+		if id < len(used) && used[id] > 0 {
+			podName := fmt.Sprintf("%s-%d", name, id)
+			dev.Pods = []*cache.PodSnapshot{{Name: podName, Namespace: "default", UID: types.UID("uid-" + podName), GPUMemory: used[id], Assigned: true}}
+		}
+		node.Devices = append(node.Devices, dev)
+	}
+	return node
+}
+
+func TestSimulateMatchesFilter(t *testing.T) {
+	c, err := cache.NewSchedulerCacheFromSnapshot(&cache.Snapshot{
+		Version: cache.SnapshotVersion,
+		Nodes: []*cache.NodeSnapshot{
+			newSnapshotNode("fragmented", 5, 5),
+			newSnapshotNode("full", 7, 7),
+			newSnapshotNode("fits", 6, 0),
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "pod", Namespace: "default", UID: "uid-pod"},
+		Spec: v1.PodSpec{Containers: []v1.Container{{Resources: v1.ResourceRequirements{Limits: v1.ResourceList{
+			"aliyun.com/gpu-mem": *resource.NewQuantity(6, resource.DecimalSI),
+		}}}}},
+	}
+	nodeNames := []string{"fragmented", "full", "fits"}
+
+	predicate := Predicate{Name: "test", cache: c, recorder: record.NewFakeRecorder(10)}
+	filterResult := predicate.Handler(context.Background(), &schedulerapi.ExtenderArgs{Pod: pod, NodeNames: &nodeNames})
+	simulateResult := NewGPUShareSimulate(c).Handler(&SimulateArgs{Pod: pod, NodeNames: nodeNames})
+
+	if len(filterResult.FailedNodes) != 2 {
+		t.Fatalf("expected the filter to reject 2 nodes, got %v", filterResult.FailedNodes)
+	}
+	if !reflect.DeepEqual(simulateResult.FailedNodes, map[string]string(filterResult.FailedNodes)) {
+		t.Errorf("expected the failed nodes of the filter %v, got %v", filterResult.FailedNodes, simulateResult.FailedNodes)
+	}
+	if simulateResult.Node != "fits" || simulateResult.Device != 1 {
+		t.Errorf("expected the pod on fits/1, got %s/%d", simulateResult.Node, simulateResult.Device)
+	}
+}
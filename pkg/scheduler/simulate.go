@@ -0,0 +1,97 @@
+package scheduler
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/AliyunContainerService/gpushare-scheduler-extender/pkg/cache"
+	"github.com/AliyunContainerService/gpushare-scheduler-extender/pkg/log"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// Handler runs the filter and the device allocation against a snapshot of each node, so the cache is not changed.
+// Among the candidates, the pod is placed on the device with the least available GPU memory, which is how
+// the device is chosen within one node.
+func (s Simulate) Handler(args *SimulateArgs) *SimulateResult {
+	result := &SimulateResult{
+		Device:      -1,
+		Candidates:  []*Candidate{},
+		FailedNodes: map[string]string{},
+	}
+	if args == nil || args.Pod == nil {
+		result.Error = "arg or pod is nil"
+		return result
+	}
+	pod := args.Pod
+	if profile := s.cache.GetProfiles().OfPod(pod); profile.GPUMemoryFromPodResource(pod) <= 0 {
+		result.Error = fmt.Sprintf("the pod doesn't request %s", profile.ResourceName)
+		return result
+	}
+
+	nodeInfos, err := s.getNodeInfos(args.NodeNames, result.FailedNodes)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	for _, info := range nodeInfos {
+		snapshot := info.Clone()
+		if _, err := CheckNodeInfo(pod, snapshot); err != nil {
+			result.FailedNodes[snapshot.GetName()] = err.Error()
+			continue
+		}
+		devID, found := snapshot.AssumeGPUID(pod)
+		if !found {
+			// the same reasons as the filter, CheckNodeInfo has found a device so it's not expected
+			if rejection := snapshot.Reject(pod); rejection != nil {
+				result.FailedNodes[snapshot.GetName()] = rejection.Error()
+			} else {
+				result.FailedNodes[snapshot.GetName()] = fmt.Sprintf("no device of node %s is allocated to the pod", snapshot.GetName())
+			}
+			continue
+		}
+		result.Candidates = append(result.Candidates, &Candidate{
+			Node:         snapshot.GetName(),
+			Device:       devID,
+			AvailableGPU: snapshot.GetAvailableGPUs()[devID],
+		})
+	}
+
+	sort.SliceStable(result.Candidates, func(i, j int) bool {
+		if result.Candidates[i].AvailableGPU != result.Candidates[j].AvailableGPU {
+			return result.Candidates[i].AvailableGPU < result.Candidates[j].AvailableGPU
+		}
+		return result.Candidates[i].Node < result.Candidates[j].Node
+	})
+	if len(result.Candidates) > 0 {
+		result.Node = result.Candidates[0].Node
+		result.Device = result.Candidates[0].Device
+	}
+
+	predicateLog.V(10).Debug("simulate pod lands on dev of node",
+		log.Pod(pod.Name),
+		log.Namespace(pod.Namespace),
+		log.DevID(result.Device),
+		log.Node(result.Node),
+		log.Int("candidates", len(result.Candidates)))
+	return result
+}
+
+// getNodeInfos returns the nodeInfos of the node names, the nodes which fail are recorded in failedNodes.
+// All the GPU sharing nodes are returned when names is empty.
+func (s Simulate) getNodeInfos(names []string, failedNodes map[string]string) ([]*cache.NodeInfo, error) {
+	if len(names) == 0 {
+		return s.cache.ListNodeInfos(labels.Everything())
+	}
+
+	nodeInfos := []*cache.NodeInfo{}
+	for _, name := range names {
+		info, err := s.cache.GetNodeInfo(name)
+		if err != nil {
+			failedNodes[name] = err.Error()
+			continue
+		}
+		nodeInfos = append(nodeInfos, info)
+	}
+	return nodeInfos, nil
+}
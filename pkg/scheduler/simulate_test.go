@@ -0,0 +1,192 @@
+package scheduler
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/AliyunContainerService/gpushare-scheduler-extender/pkg/cache"
+	"github.com/AliyunContainerService/gpushare-scheduler-extender/pkg/log"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	clientgocache "k8s.io/client-go/tools/cache"
+)
+
+func init() {
+	log.NewLoggerWithLevel(0)
+}
+
+// testNode has 2 devices of 8 gpu memory
+type testNode struct {
+	name   string
+	labels map[string]string
+	// used is the gpu memory used on each device by the pod named <node>-<device>
+	used      []uint
+	unhealthy []int
+}
+
+func newTestCache(t *testing.T, testNodes ...testNode) *cache.SchedulerCache {
+	nodes := clientgocache.NewIndexer(clientgocache.MetaNamespaceKeyFunc, clientgocache.Indexers{})
+	pods := clientgocache.NewIndexer(clientgocache.MetaNamespaceKeyFunc, clientgocache.Indexers{clientgocache.NamespaceIndex: clientgocache.MetaNamespaceIndexFunc})
+	configMaps := clientgocache.NewIndexer(clientgocache.MetaNamespaceKeyFunc, clientgocache.Indexers{clientgocache.NamespaceIndex: clientgocache.MetaNamespaceIndexFunc})
+	for _, n := range testNodes {
+		err := nodes.Add(&v1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: n.name, Labels: n.labels},
+			Status: v1.NodeStatus{Capacity: v1.ResourceList{
+				"aliyun.com/gpu-mem":   resource.MustParse("16"),
+				"aliyun.com/gpu-count": resource.MustParse("2"),
+			}},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(n.unhealthy) > 0 {
+			ids := []string{}
+			for _, id := range n.unhealthy {
+				ids = append(ids, strconv.Itoa(id))
+			}
+			err = configMaps.Add(&v1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{Name: cache.UnhealthyConfigMapName(n.name), Namespace: cache.ConfigMapNamespace},
+				Data:       map[string]string{"gpus": strings.Join(ids, ",")},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		for id, gpuMem := range n.used {
+			if gpuMem == 0 {
+				continue
+			}
+			pod := newGPUPod(fmt.Sprintf("%s-%d", n.name, id), gpuMem)
+			pod.Spec.NodeName = n.name
+			pod.Status.Phase = v1.PodRunning
+			pod.Annotations = map[string]string{
+				"ALIYUN_COM_GPU_MEM_IDX":      strconv.Itoa(id),
+				"ALIYUN_COM_GPU_MEM_POD":      strconv.Itoa(int(gpuMem)),
+				"ALIYUN_COM_GPU_MEM_ASSIGNED": "true",
+			}
+			if err := pods.Add(pod); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	c := cache.NewSchedulerCache(corelisters.NewNodeLister(nodes), corelisters.NewPodLister(pods), corelisters.NewConfigMapLister(configMaps))
+	if err := c.BuildCache(); err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// newGPUPod requests the gpu memory, or no GPU if it's 0
+func newGPUPod(name string, gpuMem uint) *v1.Pod {
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", UID: types.UID("uid-" + name)},
+		Spec:       v1.PodSpec{Containers: []v1.Container{{Name: "main"}}},
+	}
+	if gpuMem > 0 {
+		pod.Spec.Containers[0].Resources.Limits = v1.ResourceList{
+			"aliyun.com/gpu-mem": *resource.NewQuantity(int64(gpuMem), resource.DecimalSI),
+		}
+	}
+	return pod
+}
+
+func TestSimulate(t *testing.T) {
+	// a has 3 and 8 free, b has 6 and 1 free
+	c := newTestCache(t, testNode{name: "a", used: []uint{5, 0}}, testNode{name: "b", used: []uint{2, 7}})
+
+	tests := []struct {
+		name     string
+		args     *SimulateArgs
+		wantNode string
+		wantDev  int
+		// wantCandidates are <node>/<device> in the order of the result
+		wantCandidates []string
+		wantFailed     []string
+		wantError      string
+	}{
+		{
+			name:      "no pod",
+			args:      &SimulateArgs{},
+			wantDev:   -1,
+			wantError: "arg or pod is nil",
+		},
+		{
+			name:      "pod without GPU",
+			args:      &SimulateArgs{Pod: newGPUPod("cpu", 0)},
+			wantDev:   -1,
+			wantError: "the pod doesn't request aliyun.com/gpu-mem",
+		},
+		{
+			name:           "device with the least available memory is chosen",
+			args:           &SimulateArgs{Pod: newGPUPod("p", 3)},
+			wantNode:       "a",
+			wantDev:        0,
+			wantCandidates: []string{"a/0", "b/0"},
+		},
+		{
+			name:           "node without a device large enough fails",
+			args:           &SimulateArgs{Pod: newGPUPod("p", 7)},
+			wantNode:       "a",
+			wantDev:        1,
+			wantCandidates: []string{"a/1"},
+			wantFailed:     []string{"b"},
+		},
+		{
+			name:           "unknown node of the names fails",
+			args:           &SimulateArgs{Pod: newGPUPod("p", 3), NodeNames: []string{"b", "missing"}},
+			wantNode:       "b",
+			wantDev:        0,
+			wantCandidates: []string{"b/0"},
+			wantFailed:     []string{"missing"},
+		},
+		{
+			name:       "no node fits",
+			args:       &SimulateArgs{Pod: newGPUPod("p", 9)},
+			wantDev:    -1,
+			wantFailed: []string{"a", "b"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result := NewGPUShareSimulate(c).Handler(test.args)
+			if result.Error != test.wantError {
+				t.Fatalf("expected error %q, got %q", test.wantError, result.Error)
+			}
+			if result.Node != test.wantNode || result.Device != test.wantDev {
+				t.Errorf("expected dev %d of node %q, got dev %d of node %q", test.wantDev, test.wantNode, result.Device, result.Node)
+			}
+			candidates := []string{}
+			for _, candidate := range result.Candidates {
+				candidates = append(candidates, fmt.Sprintf("%s/%d", candidate.Node, candidate.Device))
+			}
+			if fmt.Sprint(candidates) != fmt.Sprint(test.wantCandidates) {
+				t.Errorf("expected the candidates %v, got %v", test.wantCandidates, candidates)
+			}
+			failed := []string{}
+			for name := range result.FailedNodes {
+				failed = append(failed, name)
+			}
+			sort.Strings(failed)
+			if fmt.Sprint(failed) != fmt.Sprint(test.wantFailed) {
+				t.Errorf("expected the failed nodes %v, got %v", test.wantFailed, failed)
+			}
+		})
+	}
+
+	// the simulations don't allocate the devices in the cache
+	info, err := c.GetNodeInfo("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if available := info.GetAvailableGPUs(); available[0] != 3 || available[1] != 8 {
+		t.Errorf("expected the cache unchanged with 3 and 8 available, got %v", available)
+	}
+}
@@ -0,0 +1,235 @@
+package simulator
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/AliyunContainerService/gpushare-scheduler-extender/pkg/cache"
+	"github.com/AliyunContainerService/gpushare-scheduler-extender/pkg/log"
+	"github.com/AliyunContainerService/gpushare-scheduler-extender/pkg/scheduler"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/uuid"
+	"k8s.io/client-go/kubernetes/fake"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	k8stesting "k8s.io/client-go/testing"
+	clientgocache "k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+	schedulerapi "k8s.io/kube-scheduler/extender/v1"
+)
+
+// Cluster is an in-memory cluster backed by fake listers and a fake clientset. The pods are
+// scheduled through the extender's filter and bind, so the real allocation code is used.
+type Cluster struct {
+	nodes      clientgocache.Indexer
+	pods       clientgocache.Indexer
+	configMaps clientgocache.Indexer
+
+	client *fake.Clientset
+
+	schedulerCache *cache.SchedulerCache
+	predicate      *scheduler.Predicate
+	bind           *scheduler.Bind
+
+	strategy Strategy
+}
+
+// NewCluster builds the cluster from the nodes, the unhealthy GPU configmaps and the pods which are already bound.
+// The pods which are not bound are returned to be scheduled later.
+func NewCluster(objs []runtime.Object, strategy Strategy) (c *Cluster, pending []*v1.Pod, err error) {
+	c = &Cluster{
+		nodes:      clientgocache.NewIndexer(clientgocache.MetaNamespaceKeyFunc, clientgocache.Indexers{}),
+		pods:       clientgocache.NewIndexer(clientgocache.MetaNamespaceKeyFunc, clientgocache.Indexers{}),
+		configMaps: clientgocache.NewIndexer(clientgocache.MetaNamespaceKeyFunc, clientgocache.Indexers{}),
+		client:     fake.NewSimpleClientset(),
+		strategy:   strategy,
+	}
+	c.client.PrependReactor("create", "pods", c.bindReactor)
+
+	c.schedulerCache = cache.NewSchedulerCache(corelisters.NewNodeLister(c.nodes), corelisters.NewPodLister(c.pods), corelisters.NewConfigMapLister(c.configMaps))
+	// The events of the simulated pods are dropped
+	c.predicate = scheduler.NewGPUsharePredicate(c.client, c.schedulerCache, &record.FakeRecorder{})
+	c.bind = scheduler.NewGPUShareBind(c.client, c.schedulerCache, &record.FakeRecorder{})
+
+	bound := []*v1.Pod{}
+	for _, obj := range objs {
+		switch t := obj.(type) {
+		case *v1.Node:
+			err = c.nodes.Add(t)
+		case *v1.ConfigMap:
+			if len(t.Namespace) == 0 {
+				t.Namespace = cache.ConfigMapNamespace
+			}
+			err = c.configMaps.Add(t)
+		case *v1.Pod:
+			defaultPod(t)
+			if len(t.Spec.NodeName) == 0 {
+				pending = append(pending, t)
+				continue
+			}
+			bound = append(bound, t)
+		default:
+			log.V(3).Warn("skip the object which is not node, pod or configmap", log.String("type", fmt.Sprintf("%T", obj)))
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	// add the bound pods after all the nodes are known
+	for _, pod := range bound {
+		if err := c.addPod(pod); err != nil {
+			return nil, nil, err
+		}
+	}
+	return c, pending, nil
+}
+
+// GetSchedulerCache returns the cache which is built from the cluster
+func (c *Cluster) GetSchedulerCache() *cache.SchedulerCache {
+	return c.schedulerCache
+}
+
+// Schedule runs the filter on all the nodes, picks one by the strategy and binds the pod.
+// It returns the reason if the pod can't be scheduled.
+func (c *Cluster) Schedule(pod *v1.Pod) (nodeName string, reason string, err error) {
+	defaultPod(pod)
+	if err := c.client.Tracker().Add(pod); err != nil {
+		return "", "", err
+	}
+	if err := c.pods.Add(pod); err != nil {
+		return "", "", err
+	}
+
+	filterResult := c.Filter(pod)
+	if len(filterResult.Error) > 0 {
+		return "", filterResult.Error, nil
+	}
+	if filterResult.NodeNames == nil || len(*filterResult.NodeNames) == 0 {
+		return "", summarizeFailedNodes(filterResult.FailedNodes), nil
+	}
+
+	candidates := []*cache.NodeInfo{}
+	for _, name := range *filterResult.NodeNames {
+		info, err := c.schedulerCache.GetNodeInfo(name)
+		if err != nil {
+			return "", "", err
+		}
+		candidates = append(candidates, info)
+	}
+	nodeName = c.strategy(pod, candidates)
+
+	bindResult := c.bind.Handler(context.Background(), schedulerapi.ExtenderBindingArgs{
+		PodName:      pod.Name,
+		PodNamespace: pod.Namespace,
+		PodUID:       pod.UID,
+		Node:         nodeName,
+	})
+	if len(bindResult.Error) > 0 {
+		return "", "", fmt.Errorf("failed to bind pod %s in ns %s to node %s: %s", pod.Name, pod.Namespace, nodeName, bindResult.Error)
+	}
+
+	// sync the bound pod into the cache as the controller does
+	obj, err := c.client.Tracker().Get(v1.SchemeGroupVersion.WithResource("pods"), pod.Namespace, pod.Name)
+	if err != nil {
+		return "", "", err
+	}
+	if err := c.pods.Update(obj); err != nil {
+		return "", "", err
+	}
+	return nodeName, "", c.schedulerCache.AddOrUpdatePod(obj.(*v1.Pod))
+}
+
+// Filter runs the extender's filter of the pod on all the nodes without scheduling it
+func (c *Cluster) Filter(pod *v1.Pod) *schedulerapi.ExtenderFilterResult {
+	nodeNames := []string{}
+	for _, key := range c.nodes.ListKeys() {
+		nodeNames = append(nodeNames, key)
+	}
+	sort.Strings(nodeNames)
+
+	return c.predicate.Handler(context.Background(), &schedulerapi.ExtenderArgs{Pod: pod, NodeNames: &nodeNames})
+}
+
+// Delete removes the pod from the cluster and releases its GPU memory
+func (c *Cluster) Delete(namespace, name string) error {
+	obj, exists, err := c.pods.GetByKey(namespace + "/" + name)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return fmt.Errorf("pod %s in ns %s is not found", name, namespace)
+	}
+
+	pod := obj.(*v1.Pod)
+	if len(pod.Spec.NodeName) > 0 {
+		c.schedulerCache.RemovePod(pod)
+	}
+	if err := c.pods.Delete(pod); err != nil {
+		return err
+	}
+	return c.client.Tracker().Delete(v1.SchemeGroupVersion.WithResource("pods"), namespace, name)
+}
+
+func (c *Cluster) addPod(pod *v1.Pod) error {
+	if err := c.client.Tracker().Add(pod); err != nil {
+		return err
+	}
+	if err := c.pods.Add(pod); err != nil {
+		return err
+	}
+	return c.schedulerCache.AddOrUpdatePod(pod)
+}
+
+// bindReactor sets the node name of the pod, which the fake clientset doesn't do for the binding subresource
+func (c *Cluster) bindReactor(action k8stesting.Action) (bool, runtime.Object, error) {
+	if action.GetSubresource() != "binding" {
+		return false, nil, nil
+	}
+	binding := action.(k8stesting.CreateAction).GetObject().(*v1.Binding)
+	gvr := v1.SchemeGroupVersion.WithResource("pods")
+	obj, err := c.client.Tracker().Get(gvr, binding.Namespace, binding.Name)
+	if err != nil {
+		return true, nil, err
+	}
+	pod := obj.(*v1.Pod).DeepCopy()
+	pod.Spec.NodeName = binding.Target.Name
+	pod.Status.Phase = v1.PodRunning
+	return true, binding, c.client.Tracker().Update(gvr, pod, pod.Namespace)
+}
+
+func defaultPod(pod *v1.Pod) {
+	if len(pod.Namespace) == 0 {
+		pod.Namespace = metav1.NamespaceDefault
+	}
+	if len(pod.UID) == 0 {
+		pod.UID = types.UID(uuid.NewUUID())
+	}
+	if len(pod.Spec.NodeName) > 0 && len(pod.Status.Phase) == 0 {
+		pod.Status.Phase = v1.PodRunning
+	}
+}
+
+// summarizeFailedNodes counts the nodes by the reason why the pod can't be scheduled
+func summarizeFailedNodes(failedNodes map[string]string) string {
+	counts := map[string]int{}
+	for _, reason := range failedNodes {
+		counts[reason]++
+	}
+	reasons := []string{}
+	for reason, count := range counts {
+		reasons = append(reasons, fmt.Sprintf("%d node(s): %s", count, reason))
+	}
+	sort.Strings(reasons)
+	if len(reasons) == 0 {
+		return "no nodes available"
+	}
+	summary := reasons[0]
+	for _, reason := range reasons[1:] {
+		summary += "; " + reason
+	}
+	return summary
+}
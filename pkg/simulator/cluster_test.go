@@ -0,0 +1,171 @@
+package simulator
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/AliyunContainerService/gpushare-scheduler-extender/pkg/log"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func init() {
+	log.NewLoggerWithLevel(0)
+}
+
+func newTestPod(name string, gpuMem int64) *v1.Pod {
+	return &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Spec: v1.PodSpec{Containers: []v1.Container{{
+			Name: "main",
+			Resources: v1.ResourceRequirements{Limits: v1.ResourceList{
+				"aliyun.com/gpu-mem": *resource.NewQuantity(gpuMem, resource.DecimalSI),
+			}},
+		}}},
+	}
+}
+
+// usedGPU formats the used gpu memory of the devices, e.g. "a:5,8 b:0,0"
+func usedGPU(report *Report) string {
+	nodes := []string{}
+	for _, node := range report.Nodes {
+		devs := []string{}
+		for _, dev := range node.Devices {
+			devs = append(devs, fmt.Sprint(dev.UsedGPU))
+		}
+		nodes = append(nodes, node.Name+":"+strings.Join(devs, ","))
+	}
+	return strings.Join(nodes, " ")
+}
+
+func TestReplaySamples(t *testing.T) {
+	tests := []struct {
+		strategy string
+		wantUsed string
+	}{
+		{strategy: "binpack", wantUsed: "gpu-node-1:5,8 gpu-node-2:8,8"},
+		{strategy: "spread", wantUsed: "gpu-node-1:8,8 gpu-node-2:5,8"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.strategy, func(t *testing.T) {
+			objs, _, err := LoadObjects("../../samples/sim/cluster.yaml")
+			if err != nil {
+				t.Fatal(err)
+			}
+			fileEvents, err := LoadEvents("../../samples/sim/events.yaml")
+			if err != nil {
+				t.Fatal(err)
+			}
+			strategy, err := GetStrategy(test.strategy)
+			if err != nil {
+				t.Fatal(err)
+			}
+			cluster, pending, err := NewCluster(objs, strategy)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// the pending pods of the cluster file arrive before the events
+			events := []Event{}
+			for _, pod := range pending {
+				events = append(events, Event{Action: ActionAdd, Pod: pod})
+			}
+			events = append(events, fileEvents...)
+
+			stats := cluster.Replay(events)
+			if stats.Scheduled != 4 || stats.Deleted != 1 || stats.Failed != 0 || len(stats.Pending) != 0 {
+				t.Errorf("expected 4 scheduled and 1 deleted, got %+v", stats)
+			}
+			report, err := cluster.Report(stats)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if used := usedGPU(report); used != test.wantUsed {
+				t.Errorf("expected the used gpu memory %q, got %q", test.wantUsed, used)
+			}
+		})
+	}
+}
+
+func TestReplay(t *testing.T) {
+	tests := []struct {
+		name          string
+		events        []Event
+		wantScheduled int
+		wantDeleted   int
+		wantPending   []string
+		wantUsed      string
+	}{
+		{
+			name:        "pod larger than a device stays pending",
+			events:      []Event{{Action: ActionAdd, Pod: newTestPod("large", 9)}},
+			wantPending: []string{"large"},
+			wantUsed:    "a:0,0",
+		},
+		{
+			name:     "departure of an unknown pod is skipped",
+			events:   []Event{{Action: ActionDelete, Name: "missing"}},
+			wantUsed: "a:0,0",
+		},
+		{
+			name: "pending pod is retried after a departure",
+			events: []Event{
+				{Action: ActionAdd, Pod: newTestPod("p1", 8)},
+				{Action: ActionAdd, Pod: newTestPod("p2", 8)},
+				{Action: ActionAdd, Pod: newTestPod("p3", 6)},
+				{Action: ActionDelete, Name: "p1"},
+			},
+			wantScheduled: 3,
+			wantDeleted:   1,
+			wantUsed:      "a:6,8",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			node := &v1.Node{
+				ObjectMeta: metav1.ObjectMeta{Name: "a"},
+				Status: v1.NodeStatus{Capacity: v1.ResourceList{
+					"aliyun.com/gpu-mem":   resource.MustParse("16"),
+					"aliyun.com/gpu-count": resource.MustParse("2"),
+				}},
+			}
+			strategy, _ := GetStrategy("binpack")
+			cluster, _, err := NewCluster([]runtime.Object{node}, strategy)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			stats := cluster.Replay(test.events)
+			if stats.Scheduled != test.wantScheduled || stats.Deleted != test.wantDeleted {
+				t.Errorf("expected %d scheduled and %d deleted, got %+v", test.wantScheduled, test.wantDeleted, stats)
+			}
+			pending := []string{}
+			for _, pod := range stats.Pending {
+				if len(pod.Reason) == 0 {
+					t.Errorf("expected the reason of the pending pod %s", pod.Name)
+				}
+				pending = append(pending, pod.Name)
+			}
+			if fmt.Sprint(pending) != fmt.Sprint(test.wantPending) {
+				t.Errorf("expected the pending pods %v, got %v", test.wantPending, pending)
+			}
+			report, err := cluster.Report(stats)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if used := usedGPU(report); used != test.wantUsed {
+				t.Errorf("expected the used gpu memory %q, got %q", test.wantUsed, used)
+			}
+		})
+	}
+}
+
+func TestGetStrategy(t *testing.T) {
+	if _, err := GetStrategy("random"); err == nil {
+		t.Error("expected an error of the unknown strategy")
+	}
+}
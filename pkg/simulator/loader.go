@@ -0,0 +1,151 @@
+package simulator
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+
+	"github.com/AliyunContainerService/gpushare-scheduler-extender/pkg/cache"
+	"github.com/AliyunContainerService/gpushare-scheduler-extender/pkg/utils"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/client-go/kubernetes/scheme"
+)
+
+// LoadObjects reads the nodes, pods and configmaps from the YAML or JSON files.
+// A file can hold several documents, a List is flattened into its items, and
+// a snapshot dumped from the extender is converted into the objects.
+// The profiles of the snapshots are returned, nil if there is no snapshot; the caller
+// sets them with utils.SetProfiles before the cluster is built, as the extender does with its configuration.
+func LoadObjects(paths ...string) ([]runtime.Object, utils.Profiles, error) {
+	objs := []runtime.Object{}
+	var profiles utils.Profiles
+	for _, path := range paths {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, nil, err
+		}
+		fileObjs, fileProfiles, err := decodeObjects(f)
+		f.Close()
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to load %s: %v", path, err)
+		}
+		if profiles, err = mergeProfiles(profiles, fileProfiles); err != nil {
+			return nil, nil, fmt.Errorf("failed to load %s: %v", path, err)
+		}
+		objs = append(objs, fileObjs...)
+	}
+	return objs, profiles, nil
+}
+
+func decodeObjects(r io.Reader) (objs []runtime.Object, profiles utils.Profiles, err error) {
+	objs = []runtime.Object{}
+	decoder := yaml.NewYAMLOrJSONDecoder(r, 4096)
+	for {
+		var raw runtime.RawExtension
+		if err := decoder.Decode(&raw); err != nil {
+			if err == io.EOF {
+				return objs, profiles, nil
+			}
+			return nil, nil, err
+		}
+		if len(raw.Raw) == 0 || string(raw.Raw) == "null" {
+			continue
+		}
+
+		snapshot, err := decodeSnapshot(raw.Raw)
+		if err != nil {
+			return nil, nil, err
+		}
+		if snapshot != nil {
+			snapshotObjs, err := snapshot.Objects()
+			if err != nil {
+				return nil, nil, err
+			}
+			if profiles, err = mergeProfiles(profiles, snapshot.Profiles); err != nil {
+				return nil, nil, err
+			}
+			objs = append(objs, snapshotObjs...)
+			continue
+		}
+
+		obj, _, err := scheme.Codecs.UniversalDeserializer().Decode(raw.Raw, nil, nil)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		list, ok := obj.(*v1.List)
+		if !ok {
+			objs = append(objs, obj)
+			continue
+		}
+		for _, item := range list.Items {
+			itemObj, _, err := scheme.Codecs.UniversalDeserializer().Decode(item.Raw, nil, nil)
+			if err != nil {
+				return nil, nil, err
+			}
+			objs = append(objs, itemObj)
+		}
+	}
+}
+
+// mergeProfiles checks that the snapshots have the same profiles, they are read with one set of profiles
+func mergeProfiles(profiles, other utils.Profiles) (utils.Profiles, error) {
+	if len(other) == 0 {
+		return profiles, nil
+	}
+	if len(profiles) > 0 && !reflect.DeepEqual(profiles, other) {
+		return nil, fmt.Errorf("the profiles of the snapshots are different")
+	}
+	return other, nil
+}
+
+// LoadEvents reads the pod arrivals and departures from a YAML or JSON file
+func LoadEvents(path string) ([]Event, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	events := []Event{}
+	if err := yaml.NewYAMLOrJSONDecoder(f, 4096).Decode(&events); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("failed to load %s: %v", path, err)
+	}
+	for i, event := range events {
+		switch event.Action {
+		case ActionAdd:
+			if event.Pod == nil {
+				return nil, fmt.Errorf("event %d adds no pod", i)
+			}
+		case ActionDelete:
+			if len(event.Name) == 0 {
+				return nil, fmt.Errorf("event %d deletes no pod", i)
+			}
+		default:
+			return nil, fmt.Errorf("event %d has unknown action %q", i, event.Action)
+		}
+	}
+	return events, nil
+}
+
+// decodeSnapshot decodes the document as a snapshot if it has a version but no kind,
+// it returns nil for the other documents
+func decodeSnapshot(data []byte) (*cache.Snapshot, error) {
+	var header struct {
+		Kind    string `json:"kind"`
+		Version string `json:"version"`
+	}
+	if err := json.Unmarshal(data, &header); err != nil || len(header.Kind) > 0 || len(header.Version) == 0 {
+		return nil, nil
+	}
+
+	snapshot := &cache.Snapshot{}
+	if err := json.Unmarshal(data, snapshot); err != nil {
+		return nil, fmt.Errorf("failed to decode the snapshot: %v", err)
+	}
+	return snapshot, nil
+}
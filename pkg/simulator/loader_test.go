@@ -0,0 +1,172 @@
+package simulator
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeTestFile(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "test.yaml")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// otherProfile is a profile of the snapshots which the reader doesn't know
+const otherProfile = `{"name": "other", "resourceName": "example.com/gpu-mem", "countName": "example.com/gpu-count", "indexKey": "EXAMPLE_IDX", "podKey": "EXAMPLE_POD", "deviceKey": "EXAMPLE_DEV", "assignedKey": "EXAMPLE_ASSIGNED", "assumeTimeKey": "EXAMPLE_ASSUME_TIME"}`
+
+func TestLoadObjects(t *testing.T) {
+	tests := []struct {
+		name      string
+		content   string
+		wantKinds string
+		// wantProfiles are the names of the returned profiles
+		wantProfiles string
+		wantError    string
+	}{
+		{
+			name: "documents and a list",
+			content: `
+kind: Node
+apiVersion: v1
+metadata:
+  name: a
+---
+kind: List
+apiVersion: v1
+items:
+- kind: Pod
+  apiVersion: v1
+  metadata:
+    name: p1
+- kind: ConfigMap
+  apiVersion: v1
+  metadata:
+    name: unhealthy-gpu-a
+`,
+			wantKinds: "*v1.Node *v1.Pod *v1.ConfigMap",
+		},
+		{
+			name:      "snapshot",
+			content:   `{"version": "gpushare.snapshot/v1", "nodes": [{"name": "a", "gpuCount": 2, "totalGPUMemory": 16, "unhealthyDevices": [1], "devs": [{"id": 0, "totalGPUMemory": 8, "pods": [{"name": "p1", "namespace": "default", "gpuMemory": 4}]}]}]}`,
+			wantKinds: "*v1.Node *v1.ConfigMap *v1.Pod",
+		},
+		{
+			name:         "snapshot with the profiles of the extender",
+			content:      `{"version": "gpushare.snapshot/v1", "profiles": [` + otherProfile + `], "nodes": [{"name": "a", "gpuCount": 1, "totalGPUMemory": 8, "devs": [{"id": 0, "totalGPUMemory": 8, "pods": [{"name": "p1", "namespace": "default", "gpuMemory": 4}]}]}]}`,
+			wantKinds:    "*v1.Node *v1.Pod",
+			wantProfiles: "other",
+		},
+		{
+			name: "snapshots with different profiles",
+			content: `{"version": "gpushare.snapshot/v1", "profiles": [` + otherProfile + `], "nodes": []}
+{"version": "gpushare.snapshot/v1", "profiles": [{"name": "default", "resourceName": "aliyun.com/gpu-mem"}], "nodes": []}`,
+			wantError: "the profiles of the snapshots are different",
+		},
+		{
+			name:      "snapshot which fails to decode",
+			content:   `{"version": "gpushare.snapshot/v1", "nodes": {"name": "a"}}`,
+			wantError: "failed to decode the snapshot",
+		},
+		{
+			name:      "snapshot of an unsupported version",
+			content:   `{"version": "gpushare.snapshot/v0", "nodes": []}`,
+			wantError: "unsupported snapshot version",
+		},
+		{
+			name:      "unknown kind",
+			content:   "kind: Unknown\napiVersion: v1\n",
+			wantError: "failed to load",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			objs, profiles, err := LoadObjects(writeTestFile(t, test.content))
+			if len(test.wantError) > 0 {
+				if err == nil || !strings.Contains(err.Error(), test.wantError) {
+					t.Fatalf("expected error containing %q, got %v", test.wantError, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			kinds := []string{}
+			for _, obj := range objs {
+				kinds = append(kinds, fmt.Sprintf("%T", obj))
+			}
+			if got := strings.Join(kinds, " "); got != test.wantKinds {
+				t.Errorf("expected the objects %q, got %q", test.wantKinds, got)
+			}
+			names := []string{}
+			for _, profile := range profiles {
+				names = append(names, profile.Name)
+			}
+			if got := strings.Join(names, " "); got != test.wantProfiles {
+				t.Errorf("expected the profiles %q, got %q", test.wantProfiles, got)
+			}
+		})
+	}
+}
+
+func TestLoadEvents(t *testing.T) {
+	tests := []struct {
+		name       string
+		content    string
+		wantEvents int
+		wantError  string
+	}{
+		{
+			name:       "arrival and departure",
+			content:    "- action: add\n  pod:\n    metadata:\n      name: p1\n- action: delete\n  name: p1\n",
+			wantEvents: 2,
+		},
+		{
+			name:    "empty file",
+			content: "",
+		},
+		{
+			name:      "arrival without a pod",
+			content:   "- action: add\n",
+			wantError: "event 0 adds no pod",
+		},
+		{
+			name:      "departure without a name",
+			content:   "- action: add\n  pod:\n    metadata:\n      name: p1\n- action: delete\n",
+			wantError: "event 1 deletes no pod",
+		},
+		{
+			name:      "unknown action",
+			content:   "- action: update\n",
+			wantError: `event 0 has unknown action "update"`,
+		},
+		{
+			name:      "not a list",
+			content:   "action: add\n",
+			wantError: "failed to load",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			events, err := LoadEvents(writeTestFile(t, test.content))
+			if len(test.wantError) > 0 {
+				if err == nil || !strings.Contains(err.Error(), test.wantError) {
+					t.Fatalf("expected error containing %q, got %v", test.wantError, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(events) != test.wantEvents {
+				t.Errorf("expected %d events, got %d", test.wantEvents, len(events))
+			}
+		})
+	}
+}
@@ -0,0 +1,113 @@
+package simulator
+
+import (
+	"github.com/AliyunContainerService/gpushare-scheduler-extender/pkg/log"
+	"github.com/AliyunContainerService/gpushare-scheduler-extender/pkg/utils"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+type Action string
+
+const (
+	ActionAdd    Action = "add"
+	ActionDelete Action = "delete"
+)
+
+// Event is a pod arrival or departure
+type Event struct {
+	Action Action `json:"action"`
+	// Pod is the arriving pod
+	Pod *v1.Pod `json:"pod,omitempty"`
+	// Namespace and Name are the departing pod
+	Namespace string `json:"namespace,omitempty"`
+	Name      string `json:"name,omitempty"`
+}
+
+// PendingPod is a pod which can't be scheduled at the end of the replay
+type PendingPod struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+	GPUMemory int    `json:"gpuMemory"`
+	Reason    string `json:"reason"`
+}
+
+// Stats counts the outcome of the replay
+type Stats struct {
+	Scheduled int           `json:"scheduled"`
+	Deleted   int           `json:"deleted"`
+	Failed    int           `json:"failed"`
+	Pending   []*PendingPod `json:"pending"`
+}
+
+// Replay applies the events in order. The pods which can't be scheduled wait in a queue,
+// and they are retried in their arrival order after each departure.
+func (c *Cluster) Replay(events []Event) *Stats {
+	stats := &Stats{Pending: []*PendingPod{}}
+	queue := []*v1.Pod{}
+	reasons := map[*v1.Pod]string{}
+
+	schedule := func(pod *v1.Pod) (scheduled bool) {
+		nodeName, reason, err := c.Schedule(pod)
+		switch {
+		case err != nil:
+			log.V(3).Warn("failed to schedule pod", log.Pod(pod.Name), log.Namespace(pod.Namespace), log.Err(err))
+			stats.Failed++
+			return true
+		case len(nodeName) == 0:
+			reasons[pod] = reason
+			return false
+		default:
+			log.V(10).Debug("scheduled pod to node", log.Pod(pod.Name), log.Namespace(pod.Namespace), log.Node(nodeName))
+			stats.Scheduled++
+			return true
+		}
+	}
+
+	for _, event := range events {
+		switch event.Action {
+		case ActionAdd:
+			pod := event.Pod.DeepCopy()
+			if !schedule(pod) {
+				queue = append(queue, pod)
+			}
+		case ActionDelete:
+			namespace := event.Namespace
+			if len(namespace) == 0 {
+				namespace = metav1.NamespaceDefault
+			}
+			if err := c.Delete(namespace, event.Name); err != nil {
+				log.V(3).Warn("failed to delete pod", log.Pod(event.Name), log.Namespace(namespace), log.Err(err))
+				continue
+			}
+			stats.Deleted++
+			queue = c.retry(queue, schedule)
+		}
+	}
+
+	for _, pod := range queue {
+		stats.Pending = append(stats.Pending, &PendingPod{
+			Name:      pod.Name,
+			Namespace: pod.Namespace,
+			GPUMemory: utils.GetGPUMemoryFromPodResource(pod),
+			Reason:    reasons[pod],
+		})
+	}
+	return stats
+}
+
+// retry schedules the pending pods, and returns the ones which are still pending
+func (c *Cluster) retry(queue []*v1.Pod, schedule func(pod *v1.Pod) bool) []*v1.Pod {
+	pending := []*v1.Pod{}
+	for _, pod := range queue {
+		// the pending pod is added again by Schedule
+		if err := c.Delete(pod.Namespace, pod.Name); err != nil {
+			log.V(3).Warn("failed to requeue pod", log.Pod(pod.Name), log.Namespace(pod.Namespace), log.Err(err))
+			continue
+		}
+		if !schedule(pod) {
+			pending = append(pending, pod)
+		}
+	}
+	return pending
+}
@@ -0,0 +1,156 @@
+package simulator
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/AliyunContainerService/gpushare-scheduler-extender/pkg/cache"
+	"github.com/AliyunContainerService/gpushare-scheduler-extender/pkg/utils"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// Report is the GPU utilization and fragmentation of the cluster
+type Report struct {
+	Nodes []*NodeReport `json:"nodes"`
+
+	TotalGPU uint `json:"totalGPU"`
+	UsedGPU  uint `json:"usedGPU"`
+	// FreeGPU is the free gpu memory of the healthy devices
+	FreeGPU uint `json:"freeGPU"`
+	// LargestFreeGPU is the largest free gpu memory in one device
+	LargestFreeGPU uint    `json:"largestFreeGPU"`
+	Utilization    float64 `json:"utilization"`
+	// Fragmentation is the share of the free gpu memory which is not in the largest free device
+	Fragmentation float64 `json:"fragmentation"`
+	// StrandedGPU is the free gpu memory in the devices which can't fit the smallest pending pod
+	StrandedGPU uint `json:"strandedGPU"`
+
+	Stats *Stats `json:"stats"`
+}
+
+type NodeReport struct {
+	Name     string          `json:"name"`
+	TotalGPU uint            `json:"totalGPU"`
+	UsedGPU  uint            `json:"usedGPU"`
+	Devices  []*DeviceReport `json:"devs"`
+}
+
+type DeviceReport struct {
+	ID        int  `json:"id"`
+	TotalGPU  uint `json:"totalGPU"`
+	UsedGPU   uint `json:"usedGPU"`
+	FreeGPU   uint `json:"freeGPU"`
+	Unhealthy bool `json:"unhealthy,omitempty"`
+	Pods      int  `json:"pods"`
+}
+
+// Report summarizes the current state of the cluster with the replay stats
+func (c *Cluster) Report(stats *Stats) (*Report, error) {
+	return BuildReport(c.schedulerCache, stats)
+}
+
+// BuildReport summarizes the state of the scheduler cache with the replay stats
+func BuildReport(schedulerCache *cache.SchedulerCache, stats *Stats) (*Report, error) {
+	nodeInfos, err := schedulerCache.ListNodeInfos(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(nodeInfos, func(i, j int) bool {
+		return nodeInfos[i].GetName() < nodeInfos[j].GetName()
+	})
+
+	minPending := 0
+	if stats != nil {
+		for _, pod := range stats.Pending {
+			if minPending == 0 || pod.GPUMemory < minPending {
+				minPending = pod.GPUMemory
+			}
+		}
+	}
+
+	report := &Report{Nodes: []*NodeReport{}, Stats: stats}
+	for _, info := range nodeInfos {
+		availableGPUs := info.GetAvailableGPUs()
+		nodeReport := &NodeReport{Name: info.GetName(), Devices: []*DeviceReport{}}
+		for _, dev := range info.GetDevs() {
+			if dev == nil {
+				continue
+			}
+			devReport := &DeviceReport{
+				ID:       dev.GetID(),
+				TotalGPU: dev.GetTotalGPUMemory(),
+				UsedGPU:  dev.GetUsedGPUMemory(),
+			}
+			for _, pod := range dev.GetPods() {
+				if utils.AssignedNonTerminatedPod(pod) {
+					devReport.Pods++
+				}
+			}
+			if free, healthy := availableGPUs[dev.GetID()]; healthy {
+				devReport.FreeGPU = free
+			} else {
+				devReport.Unhealthy = true
+			}
+
+			nodeReport.Devices = append(nodeReport.Devices, devReport)
+			nodeReport.TotalGPU += devReport.TotalGPU
+			nodeReport.UsedGPU += devReport.UsedGPU
+			report.FreeGPU += devReport.FreeGPU
+			if devReport.FreeGPU > report.LargestFreeGPU {
+				report.LargestFreeGPU = devReport.FreeGPU
+			}
+			if minPending > 0 && devReport.FreeGPU < uint(minPending) {
+				report.StrandedGPU += devReport.FreeGPU
+			}
+		}
+		report.Nodes = append(report.Nodes, nodeReport)
+		report.TotalGPU += nodeReport.TotalGPU
+		report.UsedGPU += nodeReport.UsedGPU
+	}
+
+	if report.TotalGPU > 0 {
+		report.Utilization = float64(report.UsedGPU) / float64(report.TotalGPU)
+	}
+	if report.FreeGPU > 0 {
+		report.Fragmentation = 1 - float64(report.LargestFreeGPU)/float64(report.FreeGPU)
+	}
+	return report, nil
+}
+
+// Print writes the report as tables
+func (r *Report) Print(w io.Writer) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "NODE\tDEVICE\tUSED/TOTAL\tFREE\tPODS\tHEALTHY")
+	for _, node := range r.Nodes {
+		for _, dev := range node.Devices {
+			fmt.Fprintf(tw, "%s\t%d\t%d/%d\t%d\t%d\t%v\n",
+				node.Name,
+				dev.ID,
+				dev.UsedGPU,
+				dev.TotalGPU,
+				dev.FreeGPU,
+				dev.Pods,
+				!dev.Unhealthy)
+		}
+	}
+	tw.Flush()
+
+	fmt.Fprintln(w, "------------------------------------------------------------------------------")
+	fmt.Fprintf(w, "Utilization:      %d/%d (%.1f%%)\n", r.UsedGPU, r.TotalGPU, r.Utilization*100)
+	fmt.Fprintf(w, "Free GPU Memory:  %d, largest in one device %d\n", r.FreeGPU, r.LargestFreeGPU)
+	fmt.Fprintf(w, "Fragmentation:    %.1f%%\n", r.Fragmentation*100)
+	if r.Stats == nil {
+		return
+	}
+	fmt.Fprintf(w, "Stranded Memory:  %d\n", r.StrandedGPU)
+	fmt.Fprintf(w, "Pods:             %d scheduled, %d deleted, %d failed, %d pending\n",
+		r.Stats.Scheduled,
+		r.Stats.Deleted,
+		r.Stats.Failed,
+		len(r.Stats.Pending))
+	for _, pod := range r.Stats.Pending {
+		fmt.Fprintf(w, "  pending %s/%s (%d): %s\n", pod.Namespace, pod.Name, pod.GPUMemory, pod.Reason)
+	}
+}
@@ -0,0 +1,56 @@
+package simulator
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/AliyunContainerService/gpushare-scheduler-extender/pkg/cache"
+	v1 "k8s.io/api/core/v1"
+)
+
+// Strategy picks the node from the candidates which pass the filter, it plays the role of kube-scheduler's scoring
+type Strategy func(pod *v1.Pod, candidates []*cache.NodeInfo) string
+
+var strategies = map[string]Strategy{
+	// binpack prefers the node with the least available GPU memory
+	"binpack": func(pod *v1.Pod, candidates []*cache.NodeInfo) string {
+		return pickNode(candidates, func(a, b uint) bool { return a < b })
+	},
+	// spread prefers the node with the most available GPU memory
+	"spread": func(pod *v1.Pod, candidates []*cache.NodeInfo) string {
+		return pickNode(candidates, func(a, b uint) bool { return a > b })
+	},
+}
+
+// GetStrategy returns the strategy by its name
+func GetStrategy(name string) (Strategy, error) {
+	strategy, found := strategies[name]
+	if !found {
+		return nil, fmt.Errorf("unknown strategy %q, it should be binpack or spread", name)
+	}
+	return strategy, nil
+}
+
+func pickNode(candidates []*cache.NodeInfo, better func(a, b uint) bool) string {
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].GetName() < candidates[j].GetName()
+	})
+
+	picked := ""
+	var pickedGPU uint
+	for _, info := range candidates {
+		availableGPU := availableGPUMemory(info)
+		if len(picked) == 0 || better(availableGPU, pickedGPU) {
+			picked = info.GetName()
+			pickedGPU = availableGPU
+		}
+	}
+	return picked
+}
+
+func availableGPUMemory(info *cache.NodeInfo) (gpuMem uint) {
+	for _, availableGPU := range info.GetAvailableGPUs() {
+		gpuMem += availableGPU
+	}
+	return gpuMem
+}
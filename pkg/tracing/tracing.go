@@ -0,0 +1,94 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	// ExporterNone disables tracing, the spans are not recorded
+	ExporterNone = "none"
+	// ExporterStdout writes the spans to stdout, it works offline
+	ExporterStdout = "stdout"
+	// ExporterOTLP sends the spans over OTLP/HTTP, the endpoint is set by OTEL_EXPORTER_OTLP_ENDPOINT
+	ExporterOTLP = "otlp"
+
+	serviceName = "gpushare-scheduler-extender"
+)
+
+// Setup sets the global tracer provider with the exporter, and the W3C trace context propagator.
+// The returned function flushes and stops the exporter.
+func Setup(ctx context.Context, exporter string) (shutdown func(context.Context) error, err error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	var spanExporter sdktrace.SpanExporter
+	switch exporter {
+	case "", ExporterNone:
+		return func(context.Context) error { return nil }, nil
+	case ExporterStdout:
+		spanExporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+	case ExporterOTLP:
+		spanExporter, err = otlptracehttp.New(ctx)
+	default:
+		return nil, fmt.Errorf("unknown tracing exporter %q, it should be %s, %s or %s", exporter, ExporterNone, ExporterStdout, ExporterOTLP)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(spanExporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.AlwaysSample())),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceNameKey.String(serviceName))),
+	)
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+// StartSpan starts a span of the extender, it's a no-op until Setup is called with an exporter
+func StartSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(serviceName).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// StartServerSpan starts the span of an incoming request, with the trace context propagated by the caller
+func StartServerSpan(r *http.Request, name string) (context.Context, trace.Span) {
+	ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+	return otel.Tracer(serviceName).Start(ctx, name,
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(semconv.HTTPMethodKey.String(r.Method), semconv.HTTPTargetKey.String(r.URL.Path)))
+}
+
+// EndSpan records the error if any, and ends the span
+func EndSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// Pod returns the attributes of the pod
+func Pod(name, namespace string) []attribute.KeyValue {
+	return []attribute.KeyValue{
+		attribute.String("pod", name),
+		attribute.String("namespace", namespace),
+	}
+}
+
+// Node returns the attribute of the node
+func Node(name string) attribute.KeyValue {
+	return attribute.String("node", name)
+}
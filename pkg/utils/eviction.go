@@ -0,0 +1,25 @@
+package utils
+
+import (
+	"context"
+
+	v1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// EvictPod evicts the pod through the Eviction API, so the PodDisruptionBudgets are respected.
+// The eviction is rejected with TooManyRequests when it's disallowed by a PodDisruptionBudget.
+func EvictPod(ctx context.Context, clientset kubernetes.Interface, pod *v1.Pod) error {
+	eviction := &policyv1.Eviction{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      pod.Name,
+			Namespace: pod.Namespace,
+		},
+		DeleteOptions: &metav1.DeleteOptions{
+			Preconditions: &metav1.Preconditions{UID: &pod.UID},
+		},
+	}
+	return clientset.PolicyV1().Evictions(pod.Namespace).Evict(ctx, eviction)
+}
@@ -1,6 +1,10 @@
 package utils
 
-import "k8s.io/api/core/v1"
+import (
+	"strings"
+
+	"k8s.io/api/core/v1"
+)
 
 // Is the Node for GPU sharing
 func IsGPUSharingNode(node *v1.Node) bool {
@@ -9,7 +13,17 @@ func IsGPUSharingNode(node *v1.Node) bool {
 
 // Get the total GPU memory of the Node
 func GetTotalGPUMemory(node *v1.Node) int {
-	val, ok := node.Status.Capacity[ResourceName]
+	return GetProfileOfNode(node).TotalGPUMemory(node)
+}
+
+// Get the GPU count of the node
+func GetGPUCountInNode(node *v1.Node) int {
+	return GetProfileOfNode(node).GPUCount(node)
+}
+
+// TotalGPUMemory gets the total GPU memory of the node in the resource of the profile
+func (p *Profile) TotalGPUMemory(node *v1.Node) int {
+	val, ok := node.Status.Capacity[p.ResourceName]
 
 	if !ok {
 		return 0
@@ -18,9 +32,9 @@ func GetTotalGPUMemory(node *v1.Node) int {
 	return int(val.Value())
 }
 
-// Get the GPU count of the node
-func GetGPUCountInNode(node *v1.Node) int {
-	val, ok := node.Status.Capacity[CountName]
+// GPUCount gets the GPU count of the node in the resource of the profile
+func (p *Profile) GPUCount(node *v1.Node) int {
+	val, ok := node.Status.Capacity[p.CountName]
 
 	if !ok {
 		return int(0)
@@ -28,3 +42,29 @@ func GetGPUCountInNode(node *v1.Node) int {
 
 	return int(val.Value())
 }
+
+// GetGPUUUIDsInNode gets the device UUIDs in the order of the indexes, nil if the device plugin
+// doesn't report them or they don't match the GPU count
+func GetGPUUUIDsInNode(node *v1.Node) []string {
+	return GetProfileOfNode(node).GPUUUIDs(node)
+}
+
+// GPUUUIDs gets the device UUIDs of the node in the annotation of the profile, see GetGPUUUIDsInNode
+func (p *Profile) GPUUUIDs(node *v1.Node) []string {
+	value, found := node.Annotations[p.NodeUUIDsKey]
+	if !found || len(value) == 0 {
+		return nil
+	}
+
+	uuids := strings.Split(value, ",")
+	if len(uuids) != p.GPUCount(node) {
+		return nil
+	}
+	for i := range uuids {
+		uuids[i] = strings.TrimSpace(uuids[i])
+		if len(uuids[i]) == 0 {
+			return nil
+		}
+	}
+	return uuids
+}
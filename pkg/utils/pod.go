@@ -43,14 +43,19 @@ func IsGPUsharingPod(pod *v1.Pod) bool {
 
 // GetGPUIDFromAnnotation gets GPU ID from Annotation
 func GetGPUIDFromAnnotation(pod *v1.Pod) int {
+	return GetProfileOfPod(pod).GPUIDFromAnnotation(pod)
+}
+
+// GPUIDFromAnnotation gets GPU ID from the annotation of the profile
+func (p *Profile) GPUIDFromAnnotation(pod *v1.Pod) int {
 	id := -1
 	if len(pod.ObjectMeta.Annotations) > 0 {
-		value, found := pod.ObjectMeta.Annotations[EnvResourceIndex]
+		value, found := pod.ObjectMeta.Annotations[p.IndexKey]
 		if found {
 			var err error
 			id, err = strconv.Atoi(value)
 			if err != nil {
-				log.V(9).Info("warn: Failed due to %v for pod %s in ns %s", err, pod.Name, pod.Namespace)
+				log.V(9).Warn("failed to parse the GPU ID", log.Pod(pod.Name), log.Namespace(pod.Namespace), log.Err(err))
 				id = -1
 			}
 		}
@@ -59,11 +64,22 @@ func GetGPUIDFromAnnotation(pod *v1.Pod) int {
 	return id
 }
 
+// GetGPUUUIDFromAnnotation gets the UUID of the allocated device, empty for the legacy pods which only have the index
+func GetGPUUUIDFromAnnotation(pod *v1.Pod) string {
+	return GetProfileOfPod(pod).GPUUUIDFromAnnotation(pod)
+}
+
+// GPUUUIDFromAnnotation gets the UUID of the allocated device from the annotation of the profile
+func (p *Profile) GPUUUIDFromAnnotation(pod *v1.Pod) string {
+	return pod.ObjectMeta.Annotations[p.UUIDKey]
+}
+
 // GetGPUIDFromEnv gets GPU ID from Env
 func GetGPUIDFromEnv(pod *v1.Pod) int {
 	id := -1
+	profile := GetProfileOfPod(pod)
 	for _, container := range pod.Spec.Containers {
-		id = getGPUIDFromContainer(container)
+		id = getGPUIDFromContainer(container, profile)
 		if id >= 0 {
 			return id
 		}
@@ -72,15 +88,15 @@ func GetGPUIDFromEnv(pod *v1.Pod) int {
 	return id
 }
 
-func getGPUIDFromContainer(container v1.Container) (devIdx int) {
+func getGPUIDFromContainer(container v1.Container, profile *Profile) (devIdx int) {
 	devIdx = -1
 	var err error
 loop:
 	for _, env := range container.Env {
-		if env.Name == EnvResourceIndex {
+		if env.Name == profile.IndexKey {
 			devIdx, err = strconv.Atoi(env.Value)
 			if err != nil {
-				log.V(9).Info("warn: Failed due to %v for %s", err, container.Name)
+				log.V(9).Warn("failed to parse the GPU ID", log.String("container", container.Name), log.Err(err))
 				devIdx = -1
 			}
 			break loop
@@ -92,8 +108,13 @@ loop:
 
 // GetGPUMemoryFromPodAnnotation gets the GPU Memory of the pod, choose the larger one between gpu memory and gpu init container memory
 func GetGPUMemoryFromPodAnnotation(pod *v1.Pod) (gpuMemory uint) {
+	return GetProfileOfPod(pod).GPUMemoryFromPodAnnotation(pod)
+}
+
+// GPUMemoryFromPodAnnotation gets the GPU Memory of the pod from the annotation of the profile
+func (p *Profile) GPUMemoryFromPodAnnotation(pod *v1.Pod) (gpuMemory uint) {
 	if len(pod.ObjectMeta.Annotations) > 0 {
-		value, found := pod.ObjectMeta.Annotations[EnvResourceByPod]
+		value, found := pod.ObjectMeta.Annotations[p.PodKey]
 		if found {
 			s, _ := strconv.Atoi(value)
 			if s < 0 {
@@ -104,32 +125,33 @@ func GetGPUMemoryFromPodAnnotation(pod *v1.Pod) (gpuMemory uint) {
 		}
 	}
 
-	log.V(100).Info("debug: pod %s in ns %s with status %v has GPU Mem %d",
-		pod.Name,
-		pod.Namespace,
-		pod.Status.Phase,
-		gpuMemory)
+	log.V(100).Debug("pod has GPU Mem",
+		log.Pod(pod.Name),
+		log.Namespace(pod.Namespace),
+		log.String("phase", string(pod.Status.Phase)),
+		log.ReqMem(gpuMemory))
 	return gpuMemory
 }
 
 // GetGPUMemoryFromPodEnv gets the GPU Memory of the pod, choose the larger one between gpu memory and gpu init container memory
 func GetGPUMemoryFromPodEnv(pod *v1.Pod) (gpuMemory uint) {
+	profile := GetProfileOfPod(pod)
 	for _, container := range pod.Spec.Containers {
-		gpuMemory += getGPUMemoryFromContainerEnv(container)
+		gpuMemory += getGPUMemoryFromContainerEnv(container, profile)
 	}
-	log.V(100).Info("debug: pod %s in ns %s with status %v has GPU Mem %d",
-		pod.Name,
-		pod.Namespace,
-		pod.Status.Phase,
-		gpuMemory)
+	log.V(100).Debug("pod has GPU Mem",
+		log.Pod(pod.Name),
+		log.Namespace(pod.Namespace),
+		log.String("phase", string(pod.Status.Phase)),
+		log.ReqMem(gpuMemory))
 	return gpuMemory
 }
 
-func getGPUMemoryFromContainerEnv(container v1.Container) (gpuMemory uint) {
+func getGPUMemoryFromContainerEnv(container v1.Container, profile *Profile) (gpuMemory uint) {
 	gpuMemory = 0
 loop:
 	for _, env := range container.Env {
-		if env.Name == EnvResourceByPod {
+		if env.Name == profile.PodKey {
 			s, _ := strconv.Atoi(env.Value)
 			if s < 0 {
 				s = 0
@@ -144,10 +166,15 @@ loop:
 
 // GetGPUMemoryFromPodResource gets GPU Memory of the Pod
 func GetGPUMemoryFromPodResource(pod *v1.Pod) int {
+	return GetProfileOfPod(pod).GPUMemoryFromPodResource(pod)
+}
+
+// GPUMemoryFromPodResource gets GPU Memory of the Pod in the resource of the profile
+func (p *Profile) GPUMemoryFromPodResource(pod *v1.Pod) int {
 	var total int
 	containers := pod.Spec.Containers
 	for _, container := range containers {
-		if val, ok := container.Resources.Limits[ResourceName]; ok {
+		if val, ok := container.Resources.Limits[p.ResourceName]; ok {
 			total += int(val.Value())
 		}
 	}
@@ -155,9 +182,9 @@ func GetGPUMemoryFromPodResource(pod *v1.Pod) int {
 }
 
 // GetGPUMemoryFromPodResource gets GPU Memory of the Container
-func GetGPUMemoryFromContainerResource(container v1.Container) int {
+func GetGPUMemoryFromContainerResource(container v1.Container, profile *Profile) int {
 	var total int
-	if val, ok := container.Resources.Limits[ResourceName]; ok {
+	if val, ok := container.Resources.Limits[profile.ResourceName]; ok {
 		total += int(val.Value())
 	}
 	return total
@@ -166,16 +193,17 @@ func GetGPUMemoryFromContainerResource(container v1.Container) int {
 // GetUpdatedPodEnvSpec updates pod env with devId
 func GetUpdatedPodEnvSpec(oldPod *v1.Pod, devId int, totalGPUMemByDev int) (newPod *v1.Pod) {
 	newPod = oldPod.DeepCopy()
+	profile := GetProfileOfPod(oldPod)
 	for i, c := range newPod.Spec.Containers {
-		gpuMem := GetGPUMemoryFromContainerResource(c)
+		gpuMem := GetGPUMemoryFromContainerResource(c, profile)
 
 		if gpuMem > 0 {
 			envs := []v1.EnvVar{
 				// v1.EnvVar{Name: EnvNVGPU, Value: fmt.Sprintf("%d", devId)},
-				v1.EnvVar{Name: EnvResourceIndex, Value: fmt.Sprintf("%d", devId)},
-				v1.EnvVar{Name: EnvResourceByPod, Value: fmt.Sprintf("%d", gpuMem)},
-				v1.EnvVar{Name: EnvResourceByDev, Value: fmt.Sprintf("%d", totalGPUMemByDev)},
-				v1.EnvVar{Name: EnvAssignedFlag, Value: "false"},
+				v1.EnvVar{Name: profile.IndexKey, Value: fmt.Sprintf("%d", devId)},
+				v1.EnvVar{Name: profile.PodKey, Value: fmt.Sprintf("%d", gpuMem)},
+				v1.EnvVar{Name: profile.DeviceKey, Value: fmt.Sprintf("%d", totalGPUMemByDev)},
+				v1.EnvVar{Name: profile.AssignedKey, Value: "false"},
 			}
 
 			for _, env := range envs {
@@ -188,32 +216,54 @@ func GetUpdatedPodEnvSpec(oldPod *v1.Pod, devId int, totalGPUMemByDev int) (newP
 	return newPod
 }
 
-// GetUpdatedPodAnnotationSpec updates pod env with devId
-func GetUpdatedPodAnnotationSpec(oldPod *v1.Pod, devId int, totalGPUMemByDev int) (newPod *v1.Pod) {
+// GetUpdatedPodAnnotationSpec updates pod env with devId, devUUID is empty if the node doesn't report the UUIDs
+func GetUpdatedPodAnnotationSpec(oldPod *v1.Pod, devId int, devUUID string, totalGPUMemByDev int) (newPod *v1.Pod) {
+	return GetProfileOfPod(oldPod).UpdatedPodAnnotationSpec(oldPod, devId, devUUID, totalGPUMemByDev)
+}
+
+// UpdatedPodAnnotationSpec returns a copy of the pod with the annotations of the profile with devId
+func (p *Profile) UpdatedPodAnnotationSpec(oldPod *v1.Pod, devId int, devUUID string, totalGPUMemByDev int) (newPod *v1.Pod) {
 	newPod = oldPod.DeepCopy()
 	if len(newPod.ObjectMeta.Annotations) == 0 {
 		newPod.ObjectMeta.Annotations = map[string]string{}
 	}
 
 	now := time.Now()
-	newPod.ObjectMeta.Annotations[EnvResourceIndex] = fmt.Sprintf("%d", devId)
-	newPod.ObjectMeta.Annotations[EnvResourceByDev] = fmt.Sprintf("%d", totalGPUMemByDev)
-	newPod.ObjectMeta.Annotations[EnvResourceByPod] = fmt.Sprintf("%d", GetGPUMemoryFromPodResource(newPod))
-	newPod.ObjectMeta.Annotations[EnvAssignedFlag] = "false"
-	newPod.ObjectMeta.Annotations[EnvResourceAssumeTime] = fmt.Sprintf("%d", now.UnixNano())
+	newPod.ObjectMeta.Annotations[p.IndexKey] = fmt.Sprintf("%d", devId)
+	newPod.ObjectMeta.Annotations[p.DeviceKey] = fmt.Sprintf("%d", totalGPUMemByDev)
+	newPod.ObjectMeta.Annotations[p.PodKey] = fmt.Sprintf("%d", p.GPUMemoryFromPodResource(newPod))
+	newPod.ObjectMeta.Annotations[p.AssignedKey] = "false"
+	newPod.ObjectMeta.Annotations[p.AssumeTimeKey] = fmt.Sprintf("%d", now.UnixNano())
+	if len(devUUID) > 0 {
+		newPod.ObjectMeta.Annotations[p.UUIDKey] = devUUID
+	} else {
+		delete(newPod.ObjectMeta.Annotations, p.UUIDKey)
+	}
 
 	return newPod
 }
 
-func PatchPodAnnotationSpec(oldPod *v1.Pod, devId int, totalGPUMemByDev int) ([]byte, error) {
+func PatchPodAnnotationSpec(oldPod *v1.Pod, devId int, devUUID string, totalGPUMemByDev int) ([]byte, error) {
+	return GetProfileOfPod(oldPod).PatchPodAnnotationSpec(oldPod, devId, devUUID, totalGPUMemByDev)
+}
+
+// PatchPodAnnotationSpec returns the patch of the annotations of the profile with devId. The index is kept
+// for the device plugins which don't read the UUID, devUUID is empty if the node doesn't report the UUIDs.
+func (p *Profile) PatchPodAnnotationSpec(oldPod *v1.Pod, devId int, devUUID string, totalGPUMemByDev int) ([]byte, error) {
 	now := time.Now()
+	annotations := map[string]interface{}{
+		p.IndexKey:      fmt.Sprintf("%d", devId),
+		p.DeviceKey:     fmt.Sprintf("%d", totalGPUMemByDev),
+		p.PodKey:        fmt.Sprintf("%d", p.GPUMemoryFromPodResource(oldPod)),
+		p.AssignedKey:   "false",
+		p.AssumeTimeKey: fmt.Sprintf("%d", now.UnixNano()),
+		// null removes a stale UUID
+		p.UUIDKey: nil,
+	}
+	if len(devUUID) > 0 {
+		annotations[p.UUIDKey] = devUUID
+	}
 	patchAnnotations := map[string]interface{}{
-		"metadata": map[string]map[string]string{"annotations": {
-			EnvResourceIndex:      fmt.Sprintf("%d", devId),
-			EnvResourceByDev:      fmt.Sprintf("%d", totalGPUMemByDev),
-			EnvResourceByPod:      fmt.Sprintf("%d", GetGPUMemoryFromPodResource(oldPod)),
-			EnvAssignedFlag:       "false",
-			EnvResourceAssumeTime: fmt.Sprintf("%d", now.UnixNano()),
-		}}}
+		"metadata": map[string]interface{}{"annotations": annotations}}
 	return json.Marshal(patchAnnotations)
 }
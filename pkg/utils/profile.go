@@ -0,0 +1,135 @@
+package utils
+
+import (
+	v1 "k8s.io/api/core/v1"
+)
+
+// DefaultProfileName is the name of the profile used when no profile is configured
+const DefaultProfileName = "default"
+
+// Profile pairs the resources of a device plugin with the annotation keys it reads,
+// so that one extender can serve the device plugins of several vendors or domains.
+type Profile struct {
+	Name         string          `json:"name"`
+	ResourceName v1.ResourceName `json:"resourceName"`
+	CountName    v1.ResourceName `json:"countName"`
+
+	// The annotation keys, which are also the env names of the containers
+	IndexKey      string `json:"indexKey"`
+	PodKey        string `json:"podKey"`
+	DeviceKey     string `json:"deviceKey"`
+	AssignedKey   string `json:"assignedKey"`
+	AssumeTimeKey string `json:"assumeTimeKey"`
+	// UUIDKey is the pod annotation of the allocated device's UUID, IndexKey is only read without it
+	UUIDKey string `json:"uuidKey"`
+	// NodeUUIDsKey is the node annotation of the device plugin, the device UUIDs in the order of the indexes
+	NodeUUIDsKey string `json:"nodeUUIDsKey"`
+}
+
+// NewProfile derives the annotation keys from the prefix, e.g. ALIYUN_COM_GPU_MEM_IDX
+func NewProfile(name string, resourceName, countName v1.ResourceName, annotationPrefix string) *Profile {
+	return &Profile{
+		Name:          name,
+		ResourceName:  resourceName,
+		CountName:     countName,
+		IndexKey:      annotationPrefix + "_IDX",
+		PodKey:        annotationPrefix + "_POD",
+		DeviceKey:     annotationPrefix + "_DEV",
+		AssignedKey:   annotationPrefix + "_ASSIGNED",
+		AssumeTimeKey: annotationPrefix + "_ASSUME_TIME",
+		UUIDKey:       annotationPrefix + "_UUID",
+		NodeUUIDsKey:  annotationPrefix + "_UUIDS",
+	}
+}
+
+// Profiles are the profiles served by an extender, the first one is the default
+type Profiles []*Profile
+
+// Default returns the profile of the pods and nodes which match no profile
+func (ps Profiles) Default() *Profile {
+	return ps[0]
+}
+
+// ByResource returns the profile of the resource name, nil if it's unknown
+func (ps Profiles) ByResource(resourceName v1.ResourceName) *Profile {
+	for _, p := range ps {
+		if p.ResourceName == resourceName {
+			return p
+		}
+	}
+	return nil
+}
+
+// OfPod returns the profile whose resource is requested by the pod, or whose annotations are set on the pod.
+// A pod which requests the resources of several profiles gets the first one in the order of the profiles.
+func (ps Profiles) OfPod(pod *v1.Pod) *Profile {
+	if len(ps) == 1 {
+		return ps[0]
+	}
+	for _, p := range ps {
+		for _, container := range pod.Spec.Containers {
+			if _, ok := container.Resources.Limits[p.ResourceName]; ok {
+				return p
+			}
+		}
+	}
+	for _, p := range ps {
+		if _, ok := pod.Annotations[p.IndexKey]; ok {
+			return p
+		}
+	}
+	return ps.Default()
+}
+
+// OfNode returns the profile whose resource is in the capacity of the node.
+// A node which has the resources of several profiles, e.g. it runs the device plugins of two vendors,
+// gets the first one in the order of the profiles, so only the devices of that profile are shared on it.
+func (ps Profiles) OfNode(node *v1.Node) *Profile {
+	if len(ps) == 1 {
+		return ps[0]
+	}
+	for _, p := range ps {
+		if val, ok := node.Status.Capacity[p.ResourceName]; ok && val.Value() > 0 {
+			return p
+		}
+	}
+	return ps.Default()
+}
+
+// profiles are set at startup before the informers start, the caches are created with them
+var profiles = Profiles{
+	NewProfile(DefaultProfileName, DefaultResourceName, DefaultCountName, DefaultAnnotationPrefix),
+}
+
+// SetProfiles replaces the profiles, the first one is the default. It must be called before the informers start.
+func SetProfiles(p ...*Profile) {
+	if len(p) == 0 {
+		return
+	}
+	profiles = p
+}
+
+// GetProfiles returns all the profiles
+func GetProfiles() Profiles {
+	return profiles
+}
+
+// DefaultProfile returns the profile of the pods and nodes which match no profile
+func DefaultProfile() *Profile {
+	return profiles.Default()
+}
+
+// GetProfileByResource returns the profile of the resource name, nil if it's unknown
+func GetProfileByResource(resourceName v1.ResourceName) *Profile {
+	return profiles.ByResource(resourceName)
+}
+
+// GetProfileOfPod returns the profile of the pod, see Profiles.OfPod
+func GetProfileOfPod(pod *v1.Pod) *Profile {
+	return profiles.OfPod(pod)
+}
+
+// GetProfileOfNode returns the profile of the node, see Profiles.OfNode
+func GetProfileOfNode(node *v1.Node) *Profile {
+	return profiles.OfNode(node)
+}
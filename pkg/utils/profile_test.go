@@ -0,0 +1,237 @@
+package utils
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/AliyunContainerService/gpushare-scheduler-extender/pkg/log"
+)
+
+func init() {
+	log.NewLoggerWithLevel(0)
+}
+
+var (
+	defaultProfile = NewProfile(DefaultProfileName, DefaultResourceName, DefaultCountName, DefaultAnnotationPrefix)
+	otherProfile   = NewProfile("other", "example.com/gpu-mem", "example.com/gpu-count", "EXAMPLE_COM_GPU_MEM")
+	testProfiles   = Profiles{defaultProfile, otherProfile}
+)
+
+func newProfilePod(annotations map[string]string, limits ...v1.ResourceName) *v1.Pod {
+	resources := v1.ResourceList{}
+	for _, name := range limits {
+		resources[name] = resource.MustParse("4")
+	}
+	return &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "p1", Namespace: "default", Annotations: annotations},
+		Spec: v1.PodSpec{Containers: []v1.Container{{
+			Name:      "main",
+			Resources: v1.ResourceRequirements{Limits: resources},
+		}}},
+	}
+}
+
+func newProfileNode(capacity map[v1.ResourceName]string) *v1.Node {
+	resources := v1.ResourceList{}
+	for name, value := range capacity {
+		resources[name] = resource.MustParse(value)
+	}
+	return &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "n1"},
+		Status:     v1.NodeStatus{Capacity: resources},
+	}
+}
+
+func TestProfilesOfPod(t *testing.T) {
+	tests := []struct {
+		name     string
+		profiles Profiles
+		pod      *v1.Pod
+		want     *Profile
+	}{
+		{
+			name:     "single profile is returned for any pod",
+			profiles: Profiles{otherProfile},
+			pod:      newProfilePod(nil, DefaultResourceName),
+			want:     otherProfile,
+		},
+		{
+			name:     "resource of the default profile",
+			profiles: testProfiles,
+			pod:      newProfilePod(nil, DefaultResourceName),
+			want:     defaultProfile,
+		},
+		{
+			name:     "resource of another profile",
+			profiles: testProfiles,
+			pod:      newProfilePod(nil, otherProfile.ResourceName),
+			want:     otherProfile,
+		},
+		{
+			name:     "resources of both profiles get the first one",
+			profiles: Profiles{otherProfile, defaultProfile},
+			pod:      newProfilePod(nil, DefaultResourceName, otherProfile.ResourceName),
+			want:     otherProfile,
+		},
+		{
+			name:     "annotation of another profile",
+			profiles: testProfiles,
+			pod:      newProfilePod(map[string]string{otherProfile.IndexKey: "0"}),
+			want:     otherProfile,
+		},
+		{
+			name:     "resource wins over the annotation",
+			profiles: testProfiles,
+			pod:      newProfilePod(map[string]string{otherProfile.IndexKey: "0"}, DefaultResourceName),
+			want:     defaultProfile,
+		},
+		{
+			name:     "no resource and no annotation get the default",
+			profiles: Profiles{otherProfile, defaultProfile},
+			pod:      newProfilePod(nil, v1.ResourceCPU),
+			want:     otherProfile,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := test.profiles.OfPod(test.pod); got != test.want {
+				t.Errorf("expected the profile %s, got %s", test.want.Name, got.Name)
+			}
+		})
+	}
+}
+
+func TestProfilesOfNode(t *testing.T) {
+	tests := []struct {
+		name     string
+		profiles Profiles
+		capacity map[v1.ResourceName]string
+		want     *Profile
+	}{
+		{
+			name:     "single profile is returned for any node",
+			profiles: Profiles{otherProfile},
+			capacity: map[v1.ResourceName]string{DefaultResourceName: "16"},
+			want:     otherProfile,
+		},
+		{
+			name:     "resource of another profile",
+			profiles: testProfiles,
+			capacity: map[v1.ResourceName]string{otherProfile.ResourceName: "16"},
+			want:     otherProfile,
+		},
+		{
+			name:     "resources of both profiles get the first one",
+			profiles: testProfiles,
+			capacity: map[v1.ResourceName]string{DefaultResourceName: "16", otherProfile.ResourceName: "16"},
+			want:     defaultProfile,
+		},
+		{
+			name:     "zero capacity is skipped",
+			profiles: testProfiles,
+			capacity: map[v1.ResourceName]string{DefaultResourceName: "0", otherProfile.ResourceName: "16"},
+			want:     otherProfile,
+		},
+		{
+			name:     "no resource gets the default",
+			profiles: testProfiles,
+			capacity: map[v1.ResourceName]string{v1.ResourceCPU: "4"},
+			want:     defaultProfile,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := test.profiles.OfNode(newProfileNode(test.capacity)); got != test.want {
+				t.Errorf("expected the profile %s, got %s", test.want.Name, got.Name)
+			}
+		})
+	}
+}
+
+func TestProfilesByResource(t *testing.T) {
+	if got := testProfiles.ByResource(otherProfile.ResourceName); got != otherProfile {
+		t.Errorf("expected the profile %s, got %v", otherProfile.Name, got)
+	}
+	if got := testProfiles.ByResource("example.com/unknown"); got != nil {
+		t.Errorf("expected no profile, got %s", got.Name)
+	}
+	if got := testProfiles.Default(); got != defaultProfile {
+		t.Errorf("expected the default profile, got %s", got.Name)
+	}
+}
+
+func TestProfileMethods(t *testing.T) {
+	node := newProfileNode(map[v1.ResourceName]string{
+		DefaultResourceName:       "32",
+		DefaultCountName:          "4",
+		otherProfile.ResourceName: "16",
+		otherProfile.CountName:    "2",
+	})
+	if got := otherProfile.TotalGPUMemory(node); got != 16 {
+		t.Errorf("expected the gpu memory 16, got %d", got)
+	}
+	if got := otherProfile.GPUCount(node); got != 2 {
+		t.Errorf("expected the gpu count 2, got %d", got)
+	}
+	node.Annotations = map[string]string{
+		DefaultAnnotationPrefix + "_UUIDS": "GPU-a,GPU-b,GPU-c,GPU-d",
+		otherProfile.NodeUUIDsKey:          "GPU-e, GPU-f",
+	}
+	if got := otherProfile.GPUUUIDs(node); !reflect.DeepEqual(got, []string{"GPU-e", "GPU-f"}) {
+		t.Errorf("expected the uuids of the profile, got %v", got)
+	}
+
+	pod := newProfilePod(map[string]string{
+		DefaultAnnotationPrefix + "_IDX": "3",
+		DefaultAnnotationPrefix + "_POD": "8",
+		otherProfile.IndexKey:            "1",
+		otherProfile.PodKey:              "4",
+		otherProfile.UUIDKey:             "GPU-f",
+	}, otherProfile.ResourceName)
+	if got := otherProfile.GPUIDFromAnnotation(pod); got != 1 {
+		t.Errorf("expected the gpu id 1, got %d", got)
+	}
+	if got := otherProfile.GPUUUIDFromAnnotation(pod); got != "GPU-f" {
+		t.Errorf("expected the gpu uuid GPU-f, got %q", got)
+	}
+	if got := otherProfile.GPUMemoryFromPodAnnotation(pod); got != 4 {
+		t.Errorf("expected the gpu memory 4 from the annotation, got %d", got)
+	}
+	if got := otherProfile.GPUMemoryFromPodResource(pod); got != 4 {
+		t.Errorf("expected the gpu memory 4 from the resource, got %d", got)
+	}
+	if got := defaultProfile.GPUMemoryFromPodResource(pod); got != 0 {
+		t.Errorf("expected no gpu memory of the default resource, got %d", got)
+	}
+
+	patch, err := otherProfile.PatchPodAnnotationSpec(pod, 1, "GPU-f", 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got struct {
+		Metadata struct {
+			Annotations map[string]string `json:"annotations"`
+		} `json:"metadata"`
+	}
+	if err := json.Unmarshal(patch, &got); err != nil {
+		t.Fatal(err)
+	}
+	delete(got.Metadata.Annotations, otherProfile.AssumeTimeKey)
+	want := map[string]string{
+		otherProfile.IndexKey:    "1",
+		otherProfile.DeviceKey:   "16",
+		otherProfile.PodKey:      "4",
+		otherProfile.AssignedKey: "false",
+		otherProfile.UUIDKey:     "GPU-f",
+	}
+	if !reflect.DeepEqual(got.Metadata.Annotations, want) {
+		t.Errorf("expected the annotations %v, got %v", want, got.Metadata.Annotations)
+	}
+}